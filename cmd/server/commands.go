@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"chat-quick-chat-server/internal/anonymize"
+	"chat-quick-chat-server/internal/archive"
+	"chat-quick-chat-server/internal/backup"
+	"chat-quick-chat-server/internal/blocklist"
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/lan"
+	"chat-quick-chat-server/internal/scheduler"
+	"chat-quick-chat-server/internal/settings"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const usage = `usage: server [flags] [command]
+
+With no command the HTTP server is started.
+
+Commands:
+  backup <file.tar.gz>    write a snapshot of data/ and storage/chat-media
+  restore <file.tar.gz>   replace data/ and storage/chat-media from a snapshot
+                          (stop the server first)
+  import [-base-url URL] [-media-dir DIR] <file>
+                          add conversations from a session export (.zip) or a
+                          Supabase messages dump (.json or .csv); file URLs are
+                          remapped to URL (default $PUBLIC_URL)
+                          (stop the server first)
+  compact [-min-age D] [-dry-run]
+                          rewrite the data files, prune old outbox events and
+                          delete media older than D (default 1h) that no
+                          message references
+  anonymize-export [-seed S] <dir>
+                          write a copy of data/ to the empty directory dir with
+                          names, emails, IPs and message text replaced by
+                          consistent fakes, for staging (stop the server first)
+  config-export <file>    write the automations and local blocklist as one
+                          JSON bundle ("-" for stdout)
+  config-import [-dry-run] <file>
+                          replace the configuration with the sections of a
+                          bundle from config-export (stop the server first)
+  healthcheck             exit 0 if the server on $PORT answers /health with
+                          200, for Docker's HEALTHCHECK`
+
+func runCommand(name string, args []string, dataDir, storageDir string) error {
+	switch name {
+	case "backup":
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+		return backupCommand(args[0], dataDir, storageDir)
+	case "restore":
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+		return restoreCommand(args[0], dataDir, storageDir)
+	case "import":
+		return importCommand(args, dataDir, storageDir)
+	case "compact":
+		return compactCommand(args, dataDir, storageDir)
+	case "anonymize-export":
+		return anonymizeCommand(args, dataDir)
+	case "config-export":
+		if len(args) != 1 {
+			return fmt.Errorf(usage)
+		}
+		return configExportCommand(args[0], dataDir)
+	case "config-import":
+		return configImportCommand(args, dataDir)
+	case "healthcheck":
+		return healthCommand()
+	default:
+		return fmt.Errorf("unknown command %q\n%s", name, usage)
+	}
+}
+
+func backupCommand(file, dataDir, storageDir string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := backup.Write(f, db.New(dataDir), storageDir); err != nil {
+		f.Close()
+		os.Remove(file)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Backup written to %s\n", file)
+	return nil
+}
+
+func restoreCommand(file, dataDir, storageDir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := backup.Restore(f, dataDir, storageDir); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s\n", file)
+	return nil
+}
+
+func importCommand(args []string, dataDir, storageDir string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	var opts archive.ImportOptions
+	fs.StringVar(&opts.BaseURL, "base-url", os.Getenv("PUBLIC_URL"), "public origin of this server")
+	fs.StringVar(&opts.MediaDir, "media-dir", "", "directory holding media referenced by a messages dump")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf(usage)
+	}
+
+	opts.Cipher = loadCipher()
+	database := db.New(dataDir)
+	database.Cipher = opts.Cipher
+	database.IDFormat = loadIDFormat()
+	if err := database.Load(); err != nil {
+		return err
+	}
+	res, err := archive.Import(database, storageDir, fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d sessions and %d messages (%d skipped)\n", res.Sessions, res.Messages, res.SkippedMessages)
+	return nil
+}
+
+func compactCommand(args []string, dataDir, storageDir string) error {
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+	var opts db.CompactOptions
+	fs.DurationVar(&opts.MinAge, "min-age", time.Hour, "keep unreferenced media younger than this")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "only report what would be removed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf(usage)
+	}
+
+	database := db.New(dataDir)
+	database.Cipher = loadCipher()
+	if err := database.Load(); err != nil {
+		return err
+	}
+	res, err := database.Compact(storageDir, opts)
+	if err != nil {
+		return err
+	}
+	verb := "Removed"
+	if opts.DryRun {
+		verb = "Would remove"
+	}
+	for _, rel := range res.MediaRemoved {
+		fmt.Println(rel)
+	}
+	fmt.Printf("%s %d media files (%d bytes) and %d outbox events\n", verb, len(res.MediaRemoved), res.MediaBytes, res.OutboxPruned)
+	return nil
+}
+
+func anonymizeCommand(args []string, dataDir string) error {
+	fs := flag.NewFlagSet("anonymize-export", flag.ContinueOnError)
+	seed := fs.String("seed", "", "derive the fakes from this secret, so reruns give the same fakes (default: random)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf(usage)
+	}
+	key := []byte(*seed)
+	if *seed == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+	}
+
+	out, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if out == dataDir {
+		return fmt.Errorf("refusing to overwrite the data directory")
+	}
+	database := db.New(dataDir)
+	database.Cipher = loadCipher()
+	if err := database.Load(); err != nil {
+		return err
+	}
+	res, err := anonymize.Copy(database, out, key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d sessions and %d messages to %s (%d names, %d emails replaced)\n", res.Sessions, res.Messages, out, res.Names, res.Emails)
+	return nil
+}
+
+// openSettings opens the configuration a bundle covers: the blocklist in
+// dataDir and, when AUTOMATIONS_FILE is set, the automations. A file that
+// doesn't exist yet is created on import.
+func openSettings(dataDir string) (*blocklist.Blocklist, *scheduler.Automations, error) {
+	list, err := blocklist.Open(filepath.Join(dataDir, "blocklist.json"), loadCipher())
+	if err != nil {
+		return nil, nil, err
+	}
+	path := os.Getenv("AUTOMATIONS_FILE")
+	if path == "" {
+		return list, nil, nil
+	}
+	auto := &scheduler.Automations{Path: path}
+	if auto.Config, err = scheduler.LoadAutomationConfig(path); err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	return list, auto, nil
+}
+
+func configExportCommand(file, dataDir string) error {
+	list, auto, err := openSettings(dataDir)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(settings.Export(list, auto, time.Now()), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Configuration written to %s\n", file)
+	return nil
+}
+
+func configImportCommand(args []string, dataDir string) error {
+	fs := flag.NewFlagSet("config-import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only check the bundle")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf(usage)
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := settings.Parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	list, auto, err := openSettings(dataDir)
+	if err != nil {
+		return err
+	}
+	if err := settings.Check(b, list, auto); err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("%s is valid\n", fs.Arg(0))
+		return nil
+	}
+	if err := settings.Apply(b, list, auto); err != nil {
+		return err
+	}
+	fmt.Printf("Imported configuration from %s\n", fs.Arg(0))
+	return nil
+}
+
+// healthCommand asks the local server for /health; the runtime image has no
+// curl. The request goes straight to loopback, not through the outbound
+// proxy and private-network guard.
+func healthCommand() error {
+	client := &http.Client{Transport: &http.Transport{}, Timeout: 5 * time.Second}
+	resp, err := client.Get("http://127.0.0.1:" + envString("PORT", "8000") + "/health")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("unhealthy: %s %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// startLAN advertises the server on the local network and prints a QR code
+// of the widget URL (WIDGET_URL, or this machine's first LAN address).
+func startLAN(port string) (func(), error) {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	ips, err := lan.LocalIPs()
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no LAN address found")
+	}
+
+	widgetURL := os.Getenv("WIDGET_URL")
+	if widgetURL == "" {
+		widgetURL = "http://" + net.JoinHostPort(ips[0].String(), port) + "/"
+	}
+
+	server, err := lan.Advertise(envString("LAN_INSTANCE_NAME", "Quick Chat"), p, ips, widgetURL)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Advertising %s on the local network\n", lan.ServiceType)
+	if err := lan.PrintQR(os.Stdout, widgetURL); err != nil {
+		server.Shutdown()
+		return nil, err
+	}
+	return func() { server.Shutdown() }, nil
+}
@@ -1,17 +1,504 @@
 package main
 
 import (
+	"bytes"
+	"chat-quick-chat-server/internal/auth"
+	"chat-quick-chat-server/internal/backup"
+	"chat-quick-chat-server/internal/blocklist"
+	"chat-quick-chat-server/internal/calendar"
+	"chat-quick-chat-server/internal/crm"
 	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/encryption"
+	"chat-quick-chat-server/internal/geoip"
 	"chat-quick-chat-server/internal/handlers"
+	"chat-quick-chat-server/internal/identity"
+	"chat-quick-chat-server/internal/mail"
+	"chat-quick-chat-server/internal/outbound"
+	"chat-quick-chat-server/internal/outbox"
+	"chat-quick-chat-server/internal/payment"
+	"chat-quick-chat-server/internal/policy"
 	"chat-quick-chat-server/internal/realtime"
+	"chat-quick-chat-server/internal/scheduler"
+	"chat-quick-chat-server/internal/signing"
+	"chat-quick-chat-server/internal/ticket"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// envDuration reads a duration such as "10m" from the environment, returning
+// zero when the variable is unset.
+func envDuration(name string) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return d
+}
+
+func envString(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// loadAccessLog builds the request logger: slog text on stderr, or JSON
+// with LOG_FORMAT=json. ACCESS_LOG=off turns request logging off.
+func loadAccessLog() *slog.Logger {
+	if os.Getenv("ACCESS_LOG") == "off" {
+		return nil
+	}
+	switch format := envString("LOG_FORMAT", "text"); format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, nil))
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	default:
+		log.Fatalf("Invalid LOG_FORMAT: %q (want text or json)", format)
+		return nil
+	}
+}
+
+// loadRateLimits reads the RATE_LIMIT_* variables, each like "30/m".
+func loadRateLimits() handlers.RateLimits {
+	var limits handlers.RateLimits
+	for name, l := range map[string]*handlers.RateLimit{
+		"RATE_LIMIT_MESSAGES_PER_IP":      &limits.MessagesPerIP,
+		"RATE_LIMIT_MESSAGES_PER_SESSION": &limits.MessagesPerSession,
+		"RATE_LIMIT_SESSIONS_PER_IP":      &limits.SessionsPerIP,
+		"RATE_LIMIT_UPLOADS_PER_IP":       &limits.UploadsPerIP,
+		"RATE_LIMIT_UPLOADS_PER_SESSION":  &limits.UploadsPerSession,
+		"RATE_LIMIT_SIGNUPS_PER_IP":       &limits.SignupsPerIP,
+		"RATE_LIMIT_EMAILS_PER_IP":        &limits.EmailsPerIP,
+	} {
+		var err error
+		if *l, err = handlers.ParseRateLimit(os.Getenv(name)); err != nil {
+			log.Fatalf("Invalid %s: %v", name, err)
+		}
+	}
+	return limits
+}
+
+// loadLockout reads LOCKOUT_ATTEMPTS (default 5, 0 turns lockouts off),
+// LOCKOUT_DURATION (default 30s) and LOCKOUT_MAX (default 1h).
+func loadLockout() handlers.Lockout {
+	l := handlers.Lockout{Attempts: 5, Base: envDuration("LOCKOUT_DURATION"), Max: envDuration("LOCKOUT_MAX")}
+	if v := os.Getenv("LOCKOUT_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid LOCKOUT_ATTEMPTS: %q", v)
+		}
+		l.Attempts = n
+	}
+	if l.Base <= 0 {
+		l.Base = 30 * time.Second
+	}
+	if l.Max <= 0 {
+		l.Max = time.Hour
+	}
+	if l.Max < l.Base {
+		log.Fatal("LOCKOUT_MAX must not be shorter than LOCKOUT_DURATION")
+	}
+	return l
+}
+
+// loadAuditLog builds the security event log: JSON lines appended to the
+// file AUDIT_LOG names, or the access log's format on stderr when it is
+// unset. AUDIT_LOG=off turns it off.
+func loadAuditLog() *slog.Logger {
+	switch path := os.Getenv("AUDIT_LOG"); path {
+	case "off":
+		return nil
+	case "":
+		if envString("LOG_FORMAT", "text") == "json" {
+			return slog.New(slog.NewJSONHandler(os.Stderr, nil)).With("log", "audit")
+		}
+		return slog.New(slog.NewTextHandler(os.Stderr, nil)).With("log", "audit")
+	default:
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatalf("Invalid AUDIT_LOG: %v", err)
+		}
+		return slog.New(slog.NewJSONHandler(f, nil))
+	}
+}
+
+// loadCipher builds the at-rest cipher from ENCRYPTION_KEY, or returns nil
+// when encryption is not configured.
+func loadCipher() *encryption.Cipher {
+	v := os.Getenv("ENCRYPTION_KEY")
+	if v == "" {
+		return nil
+	}
+	key, err := encryption.ParseKey(v)
+	if err != nil {
+		log.Fatalf("Invalid ENCRYPTION_KEY: %v", err)
+	}
+	c, err := encryption.New(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+// loadIDFormat reads ID_FORMAT (uuid4, uuid7 or ulid).
+func loadIDFormat() db.IDFormat {
+	f, err := db.ParseIDFormat(os.Getenv("ID_FORMAT"))
+	if err != nil {
+		log.Fatalf("Invalid ID_FORMAT: %v", err)
+	}
+	return f
+}
+
+// loadSnapshotter configures scheduled backups from BACKUP_INTERVAL and
+// either BACKUP_DIR or BACKUP_S3_BUCKET, or returns nil when they are off.
+func loadSnapshotter(database *db.Database, storageDir string) *backup.Snapshotter {
+	every := envDuration("BACKUP_INTERVAL")
+	if every <= 0 {
+		return nil
+	}
+	s := &backup.Snapshotter{DB: database, StorageDir: storageDir, Every: every}
+	if v := os.Getenv("BACKUP_KEEP"); v != "" {
+		keep, err := strconv.Atoi(v)
+		if err != nil || keep < 1 {
+			log.Fatalf("Invalid BACKUP_KEEP: %q", v)
+		}
+		s.Keep = keep
+	}
+	switch dir, bucket := os.Getenv("BACKUP_DIR"), os.Getenv("BACKUP_S3_BUCKET"); {
+	case dir != "" && bucket != "":
+		log.Fatal("Set only one of BACKUP_DIR and BACKUP_S3_BUCKET")
+	case dir != "":
+		s.Target = backup.DirTarget{Dir: dir}
+	case bucket != "":
+		region := envString("BACKUP_S3_REGION", envString("AWS_REGION", "us-east-1"))
+		s.Target = backup.S3Target{
+			Endpoint:     envString("BACKUP_S3_ENDPOINT", "https://s3."+region+".amazonaws.com"),
+			Region:       region,
+			Bucket:       bucket,
+			Prefix:       os.Getenv("BACKUP_S3_PREFIX"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	default:
+		log.Fatal("BACKUP_INTERVAL needs BACKUP_DIR or BACKUP_S3_BUCKET")
+	}
+	return s
+}
+
+// loadSLA reads the SLA targets SLA_FIRST_RESPONSE and SLA_RESOLUTION, with
+// SLA_WARN (a fraction of the target, default 0.8), SLA_AGENTS (default
+// DIGEST_AGENTS) and SLA_WEBHOOK_URL. It is nil when no target is set.
+func loadSLA(database *db.Database, dataDir string) *scheduler.SLA {
+	s := &scheduler.SLA{
+		DB:            database,
+		FirstResponse: envDuration("SLA_FIRST_RESPONSE"),
+		Resolution:    envDuration("SLA_RESOLUTION"),
+		WebhookURL:    os.Getenv("SLA_WEBHOOK_URL"),
+		StateFile:     filepath.Join(dataDir, ".sla-checked"),
+	}
+	if s.FirstResponse <= 0 && s.Resolution <= 0 {
+		return nil
+	}
+	if v := os.Getenv("SLA_WARN"); v != "" {
+		warn, err := strconv.ParseFloat(v, 64)
+		if err != nil || warn <= 0 {
+			log.Fatalf("Invalid SLA_WARN: %q", v)
+		}
+		s.Warn = warn
+	}
+	if agents := splitList(envString("SLA_AGENTS", os.Getenv("DIGEST_AGENTS"))); len(agents) > 0 {
+		s.Agents = make(map[string]bool)
+		for _, a := range agents {
+			s.Agents[a] = true
+		}
+	}
+	return s
+}
+
+// loadDigest configures the unanswered-sessions email from DIGEST_TO and the
+// SMTP_* relay settings; nil when DIGEST_TO is unset.
+func loadDigest(database *db.Database) *scheduler.Digest {
+	to := splitList(os.Getenv("DIGEST_TO"))
+	if len(to) == 0 {
+		return nil
+	}
+	mailer := loadSMTP()
+	if mailer == nil {
+		log.Fatal("DIGEST_TO needs SMTP_ADDR")
+	}
+	d := &scheduler.Digest{
+		DB:           database,
+		Mailer:       mailer,
+		To:           to,
+		Every:        envDuration("DIGEST_INTERVAL"),
+		WaitingFor:   envDuration("DIGEST_UNANSWERED_AFTER"),
+		LinkTemplate: os.Getenv("DIGEST_LINK_TEMPLATE"),
+	}
+	if d.Every <= 0 {
+		d.Every = 24 * time.Hour
+	}
+	if d.WaitingFor <= 0 {
+		d.WaitingFor = 4 * time.Hour
+	}
+	if agents := splitList(os.Getenv("DIGEST_AGENTS")); len(agents) > 0 {
+		d.Agents = make(map[string]bool)
+		for _, a := range agents {
+			d.Agents[a] = true
+		}
+	}
+	return d
+}
+
+// loadSMTP configures the relay email is sent through from SMTP_ADDR,
+// SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM; nil when SMTP_ADDR is unset.
+func loadSMTP() *mail.SMTP {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		return nil
+	}
+	return &mail.SMTP{
+		Addr:     addr,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     envString("SMTP_FROM", "Quick Chat <chat@localhost>"),
+	}
+}
+
+// splitList reads a comma-separated setting, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// loadOutbound reads the proxy settings: OUTBOUND_PROXY for every provider,
+// OUTBOUND_PROXY_OVERRIDES as provider=url (or provider=direct) pairs, and
+// OUTBOUND_ALLOW_PRIVATE / OUTBOUND_ALLOW_NETS to let requests reach
+// internal addresses, and the OUTBOUND_BREAKER_* circuit breaker limits.
+func loadOutbound() error {
+	cfg := outbound.Config{
+		Proxy:           os.Getenv("OUTBOUND_PROXY"),
+		Overrides:       make(map[string]string),
+		AllowPrivate:    os.Getenv("OUTBOUND_ALLOW_PRIVATE") == "true",
+		BreakerCooldown: envDuration("OUTBOUND_BREAKER_COOLDOWN"),
+	}
+	if v := os.Getenv("OUTBOUND_BREAKER_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid OUTBOUND_BREAKER_FAILURES: %w", err)
+		}
+		cfg.BreakerFailures = n
+	}
+	for _, pair := range splitList(os.Getenv("OUTBOUND_PROXY_OVERRIDES")) {
+		name, proxy, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid OUTBOUND_PROXY_OVERRIDES entry %q", pair)
+		}
+		cfg.Overrides[strings.TrimSpace(name)] = strings.TrimSpace(proxy)
+	}
+	for _, n := range splitList(os.Getenv("OUTBOUND_ALLOW_NETS")) {
+		prefix, err := netip.ParsePrefix(n)
+		if err != nil {
+			return fmt.Errorf("invalid OUTBOUND_ALLOW_NETS: %w", err)
+		}
+		cfg.AllowNets = append(cfg.AllowNets, prefix)
+	}
+	return outbound.Setup(cfg)
+}
+
+// tokenAuthorizer accepts any of tokens, and access tokens verifier
+// accepts when it is set.
+func tokenAuthorizer(tokens []string, verifier *auth.Verifier) func(token string) bool {
+	return func(token string) bool {
+		ok := false
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				ok = true
+			}
+		}
+		if !ok && verifier != nil {
+			_, err := verifier.Verify(token)
+			ok = err == nil
+		}
+		return ok
+	}
+}
+
+// joinAuthorizer admits joins of a session's messages topic that carry its
+// session token, and service_role backends: by the service_role key as
+// session_token, or by a service_role access token that verifier accepts.
+func joinAuthorizer(tokens *auth.SessionTokens, serviceKey string, verifier *auth.Verifier) func(sessionID string, payload realtime.JoinPayload) bool {
+	return func(sessionID string, payload realtime.JoinPayload) bool {
+		return tokens.Valid(sessionID, payload.Token()) || serviceJoin(payload, serviceKey, verifier)
+	}
+}
+
+// serviceJoin reports whether a join acts as service_role: it presents
+// serviceKey as its session_token, or an access_token with that role.
+func serviceJoin(payload realtime.JoinPayload, serviceKey string, verifier *auth.Verifier) bool {
+	if serviceKey != "" && subtle.ConstantTimeCompare([]byte(payload.Token()), []byte(serviceKey)) == 1 {
+		return true
+	}
+	if verifier != nil && payload.AccessToken != "" {
+		claims, err := verifier.Verify(payload.AccessToken)
+		return err == nil && claims.Role == "service_role"
+	}
+	return false
+}
+
+// inboxAuthorizer lets service_role join tenants' inboxes and, with
+// policies on chat_sessions, joins whose access_token the policies let
+// select the tenant's sessions. Policies see a row with just tenant, null
+// for the default inbox.
+func inboxAuthorizer(serviceKey string, policies *policy.Engine, verifier *auth.Verifier) func(tenant string, payload realtime.JoinPayload) bool {
+	return func(tenant string, payload realtime.JoinPayload) bool {
+		if serviceJoin(payload, serviceKey, verifier) {
+			return true
+		}
+		if !policies.Protects("chat_sessions") {
+			return false
+		}
+		req := policy.Requester{Role: "anon"}
+		if verifier != nil && payload.AccessToken != "" {
+			claims, err := verifier.Verify(payload.AccessToken)
+			if err != nil {
+				return false
+			}
+			req = policy.Requester{Role: claims.Role, Claims: claims.All}
+		}
+		row := policy.Row{"tenant": nil}
+		if tenant != db.DefaultTenant {
+			row["tenant"] = tenant
+		}
+		return policies.Visible(req, "chat_sessions", policy.Select, row)
+	}
+}
+
+// policyJoins lets a join of a session's messages topic through next, if
+// set, and then only if the policies let the join's access_token select
+// the session's messages. Policies see a row with just session_id, so
+// rules on other columns never pass for a join.
+func policyJoins(next func(string, realtime.JoinPayload) bool, policies *policy.Engine, verifier *auth.Verifier) func(sessionID string, payload realtime.JoinPayload) bool {
+	return func(sessionID string, payload realtime.JoinPayload) bool {
+		if next != nil && !next(sessionID, payload) {
+			return false
+		}
+		req := policy.Requester{Role: "anon"}
+		if verifier != nil && payload.AccessToken != "" {
+			claims, err := verifier.Verify(payload.AccessToken)
+			if err != nil {
+				return false
+			}
+			req = policy.Requester{Role: claims.Role, Claims: claims.All}
+		}
+		return policies.Visible(req, "messages", policy.Select, policy.Row{"session_id": sessionID})
+	}
+}
+
+// loadOAuth enables the OAuth providers whose client ID and secret are set:
+// GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET, GOOGLE_CLIENT_ID and
+// GOOGLE_CLIENT_SECRET.
+func loadOAuth() map[string]*auth.OAuthProvider {
+	providers := make(map[string]*auth.OAuthProvider)
+	if id, secret := os.Getenv("GITHUB_CLIENT_ID"), os.Getenv("GITHUB_CLIENT_SECRET"); id != "" && secret != "" {
+		providers["github"] = auth.GitHub(id, secret)
+	}
+	if id, secret := os.Getenv("GOOGLE_CLIENT_ID"), os.Getenv("GOOGLE_CLIENT_SECRET"); id != "" && secret != "" {
+		providers["google"] = auth.Google(id, secret)
+	}
+	return providers
+}
+
+// loadAuth builds the access token verifier from JWT_SECRET (HS256) and
+// JWT_JWKS_URL, nil when neither is set.
+func loadAuth() *auth.Verifier {
+	secret, jwks := os.Getenv("JWT_SECRET"), os.Getenv("JWT_JWKS_URL")
+	if secret == "" && jwks == "" {
+		return nil
+	}
+	v := &auth.Verifier{Issuer: os.Getenv("JWT_ISSUER"), Audience: os.Getenv("JWT_AUDIENCE")}
+	if secret != "" {
+		v.Secret = []byte(secret)
+	}
+	if jwks != "" {
+		v.Keys = &identity.KeySet{URL: jwks, Service: "auth"}
+	}
+	return v
+}
+
+// diskAlert posts {"event": "disk_full" | "disk_recovered", "at": ...} to
+// url whenever the database runs out of space or recovers.
+func diskAlert(url string) func(full bool) {
+	return func(full bool) {
+		event := "disk_recovered"
+		if full {
+			event = "disk_full"
+		}
+		postAlert(url, map[string]any{"event": event})
+	}
+}
+
+// limitAlert posts {"event": "message_limit_<level>", "messages": ...,
+// "limit": ..., "at": ...} to url when the message limit's level changes.
+func limitAlert(url string) func(level string, messages, limit int) {
+	return func(level string, messages, limit int) {
+		postAlert(url, map[string]any{"event": "message_limit_" + level, "messages": messages, "limit": limit})
+	}
+}
+
+func postAlert(url string, alert map[string]any) {
+	alert["at"] = time.Now().UTC().Format(time.RFC3339)
+	body, _ := json.Marshal(alert)
+	client := outbound.Client("alerts", 10*time.Second)
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Alert %s failed: %v", alert["event"], err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Alert %s failed: %s", alert["event"], resp.Status)
+	}
+}
+
 func main() {
+	lanMode := flag.Bool("lan", false, "advertise the server over mDNS and print a QR code for the widget URL")
+	repair := flag.Bool("repair", false, "fix or quarantine data integrity problems found on startup")
+	strictLoad := flag.Bool("strict-load", false, "refuse to start if any data file is damaged instead of salvaging it")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// Outbound requests, the subcommands' included, go through the proxy
+	// and the private-network guard.
+	if err := loadOutbound(); err != nil {
+		log.Fatal(err)
+	}
+
 	// Directories
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -28,18 +515,288 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Only one process may write the data directory at a time. config-export
+	// only reads, and healthcheck only asks the live server. backup only
+	// reads too, but the running server writes without regard for its
+	// snapshot, which has to come from the server itself.
+	if flag.Arg(0) != "config-export" && flag.Arg(0) != "healthcheck" {
+		lock, err := db.LockDataDir(dataDir)
+		if errors.Is(err, db.ErrLocked) && flag.Arg(0) == "backup" {
+			log.Fatalf("%v: server running, use GET /admin/v1/backup", err)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer lock.Unlock()
+	}
+
+	// Subcommands (backup, restore, ...) run once and exit.
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Arg(0), flag.Args()[1:], dataDir, storageDir); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Initialize DB
+	cipher := loadCipher()
 	database := db.New(dataDir)
-	if err := database.Load(); err != nil {
+	database.Cipher = cipher
+	database.IDFormat = loadIDFormat()
+	database.MediaDir = storageDir
+	database.Repair = *repair
+	database.StrictLoad = *strictLoad
+	if url := os.Getenv("DISK_ALERT_URL"); url != "" {
+		database.OnDiskFull = diskAlert(url)
+	}
+	if v := os.Getenv("DISK_FULL_MAX_QUEUED"); v != "" {
+		if database.MaxUnsaved, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("Invalid DISK_FULL_MAX_QUEUED: %v", err)
+		}
+	}
+	if v := os.Getenv("MESSAGE_LIMIT"); v != "" {
+		if database.MessageLimit, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("Invalid MESSAGE_LIMIT: %v", err)
+		}
+	}
+	if v := os.Getenv("MESSAGE_LIMIT_WARN"); v != "" {
+		if database.MessageLimitWarn, err = strconv.ParseFloat(v, 64); err != nil {
+			log.Fatalf("Invalid MESSAGE_LIMIT_WARN: %v", err)
+		}
+	}
+	if url := envString("MESSAGE_LIMIT_ALERT_URL", os.Getenv("DISK_ALERT_URL")); url != "" {
+		database.OnMessageLimit = limitAlert(url)
+	}
+	if database.Durability, err = db.ParseDurability(os.Getenv("DURABILITY")); err != nil {
+		log.Fatalf("Invalid DURABILITY: %v", err)
+	}
+	if err := database.Load(); *strictLoad && err != nil || errors.Is(err, db.ErrSchemaTooNew) || errors.Is(err, encryption.ErrDecrypt) {
+		log.Fatal(err)
+	} else if err != nil {
 		log.Printf("Warning: Failed to load database: %v", err)
 	}
 
+	if database.Durability == db.DurabilityInterval {
+		go database.RunSync(envDuration("SYNC_INTERVAL"))
+	}
+
 	// Initialize Realtime Hub
 	hub := realtime.NewHub()
+	hub.OnJoin = func(sessionID string, payload realtime.JoinPayload) string {
+		if payload.Name() == "" {
+			return ""
+		}
+		p, err := database.JoinParticipant(sessionID, payload.Name())
+		if err != nil {
+			return ""
+		}
+		return p.ID
+	}
+	hub.OnSeen = database.TouchParticipant
+	if v := os.Getenv("REALTIME_MAX_TOPICS"); v != "" {
+		if hub.MaxTopics, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("Invalid REALTIME_MAX_TOPICS: %v", err)
+		}
+	}
+	if v := os.Getenv("REALTIME_JOIN_RATE"); v != "" {
+		if hub.JoinRate, err = strconv.ParseFloat(v, 64); err != nil {
+			log.Fatalf("Invalid REALTIME_JOIN_RATE: %v", err)
+		}
+	}
+	verifier := loadAuth()
+	if tokens := splitList(os.Getenv("REALTIME_TOKENS")); len(tokens) > 0 {
+		hub.Authorize = tokenAuthorizer(tokens, verifier)
+	}
+	if verifier != nil {
+		hub.VerifyToken = func(token string) (time.Time, error) {
+			claims, err := verifier.Verify(token)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return claims.Expiry(), nil
+		}
+	}
+	var sessionTokens *auth.SessionTokens
+	if secret := os.Getenv("SESSION_TOKEN_SECRET"); secret != "" {
+		sessionTokens = &auth.SessionTokens{Secret: []byte(secret)}
+		hub.AuthorizeJoin = joinAuthorizer(sessionTokens, os.Getenv("SERVICE_ROLE_KEY"), verifier)
+	}
+	var policies *policy.Engine
+	if path := os.Getenv("POLICY_FILE"); path != "" {
+		if policies, err = policy.Load(path); err != nil {
+			log.Fatalf("Failed to load policies: %v", err)
+		}
+		if policies.Protects("messages") {
+			hub.AuthorizeJoin = policyJoins(hub.AuthorizeJoin, policies, verifier)
+		}
+	}
+	// Inboxes carry whole session rows, so once sessions are private they
+	// are for service_role, or whom the chat_sessions policies allow.
+	if sessionTokens != nil || policies.Protects("chat_sessions") {
+		hub.AuthorizeInbox = inboxAuthorizer(os.Getenv("SERVICE_ROLE_KEY"), policies, verifier)
+	}
 	go hub.Run()
 
+	// Realtime events are recorded in the outbox with each change and
+	// published from there.
+	dispatcher := &outbox.Dispatcher{DB: database, Hub: hub}
+	if path := os.Getenv("CRM_CONFIG"); path != "" {
+		connector, err := crm.LoadConfig(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		contacts := crm.NewSync(connector)
+		dispatcher.Subscribers = append(dispatcher.Subscribers, contacts.Handle)
+		go contacts.Run()
+	}
+	go dispatcher.Run()
+
+	// Background jobs
+	tick := envDuration("SCHEDULER_INTERVAL")
+	if tick <= 0 {
+		tick = 15 * time.Second
+	}
+	sched := scheduler.New(tick)
+	sched.Clock = database
+	sched.Add(database.RetrySave)
+	inactivity := &scheduler.Inactivity{
+		DB:          database,
+		WarnAfter:   envDuration("SESSION_IDLE_WARN_AFTER"),
+		CloseAfter:  envDuration("SESSION_IDLE_CLOSE_AFTER"),
+		WarningText: envString("SESSION_IDLE_WARNING_TEXT", "Are you still there?"),
+		ClosingText: envString("SESSION_IDLE_CLOSING_TEXT", "This conversation was closed due to inactivity."),
+	}
+	if inactivity.WarnAfter > 0 || inactivity.CloseAfter > 0 {
+		sched.Add(inactivity.Run)
+	}
+	var automations *scheduler.Automations
+	if path := os.Getenv("AUTOMATIONS_FILE"); path != "" {
+		cfg, err := scheduler.LoadAutomationConfig(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		automations = &scheduler.Automations{DB: database, Config: cfg, Path: path}
+		sched.Add(automations.Run)
+	}
+	blocked, err := blocklist.Open(filepath.Join(dataDir, "blocklist.json"), cipher)
+	if err != nil {
+		log.Fatalf("Failed to load blocklist: %v", err)
+	}
+	if url := os.Getenv("BLOCKLIST_URL"); url != "" {
+		every := envDuration("BLOCKLIST_REFRESH")
+		if every <= 0 {
+			every = time.Hour
+		}
+		sched.Add((&blocklist.Refresher{List: blocked, URL: url, Every: every}).Run)
+	}
+	if snapshots := loadSnapshotter(database, storageDir); snapshots != nil {
+		sched.Add(snapshots.Run)
+	}
+	if digest := loadDigest(database); digest != nil {
+		sched.Add(digest.Run)
+	}
+	sla := loadSLA(database, dataDir)
+	if sla != nil {
+		sched.Add(sla.Run)
+	}
+	go sched.Run()
+
 	// Initialize Handlers
 	handler := handlers.New(database, storageDir, hub)
+	handler.AdminToken = os.Getenv("ADMIN_TOKEN")
+	handler.AccessLog = loadAccessLog()
+	handler.Limits = loadRateLimits()
+	handler.Lockout = loadLockout()
+	handler.AuditLog = loadAuditLog()
+	handler.Compress = os.Getenv("COMPRESSION") != "off"
+	handler.AnonKey = os.Getenv("ANON_KEY")
+	handler.ServiceRoleKey = os.Getenv("SERVICE_ROLE_KEY")
+	handler.Auth = verifier
+	handler.SessionTokens = sessionTokens
+	handler.Policy = policies
+	if verifier != nil && verifier.Secret != nil {
+		if handler.Users, err = auth.OpenUsers(filepath.Join(dataDir, "auth_users.json"), cipher); err != nil {
+			log.Fatal(err)
+		}
+		handler.AccessTTL = envDuration("JWT_EXPIRY")
+		handler.OAuth = loadOAuth()
+		handler.SiteURL = os.Getenv("AUTH_SITE_URL")
+		handler.RedirectURLs = splitList(os.Getenv("AUTH_REDIRECT_URLS"))
+		handler.OAuthCallbackURL = os.Getenv("OAUTH_CALLBACK_URL")
+		handler.ExternalURL = os.Getenv("API_EXTERNAL_URL")
+		if len(handler.OAuth) > 0 && handler.SiteURL == "" {
+			log.Fatal("AUTH_SITE_URL is required for OAuth sign-in")
+		}
+		if os.Getenv("AUTH_EMAIL_SIGN_IN") == "true" {
+			if handler.Mailer = loadSMTP(); handler.Mailer == nil {
+				log.Fatal("AUTH_EMAIL_SIGN_IN needs SMTP_ADDR")
+			}
+			if handler.SiteURL == "" {
+				log.Fatal("AUTH_SITE_URL is required for email sign-in")
+			}
+			// The emailed links must not be built from the Host header,
+			// which whoever asks for a link controls.
+			if handler.ExternalURL == "" {
+				log.Fatal("API_EXTERNAL_URL is required for email sign-in")
+			}
+		}
+	}
+	if secret := os.Getenv("SIGNING_SECRET"); secret != "" {
+		handler.Signing = signing.New([]byte(secret), envDuration("SIGNING_TOLERANCE"))
+	}
+	handler.Automations = automations
+	handler.SLA = sla
+	handler.MediaMaxAge = envDuration("MEDIA_CACHE_MAX_AGE")
+	handler.CDNPurgeURL = os.Getenv("CDN_PURGE_URL")
+	handler.Cipher = cipher
+	handler.JoinURLTemplate = os.Getenv("JOIN_URL_TEMPLATE")
+	handler.TrustProxy = os.Getenv("TRUST_PROXY") == "true"
+	handler.Blocklist = blocked
+	handler.FlagFiltered = os.Getenv("BLOCKLIST_ACTION") == "flag"
+	if v := os.Getenv("MAX_ROWS"); v != "" {
+		if handler.MaxRows, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("Invalid MAX_ROWS: %v", err)
+		}
+	}
+	if v := os.Getenv("MAX_BODY_BYTES"); v != "" {
+		if handler.MaxBodyBytes, err = strconv.ParseInt(v, 10, 64); err != nil {
+			log.Fatalf("Invalid MAX_BODY_BYTES: %v", err)
+		}
+	}
+	if v := os.Getenv("MAX_UPLOAD_BYTES"); v != "" {
+		if handler.MaxUploadBytes, err = strconv.ParseInt(v, 10, 64); err != nil {
+			log.Fatalf("Invalid MAX_UPLOAD_BYTES: %v", err)
+		}
+	}
+	if path := os.Getenv("TICKET_CONFIG"); path != "" {
+		if handler.Tickets, err = ticket.LoadConfig(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if path := os.Getenv("PAYMENT_CONFIG"); path != "" {
+		if handler.Payments, err = payment.LoadConfig(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if path := os.Getenv("CALENDAR_CONFIG"); path != "" {
+		if handler.Calendar, err = calendar.LoadConfig(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if url := os.Getenv("IDENTITY_JWKS_URL"); url != "" {
+		handler.Identity = identity.New(url)
+		handler.Identity.Issuer = os.Getenv("IDENTITY_ISSUER")
+		handler.Identity.Audience = os.Getenv("IDENTITY_AUDIENCE")
+	}
+	if path := os.Getenv("GEOIP_DB"); path != "" {
+		resolver, err := geoip.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open GEOIP_DB: %v", err)
+		}
+		defer resolver.Close()
+		resolver.City = os.Getenv("GEOIP_PRECISION") == "city"
+		handler.GeoIP = resolver
+	}
 
 	// Server
 	port := "8000"
@@ -51,6 +808,14 @@ func main() {
 	fmt.Printf("Data directory: %s\n", dataDir)
 	fmt.Printf("Storage directory: %s\n", storageDir)
 
+	if *lanMode {
+		stop, err := startLAN(port)
+		if err != nil {
+			log.Fatalf("LAN mode: %v", err)
+		}
+		defer stop()
+	}
+
 	if err := http.ListenAndServe(":"+port, handler); err != nil {
 		log.Fatal(err)
 	}
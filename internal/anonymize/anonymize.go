@@ -0,0 +1,300 @@
+// Package anonymize writes copies of a data directory with the personal
+// data replaced by fakes, for staging environments and bug reports.
+package anonymize
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Result summarises an anonymized copy.
+type Result struct {
+	Sessions int
+	Messages int
+	Names    int
+	Emails   int
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// IP candidates are confirmed with net.ParseIP, so clock times and
+	// version numbers stay.
+	ipv4Pattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Pattern = regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`)
+	// Phone numbers in international form, with an area code in
+	// parentheses, or grouped 3-3-4; dates and IDs don't match.
+	phonePattern = regexp.MustCompile(`\+\d[\d \-().]{6,}\d|\(\d{2,4}\)[\d \-.]{5,}\d|\b\d{3}[ .-]\d{3,4}[ .-]\d{3,4}\b`)
+)
+
+// Copy writes the data of src to dir, which must not exist or be empty,
+// with display names, emails, IPs, external user IDs and message text
+// replaced. Each real name or email maps to the same fake everywhere, so
+// conversations keep their shape: who talked to whom, how often, and how
+// long the messages were. IDs, timestamps and file URLs are kept (media is
+// not copied), the outbox is left empty and the copy is not encrypted.
+// key seeds the fakes; the same key gives the same fakes.
+func Copy(src *db.Database, dir string, key []byte) (Result, error) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return Result{}, fmt.Errorf("%s is not empty", dir)
+	}
+
+	out := db.New(dir)
+	if err := out.Load(); err != nil {
+		return Result{}, err
+	}
+	err := src.ReadLocked(func() error {
+		out.Sessions = append([]db.ChatSession(nil), src.Sessions...)
+		out.Messages = append([]db.Message(nil), src.Messages...)
+		out.Participants = append([]db.Participant(nil), src.Participants...)
+		out.Flags = append([]db.Flag(nil), src.Flags...)
+		out.Reactions = append([]db.Reaction(nil), src.Reactions...)
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	a := &anonymizer{f: newFaker(key)}
+	a.collectNames(out)
+	for i := range out.Sessions {
+		a.session(&out.Sessions[i])
+	}
+	for i := range out.Messages {
+		a.message(&out.Messages[i])
+	}
+	for i := range out.Participants {
+		out.Participants[i].DisplayName = a.f.name(out.Participants[i].DisplayName)
+	}
+	for i := range out.Reactions {
+		out.Reactions[i].SenderName = a.f.name(out.Reactions[i].SenderName)
+	}
+	for i := range out.Flags {
+		a.flag(&out.Flags[i])
+	}
+
+	if err := out.Save(); err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Sessions: len(out.Sessions),
+		Messages: len(out.Messages),
+		Names:    len(a.f.names),
+		Emails:   len(a.f.emails),
+	}, nil
+}
+
+type anonymizer struct {
+	f *faker
+	// known matches the real names in free text, longest first.
+	known *regexp.Regexp
+}
+
+// collectNames assigns fakes to every name stored in a name field first, so
+// that mentions of those names in free text can be replaced as well.
+func (a *anonymizer) collectNames(d *db.Database) {
+	seen := make(map[string]bool)
+	add := func(s *string) {
+		if s != nil && *s != "" {
+			seen[*s] = true
+		}
+	}
+	for _, s := range d.Sessions {
+		add(s.CreatedBy)
+		add(s.AssignedTo)
+	}
+	for _, m := range d.Messages {
+		add(m.SenderName)
+		if m.SenderIdentity != nil {
+			add(&m.SenderIdentity.Name)
+		}
+	}
+	for _, p := range d.Participants {
+		add(&p.DisplayName)
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	// Sorted so fakes are handed out in the same order on every run.
+	sort.Strings(names)
+	for _, n := range names {
+		a.f.name(n)
+	}
+
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	var quoted []string
+	for _, n := range names {
+		// Very short names ("A", "me") would match inside ordinary words.
+		if len(n) >= 3 {
+			quoted = append(quoted, regexp.QuoteMeta(n))
+		}
+	}
+	if len(quoted) > 0 {
+		a.known = regexp.MustCompile(`\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+}
+
+// scrub replaces known names, emails, IPs and phone numbers in free text.
+func (a *anonymizer) scrub(s string) string {
+	if a.known != nil {
+		s = a.known.ReplaceAllStringFunc(s, a.f.name)
+	}
+	s = emailPattern.ReplaceAllStringFunc(s, a.f.email)
+	s = ipv6Pattern.ReplaceAllStringFunc(s, a.ip)
+	s = ipv4Pattern.ReplaceAllStringFunc(s, a.ip)
+	return phonePattern.ReplaceAllStringFunc(s, a.f.phone)
+}
+
+func (a *anonymizer) ip(s string) string {
+	if net.ParseIP(s) == nil {
+		return s
+	}
+	return a.f.ip(s)
+}
+
+func (a *anonymizer) scrubPtr(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := a.scrub(*s)
+	return &v
+}
+
+func (a *anonymizer) namePtr(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := a.f.name(*s)
+	return &v
+}
+
+// value anonymizes decoded JSON, choosing the fake by key where the key
+// says what the value is and scrubbing other strings.
+func (a *anonymizer) value(key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			out[k] = a.value(k, x)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, x := range v {
+			out[i] = a.value(key, x)
+		}
+		return out
+	case string:
+		k := strings.ToLower(key)
+		if k == "id" || strings.HasSuffix(k, "_id") {
+			return v
+		}
+		if _, err := time.Parse(time.RFC3339, v); err == nil {
+			return v
+		}
+		switch {
+		case strings.Contains(k, "email"):
+			return a.f.email(v)
+		case strings.Contains(k, "phone"):
+			return a.f.phone(v)
+		case k == "ip" || strings.HasSuffix(k, "_ip") || strings.HasPrefix(k, "ip_"):
+			return a.f.ip(v)
+		case strings.Contains(k, "name") && !strings.Contains(k, "file"):
+			return a.f.name(v)
+		}
+		return a.scrub(v)
+	}
+	return v
+}
+
+func (a *anonymizer) object(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return a.value("", m).(map[string]interface{})
+}
+
+func (a *anonymizer) raw(r json.RawMessage) json.RawMessage {
+	if len(r) == 0 {
+		return r
+	}
+	var v interface{}
+	if err := json.Unmarshal(r, &v); err != nil {
+		return nil
+	}
+	out, err := json.Marshal(a.value("", v))
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func (a *anonymizer) session(s *db.ChatSession) {
+	if s.Title != nil {
+		t := a.f.text(s.ID, *s.Title)
+		s.Title = &t
+	}
+	s.CreatedBy = a.namePtr(s.CreatedBy)
+	s.AssignedTo = a.namePtr(s.AssignedTo)
+	s.Metadata = a.object(s.Metadata)
+	if s.Geo != nil {
+		// The country is coarse enough to keep; the city is not.
+		g := *s.Geo
+		g.City = ""
+		s.Geo = &g
+	}
+}
+
+func (a *anonymizer) message(m *db.Message) {
+	if m.Content != nil {
+		var c string
+		if m.MessageType == db.MessageTypeSystem {
+			// Server notices are kept readable: only the people in them
+			// change.
+			c = a.scrub(*m.Content)
+		} else {
+			c = a.f.text(m.ID, *m.Content)
+		}
+		m.Content = &c
+	}
+	m.SenderName = a.namePtr(m.SenderName)
+	if m.SenderIdentity != nil {
+		m.SenderIdentity = &db.SenderIdentity{
+			ExternalID: a.f.externalID(m.SenderIdentity.ExternalID),
+			Email:      a.f.email(m.SenderIdentity.Email),
+			Name:       a.f.name(m.SenderIdentity.Name),
+		}
+	}
+	m.Metadata = a.raw(m.Metadata)
+	if m.Event != nil {
+		m.Event = &db.SystemEvent{
+			Kind:  m.Event.Kind,
+			Actor: a.namePtr(m.Event.Actor),
+			Data:  a.object(m.Event.Data),
+		}
+	}
+	if m.Schedule != nil {
+		sched := *m.Schedule
+		sched.SelectedBy = a.namePtr(sched.SelectedBy)
+		m.Schedule = &sched
+	}
+}
+
+func (a *anonymizer) flag(fl *db.Flag) {
+	fl.Reason = a.scrubPtr(fl.Reason)
+	fl.AssignedTo = a.namePtr(fl.AssignedTo)
+	history := make([]db.FlagEvent, len(fl.History))
+	for i, e := range fl.History {
+		e.Actor = a.namePtr(e.Actor)
+		e.Note = a.scrubPtr(e.Note)
+		history[i] = e
+	}
+	fl.History = history
+}
@@ -0,0 +1,184 @@
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+var firstNames = []string{
+	"Ada", "Alex", "Amara", "Anna", "Ben", "Carla", "Chen", "Dana", "David", "Elena",
+	"Emil", "Fatima", "Felix", "Grace", "Hana", "Hugo", "Ines", "Ivan", "Jade", "Jonas",
+	"Kai", "Kemal", "Lara", "Leo", "Lina", "Luca", "Maya", "Mateo", "Mila", "Nadia",
+	"Noah", "Olga", "Omar", "Paula", "Priya", "Quinn", "Rafael", "Rosa", "Sami", "Sara",
+	"Theo", "Tomas", "Uma", "Victor", "Wen", "Yara", "Yusuf", "Zoe",
+}
+
+var lastNames = []string{
+	"Abbott", "Alvarez", "Berg", "Brennan", "Castillo", "Dahl", "Dubois", "Eriksen", "Fischer", "Garcia",
+	"Haddad", "Horvat", "Ito", "Jansen", "Kaur", "Kowalski", "Larsen", "Lopez", "Martin", "Moreau",
+	"Nakamura", "Novak", "Okafor", "Olsen", "Park", "Petrov", "Quinn", "Rossi", "Santos", "Schmidt",
+	"Silva", "Tanaka", "Torres", "Varga", "Weber", "Wong", "Yilmaz", "Zhang",
+}
+
+// words make up fake message text. They are common support-chat words so
+// that search and length-dependent rendering behave as with real data.
+var words = []string{
+	"the", "a", "to", "and", "is", "it", "my", "your", "for", "on", "with", "can", "you", "we", "this",
+	"order", "account", "help", "thanks", "please", "issue", "problem", "payment", "delivery", "refund",
+	"login", "password", "email", "update", "check", "still", "again", "working", "today", "tomorrow",
+	"yesterday", "support", "question", "invoice", "subscription", "plan", "price", "change", "cancel",
+	"send", "receive", "number", "details", "page", "app", "error", "screen", "link", "code", "time",
+	"sure", "great", "okay", "sorry", "understand", "wait", "minute", "look", "into", "fixed", "now",
+}
+
+// faker derives fake values from real ones with a keyed hash, so the same
+// input always gets the same fake within one run (one key) and the fakes
+// can't be reversed by hashing guesses. Names and emails are kept unique:
+// two people never share a fake.
+type faker struct {
+	key    []byte
+	names  map[string]string
+	emails map[string]string
+	ids    map[string]string
+	ips    map[string]string
+	used   map[string]bool
+}
+
+func newFaker(key []byte) *faker {
+	return &faker{
+		key:    key,
+		names:  make(map[string]string),
+		emails: make(map[string]string),
+		ids:    make(map[string]string),
+		ips:    make(map[string]string),
+		used:   make(map[string]bool),
+	}
+}
+
+func (f *faker) hash(kind, s string) uint64 {
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write([]byte(kind + "\x00" + s))
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// unique returns candidate(0), or candidate(1), ... whichever is unused.
+func (f *faker) unique(candidate func(n int) string) string {
+	for n := 0; ; n++ {
+		if c := candidate(n); !f.used[c] {
+			f.used[c] = true
+			return c
+		}
+	}
+}
+
+// name maps a display name to a fake "First Last".
+func (f *faker) name(real string) string {
+	if real == "" {
+		return ""
+	}
+	if fake, ok := f.names[real]; ok {
+		return fake
+	}
+	h := f.hash("name", real)
+	first := firstNames[h%uint64(len(firstNames))]
+	last := lastNames[(h>>16)%uint64(len(lastNames))]
+	fake := f.unique(func(n int) string {
+		if n == 0 {
+			return first + " " + last
+		}
+		return fmt.Sprintf("%s %s %d", first, last, n+1)
+	})
+	f.names[real] = fake
+	return fake
+}
+
+func (f *faker) email(real string) string {
+	if real == "" {
+		return ""
+	}
+	key := strings.ToLower(real)
+	if fake, ok := f.emails[key]; ok {
+		return fake
+	}
+	h := f.hash("email", key)
+	local := strings.ToLower(firstNames[h%uint64(len(firstNames))] + "." + lastNames[(h>>16)%uint64(len(lastNames))])
+	fake := f.unique(func(n int) string {
+		if n == 0 {
+			return local + "@example.com"
+		}
+		return fmt.Sprintf("%s%d@example.com", local, n+1)
+	})
+	f.emails[key] = fake
+	return fake
+}
+
+// externalID maps an integrator's user ID to an opaque one.
+func (f *faker) externalID(real string) string {
+	if real == "" {
+		return ""
+	}
+	if fake, ok := f.ids[real]; ok {
+		return fake
+	}
+	fake := fmt.Sprintf("user-%012x", f.hash("id", real)>>16)
+	f.ids[real] = fake
+	return fake
+}
+
+// ip maps an address into the documentation ranges (RFC 5737, RFC 3849).
+func (f *faker) ip(real string) string {
+	if fake, ok := f.ips[real]; ok {
+		return fake
+	}
+	h := f.hash("ip", real)
+	var fake string
+	if strings.Contains(real, ":") {
+		fake = f.unique(func(n int) string { return fmt.Sprintf("2001:db8::%x", (h+uint64(n))&0xffff) })
+	} else {
+		nets := []string{"192.0.2", "198.51.100", "203.0.113"}
+		fake = f.unique(func(n int) string {
+			v := h + uint64(n)
+			return fmt.Sprintf("%s.%d", nets[v%3], 1+(v>>8)%254)
+		})
+	}
+	f.ips[real] = fake
+	return fake
+}
+
+func (f *faker) phone(real string) string {
+	return fmt.Sprintf("+1 555 01%02d", f.hash("phone", real)%100)
+}
+
+// text replaces a message with fake words of about the same length,
+// derived from seed (the message ID) so reruns with the same key match.
+func (f *faker) text(seed, real string) string {
+	if real == "" {
+		return ""
+	}
+	n := len(strings.Fields(real))
+	if n == 0 {
+		n = 1
+	}
+	var b strings.Builder
+	h := f.hash("text", seed)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		h = h*6364136223846793005 + 1442695040888963407
+		w := words[(h>>33)%uint64(len(words))]
+		if i == 0 {
+			w = strings.ToUpper(w[:1]) + w[1:]
+		}
+		b.WriteString(w)
+	}
+	if strings.HasSuffix(strings.TrimSpace(real), "?") {
+		b.WriteByte('?')
+	} else {
+		b.WriteByte('.')
+	}
+	return b.String()
+}
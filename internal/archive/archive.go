@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"archive/zip"
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/encryption"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Layout of a session archive:
+//
+//	session.json             the chat_sessions row
+//	messages.json            the messages, oldest first (or messages.csv)
+//	media/<storage path>     every locally stored file referenced by file_url
+const (
+	SessionFile     = "session.json"
+	MessagesFile    = "messages.json"
+	MessagesCSVFile = "messages.csv"
+	MediaDir        = "media/"
+	FormatJSON      = "json"
+	FormatCSV       = "csv"
+)
+
+// CSVHeader is the column order used for messages.csv.
+var CSVHeader = []string{"id", "session_id", "created_at", "sender_name", "origin", "message_type", "content", "file_url", "metadata", "event", "parent_message_id"}
+
+// WriteSession writes a zip archive of one conversation and its media,
+// decrypting stored media with c. Missing media files are skipped rather
+// than failing the export.
+func WriteSession(w io.Writer, session *db.ChatSession, messages []db.Message, storageDir, format string, c *encryption.Cipher) error {
+	zw := zip.NewWriter(w)
+
+	if err := writeJSON(zw, SessionFile, session); err != nil {
+		return err
+	}
+	if format == FormatCSV {
+		if err := writeCSV(zw, messages); err != nil {
+			return err
+		}
+	} else if err := writeJSON(zw, MessagesFile, messages); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range messages {
+		if m.FileURL == nil {
+			continue
+		}
+		rel, ok := db.MediaPath(*m.FileURL)
+		if !ok || seen[rel] {
+			continue
+		}
+		seen[rel] = true
+		if err := addFile(zw, MediaDir+rel, filepath.Join(storageDir, filepath.FromSlash(rel)), c); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeCSV(zw *zip.Writer, messages []db.Message) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: MessagesCSVFile, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+	for _, m := range messages {
+		row := []string{
+			m.ID,
+			m.SessionID,
+			m.CreatedAt.Format(time.RFC3339Nano),
+			deref(m.SenderName),
+			deref(m.Origin),
+			m.MessageType,
+			deref(m.Content),
+			deref(m.FileURL),
+			string(m.Metadata),
+			eventJSON(m.Event),
+			deref(m.ParentMessageID),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func addFile(zw *zip.Writer, name, path string, c *encryption.Cipher) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := c.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	// Media is usually already compressed.
+	hdr.Method = zip.Store
+	dst, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(data)
+	return err
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func eventJSON(e *db.SystemEvent) string {
+	if e == nil {
+		return ""
+	}
+	data, _ := json.Marshal(e)
+	return string(data)
+}
@@ -0,0 +1,290 @@
+package archive
+
+import (
+	"archive/zip"
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/encryption"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ImportOptions controls how file URLs are rewritten during an import.
+type ImportOptions struct {
+	// BaseURL is this server's public origin, e.g. "https://chat.example.com".
+	// Imported file_url values are rewritten to BaseURL + the public media
+	// path. Empty produces root-relative URLs.
+	BaseURL string
+	// MediaDir, when set, is searched for files referenced by a bare
+	// messages dump (archives carry their own media).
+	MediaDir string
+	// Cipher encrypts media copied into storage, matching the server's
+	// encryption-at-rest setting.
+	Cipher *encryption.Cipher
+}
+
+// Import reads a session archive (.zip), or a Supabase dump of the messages
+// table (.json array or .csv with a header row), into the database. Media is
+// copied into storageDir and file URLs pointing at any chat-media bucket are
+// remapped to this server.
+func Import(database *db.Database, storageDir, path string, opts ImportOptions) (db.ImportResult, error) {
+	var sessions []db.ChatSession
+	var messages []db.Message
+	var media map[string]*zip.File
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip":
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return db.ImportResult{}, err
+		}
+		defer zr.Close()
+		session, msgs, files, err := readArchive(&zr.Reader)
+		if err != nil {
+			return db.ImportResult{}, err
+		}
+		if session != nil {
+			sessions = append(sessions, *session)
+		}
+		messages, media = msgs, files
+	case ".json":
+		f, err := os.Open(path)
+		if err != nil {
+			return db.ImportResult{}, err
+		}
+		defer f.Close()
+		if err := json.NewDecoder(f).Decode(&messages); err != nil {
+			return db.ImportResult{}, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".csv":
+		f, err := os.Open(path)
+		if err != nil {
+			return db.ImportResult{}, err
+		}
+		defer f.Close()
+		if messages, err = ReadMessagesCSV(f); err != nil {
+			return db.ImportResult{}, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return db.ImportResult{}, fmt.Errorf("unsupported import file %q (want .zip, .json or .csv)", path)
+	}
+
+	base := strings.TrimSuffix(opts.BaseURL, "/")
+	seen := make(map[string]bool)
+	for i := range messages {
+		m := &messages[i]
+		// Messages database.Import will skip as duplicates bring no media.
+		duplicate := m.ID != "" && seen[m.ID]
+		if !duplicate && m.ID != "" {
+			_, err := database.GetMessage(m.ID)
+			duplicate = err == nil
+		}
+		seen[m.ID] = true
+		if m.FileURL == nil || m.SessionID == "" || duplicate {
+			continue
+		}
+		rel, ok := db.MediaPath(*m.FileURL)
+		if !ok {
+			continue
+		}
+		rel, err := copyMedia(storageDir, rel, media, opts.MediaDir, opts.Cipher)
+		if err != nil {
+			return db.ImportResult{}, err
+		}
+		url := base + db.PublicMediaPrefix + rel
+		m.FileURL = &url
+	}
+
+	return database.Import(sessions, messages)
+}
+
+func readArchive(zr *zip.Reader) (*db.ChatSession, []db.Message, map[string]*zip.File, error) {
+	var session *db.ChatSession
+	var messages []db.Message
+	media := make(map[string]*zip.File)
+
+	for _, f := range zr.File {
+		switch {
+		case f.Name == SessionFile:
+			session = &db.ChatSession{}
+			if err := decodeZipJSON(f, session); err != nil {
+				return nil, nil, nil, err
+			}
+		case f.Name == MessagesFile:
+			if err := decodeZipJSON(f, &messages); err != nil {
+				return nil, nil, nil, err
+			}
+		case f.Name == MessagesCSVFile:
+			rc, err := f.Open()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			messages, err = ReadMessagesCSV(rc)
+			rc.Close()
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+		case strings.HasPrefix(f.Name, MediaDir):
+			media[strings.TrimPrefix(f.Name, MediaDir)] = f
+		}
+	}
+	return session, messages, media, nil
+}
+
+func decodeZipJSON(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// ReadMessagesCSV parses messages from CSV with a header row. Columns are
+// matched by name, so both our own export and a Supabase table export work;
+// empty cells become null.
+func ReadMessagesCSV(r io.Reader) ([]db.Message, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	get := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	optional := func(row []string, name string) *string {
+		if v := get(row, name); v != "" {
+			return &v
+		}
+		return nil
+	}
+
+	var messages []db.Message
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return messages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := db.Message{
+			ID:              get(row, "id"),
+			SessionID:       get(row, "session_id"),
+			Content:         optional(row, "content"),
+			MessageType:     get(row, "message_type"),
+			FileURL:         optional(row, "file_url"),
+			SenderName:      optional(row, "sender_name"),
+			Origin:          optional(row, "origin"),
+			ParentMessageID: optional(row, "parent_message_id"),
+		}
+		if v := get(row, "metadata"); v != "" {
+			if !json.Valid([]byte(v)) {
+				return nil, fmt.Errorf("message %s: metadata is not valid JSON", m.ID)
+			}
+			m.Metadata = json.RawMessage(v)
+		}
+		if v := get(row, "event"); v != "" {
+			if err := json.Unmarshal([]byte(v), &m.Event); err != nil {
+				return nil, fmt.Errorf("message %s: event: %w", m.ID, err)
+			}
+		}
+		if ts := get(row, "created_at"); ts != "" {
+			if m.CreatedAt, err = parseTimestamp(ts); err != nil {
+				return nil, fmt.Errorf("message %s: %w", m.ID, err)
+			}
+		}
+		messages = append(messages, m)
+	}
+}
+
+// parseTimestamp accepts RFC 3339 and the "2006-01-02 15:04:05.999999+00"
+// form Postgres uses in CSV dumps.
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07", "2006-01-02 15:04:05.999999999-07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", s)
+}
+
+// copyMedia places rel into storageDir, from the archive when it carries the
+// file, otherwise from mediaDir, and returns the path it was stored under.
+// A file already at rel is never overwritten: the copy gets a numbered name
+// instead ("photo-2.jpg"). Files that can't be found are left for the
+// operator to copy by hand.
+func copyMedia(storageDir, rel string, media map[string]*zip.File, mediaDir string, c *encryption.Cipher) (string, error) {
+	var src io.ReadCloser
+	if f, ok := media[rel]; ok {
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		src = rc
+	} else if mediaDir != "" {
+		f, err := os.Open(filepath.Join(mediaDir, filepath.FromSlash(rel)))
+		if os.IsNotExist(err) {
+			return rel, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		src = f
+	} else {
+		return rel, nil
+	}
+	defer src.Close()
+
+	plain, err := io.ReadAll(src)
+	if err != nil {
+		return "", err
+	}
+	data, err := c.Seal(plain)
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(storageDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	ext := path.Ext(rel)
+	stem := strings.TrimSuffix(rel, ext)
+	for n := 2; ; n++ {
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			rel = fmt.Sprintf("%s-%d%s", stem, n, ext)
+			dest = filepath.Join(storageDir, filepath.FromSlash(rel))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			os.Remove(dest)
+			return "", err
+		}
+		if err := f.Close(); err != nil {
+			os.Remove(dest)
+			return "", err
+		}
+		return rel, nil
+	}
+}
@@ -0,0 +1,37 @@
+package archive
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"strings"
+	"time"
+)
+
+// Transcript renders messages as plain text, one line per message:
+//
+//	2024-05-01 09:30:12 UTC  Alice: hello
+//
+// Attachments are shown by URL; system messages have no sender.
+func Transcript(messages []db.Message) string {
+	var b strings.Builder
+	for _, m := range messages {
+		b.WriteString(m.CreatedAt.UTC().Format(time.DateTime + " UTC"))
+		b.WriteString("  ")
+		if m.MessageType != db.MessageTypeSystem {
+			name := deref(m.SenderName)
+			if name == "" {
+				name = "Visitor"
+			}
+			b.WriteString(name + ": ")
+		}
+		text := deref(m.Content)
+		if m.FileURL != nil {
+			if text != "" {
+				text += " "
+			}
+			text += "[" + *m.FileURL + "]"
+		}
+		b.WriteString(strings.ReplaceAll(text, "\n", "\n    "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
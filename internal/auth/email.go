@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailLinkTTL is how long a sign-in link stays valid, and EmailResendAfter
+// how long an address waits before it can be sent another.
+const (
+	EmailLinkTTL     = time.Hour
+	EmailResendAfter = time.Minute
+)
+
+// Email sign-in errors, as GoTrue words them.
+var (
+	ErrInvalidEmail  = errors.New("Unable to validate email address: invalid format")
+	ErrSignupsClosed = errors.New("Signups not allowed for otp")
+	ErrResendTooSoon = errors.New("For security purposes, you can only request this once every 60 seconds")
+	ErrLinkInvalid   = errors.New("Email link is invalid or has expired")
+)
+
+// emailLink is a sign-in link sent to Email, by the SHA-256 of its token.
+// It can be used once.
+type emailLink struct {
+	Hash  string `json:"hash"`
+	Email string `json:"email"`
+	// Metadata becomes the user_metadata of a user the link signs up.
+	Metadata  json.RawMessage `json:"metadata,omitempty"`
+	Used      bool            `json:"used"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// NormalizeEmail returns address lowercased, or ErrInvalidEmail unless it
+// is a bare address such as "ada@example.com".
+func NormalizeEmail(address string) (string, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != address || !strings.Contains(address[strings.LastIndex(address, "@"):], ".") {
+		return "", ErrInvalidEmail
+	}
+	return address, nil
+}
+
+// IssueEmailLink returns the token of a new sign-in link for email. With
+// createUser false it fails with ErrSignupsClosed unless a user already has
+// that address; metadata is the user_metadata of a user it signs up.
+func (u *Users) IssueEmailLink(email string, metadata json.RawMessage, createUser bool, now time.Time) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.indexEmail(email) < 0 && !createUser {
+		return "", ErrSignupsClosed
+	}
+	for _, l := range u.links {
+		if l.Email == email && now.Sub(l.CreatedAt) < EmailResendAfter {
+			return "", ErrResendTooSoon
+		}
+	}
+	token := newToken()
+	u.links = append(u.links, emailLink{Hash: hashToken(token), Email: email, Metadata: metadata, CreatedAt: now})
+	if err := u.save(now); err != nil {
+		u.links = u.links[:len(u.links)-1]
+		return "", err
+	}
+	return token, nil
+}
+
+// SignInWithEmailLink uses up the sign-in link token and starts an auth
+// session for the user with its address, creating the user on first
+// sign-in. A user who signed in with an OAuth provider under the same
+// verified address is the same user. Used and expired links fail with
+// ErrLinkInvalid.
+func (u *Users) SignInWithEmailLink(token string, now time.Time) (User, string, string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	hash := hashToken(token)
+	var link *emailLink
+	for i := range u.links {
+		if l := &u.links[i]; l.Hash == hash && !l.Used && now.Sub(l.CreatedAt) < EmailLinkTTL {
+			link = l
+		}
+	}
+	if link == nil {
+		return User{}, "", "", ErrLinkInvalid
+	}
+	link.Used = true
+
+	idx := u.indexEmail(link.Email)
+	if idx < 0 {
+		u.users = append(u.users, User{ID: uuid.New().String(), Provider: "email", Email: link.Email, UserMetadata: link.Metadata, CreatedAt: now})
+		idx = len(u.users) - 1
+	}
+	user := &u.users[idx]
+	hasEmail := false
+	for _, id := range user.Identities {
+		hasEmail = hasEmail || id.Provider == "email"
+	}
+	if !hasEmail {
+		// The name the user signed up with, if any, names them as a
+		// sender; the address does otherwise.
+		var named struct {
+			Name string `json:"name"`
+		}
+		json.Unmarshal(link.Metadata, &named)
+		user.Identities = append(user.Identities, Identity{Provider: "email", ProviderID: user.ID, Name: named.Name, Email: link.Email, CreatedAt: now, UpdatedAt: now})
+	}
+	user.UpdatedAt, user.LastSignInAt = now, &now
+
+	sessionID := uuid.New().String()
+	refresh := u.issue(user.ID, sessionID, now)
+	if err := u.save(now); err != nil {
+		return User{}, "", "", err
+	}
+	return *user, sessionID, refresh, nil
+}
+
+// indexEmail is the index of the user with email, or -1.
+func (u *Users) indexEmail(email string) int {
+	for i, user := range u.users {
+		if !user.IsAnonymous && strings.EqualFold(user.Email, email) {
+			return i
+		}
+	}
+	return -1
+}
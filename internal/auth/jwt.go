@@ -0,0 +1,148 @@
+// Package auth verifies Supabase-compatible access tokens: JWTs signed
+// with the project's shared secret (HS256) or with keys published as a
+// JWKS, carrying the user in sub and the database role in role.
+package auth
+
+import (
+	"chat-quick-chat-server/internal/identity"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalid is returned for tokens that are malformed, expired, wrongly
+// signed or meant for someone else.
+var ErrInvalid = errors.New("invalid JWT")
+
+// leeway absorbs clock skew when checking exp and nbf.
+const leeway = time.Minute
+
+// Claims are the parts of a Supabase access token the server acts on.
+type Claims struct {
+	Subject string `json:"sub"`
+	// Role is anon, authenticated or service_role.
+	Role        string          `json:"role"`
+	Email       string          `json:"email,omitempty"`
+	SessionID   string          `json:"session_id,omitempty"`
+	IsAnonymous bool            `json:"is_anonymous,omitempty"`
+	Issuer      string          `json:"iss,omitempty"`
+	Audience    json.RawMessage `json:"aud,omitempty"`
+	ExpiresAt   *float64        `json:"exp,omitempty"`
+	NotBefore   *float64        `json:"nbf,omitempty"`
+	IssuedAt    *float64        `json:"iat,omitempty"`
+	// All holds every claim, for policies that look at custom ones.
+	All map[string]interface{} `json:"-"`
+}
+
+// Verifier checks access tokens against Secret (HS256), Keys (the
+// asymmetric algorithms identity.KeySet accepts), or both.
+type Verifier struct {
+	Secret []byte
+	Keys   *identity.KeySet
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks token and returns its claims. Tokens must carry exp and a
+// role.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	signed := parts[0] + "." + parts[1]
+	switch {
+	case h.Alg == "HS256" && v.Secret != nil:
+		if !hmac.Equal(sig, hs256(v.Secret, signed)) {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalid)
+		}
+	case h.Alg != "HS256" && v.Keys != nil:
+		if err := v.Keys.Check(h.Alg, h.Kid, signed, sig); err != nil {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalid)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalid, h.Alg)
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, ErrInvalid
+	}
+	decodeSegment(parts[1], &c.All)
+	now := time.Now()
+	switch {
+	case c.ExpiresAt == nil || c.Role == "":
+		return nil, fmt.Errorf("%w: exp and role are required", ErrInvalid)
+	case now.After(unixTime(*c.ExpiresAt).Add(leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalid)
+	case c.NotBefore != nil && now.Add(leeway).Before(unixTime(*c.NotBefore)):
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalid)
+	case v.Issuer != "" && c.Issuer != v.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalid)
+	case v.Audience != "" && !hasAudience(c.Audience, v.Audience):
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalid)
+	}
+	return &c, nil
+}
+
+// Expiry is when Verify stops accepting the token the claims came from.
+func (c *Claims) Expiry() time.Time {
+	if c.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return unixTime(*c.ExpiresAt).Add(leeway)
+}
+
+func hs256(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func unixTime(f float64) time.Time {
+	return time.Unix(int64(f), 0)
+}
+
+// hasAudience reports whether the aud claim, a string or an array of
+// strings, contains want.
+func hasAudience(raw json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == want
+	}
+	var many []string
+	json.Unmarshal(raw, &many)
+	for _, a := range many {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"chat-quick-chat-server/internal/outbound"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StateTTL is how long a sign-in may take between /authorize and the
+// provider sending the user back.
+const StateTTL = 10 * time.Minute
+
+// ErrBadState is returned for OAuth states that are forged, expired or
+// from another browser.
+var ErrBadState = errors.New("OAuth state is invalid or expired")
+
+// Identity is a user's account at an OAuth provider.
+type Identity struct {
+	Provider string `json:"provider"`
+	// ProviderID is the account's ID at the provider, which never changes;
+	// Login, Name and Email may.
+	ProviderID string    `json:"provider_id"`
+	Login      string    `json:"login,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Email      string    `json:"email,omitempty"`
+	AvatarURL  string    `json:"avatar_url,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// DisplayName is the name to show for the account.
+func (id Identity) DisplayName() string {
+	if id.Name != "" {
+		return id.Name
+	}
+	if id.Login != "" {
+		return id.Login
+	}
+	return id.Email
+}
+
+// OAuthProvider signs users in with the OAuth 2 authorization code flow.
+type OAuthProvider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	Scopes       []string
+	// account reads the identity an access token belongs to.
+	account func(client *http.Client, token string) (Identity, error)
+}
+
+// GitHub returns the GitHub provider for an OAuth app.
+func GitHub(clientID, clientSecret string) *OAuthProvider {
+	return &OAuthProvider{
+		Name:         "github",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		Scopes:       []string{"read:user", "user:email"},
+		account:      githubAccount,
+	}
+}
+
+// Google returns the Google provider for an OAuth client.
+func Google(clientID, clientSecret string) *OAuthProvider {
+	return &OAuthProvider{
+		Name:         "google",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		Scopes:       []string{"openid", "email", "profile"},
+		account:      googleAccount,
+	}
+}
+
+// AuthCodeURL is where to send the user to sign in, coming back to
+// redirectURI with state.
+func (p *OAuthProvider) AuthCodeURL(redirectURI, state string, scopes []string) string {
+	q := url.Values{
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {strings.Join(append(append([]string{}, p.Scopes...), scopes...), " ")},
+		"state":         {state},
+	}
+	return p.AuthURL + "?" + q.Encode()
+}
+
+// Exchange trades the code the provider sent back for the user's identity.
+func (p *OAuthProvider) Exchange(code, redirectURI string) (Identity, error) {
+	client := outbound.Client("oauth-"+p.Name, 10*time.Second)
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+	req, err := http.NewRequest("POST", p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := fetchJSON(client, req, &token); err != nil {
+		return Identity{}, err
+	}
+	if token.AccessToken == "" {
+		return Identity{}, fmt.Errorf("%s: %s %s", p.Name, token.Error, token.Description)
+	}
+	id, err := p.account(client, token.AccessToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if id.ProviderID == "" {
+		return Identity{}, fmt.Errorf("%s: account has no ID", p.Name)
+	}
+	id.Provider = p.Name
+	return id, nil
+}
+
+func githubAccount(client *http.Client, token string) (Identity, error) {
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		Email     string `json:"email"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := bearerGet(client, "https://api.github.com/user", token, &user); err != nil {
+		return Identity{}, err
+	}
+	id := Identity{ProviderID: strconv.FormatInt(user.ID, 10), Login: user.Login, Name: user.Name, Email: user.Email, AvatarURL: user.AvatarURL}
+	if user.ID == 0 {
+		id.ProviderID = ""
+	}
+	// The profile email is only the public one; the primary verified
+	// address needs the user:email scope.
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if bearerGet(client, "https://api.github.com/user/emails", token, &emails) == nil {
+		for _, e := range emails {
+			if e.Primary && e.Verified {
+				id.Email = e.Email
+			}
+		}
+	}
+	return id, nil
+}
+
+func googleAccount(client *http.Client, token string) (Identity, error) {
+	var user struct {
+		Sub           string `json:"sub"`
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Picture       string `json:"picture"`
+	}
+	if err := bearerGet(client, "https://openidconnect.googleapis.com/v1/userinfo", token, &user); err != nil {
+		return Identity{}, err
+	}
+	id := Identity{ProviderID: user.Sub, Name: user.Name, AvatarURL: user.Picture}
+	if user.EmailVerified {
+		id.Email = user.Email
+	}
+	return id, nil
+}
+
+func bearerGet(client *http.Client, url, token string, out interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return fetchJSON(client, req, out)
+}
+
+// fetchJSON performs req and decodes a JSON answer into out.
+func fetchJSON(client *http.Client, req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// OAuthState is what a sign-in carries through the provider: where to send
+// the user afterwards, and a nonce also kept in the browser's cookie so the
+// callback can't be replayed into someone else's browser.
+type OAuthState struct {
+	Provider   string `json:"provider"`
+	RedirectTo string `json:"redirect_to"`
+	Nonce      string `json:"nonce"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+// SealState signs state with Secret.
+func (v *Verifier) SealState(state OAuthState) (string, error) {
+	if v.Secret == nil {
+		return "", errors.New("auth: signing needs a JWT secret")
+	}
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(hs256(v.Secret, "oauth_state:"+signed)), nil
+}
+
+// OpenState checks a sealed state at now and returns it.
+func (v *Verifier) OpenState(sealed string, now time.Time) (OAuthState, error) {
+	signed, sig, ok := strings.Cut(sealed, ".")
+	if !ok || v.Secret == nil {
+		return OAuthState{}, ErrBadState
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, hs256(v.Secret, "oauth_state:"+signed)) {
+		return OAuthState{}, ErrBadState
+	}
+	var state OAuthState
+	if err := decodeSegment(signed, &state); err != nil || now.Unix() > state.ExpiresAt {
+		return OAuthState{}, ErrBadState
+	}
+	return state, nil
+}
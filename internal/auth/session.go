@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+)
+
+// SessionTokens issues and checks the capability tokens that grant access
+// to one chat session. A token is the HMAC of the session ID under Secret,
+// so nothing is stored and it stays valid for as long as the secret does.
+type SessionTokens struct {
+	Secret []byte
+}
+
+// Issue returns the token of sessionID.
+func (s *SessionTokens) Issue(sessionID string) string {
+	return base64.RawURLEncoding.EncodeToString(hs256(s.Secret, "chat_session:"+sessionID))
+}
+
+// Valid reports whether token is the token of sessionID.
+func (s *SessionTokens) Valid(sessionID, token string) bool {
+	sig, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && hmac.Equal(sig, hs256(s.Secret, "chat_session:"+sessionID))
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// DefaultAccessTTL is how long access tokens from /auth/v1 last when no
+// other lifetime is configured, as in GoTrue.
+const DefaultAccessTTL = time.Hour
+
+// Sign returns claims as an HS256 token signed with Secret.
+func (v *Verifier) Sign(claims interface{}) (string, error) {
+	if v.Secret == nil {
+		return "", errors.New("auth: signing needs a JWT secret")
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(hs256(v.Secret, signed)), nil
+}
+
+// amr is one authentication method reference of an access token.
+type amr struct {
+	Method    string `json:"method"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// AccessToken signs the access token of user in auth session sessionID,
+// with the claims GoTrue puts in one, valid for ttl from now.
+func (v *Verifier) AccessToken(user User, sessionID string, ttl time.Duration, now time.Time) (string, time.Time, error) {
+	expires := now.Add(ttl)
+	metadata := user.UserMetadata
+	if metadata == nil {
+		metadata = json.RawMessage("{}")
+	}
+	method := user.Provider
+	switch {
+	case user.Provider == "email":
+		method = "otp"
+	case !user.IsAnonymous:
+		method = "oauth"
+	}
+	claims := map[string]interface{}{
+		"aud":           "authenticated",
+		"sub":           user.ID,
+		"role":          "authenticated",
+		"exp":           expires.Unix(),
+		"iat":           now.Unix(),
+		"email":         user.Email,
+		"phone":         "",
+		"app_metadata":  user.AppMetadata(),
+		"user_metadata": metadata,
+		"aal":           "aal1",
+		"amr":           []amr{{Method: method, Timestamp: now.Unix()}},
+		"session_id":    sessionID,
+		"is_anonymous":  user.IsAnonymous,
+	}
+	if v.Issuer != "" {
+		claims["iss"] = v.Issuer
+	}
+	token, err := v.Sign(claims)
+	return token, expires, err
+}
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"chat-quick-chat-server/internal/encryption"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshTTL is how long an unused refresh token stays valid.
+const RefreshTTL = 30 * 24 * time.Hour
+
+// Refresh errors, as GoTrue names them.
+var (
+	ErrTokenNotFound = errors.New("Invalid Refresh Token: Refresh Token Not Found")
+	ErrTokenUsed     = errors.New("Invalid Refresh Token: Already Used")
+)
+
+// User is an account signed up through /auth/v1: anonymous, or signed in
+// with an OAuth provider or an emailed link.
+type User struct {
+	ID          string `json:"id"`
+	IsAnonymous bool   `json:"is_anonymous"`
+	// Provider is how the user first signed in, e.g. "anonymous",
+	// "github" or "email".
+	Provider string `json:"provider"`
+	Email    string `json:"email,omitempty"`
+	// Identities are the user's accounts at OAuth providers, and its
+	// "email" identity once it signed in with a link.
+	Identities   []Identity      `json:"identities,omitempty"`
+	UserMetadata json.RawMessage `json:"user_metadata,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	LastSignInAt *time.Time      `json:"last_sign_in_at"`
+}
+
+// refreshToken is a refresh token by the SHA-256 of its value, so a copy of
+// the data directory can't be used to sign in. Each belongs to an auth
+// session, which lasts from sign-in to sign-out across refreshes.
+type refreshToken struct {
+	Hash      string    `json:"hash"`
+	UserID    string    `json:"user_id"`
+	SessionID string    `json:"session_id"`
+	Revoked   bool      `json:"revoked"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Users keeps the accounts, refresh tokens and sign-in links of /auth/v1
+// in one file of the data directory.
+type Users struct {
+	mu     sync.Mutex
+	path   string
+	cipher *encryption.Cipher
+
+	users  []User
+	tokens []refreshToken
+	links  []emailLink
+}
+
+// storedUsers is the on-disk form of Users.
+type storedUsers struct {
+	Users         []User         `json:"users"`
+	RefreshTokens []refreshToken `json:"refresh_tokens"`
+	EmailLinks    []emailLink    `json:"email_links,omitempty"`
+}
+
+// OpenUsers reads the store at path; a missing file is an empty store.
+func OpenUsers(path string, c *encryption.Cipher) (*Users, error) {
+	u := &Users{path: path, cipher: c}
+	data, err := c.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var s storedUsers
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		u.users, u.tokens, u.links = s.Users, s.RefreshTokens, s.EmailLinks
+	}
+	return u, nil
+}
+
+// Reload reads the store back from its file, e.g. after a restore replaced
+// it. If the file can't be read the current contents stay.
+func (u *Users) Reload() error {
+	fresh, err := OpenUsers(u.path, u.cipher)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.users, u.tokens, u.links = fresh.users, fresh.tokens, fresh.links
+	return nil
+}
+
+// save writes the store, dropping refresh tokens past RefreshTTL and links
+// past EmailLinkTTL.
+func (u *Users) save(now time.Time) error {
+	kept := u.tokens[:0]
+	for _, t := range u.tokens {
+		if now.Sub(t.CreatedAt) < RefreshTTL {
+			kept = append(kept, t)
+		}
+	}
+	u.tokens = kept
+	links := u.links[:0]
+	for _, l := range u.links {
+		if now.Sub(l.CreatedAt) < EmailLinkTTL {
+			links = append(links, l)
+		}
+	}
+	u.links = links
+	data, err := json.MarshalIndent(storedUsers{Users: u.users, RefreshTokens: u.tokens, EmailLinks: u.links}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return u.cipher.WriteFile(u.path, data, 0600)
+}
+
+// SignInAnonymously creates an anonymous user and starts an auth session
+// for it. It returns the user, the session ID and its first refresh token.
+func (u *Users) SignInAnonymously(metadata json.RawMessage, now time.Time) (User, string, string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user := User{ID: uuid.New().String(), IsAnonymous: true, Provider: "anonymous", UserMetadata: metadata, CreatedAt: now, UpdatedAt: now, LastSignInAt: &now}
+	sessionID := uuid.New().String()
+	u.users = append(u.users, user)
+	token := u.issue(user.ID, sessionID, now)
+	if err := u.save(now); err != nil {
+		u.users = u.users[:len(u.users)-1]
+		return User{}, "", "", err
+	}
+	return user, sessionID, token, nil
+}
+
+// SignInWithIdentity starts an auth session for the user with id's
+// provider account, creating the user on first sign-in. The identity's
+// name, email and avatar are refreshed each time and copied into the
+// user_metadata, as GoTrue does.
+func (u *Users) SignInWithIdentity(id Identity, now time.Time) (User, string, string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	metadata, err := json.Marshal(map[string]interface{}{
+		"provider_id": id.ProviderID,
+		"sub":         id.ProviderID,
+		"name":        id.DisplayName(),
+		"full_name":   id.Name,
+		"user_name":   id.Login,
+		"email":       id.Email,
+		"avatar_url":  id.AvatarURL,
+	})
+	if err != nil {
+		return User{}, "", "", err
+	}
+	id.UpdatedAt = now
+	idx := -1
+	for i, user := range u.users {
+		for j, known := range user.Identities {
+			if known.Provider == id.Provider && known.ProviderID == id.ProviderID {
+				id.CreatedAt = known.CreatedAt
+				u.users[i].Identities[j] = id
+				idx = i
+			}
+		}
+	}
+	if idx < 0 {
+		id.CreatedAt = now
+		u.users = append(u.users, User{ID: uuid.New().String(), Provider: id.Provider, Identities: []Identity{id}, CreatedAt: now})
+		idx = len(u.users) - 1
+	}
+	user := &u.users[idx]
+	if id.Email != "" {
+		user.Email = id.Email
+	}
+	user.UserMetadata, user.UpdatedAt, user.LastSignInAt = metadata, now, &now
+
+	sessionID := uuid.New().String()
+	token := u.issue(user.ID, sessionID, now)
+	if err := u.save(now); err != nil {
+		return User{}, "", "", err
+	}
+	return *user, sessionID, token, nil
+}
+
+// Refresh trades a refresh token for a new one in the same auth session.
+// Presenting a token that was already traded revokes the whole session,
+// since one of the two holders must have stolen it.
+func (u *Users) Refresh(token string, now time.Time) (User, string, string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	hash := hashToken(token)
+	for i := range u.tokens {
+		t := &u.tokens[i]
+		if t.Hash != hash || now.Sub(t.CreatedAt) >= RefreshTTL {
+			continue
+		}
+		if t.Revoked {
+			u.revoke(func(o refreshToken) bool { return o.SessionID == t.SessionID })
+			u.save(now)
+			return User{}, "", "", ErrTokenUsed
+		}
+		user, ok := u.find(t.UserID)
+		if !ok {
+			break
+		}
+		t.Revoked = true
+		sessionID := t.SessionID
+		next := u.issue(user.ID, sessionID, now)
+		if err := u.save(now); err != nil {
+			return User{}, "", "", err
+		}
+		return user, sessionID, next, nil
+	}
+	return User{}, "", "", ErrTokenNotFound
+}
+
+// User returns the user with id.
+func (u *Users) User(id string) (User, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.find(id)
+}
+
+// Logout ends auth session sessionID of user userID: only it for scope
+// "local", every session of the user for "global", and all but it for
+// "others".
+func (u *Users) Logout(userID, sessionID, scope string, now time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.revoke(func(t refreshToken) bool {
+		switch scope {
+		case "global":
+			return t.UserID == userID
+		case "others":
+			return t.UserID == userID && t.SessionID != sessionID
+		}
+		return t.SessionID == sessionID
+	})
+	return u.save(now)
+}
+
+// AppMetadata is the app_metadata GoTrue reports for user.
+func (user User) AppMetadata() map[string]interface{} {
+	providers := []string{user.Provider}
+	for _, id := range user.Identities {
+		if id.Provider != user.Provider {
+			providers = append(providers, id.Provider)
+		}
+	}
+	return map[string]interface{}{"provider": user.Provider, "providers": providers}
+}
+
+func (u *Users) find(id string) (User, bool) {
+	for _, user := range u.users {
+		if user.ID == id {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// issue adds a refresh token to an auth session and returns its value. The
+// caller saves.
+func (u *Users) issue(userID, sessionID string, now time.Time) string {
+	token := newToken()
+	u.tokens = append(u.tokens, refreshToken{Hash: hashToken(token), UserID: userID, SessionID: sessionID, CreatedAt: now})
+	return token
+}
+
+func (u *Users) revoke(match func(refreshToken) bool) {
+	for i := range u.tokens {
+		if match(u.tokens[i]) {
+			u.tokens[i].Revoked = true
+		}
+	}
+}
+
+// newToken returns a random token for a refresh token or sign-in link.
+func newToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,267 @@
+package backup
+
+import (
+	"archive/tar"
+	"chat-quick-chat-server/internal/db"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Archive layout: everything under the data directory is stored below
+// "data/", uploaded media below "storage/chat-media/".
+const (
+	dataPrefix    = "data/"
+	storagePrefix = "storage/chat-media/"
+)
+
+// restoreDirName is the staging directory created inside each target during a
+// restore. It lives inside the target so the final moves are plain renames
+// even when the target itself is a bind mount.
+const restoreDirName = ".restore"
+
+// restoreOldName is where a restore moves the existing entries of each
+// target until the new ones are all in place, so it can put them back.
+const restoreOldName = ".restore-old"
+
+// Write streams a tar.gz snapshot of the data directory and the media storage
+// directory. The database read lock is held for the whole snapshot so the
+// JSON files can't change halfway through.
+func Write(w io.Writer, database *db.Database, storageDir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := database.ReadLocked(func() error {
+		if err := addTree(tw, database.DataDir, dataPrefix); err != nil {
+			return err
+		}
+		return addTree(tw, storageDir, storagePrefix)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addTree adds the regular files below root to the archive. Dot-files at the
+// top level (staging directories, lock files) are skipped.
+func addTree(tw *tar.Writer, root, prefix string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if strings.HasPrefix(rel, ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		hdr := &tar.Header{
+			Name:    prefix + filepath.ToSlash(rel),
+			Mode:    int64(info.Mode().Perm()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, info.Size())
+		return err
+	})
+}
+
+// Restore replaces the contents of dataDir and storageDir with a snapshot
+// produced by Write. The archive is fully extracted into staging directories
+// first, so a corrupt archive leaves the existing data untouched, and the
+// existing entries are only moved aside until the staged ones are all in
+// place, so a failure while swapping puts them back.
+func Restore(r io.Reader, dataDir, storageDir string) error {
+	dataStage := filepath.Join(dataDir, restoreDirName)
+	storageStage := filepath.Join(storageDir, restoreDirName)
+	for _, dir := range []string{dataStage, storageStage} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	if err := extract(r, dataStage, storageStage); err != nil {
+		return err
+	}
+	rollbackData, doneData, err := swap(dataDir, dataStage)
+	if err != nil {
+		return err
+	}
+	_, doneStorage, err := swap(storageDir, storageStage)
+	if err != nil {
+		if rerr := rollbackData(); rerr != nil {
+			return fmt.Errorf("%v; rolling back %s: %v", err, dataDir, rerr)
+		}
+		return err
+	}
+	doneData()
+	doneStorage()
+	return nil
+}
+
+func extract(r io.Reader, dataStage, storageStage string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		var dest string
+		switch {
+		case strings.HasPrefix(name, dataPrefix):
+			dest, err = safeJoin(dataStage, strings.TrimPrefix(name, dataPrefix))
+		case strings.HasPrefix(name, storagePrefix):
+			dest, err = safeJoin(storageStage, strings.TrimPrefix(name, storagePrefix))
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+// safeJoin joins an archive-relative path onto dir, rejecting entries that
+// would escape it.
+func safeJoin(dir, rel string) (string, error) {
+	if rel == "" || strings.HasPrefix(rel, "../") || rel == ".." || path.IsAbs(rel) {
+		return "", fmt.Errorf("invalid archive entry %q", rel)
+	}
+	return filepath.Join(dir, filepath.FromSlash(rel)), nil
+}
+
+// swap moves everything in dir except top-level dot-files (the staging
+// directory among them) aside and moves the staged entries into place. If
+// that fails partway, the old entries are put back; after it succeeds,
+// rollback does the same and done deletes them.
+func swap(dir, stage string) (rollback, done func() error, err error) {
+	old := filepath.Join(dir, restoreOldName)
+	if err := os.RemoveAll(old); err != nil {
+		return nil, nil, err
+	}
+	if err := os.Mkdir(old, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	var moved, placed []string
+	rollback = func() error {
+		var first error
+		for _, name := range placed {
+			if err := os.RemoveAll(filepath.Join(dir, name)); err != nil && first == nil {
+				first = err
+			}
+		}
+		for _, name := range moved {
+			if err := os.Rename(filepath.Join(old, name), filepath.Join(dir, name)); err != nil && first == nil {
+				first = err
+			}
+		}
+		if first != nil {
+			return first
+		}
+		return os.Remove(old)
+	}
+	done = func() error { return os.RemoveAll(old) }
+	fail := func(err error) (func() error, func() error, error) {
+		if rerr := rollback(); rerr != nil {
+			return nil, nil, fmt.Errorf("%v; rolling back %s: %v", err, dir, rerr)
+		}
+		return nil, nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fail(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if err := os.Rename(filepath.Join(dir, e.Name()), filepath.Join(old, e.Name())); err != nil {
+			return fail(err)
+		}
+		moved = append(moved, e.Name())
+	}
+
+	staged, err := os.ReadDir(stage)
+	if err != nil {
+		return fail(err)
+	}
+	for _, e := range staged {
+		if err := os.Rename(filepath.Join(stage, e.Name()), filepath.Join(dir, e.Name())); err != nil {
+			return fail(err)
+		}
+		placed = append(placed, e.Name())
+	}
+	return rollback, done, nil
+}
+
+// FileName returns the conventional name for a snapshot taken at t.
+func FileName(t time.Time) string {
+	return "backup-" + t.UTC().Format("20060102-150405") + ".tar.gz"
+}
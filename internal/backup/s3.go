@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"chat-quick-chat-server/internal/outbound"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Target keeps snapshots in an S3 bucket, or any store speaking the S3 API
+// (MinIO, R2, ...). Requests use path-style URLs, Endpoint/Bucket/Key, and
+// are signed with AWS Signature Version 4. Prefix is prepended to every
+// object name; a trailing slash makes it a folder.
+type S3Target struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// unsignedPayload tells S3 the body is not part of the signature, so uploads
+// can be streamed without hashing them first.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// emptyHash is the SHA-256 of an empty body.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (t S3Target) Put(name string, r io.Reader, size int64) error {
+	resp, err := t.do("PUT", t.Prefix+name, nil, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (t S3Target) List() ([]string, error) {
+	var names []string
+	query := url.Values{"list-type": {"2"}, "prefix": {t.Prefix}}
+	for {
+		resp, err := t.do("GET", "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listing s3://%s/%s: %v", t.Bucket, t.Prefix, err)
+		}
+		for _, c := range page.Contents {
+			if rest := strings.TrimPrefix(c.Key, t.Prefix); !strings.Contains(rest, "/") {
+				names = append(names, rest)
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return names, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (t S3Target) Delete(name string) error {
+	resp, err := t.do("DELETE", t.Prefix+name, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for key (or the bucket itself when key is "")
+// and turns any non-2xx answer into an error.
+func (t S3Target) do(method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	path := "/" + awsEscape(t.Bucket, false)
+	if key != "" {
+		path += "/" + awsEscape(key, true)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(t.Endpoint, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = canonicalQuery(query)
+	payload := emptyHash
+	if body != nil {
+		req.ContentLength = size
+		payload = unsignedPayload
+	}
+	t.sign(req, path, payload, time.Now().UTC())
+
+	client := outbound.Client("backup", 30*time.Minute)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// sign adds the Signature Version 4 headers. path is the already escaped
+// request path.
+func (t S3Target) sign(req *http.Request, path, payload string, now time.Time) {
+	stamp := now.Format("20060102T150405Z")
+	date := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if t.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", t.SessionToken)
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payload,
+		"x-amz-date":           stamp,
+	}
+	if t.SessionToken != "" {
+		headers["x-amz-security-token"] = t.SessionToken
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signed, payload,
+	}, "\n")
+	scope := date + "/" + t.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+t.SecretKey), date)
+	for _, part := range []string{t.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.AccessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query the way Signature Version 4 expects: keys
+// sorted, everything but unreserved characters percent-encoded.
+func canonicalQuery(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything except unreserved characters and,
+// when keepSlash is set, "/".
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
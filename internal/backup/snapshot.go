@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Target is where scheduled snapshots are kept.
+type Target interface {
+	// Put stores a snapshot of the given size under name.
+	Put(name string, r io.Reader, size int64) error
+	// List returns the names of the stored objects.
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+// DirTarget keeps snapshots in a local directory.
+type DirTarget struct {
+	Dir string
+}
+
+func (t DirTarget) Put(name string, r io.Reader, size int64) error {
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return err
+	}
+	// Written under a dot name and renamed, so a half-written snapshot is
+	// never listed or rotated in place of a good one.
+	tmp := filepath.Join(t.Dir, "."+name)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.Dir, name))
+}
+
+func (t DirTarget) List() ([]string, error) {
+	entries, err := os.ReadDir(t.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (t DirTarget) Delete(name string) error {
+	return os.Remove(filepath.Join(t.Dir, name))
+}
+
+// DefaultKeep is how many snapshots a Snapshotter keeps when Keep is 0.
+const DefaultKeep = 7
+
+// Snapshotter writes a snapshot to Target every Every and then deletes all
+// but the newest Keep. Run is a scheduler job; the snapshot itself runs in
+// the background so a slow upload doesn't hold up the other jobs.
+type Snapshotter struct {
+	DB         *db.Database
+	StorageDir string
+	Target     Target
+	Every      time.Duration
+	Keep       int
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (s *Snapshotter) Run(now time.Time) {
+	if !s.last.IsZero() && now.Sub(s.last) < s.Every {
+		return
+	}
+	s.last = now
+	go func() {
+		if !s.mu.TryLock() {
+			log.Printf("Scheduled backup skipped: the previous one is still running")
+			return
+		}
+		defer s.mu.Unlock()
+		name, err := s.Snapshot(now)
+		if err != nil {
+			log.Printf("Scheduled backup failed: %v", err)
+			return
+		}
+		log.Printf("Scheduled backup written: %s", name)
+	}()
+}
+
+// Snapshot writes one snapshot now, rotates old ones and returns the new
+// snapshot's name.
+func (s *Snapshotter) Snapshot(now time.Time) (string, error) {
+	// The archive is spooled to a temporary file first: the database read
+	// lock is only held while the files are copied, not for the upload, and
+	// object stores want the length up front.
+	f, err := os.CreateTemp("", "backup-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := Write(f, s.DB, s.StorageDir); err != nil {
+		return "", err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	name := FileName(now)
+	if err := s.Target.Put(name, f, size); err != nil {
+		return "", err
+	}
+	if err := s.rotate(); err != nil {
+		log.Printf("Rotating backups failed: %v", err)
+	}
+	return name, nil
+}
+
+// rotate deletes the oldest snapshots beyond Keep. Only names FileName
+// produces are considered, and those sort by time.
+func (s *Snapshotter) rotate() error {
+	keep := s.Keep
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	names, err := s.Target.List()
+	if err != nil {
+		return err
+	}
+	var snapshots []string
+	for _, n := range names {
+		if strings.HasPrefix(n, "backup-") && strings.HasSuffix(n, ".tar.gz") {
+			snapshots = append(snapshots, n)
+		}
+	}
+	sort.Strings(snapshots)
+	for len(snapshots) > keep {
+		if err := s.Target.Delete(snapshots[0]); err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
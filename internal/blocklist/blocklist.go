@@ -0,0 +1,304 @@
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"chat-quick-chat-server/internal/encryption"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// List is the exchange format for blocklists. As JSON it is
+//
+//	{"ips": ["203.0.113.7", "198.51.100.0/24"], "email_domains": ["spam.example"], "phrases": ["buy followers"]}
+//
+// As plain text it is one entry per line with "#" or ";" comments, the format
+// public IP feeds use. Lines that parse as an IP or CIDR are IPs, lines
+// starting with "@" are email domains and anything else is a phrase.
+type List struct {
+	IPs          []string `json:"ips"`
+	EmailDomains []string `json:"email_domains"`
+	Phrases      []string `json:"phrases"`
+}
+
+// Parse reads a list in either format; a document starting with "{" is JSON.
+// Entries are validated and normalised.
+func Parse(data []byte) (List, error) {
+	var l List
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &l); err != nil {
+			return List{}, err
+		}
+	} else {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			line := sc.Text()
+			if i := strings.IndexAny(line, "#;"); i >= 0 {
+				line = line[:i]
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "":
+			case strings.HasPrefix(line, "@"):
+				l.EmailDomains = append(l.EmailDomains, line[1:])
+			case parseIPNet(line) != nil:
+				l.IPs = append(l.IPs, line)
+			default:
+				l.Phrases = append(l.Phrases, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return List{}, err
+		}
+	}
+	return l.normalize()
+}
+
+// Text renders the list in the plain-text format.
+func (l List) Text() string {
+	var b strings.Builder
+	for _, ip := range l.IPs {
+		b.WriteString(ip + "\n")
+	}
+	for _, d := range l.EmailDomains {
+		b.WriteString("@" + d + "\n")
+	}
+	for _, p := range l.Phrases {
+		b.WriteString(p + "\n")
+	}
+	return b.String()
+}
+
+// Validate reports the first invalid entry of l.
+func (l List) Validate() error {
+	_, err := l.normalize()
+	return err
+}
+
+func (l List) normalize() (List, error) {
+	out := List{IPs: []string{}, EmailDomains: []string{}, Phrases: []string{}}
+	seen := make(map[string]bool)
+	add := func(dst *[]string, kind, v string) {
+		if v != "" && !seen[kind+v] {
+			seen[kind+v] = true
+			*dst = append(*dst, v)
+		}
+	}
+	for _, ip := range l.IPs {
+		ip = strings.TrimSpace(ip)
+		if parseIPNet(ip) == nil {
+			return List{}, fmt.Errorf("invalid IP or CIDR %q", ip)
+		}
+		add(&out.IPs, "ip:", ip)
+	}
+	for _, d := range l.EmailDomains {
+		add(&out.EmailDomains, "domain:", strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@")))
+	}
+	for _, p := range l.Phrases {
+		add(&out.Phrases, "phrase:", strings.ToLower(strings.TrimSpace(p)))
+	}
+	return out, nil
+}
+
+// merge returns the union of two normalised lists.
+func merge(a, b List) List {
+	out, _ := List{
+		IPs:          append(append([]string(nil), a.IPs...), b.IPs...),
+		EmailDomains: append(append([]string(nil), a.EmailDomains...), b.EmailDomains...),
+		Phrases:      append(append([]string(nil), a.Phrases...), b.Phrases...),
+	}.normalize()
+	return out
+}
+
+func parseIPNet(s string) *net.IPNet {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+
+// Blocklist is the active set of blocked IPs, email domains and phrases. It
+// keeps the entries managed on this deployment (Local) apart from the ones
+// pulled from a shared feed (Remote), so a refresh never drops local entries.
+// A nil *Blocklist blocks nothing.
+type Blocklist struct {
+	mu     sync.RWMutex
+	path   string
+	cipher *encryption.Cipher
+
+	local, remote List
+	fetchedAt     *time.Time
+
+	nets    []*net.IPNet
+	domains map[string]bool
+	phrases []string
+}
+
+// stored is the on-disk form of a Blocklist.
+type stored struct {
+	Local     List       `json:"local"`
+	Remote    List       `json:"remote"`
+	FetchedAt *time.Time `json:"remote_fetched_at"`
+}
+
+// Open loads the blocklist persisted at path; a missing file is an empty list.
+func Open(path string, c *encryption.Cipher) (*Blocklist, error) {
+	b := &Blocklist{path: path, cipher: c}
+	data, err := c.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var s stored
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		b.local, b.remote, b.fetchedAt = s.Local, s.Remote, s.FetchedAt
+	}
+	b.compile()
+	return b, nil
+}
+
+// Reload reads the list back from its file, e.g. after a restore replaced
+// it. If the file can't be read the current entries stay.
+func (b *Blocklist) Reload() error {
+	if b == nil {
+		return nil
+	}
+	fresh, err := Open(b.path, b.cipher)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.local, b.remote, b.fetchedAt = fresh.local, fresh.remote, fresh.fetchedAt
+	b.compile()
+	return nil
+}
+
+func (b *Blocklist) compile() {
+	all := merge(b.local, b.remote)
+	b.nets = b.nets[:0]
+	for _, ip := range all.IPs {
+		b.nets = append(b.nets, parseIPNet(ip))
+	}
+	b.domains = make(map[string]bool, len(all.EmailDomains))
+	for _, d := range all.EmailDomains {
+		b.domains[d] = true
+	}
+	b.phrases = all.Phrases
+}
+
+func (b *Blocklist) save() error {
+	data, err := json.MarshalIndent(stored{Local: b.local, Remote: b.remote, FetchedAt: b.fetchedAt}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.cipher.WriteFile(b.path, data, 0644)
+}
+
+// Local returns the entries managed on this deployment, without the feed's.
+func (b *Blocklist) Local() List {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return merge(b.local, List{})
+}
+
+// Export returns the effective list: local and remote entries combined.
+func (b *Blocklist) Export() List {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return merge(b.local, b.remote)
+}
+
+// Import adds l to the local entries, or replaces them when replace is set.
+func (b *Blocklist) Import(l List, replace bool) error {
+	l, err := l.normalize()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if replace {
+		b.local = l
+	} else {
+		b.local = merge(b.local, l)
+	}
+	b.compile()
+	return b.save()
+}
+
+// SetRemote replaces the entries that came from the shared feed.
+func (b *Blocklist) SetRemote(l List, fetchedAt time.Time) error {
+	l, err := l.normalize()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remote = l
+	b.fetchedAt = &fetchedAt
+	b.compile()
+	return b.save()
+}
+
+// BlocksIP reports whether ip falls in a blocked address or range.
+func (b *Blocklist) BlocksIP(ip net.IP) bool {
+	if b == nil || ip == nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, n := range b.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchText returns the entry that text violates, either a blocked phrase or
+// an email address at a blocked domain, and "" when it is clean.
+func (b *Blocklist) MatchText(text string) string {
+	if b == nil || text == "" {
+		return ""
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	lower := strings.ToLower(text)
+	for _, p := range b.phrases {
+		if strings.Contains(lower, p) {
+			return p
+		}
+	}
+	for _, m := range emailPattern.FindAllStringSubmatch(lower, -1) {
+		// A blocked domain covers its subdomains too.
+		for d := m[1]; d != ""; {
+			if b.domains[d] {
+				return "@" + d
+			}
+			_, d, _ = strings.Cut(d, ".")
+		}
+	}
+	return ""
+}
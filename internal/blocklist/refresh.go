@@ -0,0 +1,58 @@
+package blocklist
+
+import (
+	"chat-quick-chat-server/internal/outbound"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxFeedSize bounds how much of a remote feed is read.
+const maxFeedSize = 16 << 20
+
+// Refresher periodically replaces the remote entries of a Blocklist with the
+// list published at URL. Run is a scheduler job; it only fetches once Every
+// has passed since the last attempt.
+type Refresher struct {
+	List  *Blocklist
+	URL   string
+	Every time.Duration
+
+	last time.Time
+}
+
+func (r *Refresher) Run(now time.Time) {
+	if !r.last.IsZero() && now.Sub(r.last) < r.Every {
+		return
+	}
+	r.last = now
+	if err := r.Refresh(now); err != nil {
+		log.Printf("blocklist refresh from %s failed: %v", r.URL, err)
+	}
+}
+
+// Refresh fetches the feed now. On failure the previous remote entries stay
+// in effect.
+func (r *Refresher) Refresh(now time.Time) error {
+	client := outbound.Client("blocklist", 30*time.Second)
+	resp, err := client.Get(r.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return err
+	}
+	l, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	return r.List.SetRemote(l, now)
+}
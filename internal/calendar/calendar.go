@@ -0,0 +1,249 @@
+package calendar
+
+import (
+	"bytes"
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/outbound"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Calendar offers appointment slots for scheduling messages. Slots come
+// from a fixed list (Slots) or are generated from weekly opening hours,
+// minus whatever the busy calendar at BusyICSURL has booked. WebhookURL is
+// told about every slot a visitor picks.
+//
+//	{
+//	  "timezone": "Europe/Berlin",
+//	  "days": ["mon", "tue", "wed", "thu", "fri"],
+//	  "start": "09:00", "end": "17:00",
+//	  "slot_minutes": 30,
+//	  "min_notice_minutes": 60,
+//	  "horizon_days": 7,
+//	  "max_slots": 10,
+//	  "busy_ics_url": "https://calendar.example.com/agent/busy.ics",
+//	  "webhook_url": "https://crm.example.com/hooks/booking"
+//	}
+type Calendar struct {
+	Timezone         string    `json:"timezone"`
+	Days             []string  `json:"days"`
+	Start            string    `json:"start"`
+	End              string    `json:"end"`
+	SlotMinutes      int       `json:"slot_minutes"`
+	MinNoticeMinutes int       `json:"min_notice_minutes"`
+	HorizonDays      int       `json:"horizon_days"`
+	MaxSlots         int       `json:"max_slots"`
+	Slots            []db.Slot `json:"slots"`
+	BusyICSURL       string    `json:"busy_ics_url"`
+	WebhookURL       string    `json:"webhook_url"`
+
+	loc        *time.Location
+	days       map[time.Weekday]bool
+	start, end int // minutes after midnight
+
+	// lastBusy is the last busy feed read, kept for when the feed is down.
+	mu       sync.Mutex
+	lastBusy []interval
+	haveBusy bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func LoadConfig(path string) (*Calendar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Calendar
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &c, nil
+}
+
+func (c *Calendar) compile() error {
+	c.loc = time.UTC
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return err
+		}
+		c.loc = loc
+	}
+	if c.Start == "" && c.End == "" {
+		return nil
+	}
+	var err error
+	if c.start, err = clock(c.Start); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	if c.end, err = clock(c.End); err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if c.end <= c.start {
+		return fmt.Errorf("end must be after start")
+	}
+	c.days = make(map[time.Weekday]bool)
+	for _, d := range c.Days {
+		wd, ok := weekdays[strings.ToLower(d)[:min(3, len(d))]]
+		if !ok {
+			return fmt.Errorf("unknown day %q", d)
+		}
+		c.days[wd] = true
+	}
+	if len(c.days) == 0 {
+		for _, wd := range []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday} {
+			c.days[wd] = true
+		}
+	}
+	return nil
+}
+
+func clock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// busy reads the busy feed. While it fails, or its circuit is open, the
+// last good read is used so that scheduling messages keep working; only
+// without one is the error returned.
+func (c *Calendar) busy() ([]interval, error) {
+	busy, err := fetchBusy(c.BusyICSURL, c.loc)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.lastBusy, c.haveBusy = busy, true
+		return busy, nil
+	}
+	if !c.haveBusy {
+		return nil, err
+	}
+	log.Printf("Busy calendar unavailable, using the last copy: %v", err)
+	return c.lastBusy, nil
+}
+
+// Available returns the free slots after now, soonest first.
+func (c *Calendar) Available(now time.Time) ([]db.Slot, error) {
+	notice := now.Add(time.Duration(c.MinNoticeMinutes) * time.Minute)
+	maxSlots := c.MaxSlots
+	if maxSlots <= 0 {
+		maxSlots = 10
+	}
+
+	var busy []interval
+	if c.BusyICSURL != "" {
+		var err error
+		if busy, err = c.busy(); err != nil {
+			return nil, fmt.Errorf("busy calendar: %w", err)
+		}
+	}
+	free := func(s db.Slot) bool {
+		if s.Start.Before(notice) {
+			return false
+		}
+		for _, b := range busy {
+			if s.Start.Before(b.end) && b.start.Before(s.End) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var slots []db.Slot
+	if len(c.Slots) > 0 {
+		for _, s := range c.Slots {
+			if free(s) {
+				if s.ID == "" {
+					s.ID = SlotID(s.Start)
+				}
+				slots = append(slots, s)
+			}
+		}
+		sort.Slice(slots, func(i, j int) bool { return slots[i].Start.Before(slots[j].Start) })
+		if len(slots) > maxSlots {
+			slots = slots[:maxSlots]
+		}
+		return slots, nil
+	}
+	if c.days == nil {
+		return nil, fmt.Errorf("no opening hours or slots configured")
+	}
+
+	step := time.Duration(c.SlotMinutes) * time.Minute
+	if step <= 0 {
+		step = 30 * time.Minute
+	}
+	horizon := c.HorizonDays
+	if horizon <= 0 {
+		horizon = 7
+	}
+	local := now.In(c.loc)
+	for d := 0; d <= horizon && len(slots) < maxSlots; d++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+d, 0, 0, 0, 0, c.loc)
+		if !c.days[day.Weekday()] {
+			continue
+		}
+		closing := day.Add(time.Duration(c.end) * time.Minute)
+		for t := day.Add(time.Duration(c.start) * time.Minute); !t.Add(step).After(closing) && len(slots) < maxSlots; t = t.Add(step) {
+			s := db.Slot{ID: SlotID(t), Start: t.UTC(), End: t.Add(step).UTC()}
+			if free(s) {
+				slots = append(slots, s)
+			}
+		}
+	}
+	return slots, nil
+}
+
+// SlotID names a slot after its start time.
+func SlotID(start time.Time) string {
+	return start.UTC().Format(time.RFC3339)
+}
+
+// Selection is what the booking webhook receives.
+type Selection struct {
+	Event      string    `json:"event"`
+	SessionID  string    `json:"session_id"`
+	MessageID  string    `json:"message_id"`
+	Slot       db.Slot   `json:"slot"`
+	SelectedBy string    `json:"selected_by"`
+	At         time.Time `json:"at"`
+}
+
+// Notify posts the selection to WebhookURL in the background.
+func (c *Calendar) Notify(sel Selection) {
+	if c == nil || c.WebhookURL == "" {
+		return
+	}
+	sel.Event = db.EventSlotSelected
+	body, err := json.Marshal(sel)
+	if err != nil {
+		return
+	}
+	go func() {
+		client := outbound.Client("calendar", 10*time.Second)
+		resp, err := client.Post(c.WebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Booking webhook failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Booking webhook failed: %s", resp.Status)
+		}
+	}()
+}
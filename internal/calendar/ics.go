@@ -0,0 +1,120 @@
+package calendar
+
+import (
+	"bufio"
+	"chat-quick-chat-server/internal/outbound"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxICSSize bounds how much of a busy calendar is read.
+const maxICSSize = 4 << 20
+
+type interval struct {
+	start, end time.Time
+}
+
+// fetchBusy reads the events of an iCalendar feed as busy intervals.
+// Cancelled and transparent (free) events are ignored, and recurring
+// events only count at their first occurrence: publish a free/busy or
+// expanded feed for calendars that rely on RRULE.
+func fetchBusy(url string, loc *time.Location) ([]interval, error) {
+	client := outbound.Client("calendar", 10*time.Second)
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return parseICS(io.LimitReader(resp.Body, maxICSSize), loc)
+}
+
+func parseICS(r io.Reader, loc *time.Location) ([]interval, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		// Folded lines continue with a leading space or tab.
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var busy []interval
+	var in, skip bool
+	var start, end time.Time
+	var allDay bool
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		prop, params, _ := strings.Cut(name, ";")
+		switch strings.ToUpper(prop) {
+		case "BEGIN":
+			if value == "VEVENT" {
+				in, skip, start, end, allDay = true, false, time.Time{}, time.Time{}, false
+			}
+		case "END":
+			if value == "VEVENT" && in {
+				in = false
+				if skip || start.IsZero() {
+					continue
+				}
+				if end.IsZero() {
+					end = start
+					if allDay {
+						end = start.AddDate(0, 0, 1)
+					}
+				}
+				busy = append(busy, interval{start, end})
+			}
+		case "STATUS":
+			skip = skip || strings.EqualFold(value, "CANCELLED")
+		case "TRANSP":
+			skip = skip || strings.EqualFold(value, "TRANSPARENT")
+		case "DTSTART":
+			if in {
+				start, allDay = icsTime(value, params, loc)
+			}
+		case "DTEND":
+			if in {
+				end, _ = icsTime(value, params, loc)
+			}
+		}
+	}
+	return busy, nil
+}
+
+// icsTime parses a DATE or DATE-TIME value. Times without Z or TZID are
+// taken in loc, the calendar's own zone.
+func icsTime(value, params string, loc *time.Location) (time.Time, bool) {
+	for _, p := range strings.Split(params, ";") {
+		if tz, ok := strings.CutPrefix(p, "TZID="); ok {
+			if l, err := time.LoadLocation(strings.Trim(tz, `"`)); err == nil {
+				loc = l
+			}
+		}
+	}
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, false
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+		return t, false
+	}
+	if t, err := time.ParseInLocation("20060102", value, loc); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,145 @@
+package crm
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/outbound"
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Contact is what is known about a visitor once they have given an email.
+type Contact struct {
+	Email      string
+	Name       string
+	ExternalID string
+	SessionID  string
+	// TranscriptURL is filled in by the connector from its configuration.
+	TranscriptURL string
+}
+
+func (c Contact) values() map[string]string {
+	return map[string]string{
+		"email":          c.Email,
+		"name":           c.Name,
+		"external_id":    c.ExternalID,
+		"session_id":     c.SessionID,
+		"transcript_url": c.TranscriptURL,
+	}
+}
+
+// Connector creates or updates a contact in a CRM. Syncs are delivered at
+// least once, so UpsertContact must be idempotent, keyed by email.
+type Connector interface {
+	UpsertContact(c Contact) error
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+// queueSize bounds the contacts waiting for the CRM; beyond it new ones are
+// dropped (and logged) rather than holding up event dispatch.
+const queueSize = 256
+
+// retryDelays are the pauses between attempts to reach the CRM.
+var retryDelays = []time.Duration{2 * time.Second, 10 * time.Second, time.Minute}
+
+// Sync watches the outbox for new messages that carry a visitor's email —
+// the verified identity of the sender, metadata.email (a pre-chat form) or
+// an address typed into the message — and upserts a contact for it. Each
+// session's email is synced once per process.
+type Sync struct {
+	Connector Connector
+
+	queue  chan Contact
+	mu     sync.Mutex
+	synced map[string]string
+}
+
+func NewSync(c Connector) *Sync {
+	return &Sync{Connector: c, queue: make(chan Contact, queueSize), synced: make(map[string]string)}
+}
+
+// Handle is an outbox subscriber. It only inspects the event; the CRM is
+// called from Run.
+func (s *Sync) Handle(e db.OutboxEvent) {
+	if e.Kind != db.OutboxChange || e.Table != "messages" || e.Type != "INSERT" {
+		return
+	}
+	var m db.Message
+	if err := json.Unmarshal(e.Record, &m); err != nil {
+		return
+	}
+	c, ok := contactFrom(m)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	seen := s.synced[m.SessionID] == c.Email
+	if !seen {
+		s.synced[m.SessionID] = c.Email
+	}
+	s.mu.Unlock()
+	if seen {
+		return
+	}
+	select {
+	case s.queue <- c:
+	default:
+		log.Printf("CRM sync queue full, dropping contact for session %s", c.SessionID)
+	}
+}
+
+// contactFrom extracts a contact from a visitor's message. Messages the
+// server or a bot wrote never count.
+func contactFrom(m db.Message) (Contact, bool) {
+	if m.MessageType == db.MessageTypeSystem || m.Origin != nil && *m.Origin == db.OriginBot {
+		return Contact{}, false
+	}
+	c := Contact{SessionID: m.SessionID}
+	if m.SenderName != nil {
+		c.Name = *m.SenderName
+	}
+	if id := m.SenderIdentity; id != nil {
+		c.ExternalID, c.Email = id.ExternalID, id.Email
+		if id.Name != "" {
+			c.Name = id.Name
+		}
+	}
+	if c.Email == "" && len(m.Metadata) > 0 {
+		var meta struct {
+			Email string `json:"email"`
+		}
+		if json.Unmarshal(m.Metadata, &meta) == nil && emailPattern.MatchString(meta.Email) {
+			c.Email = meta.Email
+		}
+	}
+	if c.Email == "" && m.Content != nil {
+		c.Email = emailPattern.FindString(*m.Content)
+	}
+	c.Email = strings.ToLower(strings.TrimSpace(c.Email))
+	return c, c.Email != ""
+}
+
+// Run blocks, sending queued contacts to the CRM with a few retries.
+func (s *Sync) Run() {
+	for c := range s.queue {
+		err := s.Connector.UpsertContact(c)
+		for i := 0; err != nil && i < len(retryDelays); {
+			time.Sleep(retryDelays[i])
+			// While the CRM's circuit is open nothing was sent, so the
+			// contact waits its turn without using up an attempt.
+			if !errors.Is(err, outbound.ErrOpen) {
+				i++
+			}
+			err = s.Connector.UpsertContact(c)
+		}
+		if err != nil {
+			log.Printf("CRM sync for session %s failed: %v", c.SessionID, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package crm
+
+import (
+	"bytes"
+	"chat-quick-chat-server/internal/outbound"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Webhook is a Connector that sends each contact as JSON to an HTTP
+// endpoint, which is enough for CRMs with an upsert-by-email API (HubSpot's
+// batch upsert, Salesforce via a flow or middleware) and for Zapier-style
+// relays.
+//
+// A config file looks like:
+//
+//	{
+//	  "url": "https://api.example-crm.com/contacts/upsert",
+//	  "headers": {"Authorization": "Bearer ..."},
+//	  "wrap": "properties",
+//	  "fields": {"email": "email", "name": "firstname", "transcript_url": "chat_transcript"},
+//	  "transcript_url": "https://chat.example.com/admin/v1/sessions/{session_id}/export"
+//	}
+//
+// Fields maps contact fields (email, name, external_id, session_id,
+// transcript_url) to the CRM's property names; unmapped fields are not
+// sent, and without a mapping every field is sent under its own name. Wrap,
+// when set, nests the properties under that key.
+type Webhook struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Wrap    string            `json:"wrap"`
+	Fields  map[string]string `json:"fields"`
+	// TranscriptURL is the link attached to the contact, with {session_id}
+	// as placeholder. Empty sends no link.
+	TranscriptURL string `json:"transcript_url"`
+}
+
+func LoadConfig(path string) (*Webhook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var w Webhook
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if w.URL == "" {
+		return nil, fmt.Errorf("%s: url is required", path)
+	}
+	known := Contact{}.values()
+	for field := range w.Fields {
+		if _, ok := known[field]; !ok {
+			return nil, fmt.Errorf("%s: unknown contact field %q", path, field)
+		}
+	}
+	return &w, nil
+}
+
+func (w *Webhook) UpsertContact(c Contact) error {
+	if w.TranscriptURL != "" {
+		c.TranscriptURL = strings.ReplaceAll(w.TranscriptURL, "{session_id}", c.SessionID)
+	}
+	props := make(map[string]string)
+	for field, v := range c.values() {
+		name := field
+		if w.Fields != nil {
+			if name = w.Fields[field]; name == "" {
+				continue
+			}
+		}
+		if v != "" {
+			props[name] = v
+		}
+	}
+	var payload interface{} = props
+	if w.Wrap != "" {
+		payload = map[string]interface{}{w.Wrap: props}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	method := w.Method
+	if method == "" {
+		method = "POST"
+	}
+	req, err := http.NewRequest(method, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	client := outbound.Client("crm", 10*time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
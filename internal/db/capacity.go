@@ -0,0 +1,121 @@
+package db
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// Message limit levels, as passed to OnMessageLimit.
+const (
+	LimitOK          = "ok"
+	LimitApproaching = "approaching"
+	// LimitExceeded means the limit is passed and there is no closed session
+	// left to evict.
+	LimitExceeded = "exceeded"
+)
+
+// DefaultMessageLimitWarn is the share of MessageLimit at which the limit
+// counts as approaching when MessageLimitWarn is 0.
+const DefaultMessageLimitWarn = 0.9
+
+// LimitStatus reports the message limit and what it has evicted since the
+// start.
+type LimitStatus struct {
+	MessageLimit    int        `json:"message_limit"`
+	Level           string     `json:"level"`
+	EvictedSessions int64      `json:"evicted_sessions"`
+	EvictedMessages int64      `json:"evicted_messages"`
+	LastEvictionAt  *time.Time `json:"last_eviction_at"`
+}
+
+type limitState struct {
+	level    string
+	sessions int64
+	messages int64
+	last     *time.Time
+}
+
+// enforceLimit keeps the stored messages under MessageLimit by removing
+// whole closed sessions, the longest closed first. Open conversations are
+// never evicted, so the limit is soft: with nothing left to evict, new
+// messages are still stored. Uploads of evicted messages stay on disk until
+// the next compact. The caller holds the lock and saves.
+func (db *Database) enforceLimit() {
+	if db.MessageLimit <= 0 {
+		return
+	}
+	warn := db.MessageLimitWarn
+	if warn <= 0 || warn > 1 {
+		warn = DefaultMessageLimitWarn
+	}
+	// Evicting down to the warning level rather than just under the limit
+	// leaves room, so eviction and alerts don't repeat with every message.
+	if len(db.Messages) > db.MessageLimit {
+		db.evict(len(db.Messages) - int(warn*float64(db.MessageLimit)) + 1)
+	}
+	level := LimitOK
+	switch {
+	case len(db.Messages) > db.MessageLimit:
+		level = LimitExceeded
+	case float64(len(db.Messages)) >= warn*float64(db.MessageLimit):
+		level = LimitApproaching
+	}
+	if level == db.limit.level || db.limit.level == "" && level == LimitOK {
+		db.limit.level = level
+		return
+	}
+	db.limit.level = level
+	log.Printf("Message limit %s: %d of %d messages stored", level, len(db.Messages), db.MessageLimit)
+	if db.OnMessageLimit != nil {
+		go db.OnMessageLimit(level, len(db.Messages), db.MessageLimit)
+	}
+}
+
+// evict removes closed sessions until at least n messages are gone or none
+// are left.
+func (db *Database) evict(n int) {
+	var closed []ChatSession
+	for _, s := range db.Sessions {
+		if s.ClosedAt != nil {
+			closed = append(closed, s)
+		}
+	}
+	sort.SliceStable(closed, func(i, j int) bool { return closed[i].ClosedAt.Before(*closed[j].ClosedAt) })
+
+	doomed := make(map[string]bool)
+	for _, s := range closed {
+		if n <= 0 {
+			break
+		}
+		doomed[s.ID] = true
+		n -= db.counts.perSession[s.ID]
+	}
+	if len(doomed) == 0 {
+		return
+	}
+	sessions, messages := db.removeSessions(func(s ChatSession) bool { return doomed[s.ID] })
+	now := db.Now()
+	db.limit.sessions += int64(len(sessions))
+	db.limit.messages += int64(len(messages))
+	db.limit.last = &now
+	log.Printf("Message limit: evicted %d closed sessions with %d messages", len(sessions), len(messages))
+}
+
+// LimitStatus reports the message limit's state.
+func (db *Database) LimitStatus() LimitStatus {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	level := db.limit.level
+	if level == "" {
+		level = LimitOK
+	}
+	return LimitStatus{
+		MessageLimit:    db.MessageLimit,
+		Level:           level,
+		EvictedSessions: db.limit.sessions,
+		EvictedMessages: db.limit.messages,
+		LastEvictionAt:  db.limit.last,
+	}
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock tells the time. The database stamps rows with it, and the handlers
+// and the scheduler read it through Database.Now, so a test can freeze time
+// in one place.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// FrozenClock stands still until Set or Advance moves it.
+type FrozenClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func NewFrozenClock(t time.Time) *FrozenClock {
+	return &FrozenClock{t: t}
+}
+
+func (c *FrozenClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *FrozenClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
+
+func (c *FrozenClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// IDGenerator makes the IDs of new rows.
+type IDGenerator interface {
+	NewID() string
+}
+
+// SequentialIDs hands out Prefix1, Prefix2, ... in order.
+type SequentialIDs struct {
+	Prefix string
+
+	mu sync.Mutex
+	n  int
+}
+
+func (s *SequentialIDs) NewID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	return fmt.Sprintf("%s%d", s.Prefix, s.n)
+}
+
+// Now is the database clock's time in UTC. *Database is itself a Clock.
+func (db *Database) Now() time.Time {
+	if db.Clock == nil {
+		return time.Now().UTC()
+	}
+	return db.Clock.Now().UTC()
+}
+
+// newID returns the ID of a new session or message, in IDFormat unless IDs
+// is set.
+func (db *Database) newID() string {
+	if db.IDs != nil {
+		return db.IDs.NewID()
+	}
+	return db.IDFormat.newID(db.Now())
+}
+
+// newRowID returns the ID of any other new row: a UUIDv4 unless IDs is set.
+func (db *Database) newRowID() string {
+	if db.IDs != nil {
+		return db.IDs.NewID()
+	}
+	return uuid.New().String()
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// sessionCodeLength is the length of the short codes given to new sessions.
+// Six Crockford base32 digits give about a billion codes.
+const sessionCodeLength = 6
+
+// newSessionCode returns a code no existing session uses. The caller holds
+// the lock.
+func (db *Database) newSessionCode() string {
+	taken := make(map[string]bool, len(db.Sessions))
+	for _, s := range db.Sessions {
+		if s.Code != nil {
+			taken[*s.Code] = true
+		}
+	}
+	return randomSessionCode(taken)
+}
+
+func (db *Database) codeTaken(code string) bool {
+	for _, s := range db.Sessions {
+		if s.Code != nil && *s.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// randomSessionCode draws codes until one is not in taken.
+func randomSessionCode(taken map[string]bool) string {
+	for {
+		var b [sessionCodeLength]byte
+		rand.Read(b[:])
+		for i := range b {
+			b[i] = crockford[b[i]&31]
+		}
+		if code := string(b[:]); !taken[code] {
+			return code
+		}
+	}
+}
+
+// NormalizeSessionCode turns a code as a visitor might read or type it into
+// its canonical form: case and separators are ignored and the letters
+// Crockford base32 leaves out are read as the digits they resemble.
+func NormalizeSessionCode(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(s) {
+		switch r {
+		case ' ', '-', '_':
+			continue
+		case 'O':
+			r = '0'
+		case 'I', 'L':
+			r = '1'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SessionByCode finds a session by its short code.
+func (db *Database) SessionByCode(code string) (*ChatSession, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	code = NormalizeSessionCode(code)
+	for _, s := range db.Sessions {
+		if s.Code != nil && *s.Code == code {
+			return &s, nil
+		}
+	}
+	return nil, fmt.Errorf("session not found")
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CompactOptions controls Compact. Media files younger than MinAge are kept
+// even when unreferenced, since clients upload a file before posting the
+// message that points at it. DryRun reports what would be removed without
+// touching anything.
+type CompactOptions struct {
+	MinAge time.Duration
+	DryRun bool
+}
+
+// CompactResult lists what Compact removed. MediaRemoved holds storage paths
+// relative to the storage directory, with forward slashes.
+type CompactResult struct {
+	OutboxPruned int      `json:"outbox_pruned"`
+	MediaRemoved []string `json:"media_removed"`
+	MediaBytes   int64    `json:"media_bytes"`
+	DryRun       bool     `json:"dry_run"`
+}
+
+// Compact prunes sent outbox events past retention, rewrites every data file
+// and deletes media under storageDir that no message references. The write
+// lock is held throughout so no message can start referencing a file while
+// the sweep runs.
+func (db *Database) Compact(storageDir string, opts CompactOptions) (*CompactResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := db.Now()
+	res := &CompactResult{MediaRemoved: []string{}, DryRun: opts.DryRun}
+
+	kept := make([]OutboxEvent, 0, len(db.Outbox))
+	for i, e := range db.Outbox {
+		if e.SentAt != nil && now.Sub(*e.SentAt) > outboxRetention && i < len(db.Outbox)-1 {
+			res.OutboxPruned++
+			continue
+		}
+		kept = append(kept, e)
+	}
+
+	referenced := make(map[string]bool)
+	for _, m := range db.Messages {
+		if m.FileURL == nil {
+			continue
+		}
+		if rel, ok := MediaPath(*m.FileURL); ok {
+			referenced[rel] = true
+		}
+	}
+	err := filepath.Walk(storageDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == storageDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		// Dot entries are staging areas (e.g. a restore in progress).
+		if strings.HasPrefix(info.Name(), ".") && p != storageDir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || now.Sub(info.ModTime()) < opts.MinAge {
+			return nil
+		}
+		rel, err := filepath.Rel(storageDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if referenced[rel] {
+			return nil
+		}
+		if !opts.DryRun {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+		}
+		res.MediaRemoved = append(res.MediaRemoved, rel)
+		res.MediaBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		return res, nil
+	}
+	db.Outbox = kept
+	if err := db.save(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
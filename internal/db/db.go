@@ -1,29 +1,91 @@
 package db
 
 import (
+	"chat-quick-chat-server/internal/encryption"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
 	"sync"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 type Database struct {
-	Sessions []ChatSession
-	Messages []Message
-	mu       sync.RWMutex
-	DataDir  string
+	Sessions     []ChatSession
+	Messages     []Message
+	Participants []Participant
+	Flags        []Flag
+	Reactions    []Reaction
+	Outbox       []OutboxEvent
+	mu           sync.RWMutex
+	DataDir      string
+	// Cipher encrypts sessions.json and messages.json at rest. Nil stores
+	// them as plain JSON.
+	Cipher *encryption.Cipher
+	// IDFormat is used for new session and message IDs; empty means UUIDv4.
+	IDFormat IDFormat
+	// Clock stamps new and changed rows; nil is the wall clock. IDs, when
+	// set, makes the IDs of all new rows instead of IDFormat.
+	Clock Clock
+	IDs   IDGenerator
+	// MediaDir, when set, lets the load-time integrity check confirm that
+	// file URLs still point at stored media. Load always salvages damaged
+	// files and rows; Repair makes it fix the other problems it finds
+	// instead of only reporting them, and StrictLoad makes it fail on
+	// damage without touching anything.
+	MediaDir   string
+	Repair     bool
+	StrictLoad bool
+	// Durability controls whether saves are atomic and fsynced; empty means
+	// DurabilityNone. Under DurabilityInterval, run RunSync alongside.
+	Durability Durability
+	index      searchIndex
+	seqs       map[string]int64
+	counts     counters
+
+	outboxPending bool
+	outboxSeq     int64
+	outboxReady   chan struct{}
+	// unsynced lists files written since the last Sync.
+	unsynced map[string]bool
+
+	// MaxUnsaved bounds how many changes are kept in memory while the disk
+	// is full before writes fail with ErrReadOnly; 0 means
+	// DefaultMaxUnsaved. OnDiskFull is called when saving starts failing for
+	// lack of space (true) and when it recovers (false).
+	MaxUnsaved int
+	OnDiskFull func(full bool)
+	diskFull   bool
+	unsaved    int
+
+	// MessageLimit caps the stored messages; 0 means no limit. Past it,
+	// closed sessions are evicted, oldest first (see enforceLimit).
+	// OnMessageLimit is called with the new level when the count reaches
+	// MessageLimitWarn of the limit (0 means DefaultMessageLimitWarn), passes
+	// the limit with nothing left to evict, or falls back under the warning.
+	MessageLimit     int
+	MessageLimitWarn float64
+	OnMessageLimit   func(level string, messages, limit int)
+	limit            limitState
+
+	// loadErr is what the last Load returned.
+	loadErr error
 }
 
 func New(dataDir string) *Database {
 	return &Database{
-		Sessions: []ChatSession{},
-		Messages: []Message{},
-		DataDir:  dataDir,
+		Sessions:     []ChatSession{},
+		Messages:     []Message{},
+		Participants: []Participant{},
+		Flags:        []Flag{},
+		Reactions:    []Reaction{},
+		Outbox:       []OutboxEvent{},
+		DataDir:      dataDir,
+		index:        make(searchIndex),
+		seqs:         make(map[string]int64),
+		outboxReady:  make(chan struct{}, 1),
+		counts:       counters{perSession: make(map[string]int), hourly: make(map[int64]int)},
 	}
 }
 
@@ -31,37 +93,102 @@ func (db *Database) Load() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	// Load Sessions
-	sessionsFile := filepath.Join(db.DataDir, "sessions.json")
-	if _, err := os.Stat(sessionsFile); err == nil {
-		data, err := os.ReadFile(sessionsFile)
+	db.loadErr = db.load()
+	return db.loadErr
+}
+
+// LoadError is the error of the last Load, nil when it succeeded. The server
+// keeps running on a failed load, with whatever was read.
+func (db *Database) LoadError() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.loadErr
+}
+
+func (db *Database) load() error {
+	db.Sessions = []ChatSession{}
+	db.Messages = []Message{}
+	db.Participants = []Participant{}
+	db.Flags = []Flag{}
+	db.Reactions = []Reaction{}
+	db.Outbox = []OutboxEvent{}
+
+	var issues []IntegrityIssue
+	if err := db.migrate(&issues); err != nil {
+		return err
+	}
+	if db.StrictLoad && salvaged(issues) {
+		logIssues(issues, "nothing changed (strict load)")
+		return ErrIntegrity
+	}
+
+	for _, err := range []error{
+		loadRows(db, "sessions.json", &db.Sessions, &issues),
+		loadRows(db, "messages.json", &db.Messages, &issues),
+		loadRows(db, "participants.json", &db.Participants, &issues),
+		loadRows(db, "flags.json", &db.Flags, &issues),
+		loadRows(db, "reactions.json", &db.Reactions, &issues),
+		loadRows(db, "outbox.json", &db.Outbox, &issues),
+	} {
 		if err != nil {
 			return err
 		}
-		if len(data) > 0 {
-			if err := json.Unmarshal(data, &db.Sessions); err != nil {
-				return err
-			}
-		}
 	}
 
-	// Load Messages
-	messagesFile := filepath.Join(db.DataDir, "messages.json")
-	if _, err := os.Stat(messagesFile); err == nil {
-		data, err := os.ReadFile(messagesFile)
-		if err != nil {
+	db.checkIntegrity(&issues)
+	if len(issues) > 0 {
+		if db.StrictLoad && salvaged(issues) {
+			logIssues(issues, "nothing changed (strict load)")
+			return ErrIntegrity
+		}
+		fix, outcome := db.quarantineUnparsable, "damaged data was salvaged; start with -repair to fix the rest"
+		if db.Repair {
+			fix, outcome = db.repair, "repaired"
+		}
+		logIssues(issues, outcome)
+		if err := fix(issues); err != nil {
 			return err
 		}
-		if len(data) > 0 {
-			if err := json.Unmarshal(data, &db.Messages); err != nil {
-				return err
-			}
+	}
+
+	db.outboxSeq = 0
+	for _, e := range db.Outbox {
+		if e.Seq > db.outboxSeq {
+			db.outboxSeq = e.Seq
 		}
 	}
 
+	db.rebuildIndex()
+	db.rebuildSeqs()
+	db.recountReplies()
+	db.rebuildCounters()
+
+	if db.Repair && len(issues) > 0 || salvaged(issues) {
+		return db.save()
+	}
 	return nil
 }
 
+// ReadLocked runs fn while holding the read lock, so no save can interleave
+// with it. Used to take consistent copies of the data files.
+func (db *Database) ReadLocked(fn func() error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return fn()
+}
+
+// Replace runs fn with exclusive access to the data directory and then
+// reloads the in-memory state from whatever fn left on disk.
+func (db *Database) Replace(fn func() error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := fn(); err != nil {
+		return err
+	}
+	return db.load()
+}
+
 func (db *Database) Save() error {
 	// Lock is held by caller usually, but here we might want to lock inside.
 	// To avoid deadlocks, let's assume caller handles logic or we lock here.
@@ -73,6 +200,15 @@ func (db *Database) Save() error {
 }
 
 func (db *Database) save() error {
+	db.enforceLimit()
+	if err := db.absorb(db.writeAll()); err != nil {
+		return err
+	}
+	db.signalOutbox()
+	return nil
+}
+
+func (db *Database) writeAll() error {
 	// Ensure directory exists
 	if err := os.MkdirAll(db.DataDir, 0755); err != nil {
 		return err
@@ -83,7 +219,7 @@ func (db *Database) save() error {
 	if err != nil {
 		return err
 	}
-	if err := os.WriteFile(sessionsFile, sessionsData, 0644); err != nil {
+	if err := db.writeFile(sessionsFile, sessionsData); err != nil {
 		return err
 	}
 
@@ -92,23 +228,66 @@ func (db *Database) save() error {
 	if err != nil {
 		return err
 	}
-	if err := os.WriteFile(messagesFile, messagesData, 0644); err != nil {
+	if err := db.writeFile(messagesFile, messagesData); err != nil {
 		return err
 	}
 
-	return nil
+	participantsFile := filepath.Join(db.DataDir, "participants.json")
+	participantsData, err := json.MarshalIndent(db.Participants, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := db.writeFile(participantsFile, participantsData); err != nil {
+		return err
+	}
+
+	flagsFile := filepath.Join(db.DataDir, "flags.json")
+	flagsData, err := json.MarshalIndent(db.Flags, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := db.writeFile(flagsFile, flagsData); err != nil {
+		return err
+	}
+
+	reactionsFile := filepath.Join(db.DataDir, "reactions.json")
+	reactionsData, err := json.MarshalIndent(db.Reactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := db.writeFile(reactionsFile, reactionsData); err != nil {
+		return err
+	}
+
+	return db.saveOutbox()
 }
 
-func (db *Database) CreateSession() (*ChatSession, error) {
+func (db *Database) CreateSession(session ChatSession) (*ChatSession, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	session := ChatSession{
-		ID:        uuid.New().String(),
-		CreatedAt: time.Now().UTC(),
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	if session.ID == "" {
+		session.ID = db.newID()
+	}
+	if session.Code == nil {
+		code := db.newSessionCode()
+		session.Code = &code
+	}
+	for _, s := range db.Sessions {
+		if s.ID == session.ID {
+			return nil, fmt.Errorf("session already exists")
+		}
+	}
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = db.Now()
 	}
 
 	db.Sessions = append(db.Sessions, session)
+	db.enqueueInbox(session, InboxSessionCreated)
 	if err := db.save(); err != nil {
 		return nil, err
 	}
@@ -120,6 +299,10 @@ func (db *Database) GetSession(id string) (*ChatSession, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	return db.getSession(id)
+}
+
+func (db *Database) getSession(id string) (*ChatSession, error) {
 	for _, s := range db.Sessions {
 		if s.ID == id {
 			return &s, nil
@@ -132,19 +315,101 @@ func (db *Database) CreateMessage(msg Message) (*Message, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	created, err := db.createMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.save(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// ErrDuplicateMessage is returned for a new message whose client-chosen ID
+// is already taken.
+var ErrDuplicateMessage = errors.New("a message with this id already exists")
+
+// createMessage is CreateMessage without locking or saving.
+func (db *Database) createMessage(msg Message) (*Message, error) {
 	if msg.ID == "" {
-		msg.ID = uuid.New().String()
+		msg.ID = db.newID()
+	} else if _, err := db.getMessage(msg.ID); err == nil {
+		return nil, ErrDuplicateMessage
 	}
 	if msg.CreatedAt.IsZero() {
-		msg.CreatedAt = time.Now().UTC()
+		msg.CreatedAt = db.Now()
+	}
+	msg.ReplyCount = 0
+	if err := db.addReply(msg); err != nil {
+		return nil, err
 	}
+	msg.Seq = db.nextSeq(msg.SessionID)
 
 	db.Messages = append(db.Messages, msg)
+	db.index.add(msg)
+	db.countMessage(msg)
+	db.enqueueChange(msg.SessionID, "messages", "INSERT", msg)
+	return &msg, nil
+}
+
+// UpdateMessage applies update to a copy of the message and stores it,
+// marking it edited. The search index and subscribers see the new version.
+func (db *Database) UpdateMessage(id string, update func(m *Message) error) (*Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	msg, err := db.updateMessage(id, update)
+	if err != nil {
+		return nil, err
+	}
 	if err := db.save(); err != nil {
 		return nil, err
 	}
+	return msg, nil
+}
 
-	return &msg, nil
+func (db *Database) updateMessage(id string, update func(m *Message) error) (*Message, error) {
+	for i := range db.Messages {
+		if db.Messages[i].ID != id {
+			continue
+		}
+		msg := db.Messages[i]
+		if err := update(&msg); err != nil {
+			return nil, err
+		}
+		now := db.Now()
+		msg.EditedAt = &now
+		db.index.remove(db.Messages[i])
+		db.Messages[i] = msg
+		db.index.add(msg)
+		db.enqueueChange(msg.SessionID, "messages", "UPDATE", msg)
+		return &msg, nil
+	}
+	return nil, fmt.Errorf("message not found")
+}
+
+func (db *Database) GetMessage(id string) (*Message, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getMessage(id)
+}
+
+func (db *Database) getMessage(id string) (*Message, error) {
+	for _, m := range db.Messages {
+		if m.ID == id {
+			return &m, nil
+		}
+	}
+	return nil, fmt.Errorf("message not found")
 }
 
 func (db *Database) GetMessages(sessionID string) ([]Message, error) {
@@ -158,10 +423,373 @@ func (db *Database) GetMessages(sessionID string) ([]Message, error) {
 		}
 	}
 
-	// Sort by CreatedAt ascending
-	sort.Slice(result, func(i, j int) bool {
-		return result[i].CreatedAt.Before(result[j].CreatedAt)
-	})
+	// Sort by seq ascending
+	sortMessages(result)
 
 	return result, nil
 }
+
+// SessionListing is a session plus the time of its newest message, as
+// returned by ListSessions.
+type SessionListing struct {
+	ChatSession
+	LastMessageAt *time.Time `json:"last_message_at"`
+}
+
+// ListSessions returns every session with its last_message_at, in storage
+// order. Filtering and ordering are left to the caller.
+func (db *Database) ListSessions() []SessionListing {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	last := make(map[string]time.Time)
+	for _, m := range db.Messages {
+		if m.CreatedAt.After(last[m.SessionID]) {
+			last[m.SessionID] = m.CreatedAt
+		}
+	}
+	result := make([]SessionListing, 0, len(db.Sessions))
+	for _, s := range db.Sessions {
+		l := SessionListing{ChatSession: s}
+		if t, ok := last[s.ID]; ok {
+			l.LastMessageAt = &t
+		}
+		result = append(result, l)
+	}
+	return result
+}
+
+// SessionActivity describes an open session and what happened in it.
+type SessionActivity struct {
+	Session ChatSession
+	// LastActivity is the time of the last participant message, or the
+	// session creation time when nobody has written yet. Bot and system
+	// messages don't count as activity.
+	LastActivity time.Time
+	// Replied reports whether any participant message exists.
+	Replied bool
+	// Warned reports whether a system message was posted after the last
+	// participant message, i.e. the inactivity warning already went out.
+	Warned bool
+	// LastBotMessage is the time of the newest bot-origin message.
+	LastBotMessage time.Time
+}
+
+// OpenSessionActivity summarises every open session for the scheduler.
+func (db *Database) OpenSessionActivity() []SessionActivity {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	lastActivity := make(map[string]time.Time)
+	lastSystem := make(map[string]time.Time)
+	lastBot := make(map[string]time.Time)
+	for _, m := range db.Messages {
+		target := lastActivity
+		if m.MessageType == MessageTypeSystem {
+			// Only idle warnings (and untyped notices from older builds)
+			// count as a warning; other events must not suppress one.
+			if m.Event != nil && m.Event.Kind != EventIdleWarning {
+				continue
+			}
+			target = lastSystem
+		} else if m.Origin != nil && *m.Origin == OriginBot {
+			target = lastBot
+		}
+		if m.CreatedAt.After(target[m.SessionID]) {
+			target[m.SessionID] = m.CreatedAt
+		}
+	}
+
+	var result []SessionActivity
+	for _, s := range db.Sessions {
+		if s.ClosedAt != nil {
+			continue
+		}
+		last := s.CreatedAt
+		t, replied := lastActivity[s.ID]
+		if replied && t.After(last) {
+			last = t
+		}
+		result = append(result, SessionActivity{
+			Session:        s,
+			LastActivity:   last,
+			Replied:        replied,
+			Warned:         lastSystem[s.ID].After(last),
+			LastBotMessage: lastBot[s.ID],
+		})
+	}
+	return result
+}
+
+func (db *Database) CloseSession(id string, reason string) (*ChatSession, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	for i := range db.Sessions {
+		if db.Sessions[i].ID != id {
+			continue
+		}
+		if db.Sessions[i].ClosedAt != nil {
+			return nil, fmt.Errorf("session already closed")
+		}
+		now := db.Now()
+		db.Sessions[i].ClosedAt = &now
+		db.Sessions[i].CloseReason = &reason
+		db.enqueueInbox(db.Sessions[i], InboxSessionClosed)
+		if err := db.save(); err != nil {
+			return nil, err
+		}
+		session := db.Sessions[i]
+		return &session, nil
+	}
+	return nil, fmt.Errorf("session not found")
+}
+
+// UpdateSession applies update to a copy of the session and stores the result
+// unless update returns an error.
+func (db *Database) UpdateSession(id string, update func(s *ChatSession) error) (*ChatSession, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	session, err := db.updateSession(id, update)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.save(); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (db *Database) updateSession(id string, update func(s *ChatSession) error) (*ChatSession, error) {
+	for i := range db.Sessions {
+		if db.Sessions[i].ID != id {
+			continue
+		}
+		session := db.Sessions[i]
+		if err := update(&session); err != nil {
+			return nil, err
+		}
+		before := db.Sessions[i]
+		db.Sessions[i] = session
+		if !equalPtr(before.AssignedTo, session.AssignedTo) {
+			db.enqueueInbox(session, InboxSessionAssigned)
+		}
+		if before.ClosedAt == nil && session.ClosedAt != nil {
+			db.enqueueInbox(session, InboxSessionClosed)
+		}
+		return &session, nil
+	}
+	return nil, fmt.Errorf("session not found")
+}
+
+// MergeSessions moves every message of source into target and tombstones
+// source: it is closed with reason "merged" and MergedInto set so clients
+// holding the old ID can follow the redirect. repoint, when non-nil, may
+// rewrite each moved message's file_url (e.g. after relocating the media).
+// On error nothing is changed, so the caller can undo what repoint did.
+func (db *Database) MergeSessions(sourceID, targetID string, repoint func(fileURL string) (string, error)) (*ChatSession, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	if sourceID == targetID {
+		return nil, fmt.Errorf("cannot merge a session into itself")
+	}
+	var source, target *ChatSession
+	for i := range db.Sessions {
+		switch db.Sessions[i].ID {
+		case sourceID:
+			source = &db.Sessions[i]
+		case targetID:
+			target = &db.Sessions[i]
+		}
+	}
+	if source == nil || target == nil {
+		return nil, fmt.Errorf("session not found")
+	}
+	if source.MergedInto != nil {
+		return nil, fmt.Errorf("session already merged")
+	}
+	if target.MergedInto != nil {
+		return nil, fmt.Errorf("target session was merged into %s", *target.MergedInto)
+	}
+
+	previousSessions := append([]ChatSession(nil), db.Sessions...)
+	previousMessages := append([]Message(nil), db.Messages...)
+	previousParticipants := append([]Participant(nil), db.Participants...)
+	previousFlags := append([]Flag(nil), db.Flags...)
+	previousReactions := append([]Reaction(nil), db.Reactions...)
+	previousOutbox := len(db.Outbox)
+	previousSeqs := make(map[string]int64, len(db.seqs))
+	for id, seq := range db.seqs {
+		previousSeqs[id] = seq
+	}
+	rollback := func() {
+		db.Sessions = previousSessions
+		db.Messages = previousMessages
+		db.Participants = previousParticipants
+		db.Flags = previousFlags
+		db.Reactions = previousReactions
+		db.Outbox = db.Outbox[:previousOutbox]
+		db.seqs = previousSeqs
+		db.rebuildIndex()
+		db.rebuildCounters()
+	}
+	for i := range db.Messages {
+		m := &db.Messages[i]
+		if m.SessionID != sourceID {
+			continue
+		}
+		m.SessionID = targetID
+		if repoint != nil && m.FileURL != nil {
+			url, err := repoint(*m.FileURL)
+			if err != nil {
+				rollback()
+				return nil, err
+			}
+			m.FileURL = &url
+		}
+	}
+
+	now := db.Now()
+	reason := CloseReasonMerged
+	source.MergedInto = &targetID
+	source.ClosedAt = &now
+	source.CloseReason = &reason
+
+	names := make(map[string]bool)
+	for _, p := range db.Participants {
+		if p.SessionID == targetID {
+			names[p.DisplayName] = true
+		}
+	}
+	participants := db.Participants[:0]
+	for _, p := range db.Participants {
+		if p.SessionID == sourceID {
+			if names[p.DisplayName] {
+				continue
+			}
+			p.SessionID = targetID
+		}
+		participants = append(participants, p)
+	}
+	db.Participants = participants
+
+	for i := range db.Flags {
+		if db.Flags[i].SessionID == sourceID {
+			db.Flags[i].SessionID = targetID
+		}
+	}
+	for i := range db.Reactions {
+		if db.Reactions[i].SessionID == sourceID {
+			db.Reactions[i].SessionID = targetID
+		}
+	}
+
+	db.resequence(map[string]bool{targetID: true})
+	db.rebuildIndex()
+	db.rebuildCounters()
+	db.enqueueInbox(*source, InboxSessionClosed)
+	if err := db.save(); err != nil {
+		rollback()
+		return nil, err
+	}
+	merged := *target
+	return &merged, nil
+}
+
+// ImportResult counts what Import added and skipped.
+type ImportResult struct {
+	Sessions        int
+	Messages        int
+	SkippedMessages int
+}
+
+// Import adds sessions and messages that aren't already present, keeping
+// their IDs and timestamps. Sessions referenced by messages but missing from
+// both the database and the input are created with the earliest message
+// time, so a bare dump of the messages table can be imported on its own.
+func (db *Database) Import(sessions []ChatSession, messages []Message) (ImportResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return ImportResult{}, err
+	}
+
+	var res ImportResult
+	known := make(map[string]int, len(db.Sessions))
+	for i, s := range db.Sessions {
+		known[s.ID] = i
+	}
+	for _, s := range sessions {
+		if _, ok := known[s.ID]; ok || s.ID == "" {
+			continue
+		}
+		if s.CreatedAt.IsZero() {
+			s.CreatedAt = db.Now()
+		}
+		// Codes are only unique per server; an imported code that is
+		// already in use here is replaced.
+		if s.Code == nil || db.codeTaken(*s.Code) {
+			code := db.newSessionCode()
+			s.Code = &code
+		}
+		db.Sessions = append(db.Sessions, s)
+		known[s.ID] = len(db.Sessions) - 1
+		res.Sessions++
+	}
+
+	synthesized := make(map[string]bool)
+	touched := make(map[string]bool)
+	seen := make(map[string]bool, len(db.Messages))
+	for _, m := range db.Messages {
+		seen[m.ID] = true
+	}
+	for _, m := range messages {
+		if m.SessionID == "" || seen[m.ID] {
+			res.SkippedMessages++
+			continue
+		}
+		if m.ID == "" {
+			m.ID = db.newID()
+		}
+		if m.CreatedAt.IsZero() {
+			m.CreatedAt = db.Now()
+		}
+		if i, ok := known[m.SessionID]; !ok {
+			code := db.newSessionCode()
+			db.Sessions = append(db.Sessions, ChatSession{ID: m.SessionID, Code: &code, CreatedAt: m.CreatedAt})
+			known[m.SessionID] = len(db.Sessions) - 1
+			synthesized[m.SessionID] = true
+			res.Sessions++
+		} else if synthesized[m.SessionID] && m.CreatedAt.Before(db.Sessions[i].CreatedAt) {
+			db.Sessions[i].CreatedAt = m.CreatedAt
+		}
+		db.Messages = append(db.Messages, m)
+		db.index.add(m)
+		seen[m.ID] = true
+		touched[m.SessionID] = true
+		res.Messages++
+	}
+	db.resequence(touched)
+	db.recountReplies()
+	db.rebuildCounters()
+
+	if err := db.save(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
@@ -0,0 +1,122 @@
+package db
+
+// DeleteMessages removes the messages match selects, together with their
+// replies and reactions, and returns them in storage order. Each removal
+// reaches subscribers as a DELETE. Review flags are kept as the audit trail.
+func (db *Database) DeleteMessages(match func(m Message) bool) ([]Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	doomed := make(map[string]bool)
+	for _, m := range db.Messages {
+		if match(m) {
+			doomed[m.ID] = true
+		}
+	}
+	// Replies go with their thread, as with ON DELETE CASCADE. A reply
+	// always comes after its parent, so one pass finds nested ones too.
+	for _, m := range db.Messages {
+		if m.ParentMessageID != nil && doomed[*m.ParentMessageID] {
+			doomed[m.ID] = true
+		}
+	}
+	removed := db.removeMessages(func(m Message) bool { return doomed[m.ID] })
+	if len(removed) > 0 {
+		if err := db.save(); err != nil {
+			return nil, err
+		}
+	}
+	return removed, nil
+}
+
+// DeleteSessions removes the sessions match selects with everything stored
+// about them: messages, participants, reactions and review flags. It
+// returns the sessions and their messages.
+func (db *Database) DeleteSessions(match func(s ChatSession) bool) ([]ChatSession, []Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, nil, err
+	}
+
+	removed, messages := db.removeSessions(match)
+	if len(removed) == 0 {
+		return removed, messages, nil
+	}
+
+	if err := db.save(); err != nil {
+		return nil, nil, err
+	}
+	return removed, messages, nil
+}
+
+// removeSessions drops the selected sessions and everything stored about
+// them. The caller saves.
+func (db *Database) removeSessions(match func(s ChatSession) bool) ([]ChatSession, []Message) {
+	removed := []ChatSession{}
+	doomed := make(map[string]bool)
+	kept := db.Sessions[:0]
+	for _, s := range db.Sessions {
+		if match(s) {
+			removed = append(removed, s)
+			doomed[s.ID] = true
+			continue
+		}
+		kept = append(kept, s)
+	}
+	db.Sessions = kept
+	if len(removed) == 0 {
+		return removed, []Message{}
+	}
+
+	messages := db.removeMessages(func(m Message) bool { return doomed[m.SessionID] })
+	participants := db.Participants[:0]
+	for _, p := range db.Participants {
+		if !doomed[p.SessionID] {
+			participants = append(participants, p)
+		}
+	}
+	db.Participants = participants
+	flags := db.Flags[:0]
+	for _, f := range db.Flags {
+		if !doomed[f.SessionID] {
+			flags = append(flags, f)
+		}
+	}
+	db.Flags = flags
+	for id := range doomed {
+		delete(db.seqs, id)
+	}
+	return removed, messages
+}
+
+// removeMessages drops the selected messages and their reactions and
+// queues their DELETE events. The caller saves.
+func (db *Database) removeMessages(match func(m Message) bool) []Message {
+	removed := []Message{}
+	kept := db.Messages[:0]
+	for _, m := range db.Messages {
+		if match(m) {
+			removed = append(removed, m)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	db.Messages = kept
+	if len(removed) == 0 {
+		return removed
+	}
+	for _, m := range removed {
+		db.removeReactions(m.ID)
+		db.enqueueChange(m.SessionID, "messages", "DELETE", m)
+	}
+	db.recountReplies()
+	db.rebuildIndex()
+	db.rebuildCounters()
+	return removed
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"syscall"
+	"time"
+)
+
+// ErrReadOnly is returned by writes while the disk is full and the backlog of
+// unsaved changes has reached MaxUnsaved.
+var ErrReadOnly = errors.New("storage is full; writes are paused")
+
+// DefaultMaxUnsaved is the unsaved-change bound used when MaxUnsaved is 0.
+const DefaultMaxUnsaved = 1000
+
+// IsNoSpace reports whether err means the device is out of space or quota.
+func IsNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// absorb decides what a save error means for the caller. Running out of
+// space is not an error for the write that hit it: the change stays in
+// memory, counted as unsaved, and is written by the next save that
+// succeeds. The caller holds the lock.
+func (db *Database) absorb(err error) error {
+	if err == nil {
+		if db.diskFull {
+			log.Printf("Storage writable again; %d queued changes saved", db.unsaved)
+			db.diskFull, db.unsaved = false, 0
+			db.notifyDisk(false)
+		}
+		return nil
+	}
+	if !IsNoSpace(err) {
+		return err
+	}
+	db.unsaved++
+	if !db.diskFull {
+		log.Printf("Storage full, queueing changes in memory: %v", err)
+		db.diskFull = true
+		db.notifyDisk(true)
+	}
+	return nil
+}
+
+func (db *Database) notifyDisk(full bool) {
+	if db.OnDiskFull != nil {
+		go db.OnDiskFull(full)
+	}
+}
+
+// writable refuses new changes once the in-memory backlog is full. The caller
+// holds the lock.
+func (db *Database) writable() error {
+	max := db.MaxUnsaved
+	if max <= 0 {
+		max = DefaultMaxUnsaved
+	}
+	if db.diskFull && db.unsaved >= max {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// ReadOnly reports whether writes are currently refused.
+func (db *Database) ReadOnly() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.writable() != nil
+}
+
+// DiskStatus reports whether the last save ran out of space and how many
+// changes are waiting to be written.
+func (db *Database) DiskStatus() (full bool, unsaved int) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.diskFull, db.unsaved
+}
+
+// NoteDiskFull records that something else, such as a media upload, ran out
+// of space. The next successful save clears it.
+func (db *Database) NoteDiskFull() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.diskFull {
+		db.diskFull = true
+		db.notifyDisk(true)
+	}
+}
+
+// RetrySave is a scheduler job that flushes queued changes once space is
+// available again, without waiting for the next write.
+func (db *Database) RetrySave(now time.Time) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.diskFull {
+		if err := db.save(); err != nil {
+			log.Printf("Retrying save failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Durability says how hard save tries to get data files onto stable storage.
+type Durability string
+
+const (
+	// DurabilityNone replaces files atomically (write, then rename) and
+	// leaves flushing to the OS. A failed write, e.g. on a full disk, leaves
+	// the old file in place, but a power loss can lose recent writes.
+	DurabilityNone Durability = "none"
+	// DurabilityInterval also fsyncs the files in the background every
+	// SyncInterval. A failed write leaves the old file in place; a power loss
+	// can still lose the writes of the last interval, and on filesystems
+	// that reorder the rename ahead of the data, leave such a file empty.
+	DurabilityInterval Durability = "interval"
+	// DurabilityAlways fsyncs each file before it is renamed into place, and
+	// the directory after, before save returns.
+	DurabilityAlways Durability = "always-fsync"
+)
+
+// DefaultSyncInterval is the background fsync period of DurabilityInterval.
+const DefaultSyncInterval = time.Second
+
+// ParseDurability validates a level name; "" is DurabilityNone.
+func ParseDurability(s string) (Durability, error) {
+	switch d := Durability(s); d {
+	case "":
+		return DurabilityNone, nil
+	case DurabilityNone, DurabilityInterval, DurabilityAlways:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown durability %q (want always-fsync, interval or none)", s)
+}
+
+// writeFile encrypts and writes one data file according to db.Durability.
+// The caller holds the lock.
+func (db *Database) writeFile(path string, plain []byte) error {
+	data, err := db.Cipher.Seal(plain)
+	if err != nil {
+		return err
+	}
+	return db.replaceFile(path, data)
+}
+
+// replaceFile writes data to path according to db.Durability. The caller
+// holds the lock.
+func (db *Database) replaceFile(path string, data []byte) error {
+	// Every level writes a temporary file and renames it over the old one,
+	// so a failed write never truncates a data file; they differ only in
+	// when the data is fsynced.
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && db.Durability == DurabilityAlways {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	switch db.Durability {
+	case DurabilityAlways:
+		return syncDir(filepath.Dir(path))
+	case DurabilityInterval:
+		if db.unsynced == nil {
+			db.unsynced = make(map[string]bool)
+		}
+		db.unsynced[path] = true
+	}
+	return nil
+}
+
+// RunSync fsyncs the files written since the last pass, every interval. It
+// only has work to do under DurabilityInterval and never returns.
+func (db *Database) RunSync(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	for range time.Tick(interval) {
+		if err := db.Sync(); err != nil {
+			log.Printf("Sync failed: %v", err)
+		}
+	}
+}
+
+// Sync flushes files written under DurabilityInterval to stable storage.
+func (db *Database) Sync() error {
+	db.mu.Lock()
+	paths := db.unsynced
+	db.unsynced = nil
+	db.mu.Unlock()
+	if len(paths) == 0 {
+		return nil
+	}
+
+	dirs := make(map[string]bool)
+	var firstErr error
+	for path := range paths {
+		dirs[filepath.Dir(path)] = true
+		if err := syncFile(path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// syncDir makes a rename in dir durable. Not every platform can fsync a
+// directory, so failures to do so are ignored.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	f.Sync()
+	return f.Close()
+}
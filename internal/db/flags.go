@@ -0,0 +1,249 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FlagMessage puts a message in the review queue. If the message already has
+// an open flag, the new flag is recorded in that flag's history instead.
+func (db *Database) FlagMessage(messageID, source string, reason, actor *string) (*Flag, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	f, err := db.flagMessage(messageID, source, reason, actor)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.save(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (db *Database) flagMessage(messageID, source string, reason, actor *string) (*Flag, error) {
+	var msg *Message
+	for i := range db.Messages {
+		if db.Messages[i].ID == messageID {
+			msg = &db.Messages[i]
+			break
+		}
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("message not found")
+	}
+
+	now := db.Now()
+	event := FlagEvent{At: now, Action: "flagged:" + source, Actor: actor, Note: reason}
+	for i := range db.Flags {
+		f := &db.Flags[i]
+		if f.MessageID == messageID && f.Decision == nil {
+			f.History = append(f.History, event)
+			flag := *f
+			return &flag, nil
+		}
+	}
+
+	f := Flag{
+		ID:        db.newRowID(),
+		MessageID: messageID,
+		SessionID: msg.SessionID,
+		Source:    source,
+		Reason:    reason,
+		CreatedAt: now,
+		History:   []FlagEvent{event},
+	}
+	db.Flags = append(db.Flags, f)
+	return &f, nil
+}
+
+// GetFlags lists flags oldest first. status is "open", "resolved" or "" for
+// both; a non-empty assignedTo keeps only that reviewer's flags.
+func (db *Database) GetFlags(status, assignedTo string) ([]Flag, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	result := []Flag{}
+	for _, f := range db.Flags {
+		if status == "open" && f.Decision != nil || status == "resolved" && f.Decision == nil {
+			continue
+		}
+		if assignedTo != "" && (f.AssignedTo == nil || *f.AssignedTo != assignedTo) {
+			continue
+		}
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// AssignFlag hands an open flag to a reviewer.
+func (db *Database) AssignFlag(id, reviewer string, actor *string) (*Flag, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	f, err := db.openFlag(id)
+	if err != nil {
+		return nil, err
+	}
+	f.AssignedTo = &reviewer
+	f.History = append(f.History, FlagEvent{At: db.Now(), Action: "assigned", Actor: actor, Note: &reviewer})
+	if err := db.save(); err != nil {
+		return nil, err
+	}
+	flag := *f
+	return &flag, nil
+}
+
+// Review is the outcome of ResolveFlag. Message is the message as it now
+// stands (nil if the decision removed it) and Removed the message that was
+// deleted; Session is set when a ban closed the session. FileURL is the
+// upload the message referenced before the decision, if any.
+type Review struct {
+	Flag    *Flag
+	Message *Message
+	Removed *Message
+	Session *ChatSession
+	FileURL *string
+}
+
+// ResolveFlag applies a review decision:
+//   - allow leaves the message alone;
+//   - redact clears its content, file and metadata;
+//   - delete removes it;
+//   - ban removes it and closes the session with reason "banned".
+func (db *Database) ResolveFlag(id, decision string, actor, note *string) (*Review, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	switch decision {
+	case DecisionAllow, DecisionRedact, DecisionDelete, DecisionBan:
+	default:
+		return nil, fmt.Errorf("unknown decision %q", decision)
+	}
+	f, err := db.openFlag(id)
+	if err != nil {
+		return nil, err
+	}
+
+	review := &Review{}
+	idx := -1
+	for i := range db.Messages {
+		if db.Messages[i].ID == f.MessageID {
+			idx = i
+			break
+		}
+	}
+	if idx >= 0 {
+		review.FileURL = db.Messages[idx].FileURL
+		switch decision {
+		case DecisionAllow:
+			m := db.Messages[idx]
+			review.Message = &m
+		case DecisionRedact:
+			m := &db.Messages[idx]
+			m.Content, m.FileURL, m.Metadata = nil, nil, nil
+			redacted := *m
+			review.Message = &redacted
+			db.enqueueChange(m.SessionID, "messages", "UPDATE", redacted)
+		case DecisionDelete, DecisionBan:
+			removed := db.Messages[idx]
+			review.Removed = &removed
+			db.Messages = append(db.Messages[:idx], db.Messages[idx+1:]...)
+			db.enqueueChange(removed.SessionID, "messages", "DELETE", removed)
+			db.removeReactions(removed.ID)
+			db.recountReplies()
+			db.rebuildCounters()
+		}
+	}
+	if decision == DecisionBan {
+		for i := range db.Sessions {
+			s := &db.Sessions[i]
+			if s.ID == f.SessionID && s.ClosedAt == nil {
+				now := db.Now()
+				reason := CloseReasonBanned
+				s.ClosedAt, s.CloseReason = &now, &reason
+				closed := *s
+				review.Session = &closed
+				db.enqueueInbox(closed, InboxSessionClosed)
+			}
+		}
+	}
+	if decision != DecisionAllow {
+		db.rebuildIndex()
+	}
+
+	now := db.Now()
+	f.Decision = &decision
+	f.ResolvedAt = &now
+	f.History = append(f.History, FlagEvent{At: now, Action: decision, Actor: actor, Note: note})
+	if err := db.save(); err != nil {
+		return nil, err
+	}
+	flag := *f
+	review.Flag = &flag
+	return review, nil
+}
+
+func (db *Database) openFlag(id string) (*Flag, error) {
+	for i := range db.Flags {
+		if db.Flags[i].ID == id {
+			if db.Flags[i].Decision != nil {
+				return nil, fmt.Errorf("flag already resolved")
+			}
+			return &db.Flags[i], nil
+		}
+	}
+	return nil, fmt.Errorf("flag not found")
+}
+
+// ReviewStats summarises the queue. MedianReviewSeconds is the median time
+// from flag to decision over resolved flags, nil while nothing is resolved.
+type ReviewStats struct {
+	Open                int      `json:"open"`
+	Unassigned          int      `json:"unassigned"`
+	Resolved            int      `json:"resolved"`
+	MedianReviewSeconds *float64 `json:"median_review_seconds"`
+}
+
+func (db *Database) GetReviewStats() ReviewStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var stats ReviewStats
+	var latencies []float64
+	for _, f := range db.Flags {
+		if f.Decision == nil {
+			stats.Open++
+			if f.AssignedTo == nil {
+				stats.Unassigned++
+			}
+			continue
+		}
+		stats.Resolved++
+		latencies = append(latencies, f.ResolvedAt.Sub(f.CreatedAt).Seconds())
+	}
+	if n := len(latencies); n > 0 {
+		sort.Float64s(latencies)
+		median := latencies[n/2]
+		if n%2 == 0 {
+			median = (latencies[n/2-1] + latencies[n/2]) / 2
+		}
+		stats.MedianReviewSeconds = &median
+	}
+	return stats
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IDFormat selects how new session and message IDs are generated. Existing
+// IDs are never rewritten, so a data directory can hold a mix of formats.
+type IDFormat string
+
+const (
+	// IDUUIDv4 is a random UUID, the historical format.
+	IDUUIDv4 IDFormat = "uuid4"
+	// IDUUIDv7 is a UUID whose leading bits are the creation time in
+	// milliseconds, so IDs sort chronologically.
+	IDUUIDv7 IDFormat = "uuid7"
+	// IDULID is a 26-character Crockford base32 ULID, also time-ordered.
+	IDULID IDFormat = "ulid"
+)
+
+// ParseIDFormat validates a format name; "" is IDUUIDv4.
+func ParseIDFormat(s string) (IDFormat, error) {
+	switch f := IDFormat(s); f {
+	case "":
+		return IDUUIDv4, nil
+	case IDUUIDv4, IDUUIDv7, IDULID:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown ID format %q (want uuid4, uuid7 or ulid)", s)
+}
+
+func (f IDFormat) newID(now time.Time) string {
+	switch f {
+	case IDUUIDv7:
+		if id, err := uuid.NewV7(); err == nil {
+			return id.String()
+		}
+	case IDULID:
+		return newULID(now)
+	}
+	return uuid.New().String()
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID encodes 48 bits of millisecond time followed by 80 random bits.
+func newULID(t time.Time) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(t.UnixMilli())<<16)
+	rand.Read(b[6:])
+
+	// 128 bits as 26 base32 digits; the first digit carries only 3 bits.
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
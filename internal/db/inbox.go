@@ -0,0 +1,65 @@
+package db
+
+// Events on a tenant's inbox topic, so operator UIs can keep their session
+// list current without polling.
+const (
+	InboxSessionCreated  = "session.created"
+	InboxSessionAssigned = "session.assigned"
+	InboxSessionClosed   = "session.closed"
+	InboxSLAWarning      = "sla.warning"
+	InboxSLABreached     = "sla.breached"
+)
+
+// DefaultTenant is the inbox of sessions created without a tenant.
+const DefaultTenant = "default"
+
+// TenantName is the tenant whose inbox lists s.
+func (s ChatSession) TenantName() string {
+	if s.Tenant == nil || *s.Tenant == "" {
+		return DefaultTenant
+	}
+	return *s.Tenant
+}
+
+// enqueueInbox records an inbox event carrying the session row. The caller
+// holds the lock and saves.
+func (db *Database) enqueueInbox(s ChatSession, event string) {
+	db.enqueueInboxPayload(s, event, s)
+}
+
+// enqueueInboxPayload records an event on the inbox of s's tenant carrying
+// payload. The caller holds the lock and saves.
+func (db *Database) enqueueInboxPayload(s ChatSession, event string, payload interface{}) {
+	db.Outbox = append(db.Outbox, OutboxEvent{
+		ID:        db.newRowID(),
+		Seq:       db.nextOutboxSeq(),
+		SessionID: s.ID,
+		Kind:      OutboxBroadcast,
+		Event:     event,
+		Record:    toJSON(payload),
+		Inbox:     s.TenantName(),
+		CreatedAt: db.Now(),
+	})
+	db.outboxPending = true
+}
+
+// NotifyInbox broadcasts event with payload on the inbox of session id's
+// tenant, through the outbox like the session events.
+func (db *Database) NotifyInbox(id, event string, payload interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return err
+	}
+	s, err := db.getSession(id)
+	if err != nil {
+		return err
+	}
+	db.enqueueInboxPayload(*s, event, payload)
+	return db.save()
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrIntegrity is returned by Load under StrictLoad when a data file is
+// damaged: truncated, not valid JSON, or holding rows that can't be read.
+var ErrIntegrity = errors.New("data files are damaged; start without -strict-load to salvage them")
+
+// Kinds of IntegrityIssue.
+const (
+	IssueCorruptFile    = "corrupt_file"
+	IssueUnparsable     = "unparsable"
+	IssueDuplicateID    = "duplicate_id"
+	IssueMissingSession = "missing_session"
+	IssueMissingMedia   = "missing_media"
+)
+
+// IntegrityIssue is one problem found while loading. Row is the offending
+// row as stored, kept so a repair can quarantine it.
+type IntegrityIssue struct {
+	File   string          `json:"file"`
+	Kind   string          `json:"kind"`
+	ID     string          `json:"id,omitempty"`
+	Detail string          `json:"detail"`
+	Row    json.RawMessage `json:"row,omitempty"`
+}
+
+// quarantineDir holds rows a repair took out of the data files.
+const quarantineDir = "quarantine"
+
+// loadRows decodes a data file row by row, so one bad row doesn't take the
+// whole file down with it. Rows that don't decode are reported and skipped.
+// A missing or empty file leaves dst untouched.
+func loadRows[T any](db *Database, name string, dst *[]T, issues *[]IntegrityIssue) error {
+	raw, err := db.readRaw(name, issues)
+	if err != nil {
+		return err
+	}
+	for i, r := range raw {
+		var v T
+		if err := json.Unmarshal(r, &v); err != nil {
+			*issues = append(*issues, IntegrityIssue{
+				File: name, Kind: IssueUnparsable, Row: r,
+				Detail: fmt.Sprintf("row %d: %v", i, err),
+			})
+			continue
+		}
+		*dst = append(*dst, v)
+	}
+	return nil
+}
+
+// readRaw splits a data file into its rows. A file that isn't a valid JSON
+// array (typically one truncated by a crash) is reported as corrupt and
+// moved to the quarantine directory, keeping the rows before the damage;
+// Load then writes those back. Under StrictLoad the file is left alone.
+func (db *Database) readRaw(name string, issues *[]IntegrityIssue) ([]json.RawMessage, error) {
+	path := filepath.Join(db.DataDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil
+	}
+	data, err := db.Cipher.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		raw = salvageRows(data)
+		is := IntegrityIssue{File: name, Kind: IssueCorruptFile, Detail: fmt.Sprintf("%v; %d rows recovered", err, len(raw))}
+		if !db.StrictLoad {
+			moved, err := db.quarantineFile(path)
+			if err != nil {
+				return nil, err
+			}
+			is.Detail += "; original moved to " + moved
+		}
+		*issues = append(*issues, is)
+	}
+	return raw, nil
+}
+
+// salvageRows returns the complete rows at the start of a damaged JSON array.
+func salvageRows(data []byte) []json.RawMessage {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil
+	}
+	var raw []json.RawMessage
+	for dec.More() {
+		var r json.RawMessage
+		if err := dec.Decode(&r); err != nil {
+			break
+		}
+		raw = append(raw, r)
+	}
+	return raw
+}
+
+// quarantineFile moves a damaged data file into the quarantine directory
+// under a timestamped name, returned relative to the data directory.
+func (db *Database) quarantineFile(path string) (string, error) {
+	dir := filepath.Join(db.DataDir, quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := filepath.Base(path) + "-" + db.Now().Format("20060102T150405Z")
+	if err := os.Rename(path, filepath.Join(dir, name)); err != nil {
+		return "", err
+	}
+	return filepath.Join(quarantineDir, name), nil
+}
+
+// checkIntegrity looks for duplicate IDs, messages whose session is missing
+// and, when MediaDir is set, file URLs whose file is gone.
+func (db *Database) checkIntegrity(issues *[]IntegrityIssue) {
+	sessions := make(map[string]bool, len(db.Sessions))
+	for _, s := range db.Sessions {
+		if sessions[s.ID] {
+			row, _ := json.Marshal(s)
+			*issues = append(*issues, IntegrityIssue{File: "sessions.json", Kind: IssueDuplicateID, ID: s.ID, Detail: "session ID appears more than once", Row: row})
+		}
+		sessions[s.ID] = true
+	}
+
+	messages := make(map[string]bool, len(db.Messages))
+	for _, m := range db.Messages {
+		if messages[m.ID] {
+			row, _ := json.Marshal(m)
+			*issues = append(*issues, IntegrityIssue{File: "messages.json", Kind: IssueDuplicateID, ID: m.ID, Detail: "message ID appears more than once", Row: row})
+			continue
+		}
+		messages[m.ID] = true
+		if !sessions[m.SessionID] {
+			*issues = append(*issues, IntegrityIssue{File: "messages.json", Kind: IssueMissingSession, ID: m.ID, Detail: "session " + m.SessionID + " does not exist"})
+		}
+		if db.MediaDir != "" && m.FileURL != nil {
+			if rel, ok := MediaPath(*m.FileURL); ok {
+				if _, err := os.Stat(filepath.Join(db.MediaDir, filepath.FromSlash(rel))); os.IsNotExist(err) {
+					row, _ := json.Marshal(m)
+					*issues = append(*issues, IntegrityIssue{File: "messages.json", Kind: IssueMissingMedia, ID: m.ID, Detail: "media " + rel + " is missing", Row: row})
+				}
+			}
+		}
+	}
+}
+
+// quarantineUnparsable moves rows that didn't decode to the quarantine
+// directory; without Repair that is the only fix Load applies on its own.
+func (db *Database) quarantineUnparsable(issues []IntegrityIssue) error {
+	var bad []IntegrityIssue
+	for _, is := range issues {
+		if is.Kind == IssueUnparsable {
+			bad = append(bad, is)
+		}
+	}
+	return db.writeQuarantine(bad)
+}
+
+// repair fixes what checkIntegrity and loadRows found. Unreadable rows and
+// duplicates (the first occurrence wins) are moved to the quarantine
+// directory; messages of a missing session get a session created for them,
+// as Import does; references to missing media are cleared, with the original
+// message quarantined. The caller saves afterwards.
+func (db *Database) repair(issues []IntegrityIssue) error {
+	var quarantined []IntegrityIssue
+	dupSessions, dupMessages := 0, 0
+	missingMedia := make(map[string]bool)
+	for _, is := range issues {
+		switch is.Kind {
+		case IssueUnparsable:
+			quarantined = append(quarantined, is)
+		case IssueDuplicateID:
+			quarantined = append(quarantined, is)
+			if is.File == "sessions.json" {
+				dupSessions++
+			} else {
+				dupMessages++
+			}
+		case IssueMissingMedia:
+			quarantined = append(quarantined, is)
+			missingMedia[is.ID] = true
+		}
+	}
+
+	if dupSessions > 0 {
+		seen := make(map[string]bool, len(db.Sessions))
+		kept := db.Sessions[:0]
+		for _, s := range db.Sessions {
+			if !seen[s.ID] {
+				seen[s.ID] = true
+				kept = append(kept, s)
+			}
+		}
+		db.Sessions = kept
+	}
+	if dupMessages > 0 {
+		seen := make(map[string]bool, len(db.Messages))
+		kept := db.Messages[:0]
+		for _, m := range db.Messages {
+			if !seen[m.ID] {
+				seen[m.ID] = true
+				kept = append(kept, m)
+			}
+		}
+		db.Messages = kept
+	}
+
+	known := make(map[string]int, len(db.Sessions))
+	for i, s := range db.Sessions {
+		known[s.ID] = i
+	}
+	synthesized := make(map[string]bool)
+	for i := range db.Messages {
+		m := &db.Messages[i]
+		if missingMedia[m.ID] {
+			m.FileURL = nil
+		}
+		if j, ok := known[m.SessionID]; !ok {
+			code := db.newSessionCode()
+			db.Sessions = append(db.Sessions, ChatSession{ID: m.SessionID, Code: &code, CreatedAt: m.CreatedAt})
+			known[m.SessionID] = len(db.Sessions) - 1
+			synthesized[m.SessionID] = true
+		} else if synthesized[m.SessionID] && m.CreatedAt.Before(db.Sessions[j].CreatedAt) {
+			db.Sessions[j].CreatedAt = m.CreatedAt
+		}
+	}
+
+	return db.writeQuarantine(quarantined)
+}
+
+func (db *Database) writeQuarantine(rows []IntegrityIssue) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	dir := filepath.Join(db.DataDir, quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := "repair-" + db.Now().Format("20060102T150405Z") + ".json"
+	if err := db.Cipher.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		return err
+	}
+	log.Printf("Quarantined %d rows to %s", len(rows), filepath.Join(quarantineDir, name))
+	return nil
+}
+
+// salvaged reports whether loadRows dropped rows or moved a file aside, in
+// which case the data files must be rewritten.
+func salvaged(issues []IntegrityIssue) bool {
+	for _, is := range issues {
+		if is.Kind == IssueCorruptFile || is.Kind == IssueUnparsable {
+			return true
+		}
+	}
+	return false
+}
+
+// logIssues reports load problems and, in outcome, what was done about them.
+func logIssues(issues []IntegrityIssue, outcome string) {
+	counts := make(map[string]int)
+	for _, is := range issues {
+		counts[is.Kind]++
+		log.Printf("Integrity: %s: %s %s %s", is.File, is.Kind, is.ID, is.Detail)
+	}
+	var parts []string
+	for _, kind := range []string{IssueCorruptFile, IssueUnparsable, IssueDuplicateID, IssueMissingSession, IssueMissingMedia} {
+		if counts[kind] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+	log.Printf("Integrity check found %s; %s", strings.Join(parts, ", "), outcome)
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockFileName lives in the data directory. As a dot-file it is left out of
+// backups and survives restores.
+const lockFileName = ".lock"
+
+// ErrLocked is returned by LockDataDir when another process holds the lock.
+var ErrLocked = errors.New("data directory is in use by another process")
+
+// DirLock is an exclusive hold on a data directory.
+type DirLock struct {
+	f *os.File
+}
+
+// LockDataDir locks dir for this process and records its PID in the lock
+// file, so two servers (or a server and a command such as import) can't
+// overwrite each other's JSON files.
+func LockDataDir(dir string) (*DirLock, error) {
+	path := filepath.Join(dir, lockFileName)
+	f, err := lockFile(path)
+	if errors.Is(err, ErrLocked) {
+		if pid := readLockPID(path); pid != 0 {
+			return nil, fmt.Errorf("%w (pid %d, %s)", ErrLocked, pid, path)
+		}
+		return nil, fmt.Errorf("%w (%s)", ErrLocked, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &DirLock{f: f}, nil
+}
+
+// Unlock releases the lock.
+func (l *DirLock) Unlock() error {
+	return unlockFile(l.f)
+}
+
+func readLockPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package db
+
+import "os"
+
+// lockFile falls back to exclusive creation where flock isn't available. A
+// crashed process leaves the file behind; delete it once no server runs.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, ErrLocked
+	}
+	return f, err
+}
+
+func unlockFile(f *os.File) error {
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// PublicMediaPrefix is the URL path under which uploaded media is served.
+const PublicMediaPrefix = "/storage/v1/object/public/chat-media/"
+
+// MediaPath extracts the storage-relative path from a message file_url that
+// points at this server's public media endpoint. It reports false for
+// foreign URLs and for paths that would escape the storage directory.
+func MediaPath(fileURL string) (string, bool) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return "", false
+	}
+	i := strings.Index(u.Path, PublicMediaPrefix)
+	if i < 0 {
+		return "", false
+	}
+	rel := u.Path[i+len(PublicMediaPrefix):]
+	clean := path.Clean("/" + rel)[1:]
+	if clean == "" || clean != rel {
+		return "", false
+	}
+	return rel, true
+}
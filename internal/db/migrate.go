@@ -0,0 +1,219 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the layout of sessions.json/messages.json this build reads
+// and writes. Bump it and append to migrations whenever a stored field is
+// renamed or reinterpreted. New nullable fields decode as nil from old rows and
+// don't need a migration.
+const SchemaVersion = 3
+
+const schemaVersionFile = "schema_version"
+
+// ErrSchemaTooNew is returned by Load when the data directory was written by a
+// newer build. Starting anyway would overwrite that data on the next save.
+var ErrSchemaTooNew = errors.New("data directory schema is newer than this build")
+
+// rows is the untyped form of a data file, so migrations can reshape rows
+// without depending on the current structs.
+type rows []map[string]interface{}
+
+type tables struct {
+	Sessions rows
+	Messages rows
+}
+
+type migration struct {
+	version int
+	name    string
+	apply   func(t *tables) error
+}
+
+// migrations upgrade data from version-1 to version. Data directories written
+// before versioning existed have no marker and are treated as version 0.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "add closed_at and close_reason to chat_sessions",
+		apply: func(t *tables) error {
+			for _, s := range t.Sessions {
+				setDefault(s, "closed_at", nil)
+				setDefault(s, "close_reason", nil)
+			}
+			return nil
+		},
+	},
+	{
+		version: 2,
+		name:    "number messages with a per-session seq",
+		apply: func(t *tables) error {
+			type keyed struct {
+				row map[string]interface{}
+				at  time.Time
+			}
+			bySession := make(map[string][]keyed)
+			for _, m := range t.Messages {
+				sid, _ := m["session_id"].(string)
+				ts, _ := m["created_at"].(string)
+				at, _ := time.Parse(time.RFC3339Nano, ts)
+				bySession[sid] = append(bySession[sid], keyed{m, at})
+			}
+			for _, msgs := range bySession {
+				sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].at.Before(msgs[j].at) })
+				for i, m := range msgs {
+					m.row["seq"] = i + 1
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 3,
+		name:    "give every chat session a short code",
+		apply: func(t *tables) error {
+			taken := make(map[string]bool)
+			for _, s := range t.Sessions {
+				if code, ok := s["code"].(string); ok {
+					taken[code] = true
+				}
+			}
+			for _, s := range t.Sessions {
+				if _, ok := s["code"].(string); !ok {
+					code := randomSessionCode(taken)
+					taken[code] = true
+					s["code"] = code
+				}
+			}
+			return nil
+		},
+	},
+}
+
+func setDefault(row map[string]interface{}, key string, value interface{}) {
+	if _, ok := row[key]; !ok {
+		row[key] = value
+	}
+}
+
+func (db *Database) readSchemaVersion() (int, error) {
+	data, err := os.ReadFile(filepath.Join(db.DataDir, schemaVersionFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", schemaVersionFile, err)
+	}
+	return v, nil
+}
+
+func (db *Database) writeSchemaVersion(v int) error {
+	if err := os.MkdirAll(db.DataDir, 0755); err != nil {
+		return err
+	}
+	return db.replaceFile(filepath.Join(db.DataDir, schemaVersionFile), []byte(strconv.Itoa(v)+"\n"))
+}
+
+// readRows reads a data file for migration, salvaging what it can as
+// loadRows does. Rows that aren't JSON objects are reported and left out.
+func (db *Database) readRows(name string, issues *[]IntegrityIssue) (rows, error) {
+	raw, err := db.readRaw(name, issues)
+	if err != nil {
+		return nil, err
+	}
+	var r rows
+	for i, data := range raw {
+		// UseNumber keeps integer fields exact through the round trip.
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var row map[string]interface{}
+		err := dec.Decode(&row)
+		if err == nil && row == nil {
+			err = errors.New("not an object")
+		}
+		if err != nil {
+			*issues = append(*issues, IntegrityIssue{
+				File: name, Kind: IssueUnparsable, Row: data,
+				Detail: fmt.Sprintf("row %d: %v", i, err),
+			})
+			continue
+		}
+		r = append(r, row)
+	}
+	return r, nil
+}
+
+func (db *Database) writeRows(name string, r rows) error {
+	if r == nil {
+		r = rows{}
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return db.writeFile(filepath.Join(db.DataDir, name), data)
+}
+
+// migrate brings the data directory up to SchemaVersion before it is loaded.
+// It refuses to touch data written by a newer build. Damaged files are
+// salvaged first, with what was lost added to issues; under StrictLoad they
+// are left alone for Load to refuse.
+func (db *Database) migrate(issues *[]IntegrityIssue) error {
+	current, err := db.readSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > SchemaVersion {
+		return fmt.Errorf("%w: found version %d, this build supports up to %d", ErrSchemaTooNew, current, SchemaVersion)
+	}
+	if current == SchemaVersion {
+		return nil
+	}
+
+	var t tables
+	if t.Sessions, err = db.readRows("sessions.json", issues); err != nil {
+		return err
+	}
+	if t.Messages, err = db.readRows("messages.json", issues); err != nil {
+		return err
+	}
+	if db.StrictLoad && salvaged(*issues) {
+		return nil
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Printf("Migrating data to schema version %d: %s", m.version, m.name)
+		if err := m.apply(&t); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+
+	if err := os.MkdirAll(db.DataDir, 0755); err != nil {
+		return err
+	}
+	if err := db.writeRows("sessions.json", t.Sessions); err != nil {
+		return err
+	}
+	if err := db.writeRows("messages.json", t.Messages); err != nil {
+		return err
+	}
+	return db.writeSchemaVersion(SchemaVersion)
+}
@@ -1,18 +1,194 @@
 package db
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type ChatSession struct {
-	ID        string    `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
+	ID string `json:"id"`
+	// Code is a short, case-insensitive alias of ID that can be read out
+	// over the phone.
+	Code        *string    `json:"code"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ClosedAt    *time.Time `json:"closed_at"`
+	CloseReason *string    `json:"close_reason"`
+	// MergedInto points at the session that absorbed this one's messages.
+	MergedInto *string `json:"merged_into"`
+	// Geo is the visitor's coarse location at session creation, when IP
+	// geolocation is enabled.
+	Geo *GeoLocation `json:"geo"`
+	// Title, CreatedBy and Metadata are set by the embedding application; the
+	// server stores them but never interprets them.
+	Title     *string                `json:"title"`
+	CreatedBy *string                `json:"created_by"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	// Tenant names the operator inbox the session is listed in (nil is
+	// DefaultTenant), and AssignedTo the operator handling it.
+	Tenant     *string `json:"tenant"`
+	AssignedTo *string `json:"assigned_to"`
 }
 
+type GeoLocation struct {
+	CountryCode string `json:"country_code"`
+	Country     string `json:"country,omitempty"`
+	City        string `json:"city,omitempty"`
+}
+
+// MessageTypeSystem marks messages generated by the server itself (warnings,
+// close notices) rather than sent by a participant.
+const MessageTypeSystem = "system"
+
+// CloseReasonInactivity is recorded when the scheduler closes an idle session.
+const CloseReasonInactivity = "inactivity"
+
+// CloseReasonMerged is recorded on a session tombstoned by MergeSessions.
+const CloseReasonMerged = "merged"
+
 type Message struct {
+	ID        string `json:"id"`
+	SessionID string `json:"session_id"`
+	// Seq numbers a session's messages 1, 2, 3, ... in insertion order. It is
+	// assigned by the server and is the canonical order of a session's
+	// messages; clients also use it to spot gaps.
+	Seq         int64   `json:"seq"`
+	Content     *string `json:"content"`
+	MessageType string  `json:"message_type"`
+	FileURL     *string `json:"file_url"`
+	SenderName  *string `json:"sender_name"`
+	// SenderVerified is set by the server when the sender presented a valid
+	// identity token; SenderIdentity then holds what the token asserted.
+	SenderVerified bool            `json:"sender_verified"`
+	SenderIdentity *SenderIdentity `json:"sender_identity"`
+	Origin         *string         `json:"origin"`
+	// Metadata is arbitrary client JSON (reply references, client IDs, card
+	// data) stored and echoed back as-is.
+	Metadata json.RawMessage `json:"metadata"`
+	// Event describes what a system message is about, so clients can render
+	// it without parsing Content. Nil on ordinary messages.
+	Event *SystemEvent `json:"event"`
+	// Schedule carries the offered slots of a scheduling message and, once
+	// the visitor picked one, their choice. Nil on other messages.
+	Schedule *Schedule `json:"schedule"`
+	// Payment is the checkout of a payment request and its status. Nil on
+	// other messages.
+	Payment *Payment `json:"payment"`
+	// ParentMessageID makes this message a reply in the thread started by
+	// that message; ReplyCount is the number of replies a message has.
+	ParentMessageID *string   `json:"parent_message_id"`
+	ReplyCount      int       `json:"reply_count"`
+	CreatedAt       time.Time `json:"created_at"`
+	// EditedAt is set when the content or metadata was changed after
+	// sending.
+	EditedAt *time.Time `json:"edited_at"`
+}
+
+// SenderIdentity is who an integrator's identity token says a sender is.
+// ExternalID is the integrator's own user ID (the token's sub).
+type SenderIdentity struct {
+	ExternalID string `json:"external_id"`
+	Email      string `json:"email,omitempty"`
+	Name       string `json:"name,omitempty"`
+}
+
+// SystemEvent is the structured payload of a system message. Data holds the
+// kind-specific details, e.g. {"reason": "inactivity"} for closed or
+// {"target_id": "..."} for merged.
+type SystemEvent struct {
+	Kind  string                 `json:"kind"`
+	Actor *string                `json:"actor"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+// System event kinds. The server emits idle_warning, closed, merged and
+// escalated itself; the others are posted by embedding applications.
+const (
+	EventAgentJoined = "agent_joined"
+	EventTransferred = "transferred"
+	EventClosed      = "closed"
+	EventRated       = "rated"
+	EventMerged      = "merged"
+	EventIdleWarning = "idle_warning"
+	EventEscalated   = "escalated"
+)
+
+// SystemMessage builds a server-generated message carrying event.
+func SystemMessage(sessionID, text string, event SystemEvent) Message {
+	return Message{
+		SessionID:   sessionID,
+		Content:     &text,
+		MessageType: MessageTypeSystem,
+		Event:       &event,
+	}
+}
+
+// OriginBot marks messages posted by automated sequences.
+const OriginBot = "bot"
+
+// Participant is someone present in a session: a websocket subscriber or a
+// message sender, identified within the session by display name.
+type Participant struct {
 	ID          string    `json:"id"`
 	SessionID   string    `json:"session_id"`
-	Content     *string   `json:"content"`
-	MessageType string    `json:"message_type"`
-	FileURL     *string   `json:"file_url"`
-	SenderName  *string   `json:"sender_name"`
-	CreatedAt   time.Time `json:"created_at"`
+	DisplayName string    `json:"display_name"`
+	JoinedAt    time.Time `json:"joined_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	// LastReadMessageID is the newest message the participant has read; it
+	// only ever moves forward in seq order.
+	LastReadMessageID *string    `json:"last_read_message_id"`
+	LastReadAt        *time.Time `json:"last_read_at"`
+}
+
+// Reaction is an emoji a participant attached to a message. Each sender can
+// use each emoji once per message.
+type Reaction struct {
+	ID         string    `json:"id"`
+	MessageID  string    `json:"message_id"`
+	SessionID  string    `json:"session_id"`
+	Emoji      string    `json:"emoji"`
+	SenderName string    `json:"sender_name"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Flag sources: an automatic content filter, a participant's report, or an
+// agent flagging a message by hand.
+const (
+	FlagSourceFilter = "filter"
+	FlagSourceReport = "report"
+	FlagSourceAgent  = "agent"
+)
+
+// Review decisions that resolve a flag.
+const (
+	DecisionAllow  = "allow"
+	DecisionRedact = "redact"
+	DecisionDelete = "delete"
+	DecisionBan    = "ban"
+)
+
+// CloseReasonBanned is recorded on a session closed by a ban decision.
+const CloseReasonBanned = "banned"
+
+// Flag is an entry in the review queue. A message may be flagged more than
+// once while it is pending; later flags are folded into the open one.
+type Flag struct {
+	ID         string      `json:"id"`
+	MessageID  string      `json:"message_id"`
+	SessionID  string      `json:"session_id"`
+	Source     string      `json:"source"`
+	Reason     *string     `json:"reason"`
+	AssignedTo *string     `json:"assigned_to"`
+	Decision   *string     `json:"decision"`
+	CreatedAt  time.Time   `json:"created_at"`
+	ResolvedAt *time.Time  `json:"resolved_at"`
+	History    []FlagEvent `json:"history"`
+}
+
+// FlagEvent records one step in a flag's life: flagged, assigned, or a
+// decision.
+type FlagEvent struct {
+	At     time.Time `json:"at"`
+	Action string    `json:"action"`
+	Actor  *string   `json:"actor"`
+	Note   *string   `json:"note"`
 }
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+)
+
+// Outbox event kinds: a row change published as postgres_changes, or a
+// Supabase broadcast message.
+const (
+	OutboxChange    = "postgres_changes"
+	OutboxBroadcast = "broadcast"
+)
+
+// outboxRetention is how long sent events are kept before being pruned.
+const outboxRetention = time.Hour
+
+// OutboxEvent is a realtime event recorded in the same save as the change
+// that caused it, so a crash between saving and publishing can't lose it.
+// A dispatcher publishes pending events and marks them sent.
+type OutboxEvent struct {
+	ID string `json:"id"`
+	// Seq increases by one per event across the whole server. It is sent
+	// to clients as event_id so they can drop replays.
+	Seq       int64  `json:"seq"`
+	SessionID string `json:"session_id"`
+	Kind      string `json:"kind"`
+	// Table and Type (INSERT, UPDATE, DELETE) describe a row change; Event
+	// names a broadcast.
+	Table  string          `json:"table,omitempty"`
+	Type   string          `json:"type,omitempty"`
+	Event  string          `json:"event,omitempty"`
+	Record json.RawMessage `json:"record,omitempty"`
+	// Inbox is set for inbox events: the tenant whose inbox topic gets the
+	// event instead of the session's topic.
+	Inbox     string          `json:"inbox,omitempty"`
+	Old       json.RawMessage `json:"old,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	SentAt    *time.Time      `json:"sent_at"`
+}
+
+func toJSON(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	// Only our own row types are passed in; they always marshal.
+	data, _ := json.Marshal(v)
+	return data
+}
+
+// enqueueChange records a row change for the dispatcher. For DELETE, row is
+// the removed row and is sent as old. The caller holds the lock and saves.
+func (db *Database) enqueueChange(sessionID, table, eventType string, row interface{}) {
+	e := OutboxEvent{
+		ID:        db.newRowID(),
+		Seq:       db.nextOutboxSeq(),
+		SessionID: sessionID,
+		Kind:      OutboxChange,
+		Table:     table,
+		Type:      eventType,
+		CreatedAt: db.Now(),
+	}
+	if eventType == "DELETE" {
+		e.Old = toJSON(row)
+	} else {
+		e.Record = toJSON(row)
+	}
+	db.Outbox = append(db.Outbox, e)
+	db.outboxPending = true
+}
+
+// enqueueBroadcast records a broadcast message for the dispatcher. The
+// caller holds the lock and saves.
+func (db *Database) enqueueBroadcast(sessionID, event string, payload interface{}) {
+	db.Outbox = append(db.Outbox, OutboxEvent{
+		ID:        db.newRowID(),
+		Seq:       db.nextOutboxSeq(),
+		SessionID: sessionID,
+		Kind:      OutboxBroadcast,
+		Event:     event,
+		Record:    toJSON(payload),
+		CreatedAt: db.Now(),
+	})
+	db.outboxPending = true
+}
+
+func (db *Database) nextOutboxSeq() int64 {
+	db.outboxSeq++
+	return db.outboxSeq
+}
+
+// LastOutboxSeq is the seq of the newest recorded event. Read it before a
+// REST query: every change in the result then has an event_id at or below
+// it, so realtime events up to it can be skipped.
+func (db *Database) LastOutboxSeq() int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.outboxSeq
+}
+
+// OutboxReady is signalled after a save that recorded new outbox events.
+func (db *Database) OutboxReady() <-chan struct{} {
+	return db.outboxReady
+}
+
+// PendingOutbox returns the unsent events in the order they were recorded.
+func (db *Database) PendingOutbox() []OutboxEvent {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var pending []OutboxEvent
+	for _, e := range db.Outbox {
+		if e.SentAt == nil {
+			pending = append(pending, e)
+		}
+	}
+	return pending
+}
+
+// MarkOutboxSent marks events as published and prunes sent events older
+// than an hour. Only the outbox file is rewritten.
+func (db *Database) MarkOutboxSent(ids []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	sent := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		sent[id] = true
+	}
+	now := db.Now()
+	kept := db.Outbox[:0]
+	for i, e := range db.Outbox {
+		if sent[e.ID] {
+			e.SentAt = &now
+		}
+		// The newest event is never pruned; it carries the seq forward
+		// across restarts.
+		if e.SentAt != nil && now.Sub(*e.SentAt) > outboxRetention && i < len(db.Outbox)-1 {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	db.Outbox = kept
+	return db.absorb(db.saveOutbox())
+}
+
+func (db *Database) saveOutbox() error {
+	data, err := json.MarshalIndent(db.Outbox, "", "  ")
+	if err != nil {
+		return err
+	}
+	return db.writeFile(filepath.Join(db.DataDir, "outbox.json"), data)
+}
+
+// signalOutbox wakes the dispatcher after a save that recorded events.
+func (db *Database) signalOutbox() {
+	if !db.outboxPending {
+		return
+	}
+	db.outboxPending = false
+	select {
+	case db.outboxReady <- struct{}{}:
+	default:
+	}
+}
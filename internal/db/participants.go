@@ -0,0 +1,152 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+)
+
+// JoinParticipant returns the participant of a session with the given
+// display name, creating it on first sight, and marks it as seen now.
+func (db *Database) JoinParticipant(sessionID, displayName string) (*Participant, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	p, created, err := db.joinParticipant(sessionID, displayName)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if err := db.save(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (db *Database) joinParticipant(sessionID, displayName string) (*Participant, bool, error) {
+	if displayName == "" {
+		return nil, false, fmt.Errorf("display_name is required")
+	}
+	if !db.sessionExists(sessionID) {
+		return nil, false, fmt.Errorf("session not found")
+	}
+	p, created := db.join(sessionID, displayName)
+	found := *p
+	return &found, created, nil
+}
+
+// join finds or creates a participant and marks it seen. The caller holds
+// the lock and saves when created is true.
+func (db *Database) join(sessionID, displayName string) (p *Participant, created bool) {
+	now := db.Now()
+	for i := range db.Participants {
+		p := &db.Participants[i]
+		if p.SessionID == sessionID && p.DisplayName == displayName {
+			p.LastSeenAt = now
+			return p, false
+		}
+	}
+
+	db.Participants = append(db.Participants, Participant{
+		ID:          db.newRowID(),
+		SessionID:   sessionID,
+		DisplayName: displayName,
+		JoinedAt:    now,
+		LastSeenAt:  now,
+	})
+	return &db.Participants[len(db.Participants)-1], true
+}
+
+// MarkRead records that a participant has read a session up to messageID.
+// Marking an older message than the current position is a no-op; advanced
+// reports whether the position moved.
+func (db *Database) MarkRead(sessionID, displayName, messageID string) (p *Participant, advanced bool, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, false, err
+	}
+
+	if displayName == "" {
+		return nil, false, fmt.Errorf("display_name is required")
+	}
+	seqOf := func(id string) int64 {
+		for _, m := range db.Messages {
+			if m.ID == id && m.SessionID == sessionID {
+				return m.Seq
+			}
+		}
+		return -1
+	}
+	seq := seqOf(messageID)
+	if seq < 0 {
+		return nil, false, fmt.Errorf("message not found in session")
+	}
+
+	p, created := db.join(sessionID, displayName)
+	if p.LastReadMessageID == nil || seqOf(*p.LastReadMessageID) < seq {
+		now := db.Now()
+		p.LastReadMessageID = &messageID
+		p.LastReadAt = &now
+		advanced = true
+		db.enqueueBroadcast(sessionID, "read", map[string]interface{}{
+			"participant_id":       p.ID,
+			"display_name":         p.DisplayName,
+			"last_read_message_id": p.LastReadMessageID,
+			"last_read_at":         p.LastReadAt,
+		})
+	}
+	if created || advanced {
+		if err := db.save(); err != nil {
+			return nil, false, err
+		}
+	}
+	found := *p
+	return &found, advanced, nil
+}
+
+// TouchParticipant bumps last_seen_at in memory only; the new value is
+// written out with the next save. Heartbeats arrive far too often to rewrite
+// the data files for each one.
+func (db *Database) TouchParticipant(id string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for i := range db.Participants {
+		if db.Participants[i].ID == id {
+			db.Participants[i].LastSeenAt = db.Now()
+			return
+		}
+	}
+}
+
+// GetParticipants lists a session's participants by join time.
+func (db *Database) GetParticipants(sessionID string) ([]Participant, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	result := []Participant{}
+	for _, p := range db.Participants {
+		if p.SessionID == sessionID {
+			result = append(result, p)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].JoinedAt.Before(result[j].JoinedAt)
+	})
+	return result, nil
+}
+
+func (db *Database) sessionExists(id string) bool {
+	for _, s := range db.Sessions {
+		if s.ID == id {
+			return true
+		}
+	}
+	return false
+}
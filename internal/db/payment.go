@@ -0,0 +1,71 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// MessageTypePaymentRequest marks a message asking the visitor to pay.
+const MessageTypePaymentRequest = "payment_request"
+
+// Payment statuses. A request starts pending and ends paid or expired.
+const (
+	PaymentPending = "pending"
+	PaymentPaid    = "paid"
+	PaymentExpired = "expired"
+)
+
+// Payment is what a payment request asks for and where it can be paid.
+// Amount is in the currency's minor unit (cents). The checkout fields and
+// the status are set by the server.
+type Payment struct {
+	Amount      int64      `json:"amount"`
+	Currency    string     `json:"currency"`
+	Description string     `json:"description"`
+	Provider    string     `json:"provider"`
+	CheckoutID  string     `json:"checkout_id"`
+	CheckoutURL string     `json:"checkout_url"`
+	Status      string     `json:"status"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+}
+
+// SetPaymentStatus moves the payment request with checkoutID at provider to
+// status. Only pending requests change, so repeated or late provider events
+// are harmless; changed reports whether this call did anything.
+func (db *Database) SetPaymentStatus(provider, checkoutID, status string) (msg *Message, changed bool, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, false, err
+	}
+
+	var m *Message
+	for i := range db.Messages {
+		p := db.Messages[i].Payment
+		if p != nil && p.Provider == provider && p.CheckoutID == checkoutID {
+			m = &db.Messages[i]
+			break
+		}
+	}
+	if m == nil {
+		return nil, false, fmt.Errorf("payment not found")
+	}
+	if m.Payment.Status != PaymentPending || status == PaymentPending {
+		updated := *m
+		return &updated, false, nil
+	}
+
+	// Like schedules, the payment is replaced so copies handed out earlier
+	// keep their state.
+	now := db.Now()
+	p := *m.Payment
+	p.Status, p.UpdatedAt = status, &now
+	m.Payment = &p
+	updated := *m
+	db.enqueueChange(m.SessionID, "messages", "UPDATE", updated)
+	if err := db.save(); err != nil {
+		return nil, false, err
+	}
+	return &updated, true, nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrDuplicateReaction is returned when a sender reacts to a message with an
+// emoji they already used on it.
+var ErrDuplicateReaction = errors.New("reaction already exists")
+
+// AddReaction attaches an emoji from sender to a message.
+func (db *Database) AddReaction(messageID, emoji, senderName string) (*Reaction, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	if emoji == "" || senderName == "" {
+		return nil, fmt.Errorf("emoji and sender_name are required")
+	}
+	var sessionID string
+	for _, m := range db.Messages {
+		if m.ID == messageID {
+			sessionID = m.SessionID
+			break
+		}
+	}
+	if sessionID == "" {
+		return nil, fmt.Errorf("message not found")
+	}
+	for _, r := range db.Reactions {
+		if r.MessageID == messageID && r.Emoji == emoji && r.SenderName == senderName {
+			return nil, ErrDuplicateReaction
+		}
+	}
+
+	r := Reaction{
+		ID:         db.newRowID(),
+		MessageID:  messageID,
+		SessionID:  sessionID,
+		Emoji:      emoji,
+		SenderName: senderName,
+		CreatedAt:  db.Now(),
+	}
+	db.Reactions = append(db.Reactions, r)
+	db.enqueueChange(r.SessionID, "reactions", "INSERT", r)
+	if err := db.save(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// RemoveReactions deletes the reactions matching every non-empty field of
+// filter (ID, MessageID, Emoji, SenderName) that allow accepts, and returns
+// them.
+func (db *Database) RemoveReactions(filter Reaction, allow func(r Reaction) bool) ([]Reaction, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+
+	if filter.ID == "" && filter.MessageID == "" {
+		return nil, fmt.Errorf("id or message_id is required")
+	}
+	removed := []Reaction{}
+	kept := db.Reactions[:0]
+	for _, r := range db.Reactions {
+		if (filter.ID == "" || r.ID == filter.ID) &&
+			(filter.MessageID == "" || r.MessageID == filter.MessageID) &&
+			(filter.Emoji == "" || r.Emoji == filter.Emoji) &&
+			(filter.SenderName == "" || r.SenderName == filter.SenderName) &&
+			allow(r) {
+			removed = append(removed, r)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	db.Reactions = kept
+	for _, r := range removed {
+		db.enqueueChange(r.SessionID, "reactions", "DELETE", r)
+	}
+	if len(removed) > 0 {
+		if err := db.save(); err != nil {
+			return nil, err
+		}
+	}
+	return removed, nil
+}
+
+// GetReactions lists reactions oldest first, either of one message or, when
+// messageID is empty, of a whole session.
+func (db *Database) GetReactions(sessionID, messageID string) ([]Reaction, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	result := []Reaction{}
+	for _, r := range db.Reactions {
+		if messageID != "" && r.MessageID != messageID || sessionID != "" && r.SessionID != sessionID {
+			continue
+		}
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// removeReactions drops the reactions of a deleted message. The caller saves.
+func (db *Database) removeReactions(messageID string) {
+	kept := db.Reactions[:0]
+	for _, r := range db.Reactions {
+		if r.MessageID != messageID {
+			kept = append(kept, r)
+		}
+	}
+	db.Reactions = kept
+}
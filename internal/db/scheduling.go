@@ -0,0 +1,95 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MessageTypeScheduling marks a message offering appointment slots.
+const MessageTypeScheduling = "scheduling"
+
+// EventSlotSelected is the system event confirming a visitor's choice.
+const EventSlotSelected = "slot_selected"
+
+// ErrSlotTaken is returned when a scheduling message already has a choice.
+var ErrSlotTaken = errors.New("a slot has already been chosen")
+
+type Schedule struct {
+	Slots []Slot `json:"slots"`
+	// SelectedSlotID, SelectedBy and SelectedAt are set by SelectSlot;
+	// clients can't write them.
+	SelectedSlotID *string    `json:"selected_slot_id"`
+	SelectedBy     *string    `json:"selected_by"`
+	SelectedAt     *time.Time `json:"selected_at"`
+}
+
+type Slot struct {
+	ID    string    `json:"id"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// SelectSlot records that by picked slotID on a scheduling message and posts
+// a system message confirming it. Both are saved together; the updated
+// message and the confirmation are returned.
+func (db *Database) SelectSlot(messageID, slotID, by string) (*Message, *Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return nil, nil, err
+	}
+
+	var m *Message
+	for i := range db.Messages {
+		if db.Messages[i].ID == messageID {
+			m = &db.Messages[i]
+			break
+		}
+	}
+	if m == nil || m.MessageType != MessageTypeScheduling || m.Schedule == nil {
+		return nil, nil, fmt.Errorf("scheduling message not found")
+	}
+	if m.Schedule.SelectedSlotID != nil {
+		return nil, nil, ErrSlotTaken
+	}
+	var slot *Slot
+	for i := range m.Schedule.Slots {
+		if m.Schedule.Slots[i].ID == slotID {
+			slot = &m.Schedule.Slots[i]
+			break
+		}
+	}
+	if slot == nil {
+		return nil, nil, fmt.Errorf("slot not found")
+	}
+
+	// The schedule is shared with copies handed out earlier, so it is
+	// replaced rather than edited in place.
+	now := db.Now()
+	chosen := *m.Schedule
+	chosen.SelectedSlotID, chosen.SelectedBy, chosen.SelectedAt = &slotID, &by, &now
+	m.Schedule = &chosen
+	updated := *m
+	db.enqueueChange(m.SessionID, "messages", "UPDATE", updated)
+
+	text := by + " booked " + slot.Start.UTC().Format("Mon 2 Jan 2006 15:04 UTC")
+	confirmation, err := db.createMessage(SystemMessage(updated.SessionID, text, SystemEvent{
+		Kind:  EventSlotSelected,
+		Actor: &by,
+		Data: map[string]interface{}{
+			"message_id": messageID,
+			"slot_id":    slotID,
+			"start":      slot.Start,
+			"end":        slot.End,
+		},
+	}))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := db.save(); err != nil {
+		return nil, nil, err
+	}
+	return &updated, confirmation, nil
+}
@@ -0,0 +1,312 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchScope selects which parts of a message a search looks at.
+type SearchScope uint8
+
+const (
+	// ScopeContent is the message text.
+	ScopeContent SearchScope = 1 << iota
+	// ScopeFilename is the attachment name: metadata.file_name, or else the
+	// last segment of file_url.
+	ScopeFilename
+	// ScopeLink is metadata.link_preview.title.
+	ScopeLink
+	// ScopeTranscription is metadata.transcription.
+	ScopeTranscription
+
+	ScopeAll = ScopeContent | ScopeFilename | ScopeLink | ScopeTranscription
+)
+
+var scopeNames = map[string]SearchScope{
+	"content":       ScopeContent,
+	"filename":      ScopeFilename,
+	"link":          ScopeLink,
+	"transcription": ScopeTranscription,
+	"all":           ScopeAll,
+}
+
+// SearchScopeNames lists the scope names ParseSearchScope accepts, sorted.
+func SearchScopeNames() []string {
+	names := make([]string, 0, len(scopeNames))
+	for name := range scopeNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseSearchScope parses a comma-separated list of scope names; "" is
+// ScopeAll.
+func ParseSearchScope(s string) (SearchScope, error) {
+	if strings.TrimSpace(s) == "" {
+		return ScopeAll, nil
+	}
+	var scope SearchScope
+	for _, name := range strings.Split(s, ",") {
+		v, ok := scopeNames[strings.TrimSpace(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown search scope %q", name)
+		}
+		scope |= v
+	}
+	return scope, nil
+}
+
+// searchIndex is an inverted index of message text, keyed by session and then
+// by token, so a search only ever touches the postings of one session. Each
+// posting records which scopes of the message contain the token.
+type searchIndex map[string]map[string]map[string]SearchScope
+
+// Tokenize splits text into lowercase search terms. Runs of letters and digits
+// form one term; Han, Hiragana, Katakana and Hangul characters are indexed one
+// rune at a time since those scripts don't separate words with spaces.
+func Tokenize(text string) []string {
+	var tokens []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			tokens = append(tokens, string(cur))
+			cur = cur[:0]
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isIdeographic(r):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			cur = append(cur, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+func isIdeographic(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// searchFields returns the indexed text of a message by scope.
+func searchFields(m Message) map[SearchScope]string {
+	fields := make(map[SearchScope]string)
+	if m.Content != nil {
+		fields[ScopeContent] = *m.Content
+	}
+	var meta struct {
+		FileName    string `json:"file_name"`
+		LinkPreview struct {
+			Title string `json:"title"`
+		} `json:"link_preview"`
+		Transcription string `json:"transcription"`
+	}
+	// Metadata is free-form; anything that isn't an object with these keys
+	// simply contributes nothing.
+	if len(m.Metadata) > 0 {
+		json.Unmarshal(m.Metadata, &meta)
+	}
+	if meta.FileName != "" {
+		fields[ScopeFilename] = meta.FileName
+	} else if m.FileURL != nil {
+		if u, err := url.Parse(*m.FileURL); err == nil {
+			if name, err := url.PathUnescape(path.Base(u.Path)); err == nil && name != "/" && name != "." {
+				fields[ScopeFilename] = name
+			}
+		}
+	}
+	if meta.LinkPreview.Title != "" {
+		fields[ScopeLink] = meta.LinkPreview.Title
+	}
+	if meta.Transcription != "" {
+		fields[ScopeTranscription] = meta.Transcription
+	}
+	return fields
+}
+
+func (idx searchIndex) add(m Message) {
+	fields := searchFields(m)
+	if len(fields) == 0 {
+		return
+	}
+	terms := idx[m.SessionID]
+	if terms == nil {
+		terms = make(map[string]map[string]SearchScope)
+		idx[m.SessionID] = terms
+	}
+	for scope, text := range fields {
+		for _, tok := range Tokenize(text) {
+			ids := terms[tok]
+			if ids == nil {
+				ids = make(map[string]SearchScope)
+				terms[tok] = ids
+			}
+			ids[m.ID] |= scope
+		}
+	}
+}
+
+// remove drops m's postings, before m is changed or deleted.
+func (idx searchIndex) remove(m Message) {
+	terms := idx[m.SessionID]
+	for _, text := range searchFields(m) {
+		for _, tok := range Tokenize(text) {
+			if ids := terms[tok]; ids != nil {
+				delete(ids, m.ID)
+				if len(ids) == 0 {
+					delete(terms, tok)
+				}
+			}
+		}
+	}
+}
+
+// lookup returns the IDs of messages in the session containing every term
+// within the given scopes.
+func (idx searchIndex) lookup(sessionID string, terms []string, scope SearchScope) map[string]struct{} {
+	postings := idx[sessionID]
+	if postings == nil || len(terms) == 0 {
+		return nil
+	}
+	var result map[string]struct{}
+	for _, t := range terms {
+		ids := postings[t]
+		if len(ids) == 0 {
+			return nil
+		}
+		if result == nil {
+			result = make(map[string]struct{}, len(ids))
+			for id, s := range ids {
+				if s&scope != 0 {
+					result[id] = struct{}{}
+				}
+			}
+			continue
+		}
+		for id := range result {
+			if ids[id]&scope == 0 {
+				delete(result, id)
+			}
+		}
+	}
+	return result
+}
+
+func (db *Database) rebuildIndex() {
+	db.index = make(searchIndex)
+	for _, m := range db.Messages {
+		db.index.add(m)
+	}
+}
+
+// SearchMessages returns the messages of a session containing all terms of
+// the query within the given scopes, ordered by seq ascending.
+func (db *Database) SearchMessages(sessionID, query string, scope SearchScope) ([]Message, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ids := db.index.lookup(sessionID, Tokenize(query), scope)
+	result := []Message{}
+	if len(ids) == 0 {
+		return result, nil
+	}
+	for _, m := range db.Messages {
+		if m.SessionID != sessionID {
+			continue
+		}
+		if _, ok := ids[m.ID]; ok {
+			result = append(result, m)
+		}
+	}
+
+	sortMessages(result)
+
+	return result, nil
+}
+
+// HistoryQuery selects messages across all sessions. Zero fields don't
+// constrain the search.
+type HistoryQuery struct {
+	// Text must appear in full, every term, within Scope.
+	Text  string
+	Scope SearchScope
+	// Sender matches sender_name without regard to case.
+	Sender string
+	// Tag matches sessions whose metadata.tags, as set by the embedding
+	// application, holds it.
+	Tag string
+	// From and To bound created_at; To is exclusive.
+	From, To time.Time
+}
+
+// SessionTags returns the strings in a session's metadata.tags.
+func SessionTags(s ChatSession) []string {
+	list, _ := s.Metadata["tags"].([]interface{})
+	var tags []string
+	for _, v := range list {
+		if tag, ok := v.(string); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// SearchHistory returns the messages of every session that match q, newest
+// first. Text goes through the index one session at a time.
+func (db *Database) SearchHistory(q HistoryQuery) []Message {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	terms := Tokenize(q.Text)
+	var ids map[string]struct{}
+	if len(terms) > 0 {
+		ids = make(map[string]struct{})
+		for sessionID := range db.index {
+			for id := range db.index.lookup(sessionID, terms, q.Scope) {
+				ids[id] = struct{}{}
+			}
+		}
+	}
+	var tagged map[string]bool
+	if q.Tag != "" {
+		tagged = make(map[string]bool)
+		for _, s := range db.Sessions {
+			for _, tag := range SessionTags(s) {
+				if strings.EqualFold(tag, q.Tag) {
+					tagged[s.ID] = true
+				}
+			}
+		}
+	}
+	result := []Message{}
+	for _, m := range db.Messages {
+		if tagged != nil && !tagged[m.SessionID] {
+			continue
+		}
+		if ids != nil {
+			if _, ok := ids[m.ID]; !ok {
+				continue
+			}
+		}
+		if q.Sender != "" && (m.SenderName == nil || !strings.EqualFold(*m.SenderName, q.Sender)) {
+			continue
+		}
+		if !q.From.IsZero() && m.CreatedAt.Before(q.From) || !q.To.IsZero() && !m.CreatedAt.Before(q.To) {
+			continue
+		}
+		result = append(result, m)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result
+}
@@ -0,0 +1,48 @@
+package db
+
+import "sort"
+
+func (db *Database) rebuildSeqs() {
+	db.seqs = make(map[string]int64)
+	for _, m := range db.Messages {
+		if m.Seq > db.seqs[m.SessionID] {
+			db.seqs[m.SessionID] = m.Seq
+		}
+	}
+}
+
+// nextSeq reserves the next sequence number of a session.
+func (db *Database) nextSeq(sessionID string) int64 {
+	db.seqs[sessionID]++
+	return db.seqs[sessionID]
+}
+
+// resequence renumbers the messages of the given sessions by created_at,
+// keeping the previous seq as a tie-breaker. Only offline-style operations
+// that interleave histories (import, merge) need it.
+func (db *Database) resequence(sessionIDs map[string]bool) {
+	var idx []int
+	for i, m := range db.Messages {
+		if sessionIDs[m.SessionID] {
+			idx = append(idx, i)
+		}
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		ma, mb := db.Messages[idx[a]], db.Messages[idx[b]]
+		if !ma.CreatedAt.Equal(mb.CreatedAt) {
+			return ma.CreatedAt.Before(mb.CreatedAt)
+		}
+		return ma.Seq < mb.Seq
+	})
+	for id := range sessionIDs {
+		db.seqs[id] = 0
+	}
+	for _, i := range idx {
+		db.Messages[i].Seq = db.nextSeq(db.Messages[i].SessionID)
+	}
+}
+
+// sortMessages orders one session's messages by seq, the canonical order.
+func sortMessages(msgs []Message) {
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Seq < msgs[j].Seq })
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// SLA clocks.
+const (
+	SLAFirstResponse = "first_response"
+	SLAResolution    = "resolution"
+)
+
+// SLAAlert is the payload of the sla.warning and sla.breached inbox events:
+// session SessionID's SLA clock started at StartedAt and runs out at DueAt.
+type SLAAlert struct {
+	SLA       string    `json:"sla"`
+	SessionID string    `json:"session_id"`
+	Tenant    string    `json:"tenant"`
+	StartedAt time.Time `json:"started_at"`
+	DueAt     time.Time `json:"due_at"`
+}
+
+// SLATimes is when the SLA clocks of a session stopped and started.
+type SLATimes struct {
+	Session ChatSession
+	// FirstVisitorAt is the visitor's first message, which starts the
+	// first-response clock, and FirstResponseAt the first reply after it;
+	// both are zero until they happen.
+	FirstVisitorAt  time.Time
+	FirstResponseAt time.Time
+}
+
+// SLATimes lists the SLA times of every session except those merged into
+// another. Who the visitor is and what counts as a reply is decided as in
+// UnansweredSessions.
+func (db *Database) SLATimes(agents map[string]bool) []SLATimes {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	type state struct {
+		SLATimes
+		first string
+	}
+	sessions := make(map[string]*state)
+	var result []*state
+	for _, s := range db.Sessions {
+		if s.MergedInto != nil {
+			continue
+		}
+		st := &state{SLATimes: SLATimes{Session: s}}
+		sessions[s.ID] = st
+		result = append(result, st)
+	}
+	// Import and MergeSessions append messages out of creation order, so
+	// the candidates are sorted before the first ones are picked.
+	var msgs []Message
+	for _, m := range db.Messages {
+		if sessions[m.SessionID] == nil || m.MessageType == MessageTypeSystem || m.SenderName == nil || *m.SenderName == "" {
+			continue
+		}
+		if m.Origin != nil && *m.Origin == OriginBot {
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	sort.SliceStable(msgs, func(i, j int) bool {
+		if !msgs[i].CreatedAt.Equal(msgs[j].CreatedAt) {
+			return msgs[i].CreatedAt.Before(msgs[j].CreatedAt)
+		}
+		return msgs[i].Seq < msgs[j].Seq
+	})
+	for _, m := range msgs {
+		st := sessions[m.SessionID]
+		if !st.FirstResponseAt.IsZero() {
+			continue
+		}
+		sender := *m.SenderName
+		if st.first == "" {
+			st.first = sender
+		}
+		isAgent := agents[sender]
+		if len(agents) == 0 {
+			isAgent = sender != st.first
+		}
+		switch {
+		case !isAgent && st.FirstVisitorAt.IsZero():
+			st.FirstVisitorAt = m.CreatedAt
+		case isAgent && !st.FirstVisitorAt.IsZero():
+			st.FirstResponseAt = m.CreatedAt
+		}
+	}
+
+	times := make([]SLATimes, len(result))
+	for i, st := range result {
+		times[i] = st.SLATimes
+	}
+	return times
+}
@@ -0,0 +1,132 @@
+package db
+
+import "time"
+
+// counters keep per-session message totals and an hourly histogram of
+// message creation so stats don't have to scan every message.
+type counters struct {
+	perSession map[string]int
+	// hourly maps the Unix hour a message was created in to a count. Only
+	// the last day is kept.
+	hourly map[int64]int
+}
+
+func hourOf(t time.Time) int64 { return t.Unix() / 3600 }
+
+func (db *Database) rebuildCounters() {
+	db.counts = counters{perSession: make(map[string]int), hourly: make(map[int64]int)}
+	cutoff := hourOf(db.Now()) - 24
+	for _, m := range db.Messages {
+		db.counts.perSession[m.SessionID]++
+		if h := hourOf(m.CreatedAt); h >= cutoff {
+			db.counts.hourly[h]++
+		}
+	}
+}
+
+func (db *Database) countMessage(m Message) {
+	db.counts.perSession[m.SessionID]++
+	h := hourOf(m.CreatedAt)
+	db.counts.hourly[h]++
+	for old := range db.counts.hourly {
+		if old < h-24 {
+			delete(db.counts.hourly, old)
+		}
+	}
+}
+
+// Stats is a snapshot of the counters.
+type Stats struct {
+	TotalSessions int `json:"total_sessions"`
+	OpenSessions  int `json:"open_sessions"`
+	TotalMessages int `json:"total_messages"`
+	// MessagesLast24h is counted in whole hours: the current hour plus the
+	// 24 before it.
+	MessagesLast24h    int            `json:"messages_last_24h"`
+	MessagesPerSession map[string]int `json:"messages_per_session"`
+}
+
+func (db *Database) Stats() Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	s := Stats{
+		TotalSessions:      len(db.Sessions),
+		TotalMessages:      len(db.Messages),
+		MessagesPerSession: make(map[string]int, len(db.counts.perSession)),
+	}
+	for _, session := range db.Sessions {
+		if session.ClosedAt == nil {
+			s.OpenSessions++
+		}
+	}
+	for id, n := range db.counts.perSession {
+		s.MessagesPerSession[id] = n
+	}
+	cutoff := hourOf(db.Now()) - 24
+	for h, n := range db.counts.hourly {
+		if h >= cutoff {
+			s.MessagesLast24h += n
+		}
+	}
+	return s
+}
+
+// SessionStats describes one session's activity.
+type SessionStats struct {
+	SessionID        string         `json:"session_id"`
+	MessageCount     int            `json:"message_count"`
+	MessagesBySender map[string]int `json:"messages_by_sender"`
+	// ThreadCount counts messages with at least one reply.
+	ThreadCount      int        `json:"thread_count"`
+	ParticipantCount int        `json:"participant_count"`
+	ReactionCount    int        `json:"reaction_count"`
+	OpenFlagCount    int        `json:"open_flag_count"`
+	FirstMessageAt   *time.Time `json:"first_message_at"`
+	LastMessageAt    *time.Time `json:"last_message_at"`
+}
+
+func (db *Database) SessionStats(sessionID string) (*SessionStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, err := db.getSession(sessionID); err != nil {
+		return nil, err
+	}
+	s := &SessionStats{SessionID: sessionID, MessagesBySender: make(map[string]int)}
+	for _, m := range db.Messages {
+		if m.SessionID != sessionID {
+			continue
+		}
+		s.MessageCount++
+		if m.SenderName != nil && *m.SenderName != "" {
+			s.MessagesBySender[*m.SenderName]++
+		}
+		if m.ReplyCount > 0 {
+			s.ThreadCount++
+		}
+		at := m.CreatedAt
+		if s.FirstMessageAt == nil || at.Before(*s.FirstMessageAt) {
+			s.FirstMessageAt = &at
+		}
+		if s.LastMessageAt == nil || at.After(*s.LastMessageAt) {
+			s.LastMessageAt = &at
+		}
+	}
+	for _, p := range db.Participants {
+		if p.SessionID == sessionID {
+			s.ParticipantCount++
+		}
+	}
+	for _, r := range db.Reactions {
+		if r.SessionID == sessionID {
+			s.ReactionCount++
+		}
+	}
+	for _, f := range db.Flags {
+		if f.SessionID == sessionID && f.ResolvedAt == nil {
+			s.OpenFlagCount++
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,35 @@
+package db
+
+import "fmt"
+
+// recountReplies recomputes ReplyCount on every message. reply_count is
+// derived data, so it is rebuilt on load rather than trusted from disk.
+func (db *Database) recountReplies() {
+	counts := make(map[string]int)
+	for _, m := range db.Messages {
+		if m.ParentMessageID != nil {
+			counts[*m.ParentMessageID]++
+		}
+	}
+	for i := range db.Messages {
+		db.Messages[i].ReplyCount = counts[db.Messages[i].ID]
+	}
+}
+
+// addReply validates a new reply's parent and bumps its ReplyCount. Replies
+// must stay in the parent's session.
+func (db *Database) addReply(msg Message) error {
+	if msg.ParentMessageID == nil {
+		return nil
+	}
+	for i := range db.Messages {
+		if db.Messages[i].ID == *msg.ParentMessageID {
+			if db.Messages[i].SessionID != msg.SessionID {
+				break
+			}
+			db.Messages[i].ReplyCount++
+			return nil
+		}
+	}
+	return fmt.Errorf("parent message not found")
+}
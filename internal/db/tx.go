@@ -0,0 +1,94 @@
+package db
+
+// Tx groups writes so they are saved together or not at all. Its methods
+// behave like the Database methods of the same name but don't save; the
+// changes, and the realtime events they queue, become durable when the
+// function passed to Database.Tx returns nil.
+type Tx struct {
+	db *Database
+}
+
+// state is the part of the database a transaction may change.
+type state struct {
+	sessions     []ChatSession
+	messages     []Message
+	participants []Participant
+	flags        []Flag
+	reactions    []Reaction
+	outbox       []OutboxEvent
+	outboxSeq    int64
+	pending      bool
+}
+
+// Tx runs fn with the write lock held. If fn returns an error, or the save
+// afterwards fails, the in-memory state is rolled back and nothing is
+// published; otherwise everything is written in a single save.
+func (db *Database) Tx(fn func(tx *Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.writable(); err != nil {
+		return err
+	}
+
+	before := db.snapshot()
+	err := fn(&Tx{db: db})
+	if err == nil {
+		err = db.save()
+	}
+	if err != nil {
+		db.rollback(before)
+		return err
+	}
+	return nil
+}
+
+// snapshot copies the row slices. Rows are values, so later in-place edits of
+// the live slices don't reach the copies.
+func (db *Database) snapshot() state {
+	return state{
+		sessions:     append([]ChatSession(nil), db.Sessions...),
+		messages:     append([]Message(nil), db.Messages...),
+		participants: append([]Participant(nil), db.Participants...),
+		flags:        append([]Flag(nil), db.Flags...),
+		reactions:    append([]Reaction(nil), db.Reactions...),
+		outbox:       append([]OutboxEvent(nil), db.Outbox...),
+		outboxSeq:    db.outboxSeq,
+		pending:      db.outboxPending,
+	}
+}
+
+func (db *Database) rollback(s state) {
+	db.Sessions, db.Messages, db.Participants = s.sessions, s.messages, s.participants
+	db.Flags, db.Reactions, db.Outbox = s.flags, s.reactions, s.outbox
+	db.outboxSeq, db.outboxPending = s.outboxSeq, s.pending
+	db.rebuildIndex()
+	db.rebuildSeqs()
+	db.recountReplies()
+	db.rebuildCounters()
+}
+
+func (tx *Tx) CreateMessage(msg Message) (*Message, error) {
+	return tx.db.createMessage(msg)
+}
+
+func (tx *Tx) GetMessage(id string) (*Message, error) {
+	return tx.db.getMessage(id)
+}
+
+func (tx *Tx) UpdateMessage(id string, update func(m *Message) error) (*Message, error) {
+	return tx.db.updateMessage(id, update)
+}
+
+func (tx *Tx) UpdateSession(id string, update func(s *ChatSession) error) (*ChatSession, error) {
+	return tx.db.updateSession(id, update)
+}
+
+func (tx *Tx) JoinParticipant(sessionID, displayName string) (*Participant, error) {
+	p, _, err := tx.db.joinParticipant(sessionID, displayName)
+	return p, err
+}
+
+func (tx *Tx) FlagMessage(messageID, source string, reason, actor *string) (*Flag, error) {
+	return tx.db.flagMessage(messageID, source, reason, actor)
+}
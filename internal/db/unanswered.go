@@ -0,0 +1,76 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// Unanswered is an open session whose visitor is waiting for a reply.
+type Unanswered struct {
+	Session ChatSession
+	// Visitor is the sender waiting; WaitingSince is their first message
+	// after the last reply (or ever), and Pending counts their messages
+	// since then. LastMessage is the newest of them.
+	Visitor      string
+	WaitingSince time.Time
+	Pending      int
+	LastMessage  Message
+}
+
+// UnansweredSessions lists the open sessions in which a visitor has been
+// waiting at least waitingFor at now, longest wait first. When agents is
+// empty the first person to write in a session is taken as its visitor and
+// anyone else's message counts as a reply; otherwise only messages from the
+// names in agents do. Bot and system messages never count.
+func (db *Database) UnansweredSessions(agents map[string]bool, waitingFor time.Duration, now time.Time) []Unanswered {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	type state struct {
+		Unanswered
+		first string
+	}
+	open := make(map[string]*state)
+	for _, s := range db.Sessions {
+		if s.ClosedAt == nil {
+			open[s.ID] = &state{Unanswered: Unanswered{Session: s}}
+		}
+	}
+	// Messages are stored in creation order.
+	for _, m := range db.Messages {
+		st := open[m.SessionID]
+		if st == nil || m.MessageType == MessageTypeSystem || m.SenderName == nil || *m.SenderName == "" {
+			continue
+		}
+		if m.Origin != nil && *m.Origin == OriginBot {
+			continue
+		}
+		sender := *m.SenderName
+		if st.first == "" {
+			st.first = sender
+		}
+		isAgent := agents[sender]
+		if len(agents) == 0 {
+			isAgent = sender != st.first
+		}
+		if isAgent {
+			st.Pending, st.Visitor, st.WaitingSince = 0, "", time.Time{}
+			continue
+		}
+		if st.Pending == 0 {
+			st.WaitingSince = m.CreatedAt
+		}
+		st.Pending++
+		st.Visitor = sender
+		st.LastMessage = m
+	}
+
+	var result []Unanswered
+	for _, st := range open {
+		if st.Pending > 0 && now.Sub(st.WaitingSince) >= waitingFor {
+			result = append(result, st.Unanswered)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].WaitingSince.Before(result[j].WaitingSince) })
+	return result
+}
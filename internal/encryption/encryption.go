@@ -0,0 +1,107 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// magic prefixes every sealed file so plaintext written before encryption
+// was enabled can still be read.
+var magic = []byte("QCE1")
+
+// ErrDecrypt is returned when an encrypted file can't be opened, either
+// because no key is configured or the key is wrong.
+var ErrDecrypt = errors.New("cannot decrypt file")
+
+// Cipher seals data files and media with AES-256-GCM. A nil *Cipher is valid
+// and passes data through unchanged, so callers don't need to branch on
+// whether encryption is configured.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// ParseKey accepts a 32-byte key encoded as hex or standard base64.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("encryption key must be 32 bytes, hex or base64 encoded")
+}
+
+func New(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts plain as magic | nonce | ciphertext.
+func (c *Cipher) Seal(plain []byte) ([]byte, error) {
+	if c == nil {
+		return plain, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(magic)+len(nonce)+len(plain)+c.aead.Overhead())
+	out = append(out, magic...)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, plain, magic), nil
+}
+
+// Open decrypts data sealed by Seal. Data without the magic prefix is
+// returned as is.
+func (c *Cipher) Open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, fmt.Errorf("%w: it is encrypted but no encryption key is configured", ErrDecrypt)
+	}
+	rest := data[len(magic):]
+	if len(rest) < c.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: encrypted data is truncated", ErrDecrypt)
+	}
+	nonce, ct := rest[:c.aead.NonceSize()], rest[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ct, magic)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return plain, nil
+}
+
+// ReadFile reads and decrypts a file.
+func (c *Cipher) ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.Open(data)
+}
+
+// WriteFile encrypts and writes a file.
+func (c *Cipher) WriteFile(path string, plain []byte, perm os.FileMode) error {
+	data, err := c.Seal(plain)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
@@ -0,0 +1,64 @@
+package geoip
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"errors"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Resolver maps client IPs to coarse locations using a local MaxMind-format
+// database (GeoLite2-Country or GeoLite2-City). IPs are never stored.
+type Resolver struct {
+	reader *geoip2.Reader
+	// City also records the city name. Off by default: country is enough for
+	// language routing and keeps the stored data coarse.
+	City bool
+}
+
+func Open(path string) (*Resolver, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{reader: reader}, nil
+}
+
+func (r *Resolver) Close() error {
+	return r.reader.Close()
+}
+
+// Lookup returns the location of ip, or nil when it is unknown (private
+// ranges, addresses missing from the database).
+func (r *Resolver) Lookup(ip net.IP) *db.GeoLocation {
+	if r == nil || ip == nil {
+		return nil
+	}
+
+	city, err := r.reader.City(ip)
+	var invalid geoip2.InvalidMethodError
+	if errors.As(err, &invalid) {
+		// Country-only database.
+		country, err := r.reader.Country(ip)
+		if err != nil || country.Country.IsoCode == "" {
+			return nil
+		}
+		return &db.GeoLocation{
+			CountryCode: country.Country.IsoCode,
+			Country:     country.Country.Names["en"],
+		}
+	}
+	if err != nil || city.Country.IsoCode == "" {
+		return nil
+	}
+
+	loc := &db.GeoLocation{
+		CountryCode: city.Country.IsoCode,
+		Country:     city.Country.Names["en"],
+	}
+	if r.City {
+		loc.City = city.City.Names["en"]
+	}
+	return loc
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/archive"
+	"chat-quick-chat-server/internal/backup"
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/signing"
+	"chat-quick-chat-server/internal/ticket"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// requireAdmin checks the bearer token against AdminToken or, when Auth is
+// set, for an access token with the admin role, or the request signature
+// when the request is signed and Signing is set. Browsers may send the
+// token as the Basic auth password instead. The admin API is disabled
+// entirely when none is configured.
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if h.AdminToken == "" && h.Signing == nil && h.Auth == nil {
+		http.NotFound(w, r)
+		return false
+	}
+	if h.Signing != nil && signing.Signed(r) {
+		if !h.allowAttempt(w, r) {
+			return false
+		}
+		if err := h.Signing.Verify(r, h.DB.Now()); err != nil {
+			h.failedAttempt(w, r, "admin_signature", "")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	if token != "" && !h.allowAttempt(w, r) {
+		return false
+	}
+	if h.adminClaims(token) {
+		return true
+	}
+	if h.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) != 1 {
+		if token != "" {
+			h.failedAttempt(w, r, "admin_token", "")
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// adminClaims reports whether token is an access token Auth accepts with
+// the admin role, which admits its holder like ADMIN_TOKEN. service_role
+// tokens don't: the admin API is kept apart from the client API.
+func (h *Handler) adminClaims(token string) bool {
+	if h.Auth == nil || token == "" {
+		return false
+	}
+	claims, err := h.Auth.Verify(token)
+	return err == nil && claims.Role == roleAdmin
+}
+
+// handleCompact serves POST /admin/v1/compact[?min_age=1h][&dry_run=true].
+func (h *Handler) handleCompact(w http.ResponseWriter, r *http.Request) {
+	opts := db.CompactOptions{MinAge: time.Hour, DryRun: r.URL.Query().Get("dry_run") == "true"}
+	if v := r.URL.Query().Get("min_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid min_age", http.StatusBadRequest)
+			return
+		}
+		opts.MinAge = d
+	}
+
+	res, err := h.DB.Compact(h.StorageDir, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !opts.DryRun {
+		h.adjustStorage(-res.MediaBytes)
+		h.purgeCDN(res.MediaRemoved...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// handleBackup spools the archive to a temporary file before sending it, so
+// the database read lock isn't held for as long as a slow client takes.
+func (h *Handler) handleBackup(w http.ResponseWriter, r *http.Request) {
+	f, err := os.CreateTemp("", "backup-*.tar.gz")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	now := h.DB.Now()
+	if err := backup.Write(f, h.DB, h.StorageDir); err != nil {
+		log.Printf("backup failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := backup.FileName(now)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(w, r, name, now, f)
+}
+
+// handleRestore replaces the data and storage directories with an archive
+// and reloads everything kept from them: the database, the blocklist and
+// the /auth/v1 users, whose next save would otherwise undo the restore.
+func (h *Handler) handleRestore(w http.ResponseWriter, r *http.Request) {
+	err := h.DB.Replace(func() error {
+		if err := backup.Restore(r.Body, h.DB.DataDir, h.StorageDir); err != nil {
+			return err
+		}
+		if err := h.Blocklist.Reload(); err != nil {
+			return err
+		}
+		if h.Users != nil {
+			return h.Users.Reload()
+		}
+		return nil
+	})
+	h.resetStorageUsage()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMergeSessions folds session {id} into the session named by target_id
+// in the request body. Media uploaded under "{id}/" is moved to "{target}/",
+// and moved back if the merge fails.
+func (h *Handler) handleMergeSessions(w http.ResponseWriter, r *http.Request) {
+	sourceID := r.PathValue("id")
+
+	var body struct {
+		TargetID string `json:"target_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.TargetID == "" {
+		http.Error(w, "Missing target_id", http.StatusBadRequest)
+		return
+	}
+
+	oldPrefix := "/chat-media/" + sourceID + "/"
+	newPrefix := "/chat-media/" + body.TargetID + "/"
+	type move struct{ from, to string }
+	var moves []move
+	repoint := func(fileURL string) (string, error) {
+		i := strings.Index(fileURL, oldPrefix)
+		if i < 0 {
+			return fileURL, nil
+		}
+		rest := fileURL[i+len(oldPrefix):]
+		if strings.Contains(rest, "..") {
+			return fileURL, nil
+		}
+		from := filepath.Join(h.StorageDir, sourceID, rest)
+		to := filepath.Join(h.StorageDir, body.TargetID, rest)
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return "", err
+		}
+		if err := os.Rename(from, to); err == nil {
+			moves = append(moves, move{from, to})
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		return fileURL[:i] + newPrefix + rest, nil
+	}
+
+	target, err := h.DB.MergeSessions(sourceID, body.TargetID, repoint)
+	if err != nil {
+		for i := len(moves) - 1; i >= 0; i-- {
+			if rerr := os.Rename(moves[i].to, moves[i].from); rerr != nil {
+				log.Printf("merge %s: moving %s back: %v", sourceID, moves[i].to, rerr)
+			}
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Leave a pointer behind in the old conversation for anyone still on it.
+	text := "This conversation was merged into " + target.ID
+	h.DB.CreateMessage(db.SystemMessage(sourceID, text, db.SystemEvent{
+		Kind: db.EventMerged,
+		Data: map[string]interface{}{"target_id": target.ID},
+	}))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(target)
+}
+
+// handleExportSession returns a zip with the session, its messages (JSON, or
+// CSV with ?format=csv) and every referenced media file.
+func (h *Handler) handleExportSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = archive.FormatJSON
+	}
+	if format != archive.FormatJSON && format != archive.FormatCSV {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	session, err := h.DB.GetSession(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	messages, err := h.DB.GetMessages(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="session-`+id+`.zip"`)
+	if err := archive.WriteSession(w, session, messages, h.StorageDir, format, h.Cipher); err != nil {
+		log.Printf("export of session %s failed: %v", id, err)
+	}
+}
+
+// handleEscalate serves POST /admin/v1/sessions/{id}/escalate: it files a
+// ticket with the transcript in the configured tracker and posts the link
+// into the conversation. Body (optional): {"title", "note", "actor"}.
+func (h *Handler) handleEscalate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if h.Tickets == nil {
+		http.Error(w, "Ticketing is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	var body struct {
+		Title string  `json:"title"`
+		Note  string  `json:"note"`
+		Actor *string `json:"actor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	session, err := h.DB.GetSession(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	messages, err := h.DB.GetMessages(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t := ticket.Ticket{Title: body.Title, SessionID: id}
+	if t.Title == "" {
+		name := id
+		if session.Title != nil && *session.Title != "" {
+			name = *session.Title
+		} else if session.Code != nil {
+			name = *session.Code
+		}
+		t.Title = "Chat escalation: " + name
+	}
+	if body.Note != "" {
+		t.Body = body.Note + "\n\n"
+	}
+	t.Body += "Session: " + id + "\n\nTranscript:\n\n" + archive.Transcript(messages)
+
+	res, err := h.Tickets.Create(t)
+	if err != nil {
+		log.Printf("escalation of session %s failed: %v", id, err)
+		http.Error(w, "Creating the ticket failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	text := "Escalated to ticket " + res.ID
+	if res.URL != "" {
+		text += ": " + res.URL
+	}
+	msg, err := h.DB.CreateMessage(db.SystemMessage(id, text, db.SystemEvent{
+		Kind:  db.EventEscalated,
+		Actor: body.Actor,
+		Data: map[string]interface{}{
+			"provider":   h.Tickets.Name(),
+			"ticket_id":  res.ID,
+			"ticket_url": res.URL,
+		},
+	}))
+	if err != nil {
+		// The ticket exists; report it even though the notice wasn't posted.
+		log.Printf("posting escalation notice to session %s failed: %v", id, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		*ticket.Result
+		Message *db.Message `json:"message"`
+	}{res, msg})
+}
+
+// handlePurgeSession serves DELETE /admin/v1/sessions/{id}: the session and
+// everything in it goes, as with the purge_session RPC.
+func (h *Handler) handlePurgeSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	removed, messages, err := h.DB.DeleteSessions(func(s db.ChatSession) bool { return s.ID == id })
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(removed) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	h.removeMessageMedia(messages)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"session_id": id, "messages_deleted": len(messages)})
+}
+
+// handleBanSession serves POST /admin/v1/sessions/{id}/ban: the session is
+// closed as banned, so it takes no more messages, and a notice is posted.
+// A session already closed for another reason is marked banned. Body
+// (optional): {"actor", "note"}.
+func (h *Handler) handleBanSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var body struct {
+		Actor *string `json:"actor"`
+		Note  *string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	session, err := h.DB.CloseSession(id, db.CloseReasonBanned)
+	if err != nil && err.Error() == "session already closed" {
+		session, err = h.DB.UpdateSession(id, func(s *db.ChatSession) error {
+			reason := db.CloseReasonBanned
+			s.CloseReason = &reason
+			return nil
+		})
+	}
+	if err != nil && err.Error() == "session not found" {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{"reason": db.CloseReasonBanned}
+	if body.Note != nil {
+		data["note"] = *body.Note
+	}
+	if _, err := h.DB.CreateMessage(db.SystemMessage(id, "This conversation was closed by a moderator.", db.SystemEvent{
+		Kind:  db.EventClosed,
+		Actor: body.Actor,
+		Data:  data,
+	})); err != nil {
+		log.Printf("posting ban notice to session %s failed: %v", id, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/realtime"
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Roles of a request, as in Supabase: anon for the public key shipped in
+// apps, service_role for trusted backends. admin is never a REST role: an
+// access token carrying it opens /admin/v1 instead of ADMIN_TOKEN.
+const (
+	roleAnon    = "anon"
+	roleService = "service_role"
+	roleAdmin   = "admin"
+)
+
+type contextKey int
+
+const (
+	roleKey contextKey = iota
+	claimsKey
+)
+
+// withRole returns r carrying role.
+func withRole(r *http.Request, role string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), roleKey, role))
+}
+
+// requestRole is the role of r's access token, or else of its API key.
+// Without either every request is trusted, as before keys existed.
+func requestRole(r *http.Request) string {
+	if claims := requestClaims(r); claims != nil {
+		return claims.Role
+	}
+	if role, ok := r.Context().Value(roleKey).(string); ok {
+		return role
+	}
+	return roleService
+}
+
+// clientAPI reports whether path belongs to the API apps call with an API
+// key and access token. Public media downloads are not part of it, since
+// they are linked from <img> tags, and neither are the probes, the admin
+// API or the payment webhook, which has its own signature.
+func clientAPI(path string) bool {
+	return strings.HasPrefix(path, "/rest/v1") ||
+		strings.HasPrefix(path, "/storage/v1/object/chat-media/") ||
+		path == "/storage/v1/object/chat-media" ||
+		path == "/search"
+}
+
+// keysRequired reports whether r must carry an API key: it is for the
+// client API or /auth/v1, but not the OAuth pages browsers navigate to, and
+// AnonKey or ServiceRoleKey is set. /auth/v1 checks its own bearer tokens,
+// so it isn't part of clientAPI.
+func (h *Handler) keysRequired(r *http.Request) bool {
+	return (h.AnonKey != "" || h.ServiceRoleKey != "") &&
+		(clientAPI(r.URL.Path) || (strings.HasPrefix(r.URL.Path, "/auth/v1/") && !browserAuthPage(r)))
+}
+
+// apiKeyRole checks the apikey header, or the apikey query parameter for
+// clients that can't set headers, and returns the role it grants.
+func (h *Handler) apiKeyRole(r *http.Request) (role string, found bool) {
+	key := r.Header.Get("apikey")
+	if key == "" {
+		key = r.URL.Query().Get("apikey")
+	}
+	switch {
+	case key == "":
+		return "", false
+	case keyMatches(key, h.ServiceRoleKey):
+		return roleService, true
+	case keyMatches(key, h.AnonKey):
+		return roleAnon, true
+	}
+	return "", true
+}
+
+func keyMatches(key, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(key), []byte(want)) == 1
+}
+
+// checkAPIKey gives r the role of its API key, or answers 401 and returns
+// false when the key is missing or unknown.
+func (h *Handler) checkAPIKey(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if !h.keysRequired(r) {
+		return r, true
+	}
+	role, found := h.apiKeyRole(r)
+	if role != "" {
+		return withRole(r, role), true
+	}
+	msg, code := "Invalid API key", "invalid_api_key"
+	if !found {
+		msg, code = "No API key found in request", "no_api_key"
+	}
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/rest/v1"), r.URL.Path == "/search":
+		restError(w, msg, http.StatusUnauthorized)
+	case strings.HasPrefix(r.URL.Path, "/auth/v1/"):
+		authError(w, http.StatusUnauthorized, code, msg)
+	default:
+		http.Error(w, msg, http.StatusUnauthorized)
+	}
+	return r, false
+}
+
+// handleRealtime serves GET /realtime/v1/websocket. As in Supabase
+// Realtime, the handshake must carry a valid apikey, in the query string
+// as supabase-js sends it, once AnonKey or ServiceRoleKey is set; the hub
+// checks the access token.
+func (h *Handler) handleRealtime(w http.ResponseWriter, r *http.Request) {
+	if h.AnonKey != "" || h.ServiceRoleKey != "" {
+		if role, found := h.apiKeyRole(r); role == "" {
+			msg := "Invalid API key"
+			if !found {
+				msg = "No API key found in request"
+			}
+			http.Error(w, msg, http.StatusUnauthorized)
+			return
+		}
+	}
+	realtime.ServeWs(h.Hub, w, r)
+}
+
+// requireServiceRole answers 403 and returns false unless r has the
+// service_role role, from its API key or access token. It guards what, an
+// operation across sessions, which a visitor with the public anon key has
+// no business making.
+func requireServiceRole(w http.ResponseWriter, r *http.Request, what string) bool {
+	if requestRole(r) == roleService {
+		return true
+	}
+	restError(w, "permission denied: "+what+" requires service_role", http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/blocklist"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// handleBlocklist exports (GET) and imports (POST merges, PUT replaces) the
+// local blocklist entries. Bodies may be JSON or the plain-text format; GET
+// returns text with ?format=text.
+func (h *Handler) handleBlocklist(w http.ResponseWriter, r *http.Request) {
+	if h.Blocklist == nil {
+		http.Error(w, "Blocklist is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		l := h.Blocklist.Export()
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, l.Text())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l)
+	case "POST", "PUT":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		l, err := blocklist.Parse(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.Blocklist.Import(l, r.Method == "PUT"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Defaults for Handler.MaxBodyBytes and Handler.MaxUploadBytes.
+const (
+	DefaultMaxBodyBytes   = 1 << 20
+	DefaultMaxUploadBytes = 50 << 20
+)
+
+// bodyLimit returns the most a request to path may send, or 0 for no limit.
+// The admin API is trusted and left alone, since restores are whole backups.
+func (h *Handler) bodyLimit(path string) int64 {
+	var limit int64
+	switch {
+	case strings.HasPrefix(path, "/storage/v1/object/chat-media/"):
+		if limit = h.MaxUploadBytes; limit == 0 {
+			limit = DefaultMaxUploadBytes
+		}
+	case strings.HasPrefix(path, "/rest/v1/"), strings.HasPrefix(path, "/payments/v1/"), strings.HasPrefix(path, "/auth/v1/"),
+		path == "/storage/v1/object/chat-media":
+		if limit = h.MaxBodyBytes; limit == 0 {
+			limit = DefaultMaxBodyBytes
+		}
+	}
+	return max(limit, 0)
+}
+
+// uploadLimit is bodyLimit for uploads, for /capabilities.
+func (h *Handler) uploadLimit() int64 {
+	return h.bodyLimit("/storage/v1/object/chat-media/")
+}
+
+// tooLarge answers 413 and returns true when err is a read cut short by
+// http.MaxBytesReader.
+func tooLarge(w http.ResponseWriter, r *http.Request, err error) bool {
+	var maxErr *http.MaxBytesError
+	if !errors.As(err, &maxErr) {
+		return false
+	}
+	writeTooLarge(w, r, maxErr.Limit)
+	return true
+}
+
+// writeTooLarge answers 413 for a body over limit bytes.
+func writeTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	msg := fmt.Sprintf("Request body is larger than %d bytes", limit)
+	if strings.HasPrefix(r.URL.Path, "/rest/v1/") {
+		restError(w, msg, http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, msg, http.StatusRequestEntityTooLarge)
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lockout throttles guessing at session tokens, session codes, refresh
+// tokens, sign-in links and the admin token. Once a client IP has failed
+// Attempts times, each further failure locks it out for Base, doubling
+// every time up to Max; failures are forgotten after Max without any. Only
+// the guessing IP is locked out: locking out a session would let anyone
+// lock its visitor out with wrong tokens. The zero value disables it.
+type Lockout struct {
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+}
+
+// lockFor is how long the failure-th failure in a row locks a key out.
+func (l Lockout) lockFor(failure int) time.Duration {
+	over := failure - l.Attempts
+	if over <= 0 {
+		return 0
+	}
+	if over > 30 {
+		return l.Max
+	}
+	return min(l.Max, l.Base<<(over-1))
+}
+
+type failures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// lockouts counts the failed attempts of each key, "ip:" and the client
+// IP.
+type lockouts struct {
+	mu     sync.Mutex
+	keys   map[string]*failures
+	pruned time.Time
+}
+
+// wait is how long key stays locked out.
+func (l *lockouts) wait(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f := l.keys[key]; f != nil && now.Before(f.until) {
+		return f.until.Sub(now)
+	}
+	return 0
+}
+
+// fail records a failed attempt of key and returns how many it has made in
+// a row and how long they lock it out.
+func (l *lockouts) fail(cfg Lockout, key string, now time.Time) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.keys == nil {
+		l.keys = make(map[string]*failures)
+	}
+	if now.Sub(l.pruned) > bucketPruneEvery {
+		for k, f := range l.keys {
+			if now.Sub(f.last) > cfg.Max {
+				delete(l.keys, k)
+			}
+		}
+		l.pruned = now
+	}
+	f := l.keys[key]
+	if f == nil || now.Sub(f.last) > cfg.Max {
+		f = &failures{}
+		l.keys[key] = f
+	}
+	f.count++
+	f.last = now
+	lock := cfg.lockFor(f.count)
+	if lock > 0 {
+		f.until = now.Add(lock)
+	}
+	return f.count, lock
+}
+
+// attemptKey is the key an attempt from r counts against.
+func (h *Handler) attemptKey(r *http.Request) string {
+	return "ip:" + h.clientIP(r).String()
+}
+
+// allowAttempt answers 429 and returns false while r's client IP is locked
+// out. It goes before checking what r presents, so a lockout hides whether
+// the guess was right.
+func (h *Handler) allowAttempt(w http.ResponseWriter, r *http.Request) bool {
+	if h.Lockout.Attempts <= 0 {
+		return true
+	}
+	wait := h.lockouts.wait(h.attemptKey(r), time.Now())
+	if wait == 0 {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	msg := "Too many failed attempts, try again later"
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/rest/v1/"), r.URL.Path == "/search":
+		restError(w, msg, http.StatusTooManyRequests)
+	case strings.HasPrefix(r.URL.Path, "/auth/v1/"):
+		authError(w, http.StatusTooManyRequests, "over_request_rate_limit", msg)
+	default:
+		http.Error(w, msg, http.StatusTooManyRequests)
+	}
+	return false
+}
+
+// failedAttempt records that r presented a wrong credential, what, for
+// sessionID if set. The failure counts against r's client IP; it and any
+// lockout it starts go to the audit log.
+func (h *Handler) failedAttempt(w http.ResponseWriter, r *http.Request, what, sessionID string) {
+	ip := h.clientIP(r).String()
+	attrs := []slog.Attr{
+		slog.String("credential", what),
+		slog.String("ip", ip),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	}
+	if sessionID != "" {
+		attrs = append(attrs, slog.String("session_id", sessionID))
+	}
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	h.audit(r, slog.LevelWarn, "auth_failed", attrs...)
+
+	if h.Lockout.Attempts <= 0 {
+		return
+	}
+	key := h.attemptKey(r)
+	count, lock := h.lockouts.fail(h.Lockout, key, time.Now())
+	if lock > 0 {
+		h.audit(r, slog.LevelWarn, "locked_out", append(attrs,
+			slog.String("key", key),
+			slog.Int("failures", count),
+			slog.Duration("duration", lock))...)
+	}
+}
+
+// audit writes a security event to AuditLog.
+func (h *Handler) audit(r *http.Request, level slog.Level, event string, attrs ...slog.Attr) {
+	if h.AuditLog != nil {
+		h.AuditLog.LogAttrs(r.Context(), level, event, attrs...)
+	}
+}
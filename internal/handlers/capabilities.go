@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"encoding/json"
+	"net/http"
+)
+
+// realtimeProtocolVersions are the Phoenix serializer versions ServeWs
+// speaks: 1.0.0 frames are JSON objects.
+var realtimeProtocolVersions = []string{"1.0.0"}
+
+// handleCapabilities serves GET /capabilities: which optional parts of the
+// server are enabled, so a widget can feature-detect instead of probing
+// endpoints for 404s. It reflects the configuration, not the data.
+func (h *Handler) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	realtimeCaps := map[string]interface{}{"enabled": h.Hub != nil}
+	if h.Hub != nil {
+		maxTopics, joinRate := h.Hub.JoinLimits()
+		auth := "none"
+		if h.Hub.Authorize != nil {
+			auth = "token"
+		}
+		realtimeCaps = map[string]interface{}{
+			"enabled":           true,
+			"protocol_versions": realtimeProtocolVersions,
+			"postgres_changes":  true,
+			"broadcast":         true,
+			"presence":          true,
+			"typing":            true,
+			"auth":              auth,
+			"requires_key":      h.AnonKey != "" || h.ServiceRoleKey != "",
+			"token_expiry":      h.Hub.VerifyToken != nil,
+			// 0 means no limit.
+			"max_topics": maxTopics,
+			"join_rate":  joinRate,
+		}
+	}
+
+	admin := "disabled"
+	switch {
+	case h.AdminToken != "" && h.Signing != nil:
+		admin = "token_or_signature"
+	case h.AdminToken != "":
+		admin = "token"
+	case h.Signing != nil:
+		admin = "signature"
+	case h.Auth != nil:
+		admin = "role"
+	}
+	maxRows := h.MaxRows
+	if maxRows <= 0 {
+		maxRows = defaultMaxRows
+	}
+	var uploadMax interface{}
+	if limit := h.uploadLimit(); limit > 0 {
+		uploadMax = limit
+	}
+
+	caps := map[string]interface{}{
+		"rest": map[string]interface{}{
+			"versions":        []string{"v1"},
+			"max_rows":        maxRows,
+			"csv":             true,
+			"logical_filters": true,
+			"embedding":       []string{"chat_sessions"},
+			"read_only":       h.DB.ReadOnly(),
+		},
+		"realtime": realtimeCaps,
+		"uploads": map[string]interface{}{
+			"enabled": true,
+			// nil when uploads aren't size-limited here; a proxy in front
+			// may still be.
+			"max_bytes": uploadMax,
+			"encrypted": h.Cipher != nil,
+			"delete":    true,
+		},
+		"reactions": true,
+		"search": map[string]interface{}{
+			"enabled": true,
+			"scopes":  db.SearchScopeNames(),
+		},
+		"auth": map[string]interface{}{
+			"realtime":          realtimeCaps["auth"],
+			"identity_tokens":   h.Identity != nil,
+			"admin":             admin,
+			"admin_role":        h.Auth != nil,
+			"rest_requires_key": h.AnonKey != "" || h.ServiceRoleKey != "",
+			"jwt":               h.Auth != nil,
+			"anonymous_sign_in": h.authEnabled(),
+			"oauth_providers":   h.oauthProviders(),
+			"email_sign_in":     h.emailSignIn(),
+			"lockout":           h.Lockout.Attempts > 0,
+			"session_tokens":    h.SessionTokens != nil,
+			"policies":          h.Policy != nil,
+		},
+		"features": map[string]interface{}{
+			"qr_join":     h.JoinURLTemplate != "",
+			"geoip":       h.GeoIP != nil,
+			"blocklist":   h.Blocklist != nil,
+			"automations": h.Automations != nil,
+			"sla":         h.SLA != nil,
+			"tickets":     h.Tickets != nil,
+			"calendar":    h.Calendar != nil,
+			"payments":    h.Payments != nil,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(caps)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"chat-quick-chat-server/internal/outbound"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// contentAddressed matches storage paths that embed a UUID or a hex digest.
+// Clients name uploads that way precisely so the URL changes with the
+// content, which makes those responses safe to cache forever.
+var contentAddressed = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}|[0-9a-f]{32,}`)
+
+// defaultMediaMaxAge applies to media whose name doesn't look content-addressed.
+const defaultMediaMaxAge = time.Hour
+
+// setMediaCacheHeaders marks a public media response as cacheable by shared
+// caches and tags it with surrogate keys a CDN can purge by.
+func (h *Handler) setMediaCacheHeaders(w http.ResponseWriter, fileName string) {
+	if contentAddressed.MatchString(fileName) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		maxAge := h.MediaMaxAge
+		if maxAge <= 0 {
+			maxAge = defaultMediaMaxAge
+		}
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	}
+	keys := strings.Join(surrogateKeys(fileName), " ")
+	w.Header().Set("Surrogate-Key", keys)
+	w.Header().Set("Cache-Tag", strings.ReplaceAll(keys, " ", ","))
+}
+
+// surrogateKeys returns the purge keys for a stored object: the bucket, every
+// directory prefix and the object itself.
+func surrogateKeys(fileName string) []string {
+	keys := []string{"chat-media"}
+	parts := strings.Split(fileName, "/")
+	for i := range parts {
+		keys = append(keys, "chat-media/"+strings.Join(parts[:i+1], "/"))
+	}
+	return keys
+}
+
+// purgeCDN asks the configured CDN purge webhook to drop cached copies of the
+// given storage paths. It runs in the background; failures are only logged
+// since the object itself has already changed.
+func (h *Handler) purgeCDN(fileNames ...string) {
+	if h.CDNPurgeURL == "" || len(fileNames) == 0 {
+		return
+	}
+	var keys, paths []string
+	for _, name := range fileNames {
+		keys = append(keys, "chat-media/"+name)
+		paths = append(paths, "/storage/v1/object/public/chat-media/"+name)
+	}
+	body, err := json.Marshal(map[string][]string{
+		"surrogate_keys": keys,
+		"paths":          paths,
+	})
+	if err != nil {
+		return
+	}
+
+	go func() {
+		client := outbound.Client("cdn", 10*time.Second)
+		resp, err := client.Post(h.CDNPurgeURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("CDN purge failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("CDN purge failed: %s", resp.Status)
+		}
+	}()
+}
+
+// handlePurge lets an operator purge storage paths by hand:
+// POST /admin/v1/storage/purge {"paths": ["a/b.png", ...]}.
+func (h *Handler) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if h.CDNPurgeURL == "" {
+		http.Error(w, "CDN purge is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	var body struct {
+		Paths []string `json:"paths"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.purgeCDN(body.Paths...)
+	w.WriteHeader(http.StatusAccepted)
+}
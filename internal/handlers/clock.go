@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// clock identifies this server process for /time. started carries Go's
+// monotonic reading, so uptime is immune to wall-clock steps.
+type clock struct {
+	started time.Time
+	bootID  string
+}
+
+func newClock() clock {
+	return clock{started: time.Now(), bootID: uuid.New().String()}
+}
+
+// handleTime serves GET /time[?client_time={epoch_ms}] for clock-skew
+// correction. A client records t0 before the request and t1 after it; the
+// offset to add to its own clock is epoch_ms - (t0+t1)/2, within half the
+// round trip. uptime_ms only ever grows while boot_id stays the same, so
+// clients can order their own events against it even if the server's wall
+// clock is adjusted.
+func (h *Handler) handleTime(w http.ResponseWriter, r *http.Request) {
+	now := h.DB.Now()
+	resp := struct {
+		Now        time.Time `json:"now"`
+		EpochMS    int64     `json:"epoch_ms"`
+		UptimeMS   int64     `json:"uptime_ms"`
+		BootID     string    `json:"boot_id"`
+		ClientTime *int64    `json:"client_time"`
+	}{
+		Now:      now.UTC(),
+		EpochMS:  now.UnixMilli(),
+		UptimeMS: time.Since(h.clock.started).Milliseconds(),
+		BootID:   h.clock.bootID,
+	}
+	if v := r.URL.Query().Get("client_time"); v != "" {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid client_time", http.StatusBadRequest)
+			return
+		}
+		resp.ClientTime = &ms
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
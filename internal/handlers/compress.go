@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minCompressBytes is the smallest response with a known length worth
+// compressing; below it the gzip framing outweighs the savings.
+const minCompressBytes = 1024
+
+// acceptedEncoding picks gzip or deflate from r's Accept-Encoding, gzip
+// when both are equally welcome, or "" for neither.
+func acceptedEncoding(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch name {
+		case "*":
+			name = "gzip"
+		case "gzip", "deflate":
+		default:
+			continue
+		}
+		if q > bestQ || q == bestQ && name == "gzip" {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether a response of this Content-Type is worth
+// compressing. Images, video, audio and archives already are.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		// Streams are flushed event by event.
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/javascript",
+		mediaType == "application/xml",
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	return false
+}
+
+// compressWriter gzips or deflates a response in encoding ("" when the
+// client accepts neither) on the way out when its headers say it is worth
+// it; otherwise it passes the response through untouched. The decision is
+// made at WriteHeader, so handlers set Content-Type first as they already
+// do.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	decided  bool
+	enc      io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.decided = true
+	hdr := w.Header()
+	if !compressible(hdr.Get("Content-Type")) || hdr.Get("Content-Encoding") != "" {
+		w.encoding = ""
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	hdr.Add("Vary", "Accept-Encoding")
+
+	length, err := strconv.Atoi(hdr.Get("Content-Length"))
+	switch {
+	case w.encoding == "":
+	case err == nil && length < minCompressBytes:
+		w.encoding = ""
+	// PostgREST's 206 carries a row range, not a byte range.
+	case status == http.StatusPartialContent && strings.HasPrefix(hdr.Get("Content-Range"), "bytes"):
+		w.encoding = ""
+	case status != http.StatusOK && status != http.StatusCreated && status != http.StatusPartialContent:
+		w.encoding = ""
+	default:
+		hdr.Set("Content-Encoding", w.encoding)
+		hdr.Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encoding == "" {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.enc == nil {
+		if w.encoding == "gzip" {
+			w.enc = gzip.NewWriter(w.ResponseWriter)
+		} else {
+			w.enc = zlib.NewWriter(w.ResponseWriter)
+		}
+	}
+	return w.enc.Write(p)
+}
+
+func (w *compressWriter) Flush() {
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close ends the compressed stream, if one was started.
+func (w *compressWriter) close() {
+	if w.enc != nil {
+		w.enc.Close()
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const csvMediaType = "text/csv"
+
+// wantsCSV reports whether the client asked for CSV with Accept: text/csv.
+func wantsCSV(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, t := range strings.Split(accept, ",") {
+			if mt, _, _ := strings.Cut(strings.TrimSpace(t), ";"); strings.EqualFold(mt, csvMediaType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeCSV answers with rows as CSV: a header row of the columns in the
+// order of the JSON representation, then one line per row. Strings are
+// written as they are, null as an empty cell, and anything else, objects
+// and arrays included, as its JSON text.
+func writeCSV[T any](w http.ResponseWriter, status int, rows []T) {
+	columns := csvColumns[T]()
+	w.Header().Set("Content-Type", csvMediaType+"; charset=utf-8")
+	w.WriteHeader(status)
+	if isHead(w) {
+		return
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		data, _ := json.Marshal(row)
+		var values map[string]json.RawMessage
+		json.Unmarshal(data, &values)
+		for i, c := range columns {
+			record[i] = csvCell(values[c])
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+}
+
+// csvColumns lists the columns of T in field order, read from the JSON of
+// an empty row so that embedded structs come out as they do in JSON.
+func csvColumns[T any]() []string {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	data, _ := json.Marshal(reflect.New(t).Interface())
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var columns []string
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return columns
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		columns = append(columns, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			break
+		}
+	}
+	return columns
+}
+
+func csvCell(v json.RawMessage) string {
+	if len(v) == 0 || string(v) == "null" {
+		return ""
+	}
+	var s string
+	if v[0] == '"' && json.Unmarshal(v, &s) == nil {
+		return s
+	}
+	return string(v)
+}
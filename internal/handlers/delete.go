@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/policy"
+	"net/http"
+)
+
+// sessionRowColumns are the stored chat_sessions columns; last_message_at
+// is only computed for listings.
+var sessionRowColumns = columnsOf(db.ChatSession{})
+
+// deleteFilters reads the filters of a DELETE. At least one is required, as
+// with PostgREST's safeupdate, so a bare DELETE can't empty the table.
+func deleteFilters(w http.ResponseWriter, r *http.Request, columns map[string]bool) ([]filter, bool) {
+	filters, err := parseFilters(r.URL.Query(), columns)
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if len(filters) == 0 {
+		restErrorCode(w, http.StatusBadRequest, "21000", "DELETE requires a WHERE clause", "")
+		return nil, false
+	}
+	return filters, true
+}
+
+// handleDeleteMessages serves DELETE /rest/v1/messages?{filters}, e.g.
+// id=eq.{messageId}. Replies and reactions go with their message, and
+// uploads no remaining message uses are removed from storage. Messages of
+// sessions r has no token for, or that the policies hide, are left alone.
+func (h *Handler) handleDeleteMessages(w http.ResponseWriter, r *http.Request) {
+	filters, ok := deleteFilters(w, r, messageColumns)
+	if !ok {
+		return
+	}
+	removed, err := h.DB.DeleteMessages(func(m db.Message) bool {
+		return matches(m, filters) && h.sessionAllowed(r, m.SessionID) && h.visible(r, "messages", policy.Delete, m)
+	})
+	if err != nil {
+		restError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.removeMessageMedia(removed)
+	writeDeleted(w, r, removed)
+}
+
+// handleDeleteSessions serves DELETE /rest/v1/chat_sessions?{filters}. The
+// sessions' messages, participants, reactions, flags and uploads go too.
+// Only service_role may delete sessions here, unless policies on the table
+// decide; as with messages, sessions r has no token for, or that the
+// policies hide, are left alone. Operators purge at /admin/v1/sessions.
+func (h *Handler) handleDeleteSessions(w http.ResponseWriter, r *http.Request) {
+	if !h.Policy.Protects("chat_sessions") && !requireServiceRole(w, r, "deleting sessions") {
+		return
+	}
+	filters, ok := deleteFilters(w, r, sessionRowColumns)
+	if !ok {
+		return
+	}
+	removed, messages, err := h.DB.DeleteSessions(func(s db.ChatSession) bool {
+		return matches(s, filters) && h.sessionAllowed(r, s.ID) && h.visible(r, "chat_sessions", policy.Delete, s)
+	})
+	if err != nil {
+		restError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.removeMessageMedia(messages)
+	writeDeleted(w, r, removed)
+}
+
+// removeMessageMedia deletes the uploads of removed messages. Merges and
+// imports can leave two messages pointing at one file, so files still in
+// use are kept.
+func (h *Handler) removeMessageMedia(removed []db.Message) {
+	urls := make(map[string]bool)
+	for _, m := range removed {
+		if m.FileURL != nil {
+			urls[*m.FileURL] = true
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+	h.DB.ReadLocked(func() error {
+		for _, m := range h.DB.Messages {
+			if m.FileURL != nil {
+				delete(urls, *m.FileURL)
+			}
+		}
+		return nil
+	})
+	for u := range urls {
+		h.removeMedia(u)
+	}
+}
+
+// writeDeleted answers a DELETE. As in PostgREST, the deleted rows are
+// only sent back under Prefer: return=representation.
+func writeDeleted[T any](w http.ResponseWriter, r *http.Request, removed []T) {
+	if returnPreference(r) != returnRepresentation {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeResult(w, r, http.StatusOK, removed)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"fmt"
+	"strings"
+)
+
+// sessionEmbed is a chat_sessions(...) item in the select= of a message
+// read, PostgREST's embedding along messages.session_id. columns is nil for
+// chat_sessions(*).
+type sessionEmbed struct {
+	columns []string
+}
+
+// messageWithSession is a message with its session embedded.
+type messageWithSession struct {
+	db.Message
+	// Session is the *db.ChatSession, or a map of the selected columns.
+	Session interface{} `json:"chat_sessions"`
+}
+
+// parseSessionEmbed finds a chat_sessions embedding in select=, e.g.
+// select=*,chat_sessions(*) or select=*,chat_sessions(id,title). It returns
+// nil when there is none. The other items of select= are not applied:
+// messages always come with all their columns.
+func parseSessionEmbed(sel string) (*sessionEmbed, error) {
+	if sel == "" {
+		return nil, nil
+	}
+	items, err := splitOperands(strings.ReplaceAll(sel, " ", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid select %q: %v", sel, err)
+	}
+	var embed *sessionEmbed
+	for _, item := range items {
+		name, inner, ok := strings.Cut(item, "(")
+		if !ok {
+			continue
+		}
+		if name != "chat_sessions" {
+			return nil, fmt.Errorf("could not find a relationship between messages and %q", name)
+		}
+		inner, ok = strings.CutSuffix(inner, ")")
+		if !ok || embed != nil {
+			return nil, fmt.Errorf("invalid select %q", sel)
+		}
+		embed = &sessionEmbed{}
+		if inner == "*" {
+			continue
+		}
+		for _, c := range strings.Split(inner, ",") {
+			if !sessionRowColumns[c] {
+				return nil, fmt.Errorf("column chat_sessions.%s does not exist", c)
+			}
+			embed.columns = append(embed.columns, c)
+		}
+	}
+	return embed, nil
+}
+
+// embedSessions joins each message with its session, looking every session
+// up once. A message whose session is gone gets null.
+func (h *Handler) embedSessions(messages []db.Message, embed *sessionEmbed) []messageWithSession {
+	sessions := make(map[string]interface{})
+	rows := make([]messageWithSession, 0, len(messages))
+	for _, m := range messages {
+		s, ok := sessions[m.SessionID]
+		if !ok {
+			if session, err := h.DB.GetSession(m.SessionID); err == nil {
+				s = embed.row(session)
+			}
+			sessions[m.SessionID] = s
+		}
+		rows = append(rows, messageWithSession{Message: m, Session: s})
+	}
+	return rows
+}
+
+func (e *sessionEmbed) row(s *db.ChatSession) interface{} {
+	if e.columns == nil {
+		return s
+	}
+	values := rowValues(s)
+	row := make(map[string]interface{}, len(e.columns))
+	for _, c := range e.columns {
+		row[c] = values[c]
+	}
+	return row
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// restErrorBody is the error PostgREST sends, which supabase-js hands back
+// as the error of a query. Code is a PostgREST (PGRSTxxx) or Postgres
+// (SQLSTATE) code; Details and Hint are null when there is nothing to add.
+type restErrorBody struct {
+	Code    string  `json:"code"`
+	Details *string `json:"details"`
+	Hint    *string `json:"hint"`
+	Message string  `json:"message"`
+}
+
+// restErrorCodes is the code sent with a status when the handler has no more
+// specific one, following the mapping PostgREST uses in the other direction.
+var restErrorCodes = map[int]string{
+	http.StatusBadRequest:                   "PGRST100",
+	http.StatusUnauthorized:                 "PGRST301",
+	http.StatusForbidden:                    "42501",
+	http.StatusNotFound:                     "P0002",
+	http.StatusMethodNotAllowed:             "PGRST117",
+	http.StatusNotAcceptable:                "PGRST116",
+	http.StatusConflict:                     "23505",
+	http.StatusRequestEntityTooLarge:        "54000",
+	http.StatusRequestedRangeNotSatisfiable: "PGRST103",
+	http.StatusTooManyRequests:              "53400",
+	http.StatusNotImplemented:               "0A000",
+	http.StatusServiceUnavailable:           "25006",
+	http.StatusInsufficientStorage:          "53100",
+}
+
+// restError answers a REST request with a PostgREST error. It takes the
+// arguments of http.Error, whose place it takes under /rest/v1.
+func restError(w http.ResponseWriter, message string, status int) {
+	code, ok := restErrorCodes[status]
+	if !ok {
+		code = "XX000"
+	}
+	restErrorCode(w, status, code, message, "")
+}
+
+// restErrorCode answers with a particular code and, unless empty, details.
+func restErrorCode(w http.ResponseWriter, status int, code, message, details string) {
+	body := restErrorBody{Code: code, Message: message}
+	if details != "" {
+		body.Details = &details
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// invalidBody reports a request body that isn't the JSON expected, or that
+// was cut off for being too large.
+func invalidBody(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		restError(w, fmt.Sprintf("Request body is larger than %d bytes", maxErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	restErrorCode(w, http.StatusBadRequest, "PGRST102", "Invalid request body", err.Error())
+}
+
+// unknownColumn reports a column that can't be written, as PostgREST does
+// for one that isn't in its schema cache.
+func unknownColumn(w http.ResponseWriter, table, column string) {
+	restErrorCode(w, http.StatusBadRequest, "PGRST204",
+		fmt.Sprintf("Could not find the '%s' column of '%s' in the schema cache", column, table), "")
+}
+
+// unknownTable answers requests for /rest/v1/{name} that name no table.
+func unknownTable(w http.ResponseWriter, r *http.Request) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/rest/v1/"), "/")
+	restErrorCode(w, http.StatusNotFound, "PGRST205",
+		fmt.Sprintf("Could not find the table 'public.%s' in the schema cache", name), "")
+}
+
+// methodNotAllowed answers 405 with the methods the resource does support in
+// Allow: a PostgREST error under /rest/v1, plain text elsewhere.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allow ...string) {
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	if strings.HasPrefix(r.URL.Path, "/rest/v1/") {
+		restError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
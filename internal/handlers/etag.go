@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// etagBuffer holds back a response body so it can be tagged before it is
+// sent. Headers go straight to the real response.
+type etagBuffer struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *etagBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *etagBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// writeTagged runs write, tags a successful response with an ETag of what
+// it holds, and answers 304 with no body instead when r's If-None-Match
+// already has that tag. Clients polling for changes then only download
+// rows that differ from the last ones they saw.
+//
+// The tag is weak, since the body may go out compressed (see
+// compressWriter) and is the same either way.
+func writeTagged(w http.ResponseWriter, r *http.Request, write func(http.ResponseWriter)) {
+	buf := &etagBuffer{ResponseWriter: w}
+	write(buf)
+	if buf.status == 0 {
+		buf.status = http.StatusOK
+	}
+	if buf.status != http.StatusOK && buf.status != http.StatusPartialContent {
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+		return
+	}
+
+	sum := sha256.New()
+	// Content-Type and Content-Range tell apart the same rows as CSV or
+	// as another page of a longer list.
+	sum.Write([]byte(w.Header().Get("Content-Type") + "\n" + w.Header().Get("Content-Range") + "\n"))
+	sum.Write(buf.body.Bytes())
+	etag := `W/"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	// Have browsers revalidate instead of reusing a stale copy.
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Range")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(buf.status)
+	w.Write(buf.body.Bytes())
+}
+
+// etagMatches reports whether the If-None-Match list matches etag. The
+// comparison is weak, as RFC 9110 asks for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filter is one PostgREST column filter, column=[not.]op.arg, e.g.
+// seq=gt.10, closed_at=not.is.null or sender_name=in.(alice,"bob, jr"), or
+// a logical group of them, [not.]or=(...) or [not.]and=(...).
+type filter struct {
+	column string
+	negate bool
+	op     string
+	arg    string
+	list   []string       // in.(...)
+	re     *regexp.Regexp // like, ilike
+	// logic is "or" or "and" for a group, whose filters are children.
+	logic    string
+	children []filter
+}
+
+// reservedParams are query parameters that are never column filters.
+var reservedParams = map[string]bool{"select": true, "order": true, "limit": true, "offset": true, "apikey": true}
+
+// parseFilters reads every column filter in q. columns lists the columns of
+// the table; skip names parameters the caller handles itself. Anything else
+// is an error, as in PostgREST, so a typo doesn't silently return everything.
+func parseFilters(q url.Values, columns map[string]bool, skip ...string) ([]filter, error) {
+	skipped := make(map[string]bool, len(skip))
+	for _, s := range skip {
+		skipped[s] = true
+	}
+	var filters []filter
+	for column, values := range q {
+		if reservedParams[column] || skipped[column] {
+			continue
+		}
+		if logic, ok := logicParam(column); ok {
+			for _, v := range values {
+				f, err := parseGroup(logic, strings.HasPrefix(column, "not."), v, columns)
+				if err != nil {
+					return nil, err
+				}
+				filters = append(filters, f)
+			}
+			continue
+		}
+		if !columns[column] {
+			return nil, fmt.Errorf("column %q does not exist", column)
+		}
+		for _, v := range values {
+			f, err := parseFilter(column, v)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f)
+		}
+	}
+	return filters, nil
+}
+
+// logicParam reports whether name is or, and, not.or or not.and, and
+// returns which of or and and it is.
+func logicParam(name string) (string, bool) {
+	logic := strings.TrimPrefix(name, "not.")
+	return logic, logic == "or" || logic == "and"
+}
+
+// parseGroup reads the operands of a logical filter, (a.eq.1,b.gt.2), each
+// either column.[not.]op.value or a nested [not.]or(...)/[not.]and(...).
+// Values may be double-quoted to hold commas and parentheses.
+func parseGroup(logic string, negate bool, v string, columns map[string]bool) (filter, error) {
+	f := filter{logic: logic, negate: negate}
+	if !strings.HasPrefix(v, "(") || !strings.HasSuffix(v, ")") {
+		return f, fmt.Errorf("invalid %s filter %q: operands must be in parentheses", logic, v)
+	}
+	operands, err := splitOperands(v[1 : len(v)-1])
+	if err != nil {
+		return f, fmt.Errorf("invalid %s filter %q: %v", logic, v, err)
+	}
+	if len(operands) == 0 {
+		return f, fmt.Errorf("invalid %s filter %q: no operands", logic, v)
+	}
+	for _, operand := range operands {
+		name, rest, _ := strings.Cut(operand, "(")
+		if l, ok := logicParam(name); ok {
+			child, err := parseGroup(l, strings.HasPrefix(name, "not."), "("+rest, columns)
+			if err != nil {
+				return f, err
+			}
+			f.children = append(f.children, child)
+			continue
+		}
+		column, expr, ok := strings.Cut(operand, ".")
+		if !ok {
+			return f, fmt.Errorf("invalid %s filter operand %q", logic, operand)
+		}
+		if !columns[column] {
+			return f, fmt.Errorf("column %q does not exist", column)
+		}
+		child, err := parseFilter(column, unquoteOperand(expr))
+		if err != nil {
+			return f, err
+		}
+		f.children = append(f.children, child)
+	}
+	return f, nil
+}
+
+// splitOperands splits s at the commas outside parentheses and double
+// quotes, keeping the quotes for parseList and unquoteOperand.
+func splitOperands(s string) ([]string, error) {
+	var operands []string
+	depth, quoted, escaped, start := 0, false, false, 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses")
+			}
+		case r == ',' && depth == 0:
+			operands = append(operands, s[start:i])
+			start = i + 1
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses")
+	}
+	if s != "" {
+		operands = append(operands, s[start:])
+	}
+	return operands, nil
+}
+
+// unquoteOperand strips the double quotes around the value of [not.]op."v",
+// with \" and \\ unescaped. in. lists are left for parseList.
+func unquoteOperand(expr string) string {
+	prefix := ""
+	if rest, ok := strings.CutPrefix(expr, "not."); ok {
+		prefix, expr = "not.", rest
+	}
+	op, arg, ok := strings.Cut(expr, ".")
+	if !ok || op == "in" || len(arg) < 2 || arg[0] != '"' || arg[len(arg)-1] != '"' {
+		return prefix + expr
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range arg[1 : len(arg)-1] {
+		if !escaped && r == '\\' {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return prefix + op + "." + b.String()
+}
+
+func parseFilter(column, v string) (filter, error) {
+	f := filter{column: column}
+	if rest, ok := strings.CutPrefix(v, "not."); ok {
+		f.negate, v = true, rest
+	}
+	op, arg, ok := strings.Cut(v, ".")
+	if !ok {
+		return f, fmt.Errorf("invalid %s filter %q", column, v)
+	}
+	f.op, f.arg = op, arg
+	switch op {
+	case "eq", "neq", "gt", "gte", "lt", "lte":
+	case "like", "ilike":
+		f.re = likePattern(arg, op == "ilike")
+	case "in":
+		list, err := parseList(arg)
+		if err != nil {
+			return f, fmt.Errorf("invalid %s filter %q: %v", column, v, err)
+		}
+		f.list = list
+	case "is":
+		switch arg {
+		case "null", "true", "false":
+		default:
+			return f, fmt.Errorf("invalid %s filter %q: is. takes null, true or false", column, v)
+		}
+	default:
+		return f, fmt.Errorf("unsupported %s operator %q", column, op)
+	}
+	return f, nil
+}
+
+// likePattern translates a LIKE pattern, with * accepted for % as PostgREST
+// does, into an anchored regexp.
+func likePattern(pattern string, fold bool) *regexp.Regexp {
+	var b strings.Builder
+	if fold {
+		b.WriteString("(?is)")
+	} else {
+		b.WriteString("(?s)")
+	}
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%', '*':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// parseList reads an in. list: (a,b,"c,d"). Double quotes protect commas
+// and parentheses; \" and \\ escape inside them.
+func parseList(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("list must be in parentheses")
+	}
+	s = s[1 : len(s)-1]
+	var items []string
+	var cur strings.Builder
+	quoted, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			items = append(items, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if s != "" {
+		items = append(items, cur.String())
+	}
+	return items, nil
+}
+
+// plainEq reports whether v is an eq. filter or a bare value, the forms
+// the single-row lookups have always accepted.
+func plainEq(v string) bool {
+	return strings.HasPrefix(v, "eq.") || !strings.Contains(v, ".")
+}
+
+// columnsOf returns the JSON field names of a row type, i.e. its columns.
+func columnsOf(row interface{}) map[string]bool {
+	m := rowValues(row)
+	columns := make(map[string]bool, len(m))
+	for k := range m {
+		columns[k] = true
+	}
+	return columns
+}
+
+func rowValues(row interface{}) map[string]interface{} {
+	data, _ := json.Marshal(row)
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	return m
+}
+
+// applyFilters keeps the rows every filter matches. Rows are compared by
+// their JSON representation, so columns are exactly what clients see. The
+// result is never nil, so it encodes as [].
+func applyFilters[T any](rows []T, filters []filter) []T {
+	if len(filters) == 0 && rows != nil {
+		return rows
+	}
+	kept := []T{}
+	for _, row := range rows {
+		values := rowValues(row)
+		ok := true
+		for _, f := range filters {
+			if !f.holds(values) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
+
+// matches reports whether every filter matches row.
+func matches[T any](row T, filters []filter) bool {
+	return len(applyFilters([]T{row}, filters)) == 1
+}
+
+// holds reports whether f matches a row's values.
+func (f filter) holds(values map[string]interface{}) bool {
+	result, known := f.eval(values)
+	return result && known
+}
+
+// eval is SQL three-valued logic: known is false when the outcome is NULL,
+// which a negation leaves NULL. It decides the groups; a lone column filter
+// is decided by match.
+func (f filter) eval(values map[string]interface{}) (result, known bool) {
+	if f.logic == "" {
+		v := values[f.column]
+		if v == nil && f.op != "is" {
+			return false, false
+		}
+		return f.match(v), true
+	}
+	// or looks for a true operand, and for a false one.
+	decisive := f.logic == "or"
+	known = true
+	for _, c := range f.children {
+		r, k := c.eval(values)
+		if k && r == decisive {
+			return decisive != f.negate, true
+		}
+		known = known && k
+	}
+	return !decisive != f.negate, known
+}
+
+// match follows SQL semantics: comparing with NULL is neither true nor
+// false, so such rows fail the filter whether or not it is negated.
+func (f filter) match(v interface{}) bool {
+	if f.op == "is" {
+		var is bool
+		switch f.arg {
+		case "null":
+			is = v == nil
+		case "true":
+			is = v == true
+		case "false":
+			is = v == false
+		}
+		return is != f.negate
+	}
+	if v == nil {
+		return false
+	}
+
+	var result bool
+	switch f.op {
+	case "like", "ilike":
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		result = f.re.MatchString(s)
+	case "in":
+		for _, item := range f.list {
+			if c, ok := compare(v, item); ok && c == 0 {
+				result = true
+				break
+			}
+		}
+	default:
+		c, ok := compare(v, f.arg)
+		if !ok {
+			return false
+		}
+		switch f.op {
+		case "eq":
+			result = c == 0
+		case "neq":
+			result = c != 0
+		case "gt":
+			result = c > 0
+		case "gte":
+			result = c >= 0
+		case "lt":
+			result = c < 0
+		case "lte":
+			result = c <= 0
+		}
+	}
+	return result != f.negate
+}
+
+// compare orders a column value against a filter argument, interpreting the
+// argument by the column's type. Timestamps compare as instants, so
+// offsets other than Z work. ok is false when the two can't be compared.
+func compare(v interface{}, arg string) (int, bool) {
+	switch v := v.(type) {
+	case float64:
+		n, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return 0, false
+		}
+		return cmp.Compare(v, n), true
+	case bool:
+		b, err := strconv.ParseBool(arg)
+		if err != nil {
+			return 0, false
+		}
+		if v == b {
+			return 0, true
+		} else if b {
+			return -1, true
+		}
+		return 1, true
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			if u, err := time.Parse(time.RFC3339Nano, arg); err == nil {
+				return t.Compare(u), true
+			}
+		}
+		return strings.Compare(v, arg), true
+	}
+	return 0, false
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/auth"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// authError answers in GoTrue's error format, which supabase-js turns into
+// an AuthApiError with code and message.
+func authError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": status, "error_code": code, "msg": msg})
+}
+
+// authEnabled reports whether /auth/v1 can issue tokens: it needs the user
+// store and a JWT secret to sign with.
+func (h *Handler) authEnabled() bool {
+	return h.Users != nil && h.Auth != nil && h.Auth.Secret != nil
+}
+
+// authRoute guards an /auth/v1 route; it is 404 while auth is disabled.
+func (h *Handler) authRoute(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.authEnabled() {
+			authError(w, http.StatusNotFound, "not_found", "Auth is not enabled on this server")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// gotrueUser is user as GoTrue returns it.
+func gotrueUser(user auth.User) map[string]interface{} {
+	metadata := user.UserMetadata
+	if metadata == nil {
+		metadata = json.RawMessage("{}")
+	}
+	identities := []interface{}{}
+	for _, id := range user.Identities {
+		identities = append(identities, map[string]interface{}{
+			"identity_id":   user.ID + ":" + id.Provider,
+			"id":            id.ProviderID,
+			"user_id":       user.ID,
+			"provider":      id.Provider,
+			"email":         id.Email,
+			"identity_data": metadata,
+			"created_at":    id.CreatedAt,
+			"updated_at":    id.UpdatedAt,
+		})
+	}
+	return map[string]interface{}{
+		"id":              user.ID,
+		"aud":             "authenticated",
+		"role":            "authenticated",
+		"email":           user.Email,
+		"phone":           "",
+		"app_metadata":    user.AppMetadata(),
+		"user_metadata":   metadata,
+		"identities":      identities,
+		"created_at":      user.CreatedAt,
+		"updated_at":      user.UpdatedAt,
+		"last_sign_in_at": user.LastSignInAt,
+		"is_anonymous":    user.IsAnonymous,
+	}
+}
+
+// accessTTL is the lifetime of issued access tokens.
+func (h *Handler) accessTTL() time.Duration {
+	if h.AccessTTL <= 0 {
+		return auth.DefaultAccessTTL
+	}
+	return h.AccessTTL
+}
+
+// writeAuthSession answers with a new access token for user in auth session
+// sessionID, next to its refresh token, as GoTrue's sign-in and refresh do.
+func (h *Handler) writeAuthSession(w http.ResponseWriter, user auth.User, sessionID, refresh string) {
+	ttl := h.accessTTL()
+	token, expires, err := h.Auth.AccessToken(user, sessionID, ttl, time.Now())
+	if err != nil {
+		authError(w, http.StatusInternalServerError, "unexpected_failure", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  token,
+		"token_type":    "bearer",
+		"expires_in":    int(ttl.Seconds()),
+		"expires_at":    expires.Unix(),
+		"refresh_token": refresh,
+		"user":          gotrueUser(user),
+	})
+}
+
+// handleSignup serves POST /auth/v1/signup. Only anonymous sign-in, which
+// supabase-js signInAnonymously() sends here without email or password, is
+// supported; the body's data becomes the user_metadata.
+func (h *Handler) handleSignup(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email    string          `json:"email"`
+		Phone    string          `json:"phone"`
+		Password string          `json:"password"`
+		Data     json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		authError(w, http.StatusBadRequest, "bad_json", "Could not parse request body as JSON: "+err.Error())
+		return
+	}
+	if body.Email != "" || body.Phone != "" || body.Password != "" {
+		authError(w, http.StatusUnprocessableEntity, "signup_disabled", "Only anonymous sign-ins are enabled")
+		return
+	}
+	if len(body.Data) > 0 && body.Data[0] != '{' {
+		authError(w, http.StatusBadRequest, "validation_failed", "data must be an object")
+		return
+	}
+	if string(body.Data) == "{}" {
+		body.Data = nil
+	}
+	if !h.limiters.signupsPerIP.allow(w, r, h.Limits.SignupsPerIP, h.clientIP(r).String(), 1) {
+		return
+	}
+
+	user, sessionID, refresh, err := h.Users.SignInAnonymously(body.Data, time.Now())
+	if err != nil {
+		authError(w, http.StatusInternalServerError, "unexpected_failure", err.Error())
+		return
+	}
+	h.writeAuthSession(w, user, sessionID, refresh)
+}
+
+// handleToken serves POST /auth/v1/token?grant_type=refresh_token with
+// {"refresh_token": "..."}. The refresh token is rotated: the response
+// carries its successor and it can't be used again.
+func (h *Handler) handleToken(w http.ResponseWriter, r *http.Request) {
+	if grant := r.URL.Query().Get("grant_type"); grant != "refresh_token" {
+		authError(w, http.StatusBadRequest, "validation_failed", "unsupported_grant_type: "+grant)
+		return
+	}
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		authError(w, http.StatusBadRequest, "bad_json", "Could not parse request body as JSON: "+err.Error())
+		return
+	}
+	if body.RefreshToken == "" {
+		authError(w, http.StatusBadRequest, "validation_failed", "refresh_token is required")
+		return
+	}
+	if !h.allowAttempt(w, r) {
+		return
+	}
+
+	user, sessionID, refresh, err := h.Users.Refresh(body.RefreshToken, time.Now())
+	switch {
+	case errors.Is(err, auth.ErrTokenNotFound):
+		h.failedAttempt(w, r, "refresh_token", "")
+		authError(w, http.StatusBadRequest, "refresh_token_not_found", err.Error())
+		return
+	case errors.Is(err, auth.ErrTokenUsed):
+		authError(w, http.StatusBadRequest, "refresh_token_already_used", err.Error())
+		return
+	case err != nil:
+		authError(w, http.StatusInternalServerError, "unexpected_failure", err.Error())
+		return
+	}
+	h.writeAuthSession(w, user, sessionID, refresh)
+}
+
+// bearerClaims verifies the access token of an /auth/v1 request, or answers
+// as GoTrue does and returns nil.
+func (h *Handler) bearerClaims(w http.ResponseWriter, r *http.Request) *auth.Claims {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		authError(w, http.StatusUnauthorized, "no_authorization", "This endpoint requires a Bearer token")
+		return nil
+	}
+	claims, err := h.Auth.Verify(token)
+	if err != nil {
+		authError(w, http.StatusForbidden, "bad_jwt", err.Error())
+		return nil
+	}
+	return claims
+}
+
+// handleAuthUser serves GET /auth/v1/user, the user of the access token.
+func (h *Handler) handleAuthUser(w http.ResponseWriter, r *http.Request) {
+	claims := h.bearerClaims(w, r)
+	if claims == nil {
+		return
+	}
+	user, ok := h.Users.User(claims.Subject)
+	if !ok {
+		authError(w, http.StatusNotFound, "user_not_found", "User from sub claim in JWT does not exist")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(gotrueUser(user))
+}
+
+// handleLogout serves POST /auth/v1/logout[?scope=global|local|others],
+// revoking the refresh tokens of the access token's auth session, or of
+// all the user's sessions for scope=global (the default, as in
+// supabase-js). Access tokens already issued stay valid until they expire.
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	claims := h.bearerClaims(w, r)
+	if claims == nil {
+		return
+	}
+	scope := r.URL.Query().Get("scope")
+	switch scope {
+	case "":
+		scope = "global"
+	case "global", "local", "others":
+	default:
+		authError(w, http.StatusBadRequest, "validation_failed", "scope must be global, local or others")
+		return
+	}
+	if err := h.Users.Logout(claims.Subject, claims.SessionID, scope, time.Now()); err != nil {
+		authError(w, http.StatusInternalServerError, "unexpected_failure", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,31 +1,159 @@
 package handlers
 
 import (
+	"bytes"
+	"chat-quick-chat-server/internal/auth"
+	"chat-quick-chat-server/internal/blocklist"
+	"chat-quick-chat-server/internal/calendar"
 	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/encryption"
+	"chat-quick-chat-server/internal/geoip"
+	"chat-quick-chat-server/internal/identity"
+	"chat-quick-chat-server/internal/mail"
+	"chat-quick-chat-server/internal/payment"
+	"chat-quick-chat-server/internal/policy"
 	"chat-quick-chat-server/internal/realtime"
+	"chat-quick-chat-server/internal/scheduler"
+	"chat-quick-chat-server/internal/signing"
+	"chat-quick-chat-server/internal/ticket"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Handler struct {
 	DB         *db.Database
 	StorageDir string
 	Hub        *realtime.Hub
+	// AdminToken guards /admin/v1. Empty disables the admin API unless
+	// Signing is set, or Auth, whose access tokens with the admin role are
+	// let in.
+	AdminToken string
+	// Signing accepts HMAC-signed requests on /admin/v1 in place of the
+	// bearer token. Nil accepts only the token.
+	Signing *signing.Verifier
+	// Automations posts greeting messages into new sessions when configured.
+	Automations *scheduler.Automations
+	// SLA adds SLA stats to /admin/v1/stats when targets are set.
+	SLA *scheduler.SLA
+	// MediaMaxAge is the shared-cache lifetime of media whose URL isn't
+	// content-addressed. Zero means one hour.
+	MediaMaxAge time.Duration
+	// CDNPurgeURL receives a POST whenever stored media changes.
+	CDNPurgeURL string
+	// Cipher encrypts uploaded media at rest. Nil stores files as sent.
+	Cipher *encryption.Cipher
+	// JoinURLTemplate is the widget URL a QR code points to, with
+	// {session_id} and {session_token} as placeholders. Empty disables /qr.
+	JoinURLTemplate string
+	// GeoIP enriches new sessions with a coarse location. Nil disables it.
+	GeoIP *geoip.Resolver
+	// TrustProxy makes clientIP honour X-Forwarded-For / X-Real-IP. Only
+	// enable it behind a reverse proxy that sets those headers.
+	TrustProxy bool
+	// Blocklist rejects sessions and messages from blocked IPs and messages
+	// containing blocked phrases or email domains. Nil disables it.
+	Blocklist *blocklist.Blocklist
+	// FlagFiltered stores messages that match the blocklist and queues them
+	// for review instead of rejecting them.
+	FlagFiltered bool
+	// Identity verifies the identity tokens widgets send in X-Identity-Token
+	// and marks those messages as from a verified sender. Nil ignores them.
+	Identity *identity.Verifier
+	// Tickets files escalated conversations in an issue tracker. Nil
+	// disables /admin/v1/sessions/{id}/escalate.
+	Tickets ticket.Provider
+	// Calendar supplies the slots of scheduling messages sent without any
+	// and is told which one a visitor booked. Nil requires explicit slots.
+	Calendar *calendar.Calendar
+	// Payments creates the checkouts of payment requests and reads the
+	// provider's webhooks. Nil rejects payment_request messages.
+	Payments payment.Provider
+	// MaxRows caps limit= and Range on REST lists. Zero means 1000.
+	MaxRows int
+	// MaxBodyBytes caps request bodies under /rest/v1 and the payment
+	// webhook, and MaxUploadBytes media uploads. Zero means
+	// DefaultMaxBodyBytes and DefaultMaxUploadBytes; negative means no
+	// limit.
+	MaxBodyBytes   int64
+	MaxUploadBytes int64
+	// Compress gzips or deflates JSON and text responses for clients that
+	// accept it.
+	Compress bool
+	// AnonKey and ServiceRoleKey, when either is set, are the apikey values
+	// the REST API, uploads and /search accept. Only the service_role key
+	// may read across sessions.
+	AnonKey        string
+	ServiceRoleKey string
+	// Auth, when set, verifies the Authorization: Bearer access tokens of
+	// client API requests; their claims are then on the request context.
+	Auth *auth.Verifier
+	// Users, with Auth's secret to sign with, enables anonymous sign-in
+	// under /auth/v1. AccessTTL is the lifetime of the access tokens it
+	// issues; zero means auth.DefaultAccessTTL.
+	Users     *auth.Users
+	AccessTTL time.Duration
+	// OAuth are the providers /auth/v1/authorize signs users in with, by
+	// name. Sign-ins return to SiteURL, or to a redirect_to under it or
+	// listed in RedirectURLs. OAuthCallbackURL is the redirect URI
+	// registered with the providers; empty derives it from the request.
+	OAuth            map[string]*auth.OAuthProvider
+	SiteURL          string
+	RedirectURLs     []string
+	OAuthCallbackURL string
+	// Mailer, when set with ExternalURL, emails sign-in links from
+	// /auth/v1/otp. They point at ExternalURL, the server's public URL,
+	// which is never taken from the request for them. OAuthCallbackURL
+	// defaults to a path under it, or under the request's host without it.
+	Mailer      *mail.SMTP
+	ExternalURL string
+	// SessionTokens, when set, issues a token with each new session and
+	// requires it on that session's messages, in X-Session-Token; the
+	// service_role key or token passes without one.
+	SessionTokens *auth.SessionTokens
+	// Policy, like row-level security, limits the chat_sessions and
+	// messages rows a requester may read and write. Nil allows everything.
+	Policy *policy.Engine
+	// AccessLog receives one record per request. Nil logs nothing.
+	AccessLog *slog.Logger
+	// AuditLog receives security events: failed attempts at credentials
+	// and the lockouts they start. Nil logs nothing.
+	AuditLog *slog.Logger
+	// Limits rate-limits new sessions, messages and uploads, and Lockout
+	// repeated wrong guesses.
+	Limits  RateLimits
+	Lockout Lockout
+
+	usage    storageUsage
+	limiters rateLimiters
+	lockouts lockouts
+	clock    clock
+	rpcs     map[string]RPC
+	mux      *http.ServeMux
 }
 
 func New(database *db.Database, storageDir string, hub *realtime.Hub) *Handler {
-	return &Handler{
+	h := &Handler{
 		DB:         database,
 		StorageDir: storageDir,
 		Hub:        hub,
+		clock:      newClock(),
 	}
+	h.registerBuiltinRPCs()
+	h.mux = h.routes()
+	return h
 }
 
 func extractEqValue(s string) string {
@@ -35,6 +163,49 @@ func extractEqValue(s string) string {
 	return s
 }
 
+// extractFtsQuery recognises PostgREST full-text operators (fts, plfts, phfts,
+// wfts), optionally with a language config such as fts(english).query.
+// All of them are treated as "every term must appear".
+func extractFtsQuery(s string) (string, bool) {
+	for _, op := range []string{"fts", "plfts", "phfts", "wfts"} {
+		if !strings.HasPrefix(s, op) {
+			continue
+		}
+		rest := s[len(op):]
+		if strings.HasPrefix(rest, "(") {
+			end := strings.Index(rest, ")")
+			if end < 0 {
+				continue
+			}
+			rest = rest[end+1:]
+		}
+		if strings.HasPrefix(rest, ".") {
+			return rest[1:], true
+		}
+	}
+	return "", false
+}
+
+// clientIP returns the address of the requesting client.
+func (h *Handler) clientIP(r *http.Request) net.IP {
+	if h.TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip
+			}
+		}
+		if ip := net.ParseIP(r.Header.Get("X-Real-IP")); ip != nil {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
 // firstFileFromMultipart returns the first file part regardless of field name (even name="").
 func firstFileFromMultipart(r *http.Request) (io.ReadCloser, string, error) {
 	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
@@ -63,10 +234,19 @@ func firstFileFromMultipart(r *http.Request) (io.ReadCloser, string, error) {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.AccessLog != nil {
+		h.logRequest(w, r, h.serve)
+		return
+	}
+	h.serve(w, r)
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) {
 	// CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Expose-Headers", lastEventIDHeader+", Content-Range, Preference-Applied, ETag, "+requestIDHeader)
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -74,147 +254,767 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	path := r.URL.Path
-	if strings.HasPrefix(path, "/rest/v1/chat_sessions") {
-		h.handleChatSessions(w, r)
-	} else if strings.HasPrefix(path, "/rest/v1/messages") {
-		h.handleMessages(w, r)
-	} else if strings.HasPrefix(path, "/storage/v1/object/chat-media/") {
-		h.handleStorageUpload(w, r)
-	} else if strings.HasPrefix(path, "/storage/v1/object/public/chat-media/") {
-		h.handleStorageServe(w, r)
-	} else if strings.HasPrefix(path, "/realtime/v1/websocket") {
-		realtime.ServeWs(h.Hub, w, r)
-	} else {
-		http.NotFound(w, r)
+	if h.Compress && r.Header.Get("Upgrade") == "" {
+		cw := &compressWriter{ResponseWriter: w, encoding: acceptedEncoding(r)}
+		defer cw.close()
+		w = cw
 	}
+
+	r, ok := h.checkAPIKey(w, r)
+	if !ok {
+		return
+	}
+	if r, ok = h.checkJWT(w, r); !ok {
+		return
+	}
+
+	// HEAD on a table (supabase-js { count: 'exact', head: true }) is a GET
+	// whose headers, Content-Range included, are all that is sent.
+	if r.Method == "HEAD" && strings.HasPrefix(path, "/rest/v1/") {
+		get := r.Clone(r.Context())
+		get.Method = "GET"
+		r, w = get, headWriter{w}
+	}
+
+	// While the disk is full and the in-memory backlog is used up, client
+	// writes are turned away up front. The read-only RPCs, media deletes and
+	// the admin API stay open so space can be freed.
+	if r.Method != "GET" && h.DB.ReadOnly() &&
+		(strings.HasPrefix(path, "/rest/v1/") && !h.readOnlyRPC(path) ||
+			strings.HasPrefix(path, "/storage/v1/object/chat-media/") && r.Method != "DELETE") {
+		w.Header().Set("Retry-After", "60")
+		restError(w, "Service temporarily read-only", http.StatusServiceUnavailable)
+		return
+	}
+
+	if limit := h.bodyLimit(path); limit > 0 {
+		if r.ContentLength > limit {
+			writeTooLarge(w, r, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	// ServeMux has no route for the path, or none for the method.
+	if _, pattern := h.mux.Handler(r); pattern == "" && strings.HasPrefix(path, "/rest/v1/") {
+		w = &restFallback{ResponseWriter: w, r: r}
+	}
+	h.mux.ServeHTTP(w, r)
 }
 
-func (h *Handler) handleChatSessions(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-		// Create session
-		session, err := h.DB.CreateSession()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+// handleCreateSession serves POST /rest/v1/chat_sessions. The body is
+// optional; only the application-owned fields are taken from it.
+func (h *Handler) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	var body db.ChatSession
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		invalidBody(w, err)
+		return
+	}
+	if h.Blocklist.BlocksIP(h.clientIP(r)) {
+		restError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !h.limiters.sessionsPerIP.allow(w, r, h.Limits.SessionsPerIP, h.clientIP(r).String(), 1) {
+		return
+	}
+	row := db.ChatSession{
+		Geo:       h.GeoIP.Lookup(h.clientIP(r)),
+		Title:     body.Title,
+		CreatedBy: body.CreatedBy,
+		Metadata:  body.Metadata,
+		Tenant:    body.Tenant,
+	}
+	if !h.writable(r, "chat_sessions", policy.Insert, row) {
+		policyViolation(w, "chat_sessions")
+		return
+	}
+	session, err := h.DB.CreateSession(row)
+	if err != nil {
+		restError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if h.Automations != nil {
+		h.Automations.SessionCreated(session)
+	}
+	if h.SessionTokens != nil {
+		writeResult(w, r, http.StatusCreated, h.withSessionTokens([]*db.ChatSession{session}))
+		return
+	}
+	writeResult(w, r, http.StatusCreated, []*db.ChatSession{session})
+}
+
+// handleGetSessions serves GET /rest/v1/chat_sessions.
+func (h *Handler) handleGetSessions(w http.ResponseWriter, r *http.Request) {
+	// Query: id=eq.{sessionId}
+	idParam := r.URL.Query().Get("id")
+	if codeParam := r.URL.Query().Get("code"); codeParam != "" && idParam == "" && plainEq(codeParam) {
+		// code=eq.{code} resolves a short code read out by a visitor. The
+		// code is shared like the session token, so it comes with it, and
+		// clients that keep guessing codes are locked out.
+		if !h.allowAttempt(w, r) {
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(session)
+		sessions := []*db.ChatSession{}
+		if session, err := h.DB.SessionByCode(extractEqValue(codeParam)); err == nil {
+			sessions = append(sessions, session)
+		} else {
+			h.failedAttempt(w, r, "session_code", "")
+		}
+		sessions = visibleRows(h, r, "chat_sessions", sessions)
+		if sessions, status, ok := paginate(w, r, sessions, 0, -1); ok {
+			if h.SessionTokens != nil {
+				writeRows(w, r, status, h.withSessionTokens(sessions))
+				return
+			}
+			writeRows(w, r, status, sessions)
+		}
+		return
+	}
+	// Anything but a plain id=eq. lookup is a filtered listing.
+	if idParam == "" || !plainEq(idParam) {
+		// With policies on the table they decide what the listing shows.
+		if h.Policy.Protects("chat_sessions") || requireServiceRole(w, r, "reading across sessions") {
+			h.handleListSessions(w, r)
+		}
 		return
 	}
+	sessions := []*db.ChatSession{}
+	if session, err := h.DB.GetSession(extractEqValue(idParam)); err == nil {
+		sessions = append(sessions, session)
+	}
+	sessions = visibleRows(h, r, "chat_sessions", sessions)
+	if sessions, status, ok := paginate(w, r, sessions, 0, -1); ok {
+		writeRows(w, r, status, sessions)
+	}
+}
 
-	if r.Method == "GET" {
-		// Check session exists
-		// Query: id=eq.{sessionId}
-		idParam := r.URL.Query().Get("id")
-		if idParam == "" {
-			http.Error(w, "Missing id parameter", http.StatusBadRequest)
+// patchableSessionFields are the chat_sessions columns PATCH may change.
+var patchableSessionFields = map[string]bool{"title": true, "created_by": true, "metadata": true, "assigned_to": true}
+
+// handlePatchSession updates the application-owned fields of the session
+// selected by id=eq.{sessionId}. Fields missing from the body are left alone;
+// null clears them.
+func (h *Handler) handlePatchSession(w http.ResponseWriter, r *http.Request) {
+	id := extractEqValue(r.URL.Query().Get("id"))
+	if id == "" {
+		restError(w, "Missing id parameter", http.StatusBadRequest)
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		invalidBody(w, err)
+		return
+	}
+	for name := range fields {
+		if !patchableSessionFields[name] {
+			unknownColumn(w, "chat_sessions", name)
 			return
 		}
-		id := extractEqValue(idParam)
+	}
 
-		session, err := h.DB.GetSession(id)
-		if err != nil {
-			// Supabase returns null or empty list depending on query, but .single() expects one.
-			// If not found, .single() in client throws error.
-			// We can return 404 or empty body.
-			// Supabase REST: [] if not found (without single), or 406 Not Acceptable with single?
-			// Let's return 404 or null.
-			// The client code: .eq('id', sessionId).single()
-			// If we return [], .single() fails.
-			// If we return null, .single() might fail.
-			// Let's return 404 for simplicity, or empty array if they didn't ask for single representation?
-			// Actually, Supabase REST returns [] by default.
-			// But if the client uses .single(), the client library handles the [] -> error conversion if empty.
-			// So we should return [].
-			// BUT, if the client sends `Accept: application/vnd.pgrst.object+json`, we should return object.
-			// Let's just return the object if found, or 404 if not found, which is standard REST.
-			// Wait, Supabase returns 200 OK with [] if not found usually.
-			// Let's try returning the array with one element if found.
-			w.Header().Set("Content-Type", "application/json")
-			// If we want to support .single() properly, we should check headers.
-			// But for this mock, let's return the object directly if found?
-			// No, standard PostgREST returns array unless header is set.
-			// However, the prompt says "compatible with supabase url".
-			// Let's return an array containing the session.
-			json.NewEncoder(w).Encode([]*db.ChatSession{session})
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		// Return array
-		json.NewEncoder(w).Encode(session)
+	session, err := h.DB.UpdateSession(id, func(s *db.ChatSession) error {
+		if !h.visible(r, "chat_sessions", policy.Update, *s) {
+			return errors.New("session not found")
+		}
+		if raw, ok := fields["title"]; ok {
+			s.Title = nil
+			if err := json.Unmarshal(raw, &s.Title); err != nil {
+				return fmt.Errorf("title: %w", err)
+			}
+		}
+		if raw, ok := fields["created_by"]; ok {
+			s.CreatedBy = nil
+			if err := json.Unmarshal(raw, &s.CreatedBy); err != nil {
+				return fmt.Errorf("created_by: %w", err)
+			}
+		}
+		if raw, ok := fields["metadata"]; ok {
+			s.Metadata = nil
+			if err := json.Unmarshal(raw, &s.Metadata); err != nil {
+				return fmt.Errorf("metadata must be an object: %w", err)
+			}
+		}
+		if raw, ok := fields["assigned_to"]; ok {
+			s.AssignedTo = nil
+			if err := json.Unmarshal(raw, &s.AssignedTo); err != nil {
+				return fmt.Errorf("assigned_to: %w", err)
+			}
+		}
+		if !h.writable(r, "chat_sessions", policy.Update, *s) {
+			return errPolicyCheck
+		}
+		return nil
+	})
+	// As in PostgREST, a filter that matches nothing updates no rows.
+	if err != nil && err.Error() == "session not found" {
+		writeResult(w, r, http.StatusOK, []*db.ChatSession{})
+		return
+	}
+	if err == errPolicyCheck {
+		policyViolation(w, "chat_sessions")
+		return
+	}
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+
+	writeResult(w, r, http.StatusOK, []*db.ChatSession{session})
 }
 
-func (h *Handler) handleMessages(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-		var msg db.Message
-		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+// handleGetMessages serves GET /rest/v1/messages. The response carries an
+// ETag, so a client polling history can send If-None-Match and get 304
+// while nothing has changed.
+func (h *Handler) handleGetMessages(w http.ResponseWriter, r *http.Request) {
+	// session_id=eq.{sessionId}, or in.(...) for several sessions at once.
+	q := r.URL.Query()
+	sessionIDParam := q.Get("session_id")
+	if sessionIDParam == "" {
+		restError(w, "Missing session_id parameter", http.StatusBadRequest)
+		return
+	}
+	sessionIDs := []string{extractEqValue(sessionIDParam)}
+	if list, ok := strings.CutPrefix(sessionIDParam, "in."); ok {
+		var err error
+		if sessionIDs, err = parseList(list); err != nil {
+			restError(w, "Invalid session_id filter: "+err.Error(), http.StatusBadRequest)
 			return
 		}
+	} else if !plainEq(sessionIDParam) {
+		restError(w, "session_id must be filtered with eq. or in.", http.StatusBadRequest)
+		return
+	}
+	if len(sessionIDs) > 1 && !h.Policy.Protects("messages") && !requireServiceRole(w, r, "reading across sessions") {
+		return
+	}
+	for _, id := range sessionIDs {
+		if !h.requireSessionToken(w, r, id) {
+			return
+		}
+	}
+	skip := []string{"session_id", "scope"}
+	query, fts := extractFtsQuery(q.Get("content"))
+	if fts {
+		skip = append(skip, "content")
+	}
+	// Any other column can be filtered PostgREST-style, e.g.
+	// seq=gt.{n} to fetch what came after a known position or
+	// parent_message_id=is.null for the top-level messages.
+	filters, err := parseFilters(q, messageColumns, skip...)
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	terms, err := parseOrder(q.Get("order"), messageColumns)
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	embed, err := parseSessionEmbed(q.Get("select"))
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, limit, err := h.pageBounds(r)
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.setLastEventID(w)
 
-		createdMsg, err := h.DB.CreateMessage(msg)
+	var messages []db.Message
+	for _, sessionID := range sessionIDs {
+		var found []db.Message
+		if fts {
+			// content=fts. searches the content column unless scope= widens it.
+			scope := db.ScopeContent
+			if s := q.Get("scope"); s != "" {
+				if scope, err = db.ParseSearchScope(s); err != nil {
+					restError(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			found, err = h.DB.SearchMessages(sessionID, query, scope)
+		} else {
+			found, err = h.DB.GetMessages(sessionID)
+		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			restError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		messages = append(messages, found...)
+	}
+	if len(sessionIDs) > 1 {
+		sort.SliceStable(messages, func(i, j int) bool { return messages[i].CreatedAt.Before(messages[j].CreatedAt) })
+	}
+	messages = visibleRows(h, r, "messages", messages)
+	messages = applyFilters(messages, filters)
+	// Results are in seq order unless order= asks otherwise.
+	sortRows(messages, terms)
+	messages, status, ok := paginate(w, r, messages, offset, limit)
+	if !ok {
+		return
+	}
+	writeTagged(w, r, func(w http.ResponseWriter) {
+		if embed != nil {
+			writeRows(w, r, status, h.embedSessions(messages, embed))
+			return
+		}
+		writeRows(w, r, status, messages)
+	})
+}
+
+// handleCreateMessages serves POST /rest/v1/messages with one message or,
+// as supabase-js .insert([...]) sends, an array of them. The rows are saved
+// together or not at all and returned in the order sent; each is published
+// as its own INSERT.
+func (h *Handler) handleCreateMessages(w http.ResponseWriter, r *http.Request) {
+	resolution := preference(r, "resolution")
+	if resolution != "" && resolution != resolutionMerge && resolution != resolutionIgnore {
+		restError(w, "resolution must be merge-duplicates or ignore-duplicates", http.StatusBadRequest)
+		return
+	}
+	if c := r.URL.Query().Get("on_conflict"); c != "" && c != "id" {
+		restError(w, "on_conflict only supports id", http.StatusBadRequest)
+		return
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		invalidBody(w, err)
+		return
+	}
+	items := []json.RawMessage{raw}
+	if trimmed := bytes.TrimLeft(raw, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			invalidBody(w, err)
+			return
+		}
+		if len(items) == 0 {
+			writeResult(w, r, http.StatusCreated, []*db.Message{})
+			return
+		}
+	}
+	msgs := make([]db.Message, len(items))
+	// sent records which columns each row had, for merging.
+	sent := make([]map[string]json.RawMessage, len(items))
+	for i, item := range items {
+		if err := json.Unmarshal(item, &msgs[i]); err != nil {
+			invalidBody(w, err)
+			return
+		}
+		if err := json.Unmarshal(item, &sent[i]); err != nil {
+			invalidBody(w, err)
+			return
+		}
+	}
+
+	if h.Blocklist.BlocksIP(h.clientIP(r)) {
+		restError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	// A batch counts as one message per row, against the sender's IP and
+	// each session it writes to.
+	if !h.limiters.messagesPerIP.allow(w, r, h.Limits.MessagesPerIP, h.clientIP(r).String(), len(msgs)) {
+		return
+	}
+	perSession := make(map[string]int)
+	for _, msg := range msgs {
+		perSession[msg.SessionID]++
+	}
+	for sessionID := range perSession {
+		if !h.requireSessionToken(w, r, sessionID) {
+			return
+		}
+	}
+	for sessionID, n := range perSession {
+		if !h.limiters.messagesPerSession.allow(w, r, h.Limits.MessagesPerSession, sessionID, n) {
+			return
+		}
+	}
+	// Only the server can vouch for a sender: the integrator with an
+	// identity token, or the account the user signed in with.
+	var who *db.SenderIdentity
+	account := h.accountIdentity(r)
+	if token := r.Header.Get(identityTokenHeader); token != "" && h.Identity != nil {
+		var err error
+		if who, err = h.Identity.Verify(token); err != nil {
+			restError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	matched := make([]string, len(msgs))
+	for i := range msgs {
+		msg := &msgs[i]
+		if msg.Event != nil {
+			if msg.Event.Kind == "" {
+				restError(w, "event.kind is required", http.StatusBadRequest)
+				return
+			}
+			msg.MessageType = db.MessageTypeSystem
+		}
+		if status, err := h.prepareSchedule(msg); err != nil {
+			restError(w, err.Error(), status)
+			return
+		}
+
+		msg.SenderVerified, msg.SenderIdentity = false, nil
+		if who == nil && account != nil {
+			msg.SenderVerified, msg.SenderIdentity = true, account
+			msg.SenderName = &account.Name
+		}
+		if who != nil {
+			msg.SenderVerified, msg.SenderIdentity = true, who
+			if (msg.SenderName == nil || *msg.SenderName == "") && who.Name != "" {
+				msg.SenderName = &who.Name
+			}
+		}
+
+		if msg.Content != nil {
+			matched[i] = h.Blocklist.MatchText(*msg.Content)
+		}
+		if matched[i] != "" && !h.FlagFiltered {
+			restError(w, "Message blocked", http.StatusForbidden)
+			return
+		}
+		if session, err := h.DB.GetSession(msg.SessionID); err == nil && session.CloseReason != nil && *session.CloseReason == db.CloseReasonBanned {
+			restError(w, "Forbidden", http.StatusForbidden)
 			return
 		}
+		if !h.writable(r, "messages", policy.Insert, msg) {
+			policyViolation(w, "messages")
+			return
+		}
+	}
 
-		type columnInfo struct {
-			Name string `json:"name"`
-			Type string `json:"type"`
+	// Checkouts are only created for requests that will be stored, and not
+	// again for a payment request that is being re-sent.
+	for i := range msgs {
+		if resolution != "" && msgs[i].ID != "" {
+			if _, err := h.DB.GetMessage(msgs[i].ID); err == nil {
+				continue
+			}
 		}
-		// Broadcast
-		payload := map[string]interface{}{
-			"schema":           "public",
-			"table":            "messages",
-			"commit_timestamp": createdMsg.CreatedAt,
-			"type":             "INSERT",
-			"record":           createdMsg,
-			"old":              map[string]interface{}{},
-			"errors":           nil,
-			"columns": []columnInfo{
-				{Name: "session_id", Type: "uuid"},
-				{Name: "content", Type: "text"},
-				{Name: "message_type", Type: "text"},
-				{Name: "file_url", Type: "text"},
-				{Name: "sender_name", Type: "text"},
-				{Name: "created_at", Type: "timestamptz"},
-			},
+		if status, err := h.preparePayment(&msgs[i]); err != nil {
+			restError(w, err.Error(), status)
+			return
 		}
+	}
 
-		data := map[string]interface{}{
-			"data": payload,
-			"ids":  []interface{}{},
+	// The messages, their review flags and the senders' participant rows
+	// are saved together.
+	created := make([]*db.Message, 0, len(msgs))
+	err := h.DB.Tx(func(tx *db.Tx) error {
+		for i, msg := range msgs {
+			var createdMsg *db.Message
+			existing, err := tx.GetMessage(msg.ID)
+			switch {
+			case msg.ID == "" || resolution == "" || err != nil:
+				if createdMsg, err = tx.CreateMessage(msg); err != nil {
+					return err
+				}
+			case existing.SessionID != msg.SessionID:
+				return db.ErrDuplicateMessage
+			case resolution == resolutionIgnore:
+				continue
+			default:
+				if createdMsg, err = h.mergeMessage(r, tx, existing, msg, sent[i]); err != nil {
+					return err
+				}
+			}
+			// A re-send that merged nothing was already flagged, if at all.
+			if matched[i] != "" && createdMsg != existing {
+				reason := "matched blocklist entry " + matched[i]
+				if _, err := tx.FlagMessage(createdMsg.ID, db.FlagSourceFilter, &reason, nil); err != nil {
+					return err
+				}
+			}
+			// Senders who never opened the websocket still count as
+			// participants; a sender can't be recorded without a session.
+			if createdMsg.SenderName != nil && *createdMsg.SenderName != "" {
+				tx.JoinParticipant(createdMsg.SessionID, *createdMsg.SenderName)
+			}
+			created = append(created, createdMsg)
 		}
-		h.Hub.Broadcast("realtime:messages:"+createdMsg.SessionID, "postgres_changes", data)
+		return nil
+	})
+	if err != nil && err.Error() == "parent message not found" {
+		restErrorCode(w, http.StatusConflict, "23503", err.Error(), "")
+		return
+	}
+	if err == errPolicyCheck {
+		policyViolation(w, "messages")
+		return
+	}
+	if err == errSystemMessage {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == db.ErrDuplicateMessage {
+		restError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		restError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if resolution != "" {
+		w.Header().Add("Preference-Applied", "resolution="+resolution)
+	}
+	writeResult(w, r, http.StatusCreated, created)
+}
+
+// Values of the resolution= preference, for upserts.
+const (
+	resolutionMerge  = "merge-duplicates"
+	resolutionIgnore = "ignore-duplicates"
+)
+
+// errSystemMessage rejects edits of system messages.
+var errSystemMessage = errors.New("system messages cannot be edited")
+
+// mergeMessage applies a re-sent message to the stored one. Only the
+// columns PATCH may change are taken from it, and only if sent; a re-send
+// that changes nothing leaves the row, and its edited_at, alone. A change
+// is an edit, so it must pass the same checks as PATCH.
+func (h *Handler) mergeMessage(r *http.Request, tx *db.Tx, existing *db.Message, msg db.Message, sent map[string]json.RawMessage) (*db.Message, error) {
+	_, hasContent := sent["content"]
+	_, hasMetadata := sent["metadata"]
+	sameContent := !hasContent || equalStrings(existing.Content, msg.Content)
+	sameMetadata := !hasMetadata || bytes.Equal(existing.Metadata, msg.Metadata)
+	if sameContent && sameMetadata {
+		return existing, nil
+	}
+	if existing.MessageType == db.MessageTypeSystem {
+		return nil, errSystemMessage
+	}
+	if !h.visible(r, "messages", policy.Update, *existing) {
+		return nil, errPolicyCheck
+	}
+	return tx.UpdateMessage(existing.ID, func(m *db.Message) error {
+		if hasContent {
+			m.Content = msg.Content
+		}
+		if hasMetadata {
+			m.Metadata = msg.Metadata
+		}
+		if !h.writable(r, "messages", policy.Update, *m) {
+			return errPolicyCheck
+		}
+		return nil
+	})
+}
+
+func equalStrings(a, b *string) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+var messageColumns = columnsOf(db.Message{})
 
-		w.WriteHeader(http.StatusCreated)
-		// If Prefer: return=representation is set (it usually is by default in supabase-js insert), return the object.
-		// We'll just always return it to be safe.
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode([]*db.Message{createdMsg})
+// patchableMessageFields are the messages columns PATCH may change.
+var patchableMessageFields = map[string]bool{"content": true, "metadata": true}
+
+// handlePatchMessage edits the message selected by id=eq.{messageId}. Other
+// filters (e.g. sender_name=eq.{name}) must match too, or nothing changes and
+// no rows are returned, as in PostgREST. The new content goes through the
+// blocklist like a new message; system messages can't be edited.
+func (h *Handler) handlePatchMessage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id := extractEqValue(q.Get("id"))
+	if id == "" || !plainEq(q.Get("id")) {
+		restError(w, "Missing id parameter", http.StatusBadRequest)
+		return
+	}
+	filters, err := parseFilters(q, messageColumns, "id")
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		invalidBody(w, err)
+		return
+	}
+	for name := range fields {
+		if !patchableMessageFields[name] {
+			unknownColumn(w, "messages", name)
+			return
+		}
+	}
+	var content *string
+	if raw, ok := fields["content"]; ok {
+		if err := json.Unmarshal(raw, &content); err != nil {
+			restError(w, "content: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if raw, ok := fields["metadata"]; ok && !json.Valid(raw) {
+		restError(w, "metadata must be valid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if h.Blocklist.BlocksIP(h.clientIP(r)) {
+		restError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var matched string
+	if content != nil {
+		matched = h.Blocklist.MatchText(*content)
+	}
+	if matched != "" && !h.FlagFiltered {
+		restError(w, "Message blocked", http.StatusForbidden)
+		return
+	}
+
+	errNoMatch := fmt.Errorf("no match")
+	updated := []*db.Message{}
+	err = h.DB.Tx(func(tx *db.Tx) error {
+		msg, err := tx.UpdateMessage(id, func(m *db.Message) error {
+			// Like a row hidden by RLS, a message of another session
+			// just doesn't match.
+			if !matches(*m, filters) || !h.sessionAllowed(r, m.SessionID) || !h.visible(r, "messages", policy.Update, *m) {
+				return errNoMatch
+			}
+			if m.MessageType == db.MessageTypeSystem {
+				return errSystemMessage
+			}
+			if _, ok := fields["content"]; ok {
+				m.Content = content
+			}
+			if raw, ok := fields["metadata"]; ok {
+				m.Metadata = nil
+				if string(raw) != "null" {
+					m.Metadata = raw
+				}
+			}
+			if !h.writable(r, "messages", policy.Update, *m) {
+				return errPolicyCheck
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if matched != "" {
+			reason := "matched blocklist entry " + matched
+			if _, err := tx.FlagMessage(msg.ID, db.FlagSourceFilter, &reason, nil); err != nil {
+				return err
+			}
+		}
+		updated = append(updated, msg)
+		return nil
+	})
+	if err == errPolicyCheck {
+		policyViolation(w, "messages")
+		return
+	}
+	if err != nil && err != errNoMatch && err.Error() != "message not found" {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeResult(w, r, http.StatusOK, updated)
+}
+
+// identityTokenHeader carries the integrator-signed token of the sender.
+const identityTokenHeader = "X-Identity-Token"
+
+// lastEventIDHeader tells REST clients which realtime events the response
+// already reflects: events with event_id at or below it can be dropped.
+const lastEventIDHeader = "X-Last-Event-Id"
+
+// setLastEventID must run before the query it describes.
+func (h *Handler) setLastEventID(w http.ResponseWriter) int64 {
+	id := h.DB.LastOutboxSeq()
+	w.Header().Set(lastEventIDHeader, strconv.FormatInt(id, 10))
+	return id
+}
+
+func (h *Handler) handleParticipants(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		var body db.Participant
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			invalidBody(w, err)
+			return
+		}
+		if !h.requireSessionToken(w, r, body.SessionID) {
+			return
+		}
+
+		p, err := h.DB.JoinParticipant(body.SessionID, body.DisplayName)
+		if err != nil {
+			restError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeResult(w, r, http.StatusCreated, []*db.Participant{p})
 		return
 	}
 
 	if r.Method == "GET" {
 		// session_id=eq.{sessionId}
-		sessionIDParam := r.URL.Query().Get("session_id")
-		if sessionIDParam == "" {
-			http.Error(w, "Missing session_id parameter", http.StatusBadRequest)
+		sessionID := extractEqValue(r.URL.Query().Get("session_id"))
+		if sessionID == "" {
+			restError(w, "Missing session_id parameter", http.StatusBadRequest)
+			return
+		}
+		if !h.requireSessionToken(w, r, sessionID) {
 			return
 		}
-		sessionID := extractEqValue(sessionIDParam)
 
-		messages, err := h.DB.GetMessages(sessionID)
+		offset, limit, err := h.pageBounds(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			restError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		participants, err := h.DB.GetParticipants(sessionID)
+		if err != nil {
+			restError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		participants, status, ok := paginate(w, r, participants, offset, limit)
+		if !ok {
 			return
 		}
+		writeRows(w, r, status, participants)
+	}
+}
+
+func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	// Query: session_id={sessionId}&q={terms}[&scope=content,filename,...]
+	sessionID := extractEqValue(r.URL.Query().Get("session_id"))
+	if sessionID == "" {
+		restError(w, "Missing session_id parameter", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	if strings.TrimSpace(query) == "" {
+		restError(w, "Missing q parameter", http.StatusBadRequest)
+		return
+	}
+
+	scope, err := db.ParseSearchScope(r.URL.Query().Get("scope"))
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.requireSessionToken(w, r, sessionID) {
+		return
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(messages)
+	messages, err := h.DB.SearchMessages(sessionID, query, scope)
+	if err != nil {
+		restError(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	messages = visibleRows(h, r, "messages", messages)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
 }
 
 func (h *Handler) handleStorageUpload(w http.ResponseWriter, r *http.Request) {
@@ -227,21 +1027,49 @@ func (h *Handler) handleStorageUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Filename required", http.StatusBadRequest)
 		return
 	}
+	// Media is stored under "{session_id}/", which is what the per-session
+	// limit goes by.
+	if !h.limiters.uploadsPerIP.allow(w, r, h.Limits.UploadsPerIP, h.clientIP(r).String(), 1) {
+		return
+	}
+	allowed, answered := h.mayWriteObject(w, r, fileName)
+	if answered {
+		return
+	}
+	if !allowed {
+		storageFailure(w, http.StatusForbidden, "Unauthorized", "permission denied for object "+fileName)
+		return
+	}
+	if sessionID, _, ok := strings.Cut(fileName, "/"); ok &&
+		!h.limiters.uploadsPerSession.allow(w, r, h.Limits.UploadsPerSession, sessionID, 1) {
+		return
+	}
 
 	// Ensure storage dir exists
 	fullPath := filepath.Join(h.StorageDir, fileName)
 	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.storageError(w, r, err)
 		return
 	}
 
-	// Create file
-	dst, err := os.Create(fullPath)
+	// The upload goes to a temporary file beside fullPath that replaces it
+	// only once complete, so a failed overwrite leaves the old media.
+	dst, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.storageError(w, r, err)
+		return
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			dst.Close()
+			os.Remove(dst.Name())
+		}
+	}()
+	if err := dst.Chmod(0644); err != nil {
+		h.storageError(w, r, err)
 		return
 	}
-	defer dst.Close()
 
 	// Copy body to file
 	// Supabase upload sends the file in the body.
@@ -254,22 +1082,51 @@ func (h *Handler) handleStorageUpload(w http.ResponseWriter, r *http.Request) {
 	if strings.HasPrefix(contentType, "multipart/form-data") {
 		file, _, err := firstFileFromMultipart(r)
 		if err != nil {
+			if tooLarge(w, r, err) {
+				return
+			}
 			http.Error(w, fmt.Sprintf("Failed to read multipart file: %v", err), http.StatusBadRequest)
 			return
 		}
 		defer file.Close()
-		if _, err := io.Copy(dst, file); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := h.writeMedia(dst, file); err != nil {
+			h.storageError(w, r, err)
 			return
 		}
 	} else {
 		// Raw body
-		if _, err := io.Copy(dst, r.Body); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := h.writeMedia(dst, r.Body); err != nil {
+			h.storageError(w, r, err)
 			return
 		}
 	}
 
+	info, err := dst.Stat()
+	if err == nil {
+		err = dst.Close()
+	}
+	if err != nil {
+		h.storageError(w, r, err)
+		return
+	}
+	old, statErr := os.Stat(fullPath)
+	overwrite := statErr == nil
+	if err := os.Rename(dst.Name(), fullPath); err != nil {
+		h.storageError(w, r, err)
+		return
+	}
+	committed = true
+	delta := info.Size()
+	if overwrite {
+		delta -= old.Size()
+	}
+	h.adjustStorage(delta)
+
+	// Cached copies of the old content are now stale.
+	if overwrite {
+		h.purgeCDN(fileName)
+	}
+
 	// Return success
 	// Supabase returns: { "Key": "chat-media/filename" }
 	w.Header().Set("Content-Type", "application/json")
@@ -278,6 +1135,21 @@ func (h *Handler) handleStorageUpload(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// storageError answers a failed media write. Running out of space is
+// reported as 507 without the filesystem's message, and puts the database in
+// its disk-full state so operators are alerted.
+func (h *Handler) storageError(w http.ResponseWriter, r *http.Request, err error) {
+	if tooLarge(w, r, err) {
+		return
+	}
+	if db.IsNoSpace(err) {
+		h.DB.NoteDiskFull()
+		http.Error(w, "Insufficient storage", http.StatusInsufficientStorage)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
 func (h *Handler) handleStorageServe(w http.ResponseWriter, r *http.Request) {
 	// Path: /storage/v1/object/public/chat-media/{fileName}
 	prefix := "/storage/v1/object/public/chat-media/"
@@ -294,5 +1166,40 @@ func (h *Handler) handleStorageServe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.ServeFile(w, r, fullPath)
+	h.setMediaCacheHeaders(w, fileName)
+	if h.Cipher == nil {
+		http.ServeFile(w, r, fullPath)
+		return
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := h.Cipher.ReadFile(fullPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, fileName, info.ModTime(), bytes.NewReader(data))
+}
+
+// writeMedia stores an upload, encrypting it first when a Cipher is set.
+// Encryption needs the whole file in memory; plain uploads are streamed.
+func (h *Handler) writeMedia(dst io.Writer, src io.Reader) error {
+	if h.Cipher == nil {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	plain, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	sealed, err := h.Cipher.Seal(plain)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(sealed)
+	return err
 }
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+)
+
+// hubPingTimeout is how long /health waits for the realtime hub's loop
+// before calling it stuck.
+const hubPingTimeout = time.Second
+
+// healthCheck is one line of /health: OK, and what went wrong if not.
+type healthCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func checkErr(err error) healthCheck {
+	if err != nil {
+		return healthCheck{Error: err.Error()}
+	}
+	return healthCheck{OK: true}
+}
+
+// probeWritable writes and removes a one-byte file in dir.
+func probeWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".health-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write([]byte{0}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// handleHealth serves GET /health for Docker's HEALTHCHECK and load
+// balancer probes: 200 when every check passes, 503 otherwise, with the
+// checks as JSON either way. It needs no credentials and reveals no data.
+func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	database := checkErr(h.DB.LoadError())
+	if database.OK && h.DB.ReadOnly() {
+		database = healthCheck{Error: "read-only: disk full"}
+	}
+	checks := map[string]healthCheck{
+		"database":    database,
+		"data_dir":    checkErr(probeWritable(h.DB.DataDir)),
+		"storage_dir": checkErr(probeWritable(h.StorageDir)),
+	}
+	if h.Hub != nil {
+		realtime := healthCheck{OK: true}
+		if !h.Hub.Alive(hubPingTimeout) {
+			realtime = healthCheck{Error: "hub not responding"}
+		}
+		checks["realtime"] = realtime
+	}
+
+	status, code := "ok", http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			status, code = "unhealthy", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status   string                 `json:"status"`
+		UptimeMS int64                  `json:"uptime_ms"`
+		BootID   string                 `json:"boot_id"`
+		Checks   map[string]healthCheck `json:"checks"`
+	}{status, time.Since(h.clock.started).Milliseconds(), h.clock.bootID, checks})
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyPageSize is the number of hits on one page of the search UI.
+const historyPageSize = 50
+
+var historyTemplate = template.Must(template.New("history").Funcs(template.FuncMap{
+	"deref": func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	},
+	"stamp": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{if .Session}}Session {{.Session.ID}}{{else}}Search{{end}}</title>
+<style>
+body { font: 14px sans-serif; margin: 2em; }
+form input { margin-right: .5em; }
+table { border-collapse: collapse; width: 100%; margin-top: 1em; }
+td, th { border-bottom: 1px solid #ddd; padding: .3em .5em; text-align: left; vertical-align: top; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+{{if .Session}}
+<p><a href="{{.Back}}">&larr; Back to results</a></p>
+<h1>{{with .Session.Title}}{{.}}{{else}}{{with .Session.Code}}{{.}}{{else}}{{$.Session.ID}}{{end}}{{end}}</h1>
+<p class="muted">{{.Session.ID}} &middot; opened {{stamp .Session.CreatedAt}}{{with .Session.ClosedAt}} &middot; closed {{stamp .}}{{end}}{{with .Tags}} &middot; tags: {{range $i, $t := .}}{{if $i}}, {{end}}{{$t}}{{end}}{{end}}</p>
+<table>
+{{range .Messages}}<tr{{if eq .ID $.Highlight}} style="background:#ffc"{{end}} id="m-{{.ID}}"><td class="muted">{{stamp .CreatedAt}}</td><td>{{deref .SenderName}}</td><td>{{deref .Content}}{{with .FileURL}} <a href="{{.}}">[file]</a>{{end}}</td></tr>
+{{end}}</table>
+{{else}}
+<h1>Search</h1>
+<form method="get">
+<input name="q" placeholder="Text" value="{{.Form.Get "q"}}">
+<input name="sender" placeholder="Sender" value="{{.Form.Get "sender"}}">
+<input name="tag" placeholder="Tag" value="{{.Form.Get "tag"}}">
+From <input type="date" name="from" value="{{.Form.Get "from"}}">
+To <input type="date" name="to" value="{{.Form.Get "to"}}">
+<button>Search</button>
+</form>
+{{if .Searched}}
+<p class="muted">{{.Total}} result{{if ne .Total 1}}s{{end}}</p>
+<table>
+{{range .Messages}}<tr><td class="muted">{{stamp .CreatedAt}}</td><td>{{deref .SenderName}}</td><td>{{deref .Content}}</td><td><a href="{{$.Base}}/sessions/{{.SessionID}}?{{$.Query}}&amp;message={{.ID}}#m-{{.ID}}">transcript</a></td></tr>
+{{end}}</table>
+<p>{{if .Prev}}<a href="?{{.Prev}}">&larr; Newer</a>{{end}} {{if .Next}}<a href="?{{.Next}}">Older &rarr;</a>{{end}}</p>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+type historyPage struct {
+	Base     string
+	Form     url.Values
+	Query    template.URL
+	Searched bool
+	Total    int
+	Prev     template.URL
+	Next     template.URL
+	Messages []db.Message
+
+	Session   *db.ChatSession
+	Tags      []string
+	Highlight string
+	Back      template.URL
+}
+
+// historyCriteria picks the search fields out of the query string, so they
+// can be carried over to pagination and transcript links.
+func historyCriteria(form url.Values) url.Values {
+	criteria := url.Values{}
+	for _, k := range []string{"q", "sender", "tag", "from", "to"} {
+		if v := strings.TrimSpace(form.Get(k)); v != "" {
+			criteria.Set(k, v)
+		}
+	}
+	return criteria
+}
+
+// handleHistorySearch serves the search form and its results at GET
+// /admin/v1/search. Query: q, sender, tag, from and to (YYYY-MM-DD, both
+// inclusive), page.
+func (h *Handler) handleHistorySearch(w http.ResponseWriter, r *http.Request) {
+	form := r.URL.Query()
+	criteria := historyCriteria(form)
+	page := historyPage{Base: "/admin/v1/search", Form: form, Query: template.URL(criteria.Encode())}
+
+	q := db.HistoryQuery{Text: criteria.Get("q"), Scope: db.ScopeAll, Sender: criteria.Get("sender"), Tag: criteria.Get("tag")}
+	var err error
+	if v := criteria.Get("from"); v != "" {
+		if q.From, err = time.Parse("2006-01-02", v); err != nil {
+			http.Error(w, "Invalid from", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := criteria.Get("to"); v != "" {
+		if q.To, err = time.Parse("2006-01-02", v); err != nil {
+			http.Error(w, "Invalid to", http.StatusBadRequest)
+			return
+		}
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+	n := 1
+	if v := form.Get("page"); v != "" {
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			http.Error(w, "Invalid page", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page.Searched = len(criteria) > 0
+	if page.Searched {
+		hits := h.DB.SearchHistory(q)
+		page.Total = len(hits)
+		start := min((n-1)*historyPageSize, len(hits))
+		end := min(start+historyPageSize, len(hits))
+		page.Messages = hits[start:end]
+		link := func(n int) template.URL {
+			v := url.Values{}
+			for k := range criteria {
+				v.Set(k, criteria.Get(k))
+			}
+			v.Set("page", strconv.Itoa(n))
+			return template.URL(v.Encode())
+		}
+		if n > 1 {
+			page.Prev = link(n - 1)
+		}
+		if end < len(hits) {
+			page.Next = link(n + 1)
+		}
+	}
+	h.renderHistory(w, page)
+}
+
+// handleHistorySession serves a session transcript at GET
+// /admin/v1/search/sessions/{id}, with the message named by ?message=
+// highlighted and a link back to the results the search criteria gave.
+func (h *Handler) handleHistorySession(w http.ResponseWriter, r *http.Request) {
+	form := r.URL.Query()
+	criteria := historyCriteria(form)
+	page := historyPage{Base: "/admin/v1/search", Form: form, Query: template.URL(criteria.Encode())}
+
+	session, err := h.DB.GetSession(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if page.Messages, err = h.DB.GetMessages(session.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page.Session = session
+	page.Tags = db.SessionTags(*session)
+	page.Highlight = form.Get("message")
+	page.Back = template.URL(page.Base) + "?" + page.Query
+	h.renderHistory(w, page)
+}
+
+func (h *Handler) renderHistory(w http.ResponseWriter, page historyPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := historyTemplate.Execute(w, page); err != nil {
+		log.Printf("rendering search page failed: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/auth"
+	"context"
+	"net/http"
+	"strings"
+)
+
+// requestClaims returns the claims of r's verified access token, nil
+// without one.
+func requestClaims(r *http.Request) *auth.Claims {
+	claims, _ := r.Context().Value(claimsKey).(*auth.Claims)
+	return claims
+}
+
+// checkJWT verifies the Authorization: Bearer access token of a client API
+// request against Auth and gives r its claims, or answers 401 and returns
+// false. supabase-js sends the API key there until a user signs in; that
+// is no access token and is left to checkAPIKey.
+func (h *Handler) checkJWT(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if h.Auth == nil || !clientAPI(r.URL.Path) {
+		return r, true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" || token == r.Header.Get("apikey") ||
+		keyMatches(token, h.AnonKey) || keyMatches(token, h.ServiceRoleKey) {
+		return r, true
+	}
+	claims, err := h.Auth.Verify(token)
+	if err != nil {
+		msg := "Invalid JWT"
+		if detail, ok := strings.CutPrefix(err.Error(), auth.ErrInvalid.Error()+": "); ok {
+			msg += ": " + detail
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		if strings.HasPrefix(r.URL.Path, "/rest/v1") {
+			restError(w, msg, http.StatusUnauthorized)
+		} else {
+			http.Error(w, msg, http.StatusUnauthorized)
+		}
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), claimsKey, claims)), true
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the ID a request is logged under. One sent by the
+// client or a proxy in front is kept; otherwise the server makes one up.
+const requestIDHeader = "X-Request-Id"
+
+// statusRecorder notes the status and body size of a response for the
+// access log. Websocket upgrades hijack the connection and are logged as
+// 101.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logRequest serves r with next and writes one AccessLog record for it.
+func (h *Handler) logRequest(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > 200 {
+		id = uuid.New().String()
+	}
+	w.Header().Set(requestIDHeader, id)
+
+	rec := &statusRecorder{ResponseWriter: w}
+	start := time.Now()
+	next(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	level := slog.LevelInfo
+	if rec.status >= 500 {
+		level = slog.LevelError
+	}
+	h.AccessLog.LogAttrs(r.Context(), level, "request",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", rec.status),
+		slog.Int64("bytes", rec.bytes),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("remote_ip", h.clientIP(r).String()),
+		slog.String("request_id", id),
+	)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/auth"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleOTP serves POST /auth/v1/otp[?redirect_to=URL] with {"email":
+// "...", "data": {...}, "create_user": bool}, where supabase-js
+// signInWithOtp() asks for a sign-in link. The link is emailed and the
+// answer is empty; following it signs the user in.
+func (h *Handler) handleOTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email      string          `json:"email"`
+		Phone      string          `json:"phone"`
+		Data       json.RawMessage `json:"data"`
+		CreateUser *bool           `json:"create_user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		authError(w, http.StatusBadRequest, "bad_json", "Could not parse request body as JSON: "+err.Error())
+		return
+	}
+	if body.Phone != "" {
+		authError(w, http.StatusUnprocessableEntity, "phone_provider_disabled", "Unsupported phone provider")
+		return
+	}
+	if h.Mailer == nil || h.ExternalURL == "" {
+		authError(w, http.StatusUnprocessableEntity, "email_provider_disabled", "Email logins are disabled")
+		return
+	}
+	email, err := auth.NormalizeEmail(body.Email)
+	if err != nil {
+		authError(w, http.StatusBadRequest, "validation_failed", err.Error())
+		return
+	}
+	if len(body.Data) > 0 && body.Data[0] != '{' {
+		authError(w, http.StatusBadRequest, "validation_failed", "data must be an object")
+		return
+	}
+	if string(body.Data) == "{}" {
+		body.Data = nil
+	}
+	if !h.limiters.emailsPerIP.allow(w, r, h.Limits.EmailsPerIP, h.clientIP(r).String(), 1) {
+		return
+	}
+
+	token, err := h.Users.IssueEmailLink(email, body.Data, body.CreateUser == nil || *body.CreateUser, time.Now())
+	switch {
+	case errors.Is(err, auth.ErrSignupsClosed):
+		authError(w, http.StatusUnprocessableEntity, "otp_disabled", err.Error())
+		return
+	case errors.Is(err, auth.ErrResendTooSoon):
+		authError(w, http.StatusTooManyRequests, "over_email_send_rate_limit", err.Error())
+		return
+	case err != nil:
+		authError(w, http.StatusInternalServerError, "unexpected_failure", err.Error())
+		return
+	}
+	// The link goes to ExternalURL only: the request's Host is the
+	// requester's to choose, and would send the victim's token there.
+	link := strings.TrimSuffix(h.ExternalURL, "/") + "/auth/v1/verify?" + url.Values{
+		"token":       {token},
+		"type":        {"magiclink"},
+		"redirect_to": {h.redirectTarget(r.URL.Query().Get("redirect_to"))},
+	}.Encode()
+	mailBody := fmt.Sprintf("Follow this link to sign in:\n\n%s\n\nThe link works once and expires in %d minutes. If you didn't ask to sign in, ignore this email.\n", link, int(auth.EmailLinkTTL.Minutes()))
+	if err := h.Mailer.Send([]string{email}, "Your sign-in link", mailBody); err != nil {
+		log.Printf("auth: sending sign-in link failed: %v", err)
+		authError(w, http.StatusInternalServerError, "unexpected_failure", "Error sending magic link email")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+// handleVerifyLink serves GET /auth/v1/verify?token=...&type=magiclink
+// &redirect_to=URL, the link in the email. The user is signed in and sent
+// on to redirect_to with the session in the URL fragment, as after OAuth
+// sign-in.
+func (h *Handler) handleVerifyLink(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	target := h.redirectTarget(q.Get("redirect_to"))
+	if t := q.Get("type"); t != "magiclink" && t != "email" {
+		redirectAuthError(w, r, target, "validation_failed", "Verify requires a verification type")
+		return
+	}
+	if !h.allowAttempt(w, r) {
+		return
+	}
+	now := time.Now()
+	user, sessionID, refresh, err := h.Users.SignInWithEmailLink(q.Get("token"), now)
+	if errors.Is(err, auth.ErrLinkInvalid) {
+		h.failedAttempt(w, r, "email_link", "")
+		redirectAuthError(w, r, target, "otp_expired", err.Error())
+		return
+	}
+	if err != nil {
+		redirectAuthError(w, r, target, "server_error", err.Error())
+		return
+	}
+	ttl := h.accessTTL()
+	token, expires, err := h.Auth.AccessToken(user, sessionID, ttl, now)
+	if err != nil {
+		redirectAuthError(w, r, target, "server_error", err.Error())
+		return
+	}
+	fragment := url.Values{
+		"access_token":  {token},
+		"token_type":    {"bearer"},
+		"expires_in":    {strconv.Itoa(int(ttl.Seconds()))},
+		"expires_at":    {strconv.FormatInt(expires.Unix(), 10)},
+		"refresh_token": {refresh},
+		"type":          {"magiclink"},
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, withFragment(target, fragment), http.StatusFound)
+}
+
+// handleVerify serves POST /auth/v1/verify with {"type": "magiclink",
+// "token_hash": "..."}, which supabase-js verifyOtp() sends for apps that
+// handle the link themselves; token_hash is the link's token. It answers
+// with the session, as the token endpoint does.
+func (h *Handler) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Type      string `json:"type"`
+		TokenHash string `json:"token_hash"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		authError(w, http.StatusBadRequest, "bad_json", "Could not parse request body as JSON: "+err.Error())
+		return
+	}
+	if body.Type != "magiclink" && body.Type != "email" {
+		authError(w, http.StatusBadRequest, "validation_failed", "Unsupported verification type: "+body.Type)
+		return
+	}
+	if body.TokenHash == "" {
+		authError(w, http.StatusBadRequest, "validation_failed", "token_hash is required")
+		return
+	}
+	if !h.allowAttempt(w, r) {
+		return
+	}
+	user, sessionID, refresh, err := h.Users.SignInWithEmailLink(body.TokenHash, time.Now())
+	switch {
+	case errors.Is(err, auth.ErrLinkInvalid):
+		h.failedAttempt(w, r, "email_link", "")
+		authError(w, http.StatusForbidden, "otp_expired", err.Error())
+		return
+	case err != nil:
+		authError(w, http.StatusInternalServerError, "unexpected_failure", err.Error())
+		return
+	}
+	h.writeAuthSession(w, user, sessionID, refresh)
+}
+
+// emailSignIn reports whether users can sign in with emailed links.
+func (h *Handler) emailSignIn() bool {
+	return h.authEnabled() && h.Mailer != nil && h.ExternalURL != ""
+}
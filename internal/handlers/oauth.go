@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/auth"
+	"chat-quick-chat-server/internal/db"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// oauthCookie holds the nonce of the sign-in in progress in the browser.
+const oauthCookie = "sb-oauth-nonce"
+
+// browserAuthPage reports whether r is for an /auth/v1 page browsers
+// navigate to, which can't carry an API key.
+func browserAuthPage(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/auth/v1/authorize", "/auth/v1/callback":
+		return true
+	case "/auth/v1/verify":
+		return r.Method == http.MethodGet
+	}
+	return false
+}
+
+// handleAuthorize serves GET /auth/v1/authorize?provider=github|google
+// [&redirect_to=URL][&scopes=a b], where supabase-js signInWithOAuth()
+// sends the browser: it goes on to the provider's sign-in page.
+func (h *Handler) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	provider := h.OAuth[q.Get("provider")]
+	if provider == nil {
+		authError(w, http.StatusBadRequest, "validation_failed", "Unsupported provider: provider is not enabled")
+		return
+	}
+	if q.Get("code_challenge") != "" {
+		authError(w, http.StatusBadRequest, "validation_failed", "PKCE flow is not supported; use flowType 'implicit'")
+		return
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	state, err := h.Auth.SealState(auth.OAuthState{
+		Provider:   provider.Name,
+		RedirectTo: h.redirectTarget(q.Get("redirect_to")),
+		Nonce:      nonce,
+		ExpiresAt:  time.Now().Add(auth.StateTTL).Unix(),
+	})
+	if err != nil {
+		authError(w, http.StatusInternalServerError, "unexpected_failure", err.Error())
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie,
+		Value:    nonce,
+		Path:     "/auth/v1/callback",
+		MaxAge:   int(auth.StateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.requestScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(h.oauthCallbackURL(r), state, strings.Fields(q.Get("scopes"))), http.StatusFound)
+}
+
+// handleOAuthCallback serves GET /auth/v1/callback, where the provider
+// sends the user back. The user is signed in and returned to the
+// redirect_to of /authorize with the session in the URL fragment, as
+// GoTrue's implicit flow does; supabase-js picks it up from there.
+func (h *Handler) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	state, err := h.Auth.OpenState(q.Get("state"), time.Now())
+	cookie, cookieErr := r.Cookie(oauthCookie)
+	if err != nil || cookieErr != nil || !keyMatches(cookie.Value, state.Nonce) {
+		authError(w, http.StatusBadRequest, "bad_oauth_state", auth.ErrBadState.Error())
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthCookie, Path: "/auth/v1/callback", MaxAge: -1})
+	if code := q.Get("error"); code != "" {
+		redirectAuthError(w, r, state.RedirectTo, code, q.Get("error_description"))
+		return
+	}
+	provider := h.OAuth[state.Provider]
+	if provider == nil {
+		redirectAuthError(w, r, state.RedirectTo, "provider_disabled", "Unsupported provider: provider is not enabled")
+		return
+	}
+
+	identity, err := provider.Exchange(q.Get("code"), h.oauthCallbackURL(r))
+	if err != nil {
+		log.Printf("oauth: %s sign-in failed: %v", provider.Name, err)
+		redirectAuthError(w, r, state.RedirectTo, "server_error", "Error getting user profile from external provider")
+		return
+	}
+	now := time.Now()
+	user, sessionID, refresh, err := h.Users.SignInWithIdentity(identity, now)
+	if err != nil {
+		redirectAuthError(w, r, state.RedirectTo, "server_error", err.Error())
+		return
+	}
+	ttl := h.accessTTL()
+	token, expires, err := h.Auth.AccessToken(user, sessionID, ttl, now)
+	if err != nil {
+		redirectAuthError(w, r, state.RedirectTo, "server_error", err.Error())
+		return
+	}
+	fragment := url.Values{
+		"access_token":  {token},
+		"token_type":    {"bearer"},
+		"expires_in":    {strconv.Itoa(int(ttl.Seconds()))},
+		"expires_at":    {strconv.FormatInt(expires.Unix(), 10)},
+		"refresh_token": {refresh},
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, withFragment(state.RedirectTo, fragment), http.StatusFound)
+}
+
+// redirectAuthError sends the browser back to target with the error in the
+// fragment, where supabase-js reports it.
+func redirectAuthError(w http.ResponseWriter, r *http.Request, target, code, description string) {
+	http.Redirect(w, r, withFragment(target, url.Values{"error": {code}, "error_description": {description}}), http.StatusFound)
+}
+
+func withFragment(target string, v url.Values) string {
+	target, _, _ = strings.Cut(target, "#")
+	return target + "#" + v.Encode()
+}
+
+// redirectTarget is where a sign-in asked to return to, if it is SiteURL,
+// under it, or one of RedirectURLs (a trailing * matches any rest), and
+// SiteURL otherwise.
+func (h *Handler) redirectTarget(requested string) string {
+	if requested == "" {
+		return h.SiteURL
+	}
+	site := strings.TrimSuffix(h.SiteURL, "/")
+	if site != "" && (requested == site || strings.HasPrefix(requested, site+"/")) {
+		return requested
+	}
+	for _, allowed := range h.RedirectURLs {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); (ok && strings.HasPrefix(requested, prefix)) || requested == allowed {
+			return requested
+		}
+	}
+	return h.SiteURL
+}
+
+// oauthCallbackURL is the redirect_uri registered with the providers:
+// OAuthCallbackURL, or /auth/v1/callback under externalURL.
+func (h *Handler) oauthCallbackURL(r *http.Request) string {
+	if h.OAuthCallbackURL != "" {
+		return h.OAuthCallbackURL
+	}
+	return h.externalURL(r) + "/auth/v1/callback"
+}
+
+// externalURL is where browsers reach this server: ExternalURL, or the
+// host r came to.
+func (h *Handler) externalURL(r *http.Request) string {
+	if h.ExternalURL != "" {
+		return strings.TrimSuffix(h.ExternalURL, "/")
+	}
+	return h.requestScheme(r) + "://" + r.Host
+}
+
+// requestScheme is the scheme the client used, as told by a trusted proxy.
+func (h *Handler) requestScheme(r *http.Request) string {
+	if h.TrustProxy {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// accountIdentity is the sender identity of r's user, if r carries the
+// access token of a user signed in with an OAuth provider or an emailed
+// link. The account, not the chosen sender_name, names the sender then.
+func (h *Handler) accountIdentity(r *http.Request) *db.SenderIdentity {
+	claims := requestClaims(r)
+	if claims == nil || h.Users == nil {
+		return nil
+	}
+	user, ok := h.Users.User(claims.Subject)
+	if !ok || len(user.Identities) == 0 {
+		return nil
+	}
+	id := user.Identities[0]
+	return &db.SenderIdentity{ExternalID: id.Provider + ":" + id.ProviderID, Email: id.Email, Name: id.DisplayName()}
+}
+
+// oauthProviders names the providers users can sign in with.
+func (h *Handler) oauthProviders() []string {
+	names := []string{}
+	if h.authEnabled() {
+		for name := range h.OAuth {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
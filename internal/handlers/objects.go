@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mediaBucket is the one storage bucket, as supabase-js names it.
+const mediaBucket = "chat-media"
+
+// storageObject is a deleted object as Supabase Storage describes it.
+type storageObject struct {
+	Name           string         `json:"name"`
+	BucketID       string         `json:"bucket_id"`
+	Owner          string         `json:"owner"`
+	ID             *string        `json:"id"`
+	UpdatedAt      string         `json:"updated_at"`
+	CreatedAt      string         `json:"created_at"`
+	LastAccessedAt string         `json:"last_accessed_at"`
+	Metadata       map[string]any `json:"metadata"`
+}
+
+// storageFailure answers in Supabase Storage's error format, whose
+// statusCode is a string.
+func storageFailure(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"statusCode": strconv.Itoa(status), "error": code, "message": msg})
+}
+
+// objectName is name if it is a clean path inside the bucket.
+func objectName(name string) (string, bool) {
+	clean := path.Clean("/" + name)[1:]
+	return clean, clean != "" && clean == name
+}
+
+// mayWriteObject reports whether r may upload or delete name. With session
+// tokens on, a visitor may only write media under "{session_id}/" with that
+// session's token, like its messages; service_role may write anything.
+// A wrong token counts as a failed attempt.
+func (h *Handler) mayWriteObject(w http.ResponseWriter, r *http.Request, name string) (allowed, answered bool) {
+	if h.SessionTokens == nil || serviceCaller(r) {
+		return true, false
+	}
+	sessionID, _, nested := strings.Cut(name, "/")
+	token := r.Header.Get(sessionTokenHeader)
+	if !nested || token == "" {
+		return false, false
+	}
+	if !h.allowAttempt(w, r) {
+		return false, true
+	}
+	if h.SessionTokens.Valid(sessionID, token) {
+		return true, false
+	}
+	h.failedAttempt(w, r, "session_token", sessionID)
+	return false, false
+}
+
+// deleteObject removes the stored upload name, frees its storage and
+// purges it from the CDN, and returns what was removed.
+func (h *Handler) deleteObject(name string) (storageObject, error) {
+	full := filepath.Join(h.StorageDir, filepath.FromSlash(name))
+	info, err := os.Stat(full)
+	if err != nil {
+		return storageObject{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return storageObject{}, os.ErrNotExist
+	}
+	if err := os.Remove(full); err != nil {
+		return storageObject{}, err
+	}
+	h.adjustStorage(-info.Size())
+	h.purgeCDN(name)
+
+	modified := info.ModTime().UTC().Format("2006-01-02T15:04:05.000Z")
+	mimetype := mime.TypeByExtension(path.Ext(name))
+	if mimetype == "" {
+		mimetype = "application/octet-stream"
+	}
+	return storageObject{
+		Name:           name,
+		BucketID:       mediaBucket,
+		UpdatedAt:      modified,
+		CreatedAt:      modified,
+		LastAccessedAt: modified,
+		Metadata: map[string]any{
+			"size":           info.Size(),
+			"mimetype":       mimetype,
+			"lastModified":   modified,
+			"contentLength":  info.Size(),
+			"httpStatusCode": http.StatusOK,
+		},
+	}, nil
+}
+
+// handleStorageDelete serves DELETE /storage/v1/object/chat-media/{path},
+// removing one upload.
+func (h *Handler) handleStorageDelete(w http.ResponseWriter, r *http.Request) {
+	name, ok := objectName(r.PathValue("path"))
+	if !ok {
+		storageFailure(w, http.StatusBadRequest, "InvalidKey", "Invalid key: "+r.PathValue("path"))
+		return
+	}
+	allowed, answered := h.mayWriteObject(w, r, name)
+	if answered {
+		return
+	}
+	if !allowed {
+		storageFailure(w, http.StatusForbidden, "Unauthorized", "permission denied for object "+name)
+		return
+	}
+	if _, err := h.deleteObject(name); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			storageFailure(w, http.StatusNotFound, "not_found", "Object not found")
+			return
+		}
+		h.storageError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Successfully deleted"})
+}
+
+// maxDeletePrefixes caps one bulk delete, as Supabase Storage does.
+const maxDeletePrefixes = 1000
+
+// handleStorageBulkDelete serves DELETE /storage/v1/object/chat-media with
+// {"prefixes": ["path", ...]}, where supabase-js remove() sends the paths
+// to delete. It answers with the objects removed; paths that don't exist
+// or r may not delete are left out, as in Supabase.
+func (h *Handler) handleStorageBulkDelete(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Prefixes []string `json:"prefixes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		if tooLarge(w, r, err) {
+			return
+		}
+		storageFailure(w, http.StatusBadRequest, "InvalidRequest", "Could not parse request body as JSON: "+err.Error())
+		return
+	}
+	if len(body.Prefixes) == 0 || len(body.Prefixes) > maxDeletePrefixes {
+		storageFailure(w, http.StatusBadRequest, "InvalidRequest", "prefixes must list 1 to "+strconv.Itoa(maxDeletePrefixes)+" paths")
+		return
+	}
+
+	removed := []storageObject{}
+	seen := make(map[string]bool)
+	for _, p := range body.Prefixes {
+		name, ok := objectName(p)
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		allowed, answered := h.mayWriteObject(w, r, name)
+		if answered {
+			return
+		}
+		if !allowed {
+			continue
+		}
+		obj, err := h.deleteObject(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			h.storageError(w, r, err)
+			return
+		}
+		removed = append(removed, obj)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(removed)
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPITable describes one table under /rest/v1 for the OpenAPI document.
+type openAPITable struct {
+	name        string
+	row         any
+	methods     []string
+	description string
+}
+
+// reportRow is the body of POST /rest/v1/message_reports.
+type reportRow struct {
+	MessageID string  `json:"message_id"`
+	Reason    *string `json:"reason"`
+	Reporter  *string `json:"reporter"`
+}
+
+var openAPITables = []openAPITable{
+	{"chat_sessions", db.SessionListing{}, []string{"get", "post", "patch", "delete"}, "Conversations. last_message_at is computed and can't be written."},
+	{"messages", db.Message{}, []string{"get", "post", "patch", "delete"}, "Messages in seq order per session. POST takes one row or an array."},
+	{"participants", db.Participant{}, []string{"get", "post"}, "Display names seen in a session."},
+	{"reactions", db.Reaction{}, []string{"get", "post", "delete"}, "Emoji reactions to messages."},
+	{"read_receipts", db.Participant{}, []string{"get", "post"}, "Participants with a read position; POST moves it forward."},
+	{"message_reports", reportRow{}, []string{"post"}, "Reports a message for review."},
+}
+
+// handleOpenAPI serves GET /rest/v1/: a Swagger 2.0 description of the REST
+// tables, the RPCs, storage and the realtime endpoint, as PostgREST serves
+// at its root. It is generated from the row types, so it follows them.
+func (h *Handler) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil || h.TrustProxy && r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	paths := make(map[string]any)
+	definitions := make(map[string]any)
+	for _, t := range openAPITables {
+		definitions[t.name] = schemaOf(reflect.TypeOf(t.row))
+		paths["/rest/v1/"+t.name] = tableOperations(t)
+	}
+	for name, rpc := range h.rpcs {
+		paths[rpcPrefix+name] = rpcOperations(name, rpc.ReadOnly)
+	}
+	paths[rpcPrefix+"session_snapshot"] = map[string]any{"get": operation("Everything a widget needs to render a session.",
+		[]any{queryParam("session_id", true), queryParam("limit", false)}, "200")}
+	paths[rpcPrefix+"session_summaries"] = rpcOperations("session_summaries", false)
+	paths[rpcPrefix+"select_slot"] = rpcOperations("select_slot", false)
+
+	object := pathParam("path", "Object path, e.g. {session_id}/{file}.")
+	upload := operation("Uploads a file; x-upsert: true overwrites.", []any{object, map[string]any{"name": "body", "in": "body", "required": true, "schema": map[string]any{"type": "string", "format": "binary"}}}, "200")
+	upload["consumes"] = []string{"application/octet-stream", "multipart/form-data"}
+	paths["/storage/v1/object/chat-media/{path}"] = map[string]any{"post": upload, "put": upload}
+	download := operation("Serves a stored file.", []any{object}, "200")
+	download["produces"] = []string{"application/octet-stream"}
+	paths["/storage/v1/object/public/chat-media/{path}"] = map[string]any{"get": download}
+	paths["/capabilities"] = map[string]any{"get": operation("Which optional parts of the server are enabled.", []any{}, "200")}
+	paths["/realtime/v1/websocket"] = map[string]any{"get": operation(
+		"Phoenix websocket. Join realtime:messages:{session_id} for postgres_changes on messages.",
+		[]any{queryParam("vsn", false), queryParam("apikey", false)}, "101")}
+
+	doc := map[string]any{
+		"swagger": "2.0",
+		"info": map[string]any{
+			"title":       "chat-quick-chat-server",
+			"description": "Supabase-compatible chat API: PostgREST tables, storage and realtime.",
+			"version":     "1.0",
+		},
+		"host":        r.Host,
+		"basePath":    "/",
+		"schemes":     []string{scheme},
+		"consumes":    []string{"application/json"},
+		"produces":    []string{"application/json", objectMediaType, csvMediaType},
+		"paths":       paths,
+		"definitions": definitions,
+	}
+	w.Header().Set("Content-Type", "application/openapi+json; charset=utf-8")
+	json.NewEncoder(w).Encode(doc)
+}
+
+func tableOperations(t openAPITable) map[string]any {
+	ref := map[string]any{"$ref": "#/definitions/" + t.name}
+	rows := map[string]any{"type": "array", "items": ref}
+	body := map[string]any{"name": "body", "in": "body", "required": true, "schema": ref}
+	prefer := map[string]any{"name": "Prefer", "in": "header", "type": "string",
+		"description": "return=minimal|representation, count=exact, resolution=merge-duplicates|ignore-duplicates"}
+
+	ops := make(map[string]any)
+	for _, m := range t.methods {
+		var params []any
+		status := "200"
+		switch m {
+		case "get":
+			sel := queryParam("select", false)
+			if t.name == "messages" {
+				sel["description"] = "*,chat_sessions(*) embeds each message's session"
+			}
+			params = append(filterParams(t.row), sel, queryParam("order", false), queryParam("limit", false), queryParam("offset", false),
+				map[string]any{"name": "Range", "in": "header", "type": "string"}, prefer)
+		case "post":
+			params, status = []any{body, prefer}, "201"
+		case "patch":
+			params = append(filterParams(t.row), body, prefer)
+		case "delete":
+			params = append(filterParams(t.row), prefer)
+		}
+		op := operation(t.description, params, status)
+		op["tags"] = []string{t.name}
+		// Reports answer with no body.
+		if t.name != "message_reports" {
+			op["responses"].(map[string]any)[status].(map[string]any)["schema"] = rows
+		}
+		ops[m] = op
+	}
+	return ops
+}
+
+// filterParams lists a query parameter per column for PostgREST filters.
+func filterParams(row any) []any {
+	columns := make([]string, 0)
+	for c := range columnsOf(row) {
+		columns = append(columns, c)
+	}
+	sort.Strings(columns)
+	params := make([]any, 0, len(columns))
+	for _, c := range columns {
+		p := queryParam(c, false)
+		p["description"] = "Filter, e.g. eq.{value}, in.(a,b), is.null"
+		params = append(params, p)
+	}
+	for _, logic := range []string{"or", "and"} {
+		p := queryParam(logic, false)
+		p["description"] = "Logical filter, e.g. (column.eq.a,column.eq.b); and(...) and or(...) nest"
+		params = append(params, p)
+	}
+	return params
+}
+
+func rpcOperations(name string, readOnly bool) map[string]any {
+	ops := map[string]any{
+		"post": operation("RPC "+name+".", []any{map[string]any{"name": "args", "in": "body", "required": true, "schema": map[string]any{"type": "object"}}}, "200"),
+	}
+	if readOnly {
+		ops["get"] = operation("RPC "+name+", arguments as query parameters.", []any{}, "200")
+	}
+	return ops
+}
+
+func operation(summary string, params []any, status string) map[string]any {
+	code, _ := strconv.Atoi(status)
+	return map[string]any{
+		"summary":    summary,
+		"parameters": params,
+		"responses":  map[string]any{status: map[string]any{"description": http.StatusText(code)}},
+	}
+}
+
+func queryParam(name string, required bool) map[string]any {
+	return map[string]any{"name": name, "in": "query", "required": required, "type": "string"}
+}
+
+func pathParam(name, description string) map[string]any {
+	return map[string]any{"name": name, "in": "path", "required": true, "type": "string", "description": description}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf describes t as it is encoded to JSON. Pointers are nullable.
+func schemaOf(t reflect.Type) map[string]any {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t, nullable = t.Elem(), true
+	}
+	var s map[string]any
+	switch {
+	case t == timeType:
+		s = map[string]any{"type": "string", "format": "date-time"}
+	case t == rawType:
+		s = map[string]any{"description": "Any JSON value"}
+	default:
+		switch t.Kind() {
+		case reflect.String:
+			s = map[string]any{"type": "string"}
+		case reflect.Bool:
+			s = map[string]any{"type": "boolean"}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			s = map[string]any{"type": "integer"}
+		case reflect.Float32, reflect.Float64:
+			s = map[string]any{"type": "number"}
+		case reflect.Slice, reflect.Array:
+			s = map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+		case reflect.Map:
+			s = map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+		case reflect.Struct:
+			props := make(map[string]any)
+			addProperties(t, props)
+			s = map[string]any{"type": "object", "properties": props}
+		default:
+			s = map[string]any{}
+		}
+	}
+	if nullable {
+		s["x-nullable"] = true
+	}
+	return s
+}
+
+// addProperties adds the JSON fields of struct t, flattening embedded
+// structs as encoding/json does.
+func addProperties(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addProperties(f.Type, props)
+			continue
+		}
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaOf(f.Type)
+	}
+}
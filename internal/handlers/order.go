@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// orderTerm is one column of a PostgREST order=, e.g. created_at.desc or
+// last_message_at.asc.nullsfirst.
+type orderTerm struct {
+	column     string
+	desc       bool
+	nullsFirst bool
+}
+
+// parseOrder reads order=col[.asc|.desc][.nullsfirst|.nullslast],... .
+// Rows whose value is null go last unless nullsfirst is given, in either
+// direction.
+func parseOrder(spec string, columns map[string]bool) ([]orderTerm, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var terms []orderTerm
+	for _, part := range strings.Split(spec, ",") {
+		fields := strings.Split(part, ".")
+		t := orderTerm{column: fields[0]}
+		if !columns[t.column] {
+			return nil, fmt.Errorf("unsupported order column %q", t.column)
+		}
+		for _, mod := range fields[1:] {
+			switch mod {
+			case "asc":
+				t.desc = false
+			case "desc":
+				t.desc = true
+			case "nullsfirst":
+				t.nullsFirst = true
+			case "nullslast":
+				t.nullsFirst = false
+			default:
+				return nil, fmt.Errorf("invalid order %q", part)
+			}
+		}
+		terms = append(terms, t)
+	}
+	return terms, nil
+}
+
+// sortRows orders rows by terms, keeping the existing order between rows
+// that compare equal.
+func sortRows[T any](rows []T, terms []orderTerm) {
+	if len(terms) == 0 {
+		return
+	}
+	values := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = rowValues(row)
+	}
+	idx := make([]int, len(rows))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, b := values[idx[i]], values[idx[j]]
+		for _, t := range terms {
+			va, vb := a[t.column], b[t.column]
+			if va == nil || vb == nil {
+				if (va == nil) == (vb == nil) {
+					continue
+				}
+				return (va == nil) == t.nullsFirst
+			}
+			c := compareValues(va, vb)
+			if c == 0 {
+				continue
+			}
+			return (c < 0) != t.desc
+		}
+		return false
+	})
+	sorted := make([]T, len(rows))
+	for i, k := range idx {
+		sorted[i] = rows[k]
+	}
+	copy(rows, sorted)
+}
+
+// compareValues orders two non-null JSON values of the same column.
+// Timestamps compare as instants; values of different kinds are equal.
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			return cmp.Compare(a, b)
+		}
+	case bool:
+		if b, ok := b.(bool); ok && a != b {
+			if a {
+				return 1
+			}
+			return -1
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			if ta, err := time.Parse(time.RFC3339Nano, a); err == nil {
+				if tb, err := time.Parse(time.RFC3339Nano, b); err == nil {
+					return ta.Compare(tb)
+				}
+			}
+			return strings.Compare(a, b)
+		}
+	}
+	return 0
+}
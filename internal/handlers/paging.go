@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxRows caps page sizes when MaxRows is unset.
+const defaultMaxRows = 1000
+
+// pageBounds reads the requested window of a REST list: limit= and offset=,
+// or PostgREST's Range: 0-24 header (Range-Unit: items) when neither is
+// given. limit is -1 when the client asked for no limit; a requested limit
+// is capped at MaxRows.
+func (h *Handler) pageBounds(r *http.Request) (offset, limit int, err error) {
+	q := r.URL.Query()
+	offset, limit = 0, -1
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("Invalid offset")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return 0, 0, fmt.Errorf("Invalid limit")
+		}
+	}
+	if rangeRequested(r) {
+		if offset, limit, err = parseRange(r.Header.Get("Range")); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	max := h.MaxRows
+	if max <= 0 {
+		max = defaultMaxRows
+	}
+	if limit > max {
+		limit = max
+	}
+	return offset, limit, nil
+}
+
+// rangeRequested reports whether the window comes from a Range header: one
+// was sent in items, and neither limit= nor offset= overrides it.
+func rangeRequested(r *http.Request) bool {
+	q := r.URL.Query()
+	if unit := r.Header.Get("Range-Unit"); unit != "" && unit != "items" {
+		return false
+	}
+	return r.Header.Get("Range") != "" && q.Get("limit") == "" && q.Get("offset") == ""
+}
+
+// parseRange reads "first-last" or "first-", optionally prefixed "items=".
+func parseRange(s string) (offset, limit int, err error) {
+	first, last, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(s), "items="), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("Invalid Range header")
+	}
+	if offset, err = strconv.Atoi(first); err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("Invalid Range header")
+	}
+	if last == "" {
+		return offset, -1, nil
+	}
+	end, err := strconv.Atoi(last)
+	if err != nil || end < offset {
+		return 0, 0, fmt.Errorf("Invalid Range header")
+	}
+	return offset, end - offset + 1, nil
+}
+
+// paginate cuts rows (already filtered and ordered) to the window and
+// reports it in Content-Range as PostgREST does: "0-24/*", or "*/*" for an
+// empty page. The total replaces the * after the slash when the client sent
+// Prefer: count=exact. status is the one to answer with: 206 when a Range
+// header asked for a window that leaves rows out, 200 otherwise. A Range
+// that starts past the last row gets 416 here and ok is false.
+func paginate[T any](w http.ResponseWriter, r *http.Request, rows []T, offset, limit int) (page []T, status int, ok bool) {
+	total := "*"
+	if wantsCount(r) {
+		total = strconv.Itoa(len(rows))
+		w.Header().Add("Preference-Applied", "count="+preference(r, "count"))
+	}
+	ranged := rangeRequested(r)
+	if ranged && offset > 0 && offset >= len(rows) {
+		w.Header().Set("Content-Range", fmt.Sprintf("*/%d", len(rows)))
+		restErrorCode(w, http.StatusRequestedRangeNotSatisfiable, "PGRST103", "Requested range not satisfiable",
+			fmt.Sprintf("An offset of %d was requested, but there are only %d rows.", offset, len(rows)))
+		return nil, 0, false
+	}
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	page = rows[offset:]
+	if limit >= 0 && limit < len(page) {
+		page = page[:limit]
+	}
+	status = http.StatusOK
+	if ranged && len(page) < len(rows) {
+		status = http.StatusPartialContent
+	}
+	if len(page) == 0 {
+		w.Header().Set("Content-Range", "*/"+total)
+		return []T{}, status, true
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("%d-%d/%s", offset, offset+len(page)-1, total))
+	return page, status, true
+}
+
+// headWriter drops the body of a response to a HEAD request.
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+// isHead reports whether w answers a HEAD request, so that the rows need
+// not be encoded at all.
+func isHead(w http.ResponseWriter) bool {
+	_, ok := w.(headWriter)
+	return ok
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/outbound"
+	"chat-quick-chat-server/internal/payment"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// preparePayment creates the provider checkout for a new payment request.
+// Clients only choose amount, currency and description; the rest is filled
+// in here.
+func (h *Handler) preparePayment(msg *db.Message) (int, error) {
+	if msg.MessageType != db.MessageTypePaymentRequest {
+		msg.Payment = nil
+		return 0, nil
+	}
+	if h.Payments == nil {
+		return http.StatusNotImplemented, fmt.Errorf("Payments are not configured")
+	}
+	if msg.Payment == nil || msg.Payment.Amount <= 0 {
+		return http.StatusBadRequest, fmt.Errorf("payment.amount must be a positive amount in minor units")
+	}
+	currency := strings.ToLower(msg.Payment.Currency)
+	if len(currency) != 3 || strings.Trim(currency, "abcdefghijklmnopqrstuvwxyz") != "" {
+		return http.StatusBadRequest, fmt.Errorf("payment.currency must be an ISO 4217 code")
+	}
+	if _, err := h.DB.GetSession(msg.SessionID); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	checkout, err := h.Payments.CreateCheckout(payment.Request{
+		Amount:      msg.Payment.Amount,
+		Currency:    currency,
+		Description: msg.Payment.Description,
+		SessionID:   msg.SessionID,
+	})
+	if errors.Is(err, outbound.ErrOpen) {
+		// The provider has been failing; don't make the sender wait for it.
+		return http.StatusServiceUnavailable, fmt.Errorf("Payments are temporarily unavailable")
+	}
+	if err != nil {
+		log.Printf("creating checkout for session %s failed: %v", msg.SessionID, err)
+		return http.StatusBadGateway, fmt.Errorf("Creating the checkout failed: %w", err)
+	}
+	msg.Payment = &db.Payment{
+		Amount:      msg.Payment.Amount,
+		Currency:    currency,
+		Description: msg.Payment.Description,
+		Provider:    h.Payments.Name(),
+		CheckoutID:  checkout.ID,
+		CheckoutURL: checkout.URL,
+		Status:      db.PaymentPending,
+	}
+	return 0, nil
+}
+
+// handlePaymentWebhook serves POST /payments/v1/webhook, where the payment
+// provider reports paid and expired checkouts. The message's new status
+// reaches clients as an UPDATE. Unknown checkouts are acknowledged so the
+// provider stops retrying them.
+func (h *Handler) handlePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.Payments == nil {
+		http.NotFound(w, r)
+		return
+	}
+	update, err := h.Payments.ParseWebhook(r)
+	if errors.Is(err, payment.ErrSignature) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if tooLarge(w, r, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if update == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_, _, err = h.DB.SetPaymentStatus(h.Payments.Name(), update.CheckoutID, update.Status)
+	if errors.Is(err, db.ErrReadOnly) {
+		// The provider retries; the status is applied once writes resume.
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Service temporarily read-only", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil && err.Error() != "payment not found" {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
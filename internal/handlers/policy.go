@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/policy"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// errPolicyCheck is returned from a transaction whose new row fails the
+// policies' check.
+var errPolicyCheck = errors.New("new row violates row-level security policy")
+
+// requester is who r acts for, as the policies see it.
+func requester(r *http.Request) policy.Requester {
+	req := policy.Requester{Role: requestRole(r)}
+	if claims := requestClaims(r); claims != nil {
+		req.Claims = claims.All
+	}
+	return req
+}
+
+// policyRow is v as the columns the policies compare.
+func policyRow(v any) policy.Row {
+	data, _ := json.Marshal(v)
+	var row policy.Row
+	json.Unmarshal(data, &row)
+	return row
+}
+
+// visible reports whether the policies let r's action reach row of table.
+func (h *Handler) visible(r *http.Request, table string, action policy.Action, row any) bool {
+	if !h.Policy.Protects(table) {
+		return true
+	}
+	return h.Policy.Visible(requester(r), table, action, policyRow(row))
+}
+
+// writable reports whether the policies let r's action write row to table.
+func (h *Handler) writable(r *http.Request, table string, action policy.Action, row any) bool {
+	if !h.Policy.Protects(table) {
+		return true
+	}
+	return h.Policy.Writable(requester(r), table, action, policyRow(row))
+}
+
+// visibleRows keeps the rows of table r may select.
+func visibleRows[T any](h *Handler, r *http.Request, table string, rows []T) []T {
+	if !h.Policy.Protects(table) {
+		return rows
+	}
+	req := requester(r)
+	kept := make([]T, 0, len(rows))
+	for _, row := range rows {
+		if h.Policy.Visible(req, table, policy.Select, policyRow(row)) {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
+
+// policyViolation answers as PostgREST does when a write fails a policy.
+func policyViolation(w http.ResponseWriter, table string) {
+	restErrorCode(w, http.StatusForbidden, "42501", fmt.Sprintf("new row violates row-level security policy for table %q", table), "")
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Values of the return= preference in PostgREST's Prefer header.
+const (
+	returnMinimal        = "minimal"
+	returnRepresentation = "representation"
+)
+
+// preference reads name= from the Prefer header; "" when the client didn't
+// say.
+func preference(r *http.Request, name string) string {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(pref), name+"="); ok {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+func returnPreference(r *http.Request) string { return preference(r, "return") }
+
+// wantsCount reports whether the client asked for the total row count with
+// Prefer: count=exact (supabase-js { count: 'exact' }). Counts here are
+// always exact, so planned and estimated get the same answer.
+func wantsCount(r *http.Request) bool {
+	switch preference(r, "count") {
+	case "exact", "planned", "estimated":
+		return true
+	}
+	return false
+}
+
+// writeResult answers a successful write with status and the written rows.
+// Under Prefer: return=minimal, which supabase-js sends when an insert or
+// update isn't followed by .select(), it answers 201 (POST) or 204 with no
+// body instead. Without a preference the rows are returned, which is what
+// existing clients expect.
+func writeResult[T any](w http.ResponseWriter, r *http.Request, status int, rows []T) {
+	pref := returnPreference(r)
+	if pref == returnMinimal || pref == returnRepresentation {
+		w.Header().Add("Preference-Applied", "return="+pref)
+	}
+	if pref == returnMinimal {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	writeRows(w, r, status, rows)
+}
+
+// objectMediaType is what supabase-js's .single() and .maybeSingle() accept.
+const objectMediaType = "application/vnd.pgrst.object+json"
+
+// wantsObject reports whether the client asked for a single object rather
+// than an array of rows.
+func wantsObject(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, t := range strings.Split(accept, ",") {
+			if mt, _, _ := strings.Cut(strings.TrimSpace(t), ";"); strings.EqualFold(mt, objectMediaType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeRows answers with rows as a JSON array, as CSV when the client asks
+// for text/csv, or, when the client accepts only an object, with the one row
+// as PostgREST does: a bare object, or 406 and error PGRST116 when there are
+// no or several rows.
+func writeRows[T any](w http.ResponseWriter, r *http.Request, status int, rows []T) {
+	if !wantsObject(r) && wantsCSV(r) {
+		writeCSV(w, status, rows)
+		return
+	}
+	if !wantsObject(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if !isHead(w) {
+			json.NewEncoder(w).Encode(rows)
+		}
+		return
+	}
+	if len(rows) != 1 {
+		restErrorCode(w, http.StatusNotAcceptable, "PGRST116", "JSON object requested, multiple (or no) rows returned",
+			fmt.Sprintf("The result contains %d rows", len(rows)))
+		return
+	}
+	w.Header().Set("Content-Type", objectMediaType+"; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rows[0])
+}
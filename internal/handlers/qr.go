@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize = 256
+	maxQRSize     = 1024
+)
+
+// joinURL fills the JoinURLTemplate for a session. {session_id} is replaced
+// with the path-escaped ID and {session_token} with the session's token, or
+// nothing when session tokens are off.
+func (h *Handler) joinURL(sessionID string) string {
+	token := ""
+	if h.SessionTokens != nil {
+		token = h.SessionTokens.Issue(sessionID)
+	}
+	return strings.NewReplacer(
+		"{session_id}", url.PathEscape(sessionID),
+		"{session_token}", url.QueryEscape(token),
+	).Replace(h.JoinURLTemplate)
+}
+
+// handleQR serves GET /qr/{sessionID}.png: a QR code of the session's join
+// URL, so a conversation started on a desktop can be continued on a phone.
+// With session tokens on, it needs the session's token.
+func (h *Handler) handleQR(w http.ResponseWriter, r *http.Request) {
+	if h.JoinURLTemplate == "" {
+		http.Error(w, "JOIN_URL_TEMPLATE is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/qr/")
+	sessionID, ok := strings.CutSuffix(name, ".png")
+	if !ok || sessionID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	session, err := h.DB.GetSession(sessionID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.requireSessionToken(w, r, sessionID) {
+		return
+	}
+	// A merged session's visitors belong in the conversation that absorbed
+	// it, so the link carries that session's token.
+	if session.MergedInto != nil {
+		sessionID = *session.MergedInto
+	}
+
+	size := defaultQRSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 64 || n > maxQRSize {
+			http.Error(w, "size must be between 64 and 1024", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	png, err := qrcode.Encode(h.joinURL(sessionID), qrcode.Medium, size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Write(png)
+}
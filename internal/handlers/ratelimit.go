@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Rate requests per second in bursts of up to Burst. The
+// zero value allows everything.
+type RateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// ParseRateLimit parses "N/s", "N/m" or "N/h": N requests per second, minute
+// or hour, in bursts of up to N. "" is no limit.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "" {
+		return RateLimit{}, nil
+	}
+	count, unit, ok := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(count, 64)
+	if !ok || err != nil || n <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q must look like 30/m", s)
+	}
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if per == 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: unit must be s, m or h", s)
+	}
+	return RateLimit{Rate: n / per.Seconds(), Burst: n}, nil
+}
+
+// RateLimits are the limits on writes from the public API, by client IP
+// and by session. Exceeding one gets 429 with Retry-After.
+type RateLimits struct {
+	MessagesPerIP      RateLimit
+	MessagesPerSession RateLimit
+	SessionsPerIP      RateLimit
+	UploadsPerIP       RateLimit
+	UploadsPerSession  RateLimit
+	SignupsPerIP       RateLimit
+	EmailsPerIP        RateLimit
+}
+
+// bucketPruneEvery is how often a limiter forgets the buckets that have
+// filled up again, which are the same as no bucket at all.
+const bucketPruneEvery = time.Minute
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// limiter keeps one token bucket per key for a RateLimit.
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+// take removes n tokens from key's bucket. When there aren't enough, it
+// takes none and reports how long until there will be.
+func (l *limiter) take(limit RateLimit, key string, n float64, now time.Time) (ok bool, wait time.Duration) {
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	if now.Sub(l.pruned) > bucketPruneEvery {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.at).Seconds()*limit.Rate >= limit.Burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: limit.Burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(limit.Burst, b.tokens+now.Sub(b.at).Seconds()*limit.Rate)
+	b.at = now
+	if b.tokens < n {
+		return false, time.Duration((n - b.tokens) / limit.Rate * float64(time.Second))
+	}
+	b.tokens -= n
+	return true, 0
+}
+
+// rateLimiters holds the buckets of each of Handler.Limits.
+type rateLimiters struct {
+	messagesPerIP, messagesPerSession limiter
+	sessionsPerIP                     limiter
+	uploadsPerIP, uploadsPerSession   limiter
+	signupsPerIP                      limiter
+	emailsPerIP                       limiter
+}
+
+// allow takes n tokens from key's bucket, or answers 429 and returns false.
+func (l *limiter) allow(w http.ResponseWriter, r *http.Request, limit RateLimit, key string, n int) bool {
+	ok, wait := l.take(limit, key, float64(n), time.Now())
+	if !ok {
+		rateLimited(w, r, wait)
+	}
+	return ok
+}
+
+// rateLimited answers 429 with Retry-After in whole seconds, rounded up.
+func rateLimited(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(max(wait, time.Second).Seconds()))))
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/rest/v1/"):
+		restError(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	case strings.HasPrefix(r.URL.Path, "/auth/v1/"):
+		authError(w, http.StatusTooManyRequests, "over_request_rate_limit", "Rate limit exceeded")
+	default:
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// handleReactions serves /rest/v1/reactions:
+//
+//	GET    ?message_id=eq.{id} or ?session_id=eq.{id}
+//	POST   {"message_id", "emoji", "sender_name"}
+//	DELETE ?id=eq.{id} or ?message_id=eq.{id}&emoji=eq.{e}&sender_name=eq.{name}
+//
+// Inserts and deletes are broadcast on the session's messages topic. With
+// session tokens on, reactions need their session's token as messages do:
+// listing by message or deleting leaves out those of other sessions.
+func (h *Handler) handleReactions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	switch r.Method {
+	case "GET":
+		sessionID := extractEqValue(q.Get("session_id"))
+		messageID := extractEqValue(q.Get("message_id"))
+		if sessionID == "" && messageID == "" {
+			restError(w, "Missing session_id or message_id parameter", http.StatusBadRequest)
+			return
+		}
+		if sessionID != "" && !h.requireSessionToken(w, r, sessionID) {
+			return
+		}
+		offset, limit, err := h.pageBounds(r)
+		if err != nil {
+			restError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.setLastEventID(w)
+		reactions, err := h.DB.GetReactions(sessionID, messageID)
+		if err != nil {
+			restError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		allowed := reactions[:0]
+		for _, reaction := range reactions {
+			if h.sessionAllowed(r, reaction.SessionID) {
+				allowed = append(allowed, reaction)
+			}
+		}
+		reactions, status, ok := paginate(w, r, allowed, offset, limit)
+		if !ok {
+			return
+		}
+		writeRows(w, r, status, reactions)
+
+	case "POST":
+		var body db.Reaction
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			invalidBody(w, err)
+			return
+		}
+		if m, err := h.DB.GetMessage(body.MessageID); err == nil && !h.requireSessionToken(w, r, m.SessionID) {
+			return
+		}
+		reaction, err := h.DB.AddReaction(body.MessageID, body.Emoji, body.SenderName)
+		if errors.Is(err, db.ErrDuplicateReaction) {
+			restError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			restError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeResult(w, r, http.StatusCreated, []*db.Reaction{reaction})
+
+	case "DELETE":
+		removed, err := h.DB.RemoveReactions(db.Reaction{
+			ID:         extractEqValue(q.Get("id")),
+			MessageID:  extractEqValue(q.Get("message_id")),
+			Emoji:      extractEqValue(q.Get("emoji")),
+			SenderName: extractEqValue(q.Get("sender_name")),
+		}, func(reaction db.Reaction) bool {
+			return h.sessionAllowed(r, reaction.SessionID)
+		})
+		if err != nil {
+			restError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeDeleted(w, r, removed)
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"encoding/json"
+	"net/http"
+)
+
+// handleReadReceipts serves /rest/v1/read_receipts. GET ?session_id=eq.{id}
+// lists the participants that have read something; POST {"session_id",
+// "display_name", "message_id"} moves a participant's read position forward
+// and broadcasts a "read" event on the session topic. Both need the
+// session's token when session tokens are on.
+func (h *Handler) handleReadReceipts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		sessionID := extractEqValue(r.URL.Query().Get("session_id"))
+		if sessionID == "" {
+			restError(w, "Missing session_id parameter", http.StatusBadRequest)
+			return
+		}
+		if !h.requireSessionToken(w, r, sessionID) {
+			return
+		}
+		offset, limit, err := h.pageBounds(r)
+		if err != nil {
+			restError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		participants, err := h.DB.GetParticipants(sessionID)
+		if err != nil {
+			restError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		receipts := []db.Participant{}
+		for _, p := range participants {
+			if p.LastReadMessageID != nil {
+				receipts = append(receipts, p)
+			}
+		}
+		receipts, status, ok := paginate(w, r, receipts, offset, limit)
+		if !ok {
+			return
+		}
+		writeRows(w, r, status, receipts)
+
+	case "POST":
+		var body struct {
+			SessionID   string `json:"session_id"`
+			DisplayName string `json:"display_name"`
+			MessageID   string `json:"message_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			invalidBody(w, err)
+			return
+		}
+		if !h.requireSessionToken(w, r, body.SessionID) {
+			return
+		}
+		p, _, err := h.DB.MarkRead(body.SessionID, body.DisplayName, body.MessageID)
+		if err != nil {
+			restError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeResult(w, r, http.StatusOK, []*db.Participant{p})
+	}
+}
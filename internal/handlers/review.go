@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"encoding/json"
+	"net/http"
+)
+
+// handleReports lets participants report a message for review:
+// POST /rest/v1/message_reports {"message_id": "...", "reason": "..."}.
+// With session tokens on, only those holding the session's token may.
+func (h *Handler) handleReports(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MessageID string  `json:"message_id"`
+		Reason    *string `json:"reason"`
+		Reporter  *string `json:"reporter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		invalidBody(w, err)
+		return
+	}
+	if m, err := h.DB.GetMessage(body.MessageID); err == nil && !h.requireSessionToken(w, r, m.SessionID) {
+		return
+	}
+	if _, err := h.DB.FlagMessage(body.MessageID, db.FlagSourceReport, body.Reason, body.Reporter); err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The queue itself is not visible to participants.
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleFlagQueue serves the review queue at /admin/v1/flags: GET lists it
+// (?status=open&assigned_to=...) and POST {"message_id", "reason", "actor"}
+// flags a message by hand.
+func (h *Handler) handleFlagQueue(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		q := r.URL.Query()
+		flags, err := h.DB.GetFlags(extractEqValue(q.Get("status")), extractEqValue(q.Get("assigned_to")))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(flags)
+	case "POST":
+		var body struct {
+			MessageID string  `json:"message_id"`
+			Reason    *string `json:"reason"`
+			Actor     *string `json:"actor"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flag, err := h.DB.FlagMessage(body.MessageID, db.FlagSourceAgent, body.Reason, body.Actor)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(flag)
+	}
+}
+
+// handleFlagStats serves GET /admin/v1/flags/stats: queue size and review
+// latency.
+func (h *Handler) handleFlagStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.DB.GetReviewStats())
+}
+
+// handleAssignFlag serves POST /admin/v1/flags/{id}/assign {"reviewer",
+// "actor"}.
+func (h *Handler) handleAssignFlag(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var body struct {
+		Reviewer string  `json:"reviewer"`
+		Actor    *string `json:"actor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Reviewer == "" {
+		http.Error(w, "Missing reviewer", http.StatusBadRequest)
+		return
+	}
+
+	flag, err := h.DB.AssignFlag(id, body.Reviewer, body.Actor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flag)
+}
+
+// handleFlagDecision serves POST /admin/v1/flags/{id}/decision {"decision",
+// "actor", "note"}.
+func (h *Handler) handleFlagDecision(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var body struct {
+		Decision string  `json:"decision"`
+		Actor    *string `json:"actor"`
+		Note     *string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review, err := h.DB.ResolveFlag(id, body.Decision, body.Actor, body.Note)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if review.Session != nil {
+		h.DB.CreateMessage(db.SystemMessage(review.Session.ID, "This conversation was closed by a moderator.", db.SystemEvent{
+			Kind:  db.EventClosed,
+			Actor: body.Actor,
+			Data:  map[string]interface{}{"reason": db.CloseReasonBanned},
+		}))
+	}
+	if body.Decision != db.DecisionAllow && review.FileURL != nil {
+		h.removeMedia(*review.FileURL)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review.Flag)
+}
+
+// removeMedia deletes a stored upload referenced by a public URL and purges
+// it from the CDN. URLs outside our storage are ignored.
+func (h *Handler) removeMedia(fileURL string) {
+	if rel, ok := db.MediaPath(fileURL); ok {
+		h.deleteObject(rel)
+	}
+}
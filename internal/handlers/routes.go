@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// routes registers every endpoint by method and path. A GET route also
+// answers HEAD. Paths without a route get 404, and routed paths asked with
+// another method 405 with Allow; under /rest/v1 both become PostgREST errors
+// (see restFallback).
+func (h *Handler) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /rest/v1", h.handleOpenAPI)
+	mux.HandleFunc("GET /rest/v1/{$}", h.handleOpenAPI)
+
+	mux.HandleFunc("GET /rest/v1/chat_sessions", h.handleGetSessions)
+	mux.HandleFunc("POST /rest/v1/chat_sessions", h.handleCreateSession)
+	mux.HandleFunc("PATCH /rest/v1/chat_sessions", h.handlePatchSession)
+	mux.HandleFunc("DELETE /rest/v1/chat_sessions", h.handleDeleteSessions)
+
+	mux.HandleFunc("GET /rest/v1/messages", h.handleGetMessages)
+	mux.HandleFunc("POST /rest/v1/messages", h.handleCreateMessages)
+	mux.HandleFunc("PATCH /rest/v1/messages", h.handlePatchMessage)
+	mux.HandleFunc("DELETE /rest/v1/messages", h.handleDeleteMessages)
+
+	mux.HandleFunc("POST /rest/v1/message_reports", h.handleReports)
+
+	mux.HandleFunc("GET /rest/v1/reactions", h.handleReactions)
+	mux.HandleFunc("POST /rest/v1/reactions", h.handleReactions)
+	mux.HandleFunc("DELETE /rest/v1/reactions", h.handleReactions)
+
+	mux.HandleFunc("GET /rest/v1/read_receipts", h.handleReadReceipts)
+	mux.HandleFunc("POST /rest/v1/read_receipts", h.handleReadReceipts)
+
+	mux.HandleFunc("GET /rest/v1/participants", h.handleParticipants)
+	mux.HandleFunc("POST /rest/v1/participants", h.handleParticipants)
+
+	mux.HandleFunc("GET /rest/v1/rpc/session_snapshot", h.handleSessionSnapshot)
+	mux.HandleFunc("POST /rest/v1/rpc/session_summaries", h.handleSessionSummaries)
+	mux.HandleFunc("POST /rest/v1/rpc/select_slot", h.handleSelectSlot)
+	// Registered functions; GET is only served for read-only ones.
+	mux.HandleFunc("GET /rest/v1/rpc/{name}", h.handleRPC)
+	mux.HandleFunc("POST /rest/v1/rpc/{name}", h.handleRPC)
+
+	// POST uploads and PUT replaces, as in Supabase Storage.
+	mux.HandleFunc("POST /storage/v1/object/chat-media/{path...}", h.handleStorageUpload)
+	mux.HandleFunc("PUT /storage/v1/object/chat-media/{path...}", h.handleStorageUpload)
+	mux.HandleFunc("DELETE /storage/v1/object/chat-media/{path...}", h.handleStorageDelete)
+	mux.HandleFunc("DELETE /storage/v1/object/chat-media", h.handleStorageBulkDelete)
+	mux.HandleFunc("GET /storage/v1/object/public/chat-media/{path...}", h.handleStorageServe)
+
+	mux.HandleFunc("POST /payments/v1/webhook", h.handlePaymentWebhook)
+
+	mux.HandleFunc("POST /auth/v1/signup", h.authRoute(h.handleSignup))
+	mux.HandleFunc("POST /auth/v1/token", h.authRoute(h.handleToken))
+	mux.HandleFunc("GET /auth/v1/user", h.authRoute(h.handleAuthUser))
+	mux.HandleFunc("POST /auth/v1/logout", h.authRoute(h.handleLogout))
+	mux.HandleFunc("GET /auth/v1/authorize", h.authRoute(h.handleAuthorize))
+	mux.HandleFunc("GET /auth/v1/callback", h.authRoute(h.handleOAuthCallback))
+	mux.HandleFunc("POST /auth/v1/otp", h.authRoute(h.handleOTP))
+	mux.HandleFunc("GET /auth/v1/verify", h.authRoute(h.handleVerifyLink))
+	mux.HandleFunc("POST /auth/v1/verify", h.authRoute(h.handleVerify))
+
+	mux.HandleFunc("GET /admin/v1/backup", h.admin(h.handleBackup))
+	mux.HandleFunc("POST /admin/v1/restore", h.admin(h.handleRestore))
+	mux.HandleFunc("POST /admin/v1/storage/purge", h.admin(h.handlePurge))
+	mux.HandleFunc("POST /admin/v1/compact", h.admin(h.handleCompact))
+	mux.HandleFunc("GET /admin/v1/stats", h.admin(h.handleStats))
+	mux.HandleFunc("GET /admin/v1/blocklist", h.admin(h.handleBlocklist))
+	mux.HandleFunc("POST /admin/v1/blocklist", h.admin(h.handleBlocklist))
+	mux.HandleFunc("PUT /admin/v1/blocklist", h.admin(h.handleBlocklist))
+	mux.HandleFunc("GET /admin/v1/config", h.admin(h.handleSettings))
+	mux.HandleFunc("PUT /admin/v1/config", h.admin(h.handleSettings))
+	mux.HandleFunc("GET /admin/v1/flags", h.admin(h.handleFlagQueue))
+	mux.HandleFunc("POST /admin/v1/flags", h.admin(h.handleFlagQueue))
+	mux.HandleFunc("GET /admin/v1/flags/stats", h.admin(h.handleFlagStats))
+	mux.HandleFunc("POST /admin/v1/flags/{id}/assign", h.admin(h.handleAssignFlag))
+	mux.HandleFunc("POST /admin/v1/flags/{id}/decision", h.admin(h.handleFlagDecision))
+	mux.HandleFunc("GET /admin/v1/search", h.admin(h.handleHistorySearch))
+	mux.HandleFunc("GET /admin/v1/search/sessions/{id}", h.admin(h.handleHistorySession))
+	mux.HandleFunc("GET /admin/v1/sessions", h.admin(h.handleListSessions))
+	mux.HandleFunc("DELETE /admin/v1/sessions/{id}", h.admin(h.handlePurgeSession))
+	mux.HandleFunc("POST /admin/v1/sessions/{id}/ban", h.admin(h.handleBanSession))
+	mux.HandleFunc("POST /admin/v1/sessions/{id}/merge", h.admin(h.handleMergeSessions))
+	mux.HandleFunc("GET /admin/v1/sessions/{id}/export", h.admin(h.handleExportSession))
+	mux.HandleFunc("POST /admin/v1/sessions/{id}/escalate", h.admin(h.handleEscalate))
+
+	mux.HandleFunc("GET /qr/{name}", h.handleQR)
+	mux.HandleFunc("GET /search", h.handleSearch)
+	mux.HandleFunc("GET /time", h.handleTime)
+	mux.HandleFunc("GET /health", h.handleHealth)
+	mux.HandleFunc("GET /capabilities", h.handleCapabilities)
+	mux.HandleFunc("GET /realtime/v1/websocket", h.handleRealtime)
+
+	return mux
+}
+
+// admin guards an /admin/v1 route with requireAdmin.
+func (h *Handler) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.requireAdmin(w, r) {
+			next(w, r)
+		}
+	}
+}
+
+// restFallback stands in for the ResponseWriter when ServeMux has no route
+// for a /rest/v1 request, and replaces the plain-text 404 or 405 it sends
+// with the error PostgREST would. The Allow header of a 405 is kept; other
+// responses, such as ServeMux's redirects, pass through.
+type restFallback struct {
+	http.ResponseWriter
+	r      *http.Request
+	wrote  bool
+	passed bool
+}
+
+func (w *restFallback) WriteHeader(status int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	switch status {
+	case http.StatusMethodNotAllowed:
+		restError(w.ResponseWriter, "Method not allowed", status)
+	case http.StatusNotFound:
+		unknownTable(w.ResponseWriter, w.r)
+	default:
+		w.passed = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *restFallback) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passed {
+		return w.ResponseWriter.Write(b)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/policy"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const rpcPrefix = "/rest/v1/rpc/"
+
+// RPC is a function served at POST /rest/v1/rpc/{name}, like a PostgREST
+// stored procedure.
+type RPC struct {
+	// Fn receives the JSON body, null if there is none, and returns the
+	// value to encode as the response. An *RPCError chooses the status;
+	// other errors are a 500.
+	Fn func(r *http.Request, args json.RawMessage) (any, error)
+	// ReadOnly functions can also be called with GET, with their arguments
+	// as query parameters, and stay available while the database refuses
+	// writes.
+	ReadOnly bool
+}
+
+// RPCError is an error an RPC wants sent with a particular status.
+type RPCError struct {
+	Status  int
+	Message string
+}
+
+func (e *RPCError) Error() string { return e.Message }
+
+// builtinRPCs are served by their own handlers and can't be registered.
+var builtinRPCs = map[string]bool{"session_snapshot": true, "session_summaries": true, "select_slot": true}
+
+// RegisterRPC adds a function under /rest/v1/rpc/{name}. It panics if the
+// name is taken. Register before serving; the registry isn't locked.
+func (h *Handler) RegisterRPC(name string, rpc RPC) {
+	if _, taken := h.rpcs[name]; taken || builtinRPCs[name] || name == "" || strings.Contains(name, "/") {
+		panic(fmt.Sprintf("handlers: RPC %q already registered or invalid", name))
+	}
+	if h.rpcs == nil {
+		h.rpcs = make(map[string]RPC)
+	}
+	h.rpcs[name] = rpc
+}
+
+func (h *Handler) registerBuiltinRPCs() {
+	h.RegisterRPC("session_stats", RPC{Fn: h.rpcSessionStats, ReadOnly: true})
+	h.RegisterRPC("purge_session", RPC{Fn: h.rpcPurgeSession})
+}
+
+// readOnlyRPC reports whether path is an RPC that only reads.
+func (h *Handler) readOnlyRPC(path string) bool {
+	name, ok := strings.CutPrefix(path, rpcPrefix)
+	if !ok {
+		return false
+	}
+	return name == "session_snapshot" || name == "session_summaries" || h.rpcs[name].ReadOnly
+}
+
+// handleRPC serves the registered functions.
+func (h *Handler) handleRPC(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, rpcPrefix)
+	rpc, ok := h.rpcs[name]
+	if !ok {
+		restErrorCode(w, http.StatusNotFound, "PGRST202", "Could not find the function public."+name+" in the schema cache", "")
+		return
+	}
+
+	var args json.RawMessage
+	switch {
+	case r.Method == "POST":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			invalidBody(w, err)
+			return
+		}
+		if len(bytes.TrimSpace(body)) == 0 {
+			body = []byte("null")
+		}
+		if !json.Valid(body) {
+			restErrorCode(w, http.StatusBadRequest, "PGRST102", "Invalid request body", "body is not valid JSON")
+			return
+		}
+		args = body
+	case r.Method == "GET" && rpc.ReadOnly:
+		params := make(map[string]string)
+		for k, v := range r.URL.Query() {
+			if k != "apikey" {
+				params[k] = v[0]
+			}
+		}
+		args, _ = json.Marshal(params)
+	case rpc.ReadOnly:
+		methodNotAllowed(w, r, "GET", "HEAD", "POST")
+		return
+	default:
+		methodNotAllowed(w, r, "POST")
+		return
+	}
+
+	result, err := rpc.Fn(r, args)
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		restError(w, rpcErr.Message, rpcErr.Status)
+		return
+	}
+	if err != nil {
+		restError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// sessionArgs decodes {"session_id": ...}, which is required.
+func sessionArgs(args json.RawMessage) (string, error) {
+	var body struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(args, &body); err != nil {
+		return "", &RPCError{http.StatusBadRequest, err.Error()}
+	}
+	if body.SessionID == "" {
+		return "", &RPCError{http.StatusBadRequest, "session_id is required"}
+	}
+	return body.SessionID, nil
+}
+
+// rpcSessionStats serves session_stats with {"session_id": ...}, given
+// the session's token when session tokens are on.
+func (h *Handler) rpcSessionStats(r *http.Request, args json.RawMessage) (any, error) {
+	id, err := sessionArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if !h.sessionAllowed(r, id) {
+		return nil, &RPCError{http.StatusForbidden, "permission denied for session " + id}
+	}
+	stats, err := h.DB.SessionStats(id)
+	if err != nil {
+		return nil, &RPCError{http.StatusNotFound, err.Error()}
+	}
+	return stats, nil
+}
+
+// rpcPurgeSession serves purge_session with {"session_id": ...}: the session
+// goes as with DELETE /rest/v1/chat_sessions?id=eq.{id}, and likewise only
+// for service_role unless policies decide.
+func (h *Handler) rpcPurgeSession(r *http.Request, args json.RawMessage) (any, error) {
+	id, err := sessionArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if !h.Policy.Protects("chat_sessions") && requestRole(r) != roleService {
+		return nil, &RPCError{http.StatusForbidden, "permission denied: deleting sessions requires service_role"}
+	}
+	if !h.sessionAllowed(r, id) {
+		return nil, &RPCError{http.StatusForbidden, "permission denied for session " + id}
+	}
+	removed, messages, err := h.DB.DeleteSessions(func(s db.ChatSession) bool {
+		return s.ID == id && h.visible(r, "chat_sessions", policy.Delete, s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(removed) == 0 {
+		return nil, &RPCError{http.StatusNotFound, "session not found"}
+	}
+	h.removeMessageMedia(messages)
+	return map[string]any{"session_id": id, "messages_deleted": len(messages)}, nil
+}
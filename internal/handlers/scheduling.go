@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/calendar"
+	"chat-quick-chat-server/internal/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// prepareSchedule readies the slots of a new scheduling message: agents may
+// list them, otherwise they come from the calendar. The selection is always
+// left for select_slot to make.
+func (h *Handler) prepareSchedule(msg *db.Message) (int, error) {
+	if msg.MessageType != db.MessageTypeScheduling {
+		msg.Schedule = nil
+		return 0, nil
+	}
+	if msg.Schedule == nil {
+		msg.Schedule = &db.Schedule{}
+	}
+	sched := msg.Schedule
+	sched.SelectedSlotID, sched.SelectedBy, sched.SelectedAt = nil, nil, nil
+	if len(sched.Slots) == 0 {
+		if h.Calendar == nil {
+			return http.StatusBadRequest, fmt.Errorf("schedule.slots is required")
+		}
+		slots, err := h.Calendar.Available(h.DB.Now())
+		if err != nil {
+			return http.StatusBadGateway, err
+		}
+		if len(slots) == 0 {
+			return http.StatusConflict, fmt.Errorf("No free slots")
+		}
+		sched.Slots = slots
+	}
+	seen := make(map[string]bool)
+	for i := range sched.Slots {
+		s := &sched.Slots[i]
+		if s.Start.IsZero() || !s.End.After(s.Start) {
+			return http.StatusBadRequest, fmt.Errorf("slot %d needs a start before its end", i)
+		}
+		if s.ID == "" {
+			s.ID = calendar.SlotID(s.Start)
+		}
+		if seen[s.ID] {
+			return http.StatusBadRequest, fmt.Errorf("duplicate slot id %q", s.ID)
+		}
+		seen[s.ID] = true
+	}
+	return 0, nil
+}
+
+// handleSelectSlot serves POST /rest/v1/rpc/select_slot with
+// {"message_id": ..., "slot_id": ..., "display_name": "visitor"} and returns
+// the updated scheduling message and its confirmation. With session tokens
+// on, it needs the token of the message's session.
+func (h *Handler) handleSelectSlot(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MessageID   string `json:"message_id"`
+		SlotID      string `json:"slot_id"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		invalidBody(w, err)
+		return
+	}
+	if body.MessageID == "" || body.SlotID == "" || body.DisplayName == "" {
+		restError(w, "message_id, slot_id and display_name are required", http.StatusBadRequest)
+		return
+	}
+	if m, err := h.DB.GetMessage(body.MessageID); err == nil && !h.requireSessionToken(w, r, m.SessionID) {
+		return
+	}
+
+	msg, confirmation, err := h.DB.SelectSlot(body.MessageID, body.SlotID, body.DisplayName)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrSlotTaken):
+			restError(w, err.Error(), http.StatusConflict)
+		case err.Error() == "scheduling message not found":
+			restError(w, err.Error(), http.StatusNotFound)
+		default:
+			restError(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	for _, s := range msg.Schedule.Slots {
+		if s.ID == body.SlotID {
+			h.Calendar.Notify(calendar.Selection{
+				SessionID:  msg.SessionID,
+				MessageID:  msg.ID,
+				Slot:       s,
+				SelectedBy: body.DisplayName,
+				At:         *msg.Schedule.SelectedAt,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]*db.Message{"message": msg, "confirmation": confirmation})
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"net/http"
+)
+
+// handleListSessions serves GET /rest/v1/chat_sessions without an id filter.
+// It understands a PostgREST subset:
+//
+//	{column}=[not.]{op}.{value}, op one of eq, neq, gt, gte, lt, lte,
+//	  like, ilike, in.(a,b) and is.null/true/false; filters may repeat
+//	[not.]or=({column}.{op}.{value},...) and [not.]and=(...), which nest
+//	order={column}[.asc|.desc][.nullsfirst|.nullslast][,...], newest
+//	  first by default
+//	limit=N&offset=M, or a Range header
+func (h *Handler) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filters, err := parseFilters(q, sessionColumns)
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	order := q.Get("order")
+	if order == "" {
+		order = "created_at.desc"
+	}
+	terms, err := parseOrder(order, sessionColumns)
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, limit, err := h.pageBounds(r)
+	if err != nil {
+		restError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessions := applyFilters(visibleRows(h, r, "chat_sessions", h.DB.ListSessions()), filters)
+	sortRows(sessions, terms)
+	sessions, status, ok := paginate(w, r, sessions, offset, limit)
+	if !ok {
+		return
+	}
+	writeRows(w, r, status, sessions)
+}
+
+var sessionColumns = columnsOf(db.SessionListing{})
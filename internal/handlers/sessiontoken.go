@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"net/http"
+)
+
+// sessionTokenHeader carries the session token of the session a request
+// reads or writes, as returned when the session was created.
+const sessionTokenHeader = "X-Session-Token"
+
+// sessionWithToken is a chat_sessions row as returned to whoever created or
+// resolved it, with the token that opens its messages.
+type sessionWithToken struct {
+	db.ChatSession
+	SessionToken string `json:"session_token"`
+}
+
+// withSessionTokens adds the token of each session. Session tokens must be
+// enabled.
+func (h *Handler) withSessionTokens(sessions []*db.ChatSession) []sessionWithToken {
+	rows := make([]sessionWithToken, len(sessions))
+	for i, s := range sessions {
+		rows[i] = sessionWithToken{ChatSession: *s, SessionToken: h.SessionTokens.Issue(s.ID)}
+	}
+	return rows
+}
+
+// serviceCaller reports whether r proved the service_role role with its
+// API key or access token. Unlike requestRole it doesn't trust requests
+// that carry neither.
+func serviceCaller(r *http.Request) bool {
+	if claims := requestClaims(r); claims != nil {
+		return claims.Role == roleService
+	}
+	role, _ := r.Context().Value(roleKey).(string)
+	return role == roleService
+}
+
+// sessionAllowed reports whether r may read and write the messages of
+// sessionID: session tokens are disabled, r carries the session's token, or
+// it comes from a service_role backend.
+func (h *Handler) sessionAllowed(r *http.Request, sessionID string) bool {
+	if h.SessionTokens == nil || serviceCaller(r) {
+		return true
+	}
+	return h.SessionTokens.Valid(sessionID, r.Header.Get(sessionTokenHeader))
+}
+
+// requireSessionToken answers 403 and returns false unless r may access
+// sessionID. A wrong token counts as a failed attempt of r's client IP.
+func (h *Handler) requireSessionToken(w http.ResponseWriter, r *http.Request, sessionID string) bool {
+	if h.SessionTokens == nil || serviceCaller(r) {
+		return true
+	}
+	token := r.Header.Get(sessionTokenHeader)
+	if token != "" && !h.allowAttempt(w, r) {
+		return false
+	}
+	if h.SessionTokens.Valid(sessionID, token) {
+		return true
+	}
+	if token != "" {
+		h.failedAttempt(w, r, "session_token", sessionID)
+	}
+	restErrorCode(w, http.StatusForbidden, "42501", "permission denied for session "+sessionID,
+		"send the session's token in "+sessionTokenHeader)
+	return false
+}
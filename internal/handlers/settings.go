@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/settings"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// handleSettings exports (GET) and imports (PUT) the configuration bundle
+// of /admin/v1/config. PUT replaces every section the bundle contains and
+// leaves the others; with ?dry_run=true it only checks the bundle.
+func (h *Handler) handleSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="config.json"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(settings.Export(h.Blocklist, h.Automations, h.DB.Now()))
+	case "PUT":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, err := settings.Parse(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := settings.Check(b, h.Blocklist, h.Automations); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if r.URL.Query().Get("dry_run") == "true" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err := settings.Apply(b, h.Blocklist, h.Automations); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/policy"
+	"chat-quick-chat-server/internal/realtime"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSnapshotMessages = 50
+	maxSnapshotMessages     = 500
+	// onlineWindow is how recently a participant must have been seen to count
+	// as present; supabase-js heartbeats every 30 seconds.
+	onlineWindow = time.Minute
+)
+
+type presenceEntry struct {
+	db.Participant
+	// Online is true while the participant has a realtime connection
+	// joined to the session, or was seen within onlineWindow.
+	Online bool `json:"online"`
+	Typing bool `json:"typing"`
+	// LastReadSeq is the seq of LastReadMessageID, 0 if nothing is read.
+	LastReadSeq int64 `json:"last_read_seq"`
+	UnreadCount int   `json:"unread_count"`
+}
+
+type sessionSnapshot struct {
+	Session   *db.ChatSession `json:"session"`
+	Messages  []db.Message    `json:"messages"`
+	HasMore   bool            `json:"has_more"`
+	Reactions []db.Reaction   `json:"reactions"`
+	Presence  []presenceEntry `json:"presence"`
+	// Typing names who is typing in the session right now, as relayed over
+	// realtime broadcast.
+	Typing []string `json:"typing"`
+	// LastEventID is the newest realtime event_id the snapshot reflects.
+	LastEventID int64 `json:"last_event_id"`
+}
+
+// handleSessionSnapshot returns everything a widget needs to render a
+// conversation in one response:
+// GET /rest/v1/rpc/session_snapshot?session_id={id}[&limit=N].
+func (h *Handler) handleSessionSnapshot(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	sessionID := extractEqValue(q.Get("session_id"))
+	if sessionID == "" {
+		restError(w, "Missing session_id parameter", http.StatusBadRequest)
+		return
+	}
+	if !h.requireSessionToken(w, r, sessionID) {
+		return
+	}
+	limit := defaultSnapshotMessages
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSnapshotMessages {
+			restError(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	lastEventID := h.setLastEventID(w)
+	session, err := h.DB.GetSession(sessionID)
+	if err == nil && !h.visible(r, "chat_sessions", policy.Select, session) {
+		err = errors.New("session not found")
+	}
+	if err != nil {
+		restError(w, "session not found", http.StatusNotFound)
+		return
+	}
+	messages, err := h.DB.GetMessages(sessionID)
+	if err != nil {
+		restError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	messages = visibleRows(h, r, "messages", messages)
+	participants, err := h.DB.GetParticipants(sessionID)
+	if err != nil {
+		restError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reactions, err := h.DB.GetReactions(sessionID, "")
+	if err != nil {
+		restError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := h.DB.Now()
+	typing := []string{}
+	connected := map[string]bool{}
+	if h.Hub != nil {
+		// Typing expires on the wall clock the hub keeps, not the database's.
+		topic := realtime.MessagesTopic(sessionID)
+		typing = h.Hub.Typing(topic, time.Now())
+		connected = h.Hub.Connected(topic)
+	}
+	isTyping := make(map[string]bool, len(typing))
+	for _, name := range typing {
+		isTyping[name] = true
+	}
+	presence := make([]presenceEntry, 0, len(participants))
+	for _, p := range participants {
+		presence = append(presence, presenceEntry{
+			Participant: p,
+			Online:      connected[p.ID] || now.Sub(p.LastSeenAt) < onlineWindow,
+			Typing:      isTyping[p.DisplayName],
+			LastReadSeq: readSeq(messages, p),
+			UnreadCount: unreadCount(messages, p),
+		})
+	}
+
+	snap := sessionSnapshot{Session: session, Messages: messages, Presence: presence, Typing: typing, Reactions: []db.Reaction{}, LastEventID: lastEventID}
+	if len(messages) > limit {
+		snap.Messages = messages[len(messages)-limit:]
+		snap.HasMore = true
+	}
+	shown := make(map[string]bool, len(snap.Messages))
+	for _, m := range snap.Messages {
+		shown[m.ID] = true
+	}
+	for _, re := range reactions {
+		if shown[re.MessageID] {
+			snap.Reactions = append(snap.Reactions, re)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snap)
+}
+
+// readSeq returns the seq of p's read position, 0 if p has read nothing.
+func readSeq(messages []db.Message, p db.Participant) int64 {
+	if p.LastReadMessageID != nil {
+		for _, m := range messages {
+			if m.ID == *p.LastReadMessageID {
+				return m.Seq
+			}
+		}
+	}
+	return 0
+}
+
+// unreadCount counts the messages after p's read position that p didn't send.
+func unreadCount(messages []db.Message, p db.Participant) int {
+	read := readSeq(messages, p)
+	unread := 0
+	for _, m := range messages {
+		if m.Seq > read && (m.SenderName == nil || *m.SenderName != p.DisplayName) {
+			unread++
+		}
+	}
+	return unread
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/outbound"
+	"chat-quick-chat-server/internal/scheduler"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// storageUsage tracks the bytes under StorageDir. It is computed by a walk on
+// first use and then adjusted on every upload and removal.
+type storageUsage struct {
+	mu    sync.Mutex
+	known bool
+	bytes int64
+}
+
+func (h *Handler) storageBytes() (int64, error) {
+	h.usage.mu.Lock()
+	defer h.usage.mu.Unlock()
+
+	if !h.usage.known {
+		var total int64
+		err := filepath.Walk(h.StorageDir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && !strings.HasPrefix(filepath.Base(p), ".") {
+				total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		h.usage.bytes, h.usage.known = total, true
+	}
+	return h.usage.bytes, nil
+}
+
+func (h *Handler) adjustStorage(delta int64) {
+	h.usage.mu.Lock()
+	defer h.usage.mu.Unlock()
+	if h.usage.known {
+		h.usage.bytes += delta
+	}
+}
+
+// resetStorageUsage forces a fresh walk, e.g. after a restore replaced the
+// storage directory.
+func (h *Handler) resetStorageUsage() {
+	h.usage.mu.Lock()
+	defer h.usage.mu.Unlock()
+	h.usage.known = false
+}
+
+// handleStats serves GET /admin/v1/stats.
+func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
+	bytes, err := h.storageBytes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats := struct {
+		db.Stats
+		StorageBytes int64 `json:"storage_bytes"`
+		// DiskFull is set while saves fail for lack of space; UnsavedChanges
+		// are held in memory meanwhile and ReadOnly means the backlog is
+		// full and writes are refused.
+		DiskFull       bool `json:"disk_full"`
+		UnsavedChanges int  `json:"unsaved_changes"`
+		ReadOnly       bool `json:"read_only"`
+		// Outbound is the circuit of each external service called so far.
+		Outbound []outbound.Health `json:"outbound"`
+		Limit    db.LimitStatus    `json:"limit"`
+		// SLA is how sessions did against each SLA target.
+		SLA map[string]scheduler.SLAStats `json:"sla,omitempty"`
+	}{Stats: h.DB.Stats(), StorageBytes: bytes, ReadOnly: h.DB.ReadOnly(), Outbound: outbound.Status(), Limit: h.DB.LimitStatus()}
+	stats.DiskFull, stats.UnsavedChanges = h.DB.DiskStatus()
+	if h.SLA != nil {
+		stats.SLA = h.SLA.Stats(h.DB.Now())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
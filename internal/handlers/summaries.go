@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"encoding/json"
+	"net/http"
+)
+
+// maxSummarySessions bounds one session_summaries call.
+const maxSummarySessions = 200
+
+// Session statuses reported by session_summaries.
+const (
+	statusOpen     = "open"
+	statusClosed   = "closed"
+	statusMerged   = "merged"
+	statusNotFound = "not_found"
+)
+
+type sessionSummary struct {
+	SessionID    string          `json:"session_id"`
+	Status       string          `json:"status"`
+	Session      *db.ChatSession `json:"session"`
+	LastMessage  *db.Message     `json:"last_message"`
+	MessageCount int             `json:"message_count"`
+	// UnreadCount is relative to the display_name of the request, nil
+	// without one.
+	UnreadCount *int `json:"unread_count"`
+}
+
+// handleSessionSummaries serves POST /rest/v1/rpc/session_summaries with
+// {"session_ids": [...], "display_name": "agent"} and returns one summary per
+// ID, in request order, for inbox views.
+func (h *Handler) handleSessionSummaries(w http.ResponseWriter, r *http.Request) {
+	if !requireServiceRole(w, r, "reading across sessions") {
+		return
+	}
+	var body struct {
+		SessionIDs  []string `json:"session_ids"`
+		DisplayName string   `json:"display_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		invalidBody(w, err)
+		return
+	}
+	if len(body.SessionIDs) == 0 {
+		restError(w, "session_ids is required", http.StatusBadRequest)
+		return
+	}
+	if len(body.SessionIDs) > maxSummarySessions {
+		restError(w, "At most 200 session_ids per call", http.StatusBadRequest)
+		return
+	}
+
+	h.setLastEventID(w)
+	summaries := make([]sessionSummary, 0, len(body.SessionIDs))
+	for _, id := range body.SessionIDs {
+		sum := sessionSummary{SessionID: id, Status: statusNotFound}
+		session, err := h.DB.GetSession(id)
+		if err != nil {
+			summaries = append(summaries, sum)
+			continue
+		}
+		sum.Session = session
+		switch {
+		case session.MergedInto != nil:
+			sum.Status = statusMerged
+		case session.ClosedAt != nil:
+			sum.Status = statusClosed
+		default:
+			sum.Status = statusOpen
+		}
+
+		messages, err := h.DB.GetMessages(id)
+		if err != nil {
+			restError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sum.MessageCount = len(messages)
+		if n := len(messages); n > 0 {
+			sum.LastMessage = &messages[n-1]
+		}
+		if body.DisplayName != "" {
+			// Someone who never joined hasn't read anything.
+			p := db.Participant{DisplayName: body.DisplayName}
+			participants, err := h.DB.GetParticipants(id)
+			if err != nil {
+				restError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for _, candidate := range participants {
+				if candidate.DisplayName == body.DisplayName {
+					p = candidate
+				}
+			}
+			unread := unreadCount(messages, p)
+			sum.UnreadCount = &unread
+		}
+		summaries = append(summaries, sum)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
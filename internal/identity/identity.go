@@ -0,0 +1,123 @@
+package identity
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalid is returned for tokens that are malformed, expired, signed by
+// an unknown key or meant for someone else.
+var ErrInvalid = errors.New("invalid identity token")
+
+const (
+	// keysTTL is how long a fetched key set is used before it is refreshed.
+	keysTTL = time.Hour
+	// refetchAfter rate-limits refreshes triggered by an unknown key ID, so
+	// tokens with made-up kids can't make us hammer the integrator.
+	refetchAfter = time.Minute
+	// leeway absorbs clock skew when checking exp and nbf.
+	leeway = time.Minute
+)
+
+// Verifier checks identity tokens: JWTs an integrator's backend signs for
+// its logged-in users, verified against the integrator's published JWKS.
+// RS256/384/512, ES256/384/512 and EdDSA (Ed25519) are accepted.
+type Verifier struct {
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+
+	keys KeySet
+}
+
+func New(jwksURL string) *Verifier {
+	return &Verifier{keys: KeySet{URL: jwksURL}}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type claims struct {
+	Sub   string          `json:"sub"`
+	Email string          `json:"email"`
+	Name  string          `json:"name"`
+	Iss   string          `json:"iss"`
+	Aud   json.RawMessage `json:"aud"`
+	Exp   *float64        `json:"exp"`
+	Nbf   *float64        `json:"nbf"`
+}
+
+// Verify checks token and returns the identity it asserts. Tokens must carry
+// sub and exp.
+func (v *Verifier) Verify(token string) (*db.SenderIdentity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	if err := v.keys.Check(h.Alg, h.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, ErrInvalid
+	}
+	now := time.Now()
+	switch {
+	case c.Sub == "", c.Exp == nil:
+		return nil, fmt.Errorf("%w: sub and exp are required", ErrInvalid)
+	case now.After(unixTime(*c.Exp).Add(leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalid)
+	case c.Nbf != nil && now.Add(leeway).Before(unixTime(*c.Nbf)):
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalid)
+	case v.Issuer != "" && c.Iss != v.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalid)
+	case v.Audience != "" && !hasAudience(c.Aud, v.Audience):
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalid)
+	}
+	return &db.SenderIdentity{ExternalID: c.Sub, Email: c.Email, Name: c.Name}, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func unixTime(f float64) time.Time {
+	return time.Unix(int64(f), 0)
+}
+
+// hasAudience reports whether the aud claim, a string or an array of
+// strings, contains want.
+func hasAudience(raw json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == want
+	}
+	var many []string
+	json.Unmarshal(raw, &many)
+	for _, a := range many {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package identity
+
+import (
+	"chat-quick-chat-server/internal/outbound"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxJWKSSize bounds how much of a key set is read.
+const maxJWKSSize = 1 << 20
+
+// jwk is one entry of a JSON Web Key Set. Only the public members are read.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeySet is a JSON Web Key Set published at URL, fetched on first use and
+// refreshed hourly or when a token names a key it doesn't know.
+type KeySet struct {
+	URL string
+	// Service names the key set's owner for outbound circuit breaking and
+	// logs; empty means "identity".
+	Service string
+
+	mu      sync.Mutex
+	keys    []key
+	fetched time.Time
+}
+
+func (s *KeySet) service() string {
+	if s.Service == "" {
+		return "identity"
+	}
+	return s.Service
+}
+
+// Check verifies sig over signed with a key of the set that alg and kid
+// select. RS256/384/512, ES256/384/512 and EdDSA (Ed25519) are accepted.
+func (s *KeySet) Check(alg, kid, signed string, sig []byte) error {
+	var hashFn func() hash.Hash
+	var cryptoHash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hashFn, cryptoHash = sha256.New, crypto.SHA256
+	case "RS384", "ES384":
+		hashFn, cryptoHash = sha512.New384, crypto.SHA384
+	case "RS512", "ES512":
+		hashFn, cryptoHash = sha512.New, crypto.SHA512
+	case "EdDSA":
+	default:
+		// "none" and the HMAC algorithms have no place with a public key set.
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalid, alg)
+	}
+	var digest []byte
+	if hashFn != nil {
+		d := hashFn()
+		d.Write([]byte(signed))
+		digest = d.Sum(nil)
+	}
+
+	for _, k := range s.candidates(kid) {
+		switch pub := k.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(pub, cryptoHash, digest, sig) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			if strings.HasPrefix(alg, "ES") && len(sig) == 2*size {
+				r := new(big.Int).SetBytes(sig[:size])
+				sv := new(big.Int).SetBytes(sig[size:])
+				if ecdsa.Verify(pub, digest, r, sv) {
+					return nil
+				}
+			}
+		case ed25519.PublicKey:
+			if alg == "EdDSA" && ed25519.Verify(pub, []byte(signed), sig) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: bad signature", ErrInvalid)
+}
+
+// candidates returns the keys a token with key ID kid may be signed with,
+// refreshing the key set when it is stale or doesn't know kid. A failed
+// refresh keeps the keys fetched before.
+func (s *KeySet) candidates(kid string) []crypto.PublicKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	match := func() []crypto.PublicKey {
+		var out []crypto.PublicKey
+		for _, k := range s.keys {
+			if kid == "" || k.id == kid {
+				out = append(out, k.pub)
+			}
+		}
+		return out
+	}
+	found := match()
+	age := time.Since(s.fetched)
+	if age > keysTTL || len(found) == 0 && age > refetchAfter {
+		s.fetched = time.Now()
+		keys, err := fetchKeys(s.service(), s.URL)
+		if err != nil {
+			log.Printf("Fetching %s keys from %s failed: %v", s.service(), s.URL, err)
+		} else {
+			s.keys = keys
+			found = match()
+		}
+	}
+	return found
+}
+
+// fetchKeys downloads the key set at url. Keys of unsupported types are
+// skipped; keys without a kid are stored under "".
+func fetchKeys(service, url string) ([]key, error) {
+	client := outbound.Client(service, 10*time.Second)
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, err
+	}
+	var keys []key
+	for _, k := range set.Keys {
+		if pub, err := k.publicKey(); err == nil {
+			keys = append(keys, key{id: k.Kid, pub: pub})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable keys")
+	}
+	return keys, nil
+}
+
+type key struct {
+	id  string
+	pub crypto.PublicKey
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("bad Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("bad key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
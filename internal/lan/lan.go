@@ -0,0 +1,72 @@
+package lan
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/hashicorp/mdns"
+	"github.com/skip2/go-qrcode"
+)
+
+// ServiceType is the DNS-SD service the server advertises in LAN mode.
+const ServiceType = "_http._tcp"
+
+// LocalIPs returns the non-loopback unicast addresses of the machine's up
+// interfaces, IPv4 first.
+func LocalIPs() ([]net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var v4, v6 []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || !ipnet.IP.IsGlobalUnicast() {
+				continue
+			}
+			if ip4 := ipnet.IP.To4(); ip4 != nil {
+				v4 = append(v4, ip4)
+			} else {
+				v6 = append(v6, ipnet.IP)
+			}
+		}
+	}
+	return append(v4, v6...), nil
+}
+
+// Advertise announces the server over mDNS under the given instance name
+// until the returned server is shut down.
+func Advertise(instance string, port int, ips []net.IP, widgetURL string) (*mdns.Server, error) {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "quick-chat"
+	}
+	txt := []string{"path=/", "url=" + widgetURL}
+	service, err := mdns.NewMDNSService(instance, ServiceType, "", host+".", port, ips, txt)
+	if err != nil {
+		return nil, err
+	}
+	return mdns.NewServer(&mdns.Config{Zone: service})
+}
+
+// PrintQR writes url as a terminal-renderable QR code followed by the URL
+// itself, for scanning with a phone.
+func PrintQR(w io.Writer, url string) error {
+	qr, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(w, qr.ToSmallString(false))
+	fmt.Fprintf(w, "%s\n", url)
+	return nil
+}
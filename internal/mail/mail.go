@@ -0,0 +1,64 @@
+// Package mail sends plain-text email through an SMTP relay.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP is a relay reached at Addr (host:port). Username and Password, when
+// set, are sent with PLAIN auth, which net/smtp only allows over TLS or to
+// localhost; STARTTLS is used whenever the server offers it.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers one message with a UTF-8 text body to every address in to.
+func (s *SMTP) Send(to []string, subject, body string) error {
+	if len(to) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, envelope(s.From), to, msg.Bytes())
+}
+
+// envelope extracts the bare address from a From header such as
+// "Quick Chat <chat@example.com>".
+func envelope(from string) string {
+	if i := strings.LastIndex(from, "<"); i >= 0 {
+		return strings.TrimSuffix(from[i+1:], ">")
+	}
+	return from
+}
@@ -0,0 +1,137 @@
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned without trying while a provider's circuit is open.
+var ErrOpen = errors.New("circuit open")
+
+// Circuit states.
+const (
+	Closed   = "closed"
+	Open     = "open"
+	HalfOpen = "half_open"
+)
+
+// Health is the state of one provider's circuit.
+type Health struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	// ConsecutiveFailures counts failures since the last success.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// Requests, Failures and Rejected count since the start; rejected
+	// requests were refused by the open circuit and never sent.
+	Requests    int64      `json:"requests"`
+	Failures    int64      `json:"failures"`
+	Rejected    int64      `json:"rejected"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastFailure *time.Time `json:"last_failure"`
+	OpenedAt    *time.Time `json:"opened_at"`
+}
+
+// breaker stops calling a provider that keeps failing. After threshold
+// failures in a row the circuit opens and requests fail at once with
+// ErrOpen; after cooldown one request is let through, and its outcome
+// closes or reopens the circuit. Transport errors, timeouts and 5xx or 429
+// answers are failures; refusals of the private-network guard are not.
+type breaker struct {
+	next      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+
+	mu      sync.Mutex
+	health  Health
+	probing bool
+}
+
+func (b *breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := b.allow(time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := b.next.RoundTrip(req)
+	switch {
+	case err != nil && errors.Is(err, ErrBlocked):
+		b.done(nil)
+	case err != nil:
+		b.done(err)
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		b.done(fmt.Errorf("%s", resp.Status))
+	default:
+		b.done(nil)
+	}
+	return resp, err
+}
+
+func (b *breaker) allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.health.State == Open && now.Sub(*b.health.OpenedAt) >= b.cooldown {
+		b.health.State = HalfOpen
+	}
+	if b.health.State == Open || b.health.State == HalfOpen && b.probing {
+		b.health.Rejected++
+		return fmt.Errorf("%s: %w", b.health.Provider, ErrOpen)
+	}
+	if b.health.State == HalfOpen {
+		b.probing = true
+	}
+	b.health.Requests++
+	return nil
+}
+
+func (b *breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		if b.health.State != Closed {
+			log.Printf("outbound: %s recovered, circuit closed", b.health.Provider)
+		}
+		b.health.State, b.health.ConsecutiveFailures, b.health.OpenedAt = Closed, 0, nil
+		return
+	}
+	now := time.Now().UTC()
+	b.health.Failures++
+	b.health.ConsecutiveFailures++
+	b.health.LastError, b.health.LastFailure = err.Error(), &now
+	if b.health.State == HalfOpen || b.health.ConsecutiveFailures >= b.threshold {
+		if b.health.State != Open {
+			log.Printf("outbound: %s failed %d times in a row (%v), circuit open for %s", b.health.Provider, b.health.ConsecutiveFailures, err, b.cooldown)
+		}
+		b.health.State, b.health.OpenedAt = Open, &now
+	}
+}
+
+func (b *breaker) snapshot() Health {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.health
+	if h.State == Open && time.Since(*h.OpenedAt) >= b.cooldown {
+		h.State = HalfOpen
+	}
+	return h
+}
+
+// Status lists the circuits of the providers called so far, by name.
+func Status() []Health {
+	mu.Lock()
+	breakers := make([]*breaker, 0, len(clients))
+	for _, b := range clients {
+		breakers = append(breakers, b)
+	}
+	mu.Unlock()
+
+	out := make([]Health, 0, len(breakers))
+	for _, b := range breakers {
+		out = append(out, b.snapshot())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Provider < out[j].Provider })
+	return out
+}
@@ -0,0 +1,243 @@
+// Package outbound builds the HTTP clients for calls to other services
+// (webhooks, feeds, payment and ticket APIs, S3), so that proxying, the
+// private-network guard and circuit breaking apply to all of them.
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrBlocked is returned for requests to addresses the guard refuses.
+var ErrBlocked = errors.New("destination is not a public address")
+
+// Direct as an override sends a provider's requests without a proxy.
+const Direct = "direct"
+
+// Config controls outbound requests.
+type Config struct {
+	// Proxy is used by every provider without an override: an http://,
+	// https://, socks5:// or socks5h:// URL, with user:password if the proxy
+	// wants it. Empty falls back to HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	Proxy string
+	// Overrides maps provider names to their own proxy URL, or Direct.
+	Overrides map[string]string
+	// AllowPrivate lets requests reach loopback, private, link-local and
+	// other non-public addresses. By default they are refused, so that
+	// URLs from configuration or webhooks can't probe the internal network.
+	AllowPrivate bool
+	// AllowNets are non-public ranges that may be reached anyway.
+	AllowNets []netip.Prefix
+	// BreakerFailures failures in a row open a provider's circuit for
+	// BreakerCooldown. Zero means 5 failures and 30 seconds.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+var (
+	mu      sync.Mutex
+	config  = &Config{}
+	clients = make(map[string]*breaker)
+)
+
+// Setup replaces the configuration. Clients made before keep the old one.
+func Setup(c Config) error {
+	for _, p := range append([]string{c.Proxy}, values(c.Overrides)...) {
+		if p == "" || p == Direct {
+			continue
+		}
+		if _, err := parseProxy(p); err != nil {
+			return err
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	config = &c
+	clients = make(map[string]*breaker)
+	return nil
+}
+
+func values(m map[string]string) []string {
+	var out []string
+	for _, v := range m {
+		out = append(out, v)
+	}
+	return out
+}
+
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", s, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: scheme must be http, https, socks5 or socks5h", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: no host", s)
+	}
+	return u, nil
+}
+
+// Client returns a client for provider's requests. The transport and its
+// circuit breaker are shared per provider, so connections are reused and
+// failures add up across calls.
+func Client(provider string, timeout time.Duration) *http.Client {
+	mu.Lock()
+	defer mu.Unlock()
+	b := clients[provider]
+	if b == nil {
+		b = &breaker{
+			next:      config.transport(provider),
+			threshold: config.BreakerFailures,
+			cooldown:  config.BreakerCooldown,
+			health:    Health{Provider: provider, State: Closed},
+		}
+		if b.threshold <= 0 {
+			b.threshold = 5
+		}
+		if b.cooldown <= 0 {
+			b.cooldown = 30 * time.Second
+		}
+		clients[provider] = b
+	}
+	return &http.Client{Timeout: timeout, Transport: b}
+}
+
+func (c *Config) transport(provider string) *http.Transport {
+	g := &guard{allowPrivate: c.AllowPrivate, allowNets: c.AllowNets}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxy := c.Proxy
+	if p, ok := c.Overrides[provider]; ok {
+		proxy = p
+	}
+	switch proxy {
+	case Direct:
+		t.Proxy = nil
+	case "":
+		t.Proxy = g.proxy(http.ProxyFromEnvironment)
+	default:
+		// Validated by Setup.
+		u, _ := parseProxy(proxy)
+		t.Proxy = g.proxy(http.ProxyURL(u))
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: g.control}
+	trusted := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if g.isProxy(addr) {
+			return trusted.DialContext(ctx, network, addr)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return t
+}
+
+// guard refuses connections to non-public addresses. Direct connections are
+// checked at the address actually dialled, after DNS, so a name that
+// resolves to an internal address is caught too. The operator's proxies are
+// trusted; requests through them are checked by resolving the destination
+// here, which is the best that can be done without seeing the proxy's DNS.
+type guard struct {
+	allowPrivate bool
+	allowNets    []netip.Prefix
+
+	mu      sync.Mutex
+	proxies map[string]bool
+}
+
+// cgnat is the shared address space carriers and some clouds use internally.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+func (g *guard) allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if g.allowPrivate || ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnat.Contains(ip) {
+		return true
+	}
+	for _, n := range g.allowNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *guard) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !g.allowed(ip) {
+		return fmt.Errorf("%w: %s", ErrBlocked, ip)
+	}
+	return nil
+}
+
+// proxy wraps a Transport.Proxy function: the destination is checked before
+// a proxy is used, and the proxy's address is remembered as trusted.
+func (g *guard) proxy(next func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := next(req)
+		if err != nil || u == nil {
+			return u, err
+		}
+		if err := g.checkHost(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+		g.mu.Lock()
+		if g.proxies == nil {
+			g.proxies = make(map[string]bool)
+		}
+		g.proxies[proxyAddr(u)] = true
+		g.mu.Unlock()
+		return u, nil
+	}
+}
+
+func (g *guard) isProxy(addr string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.proxies[addr]
+}
+
+func (g *guard) checkHost(ctx context.Context, host string) error {
+	if g.allowPrivate {
+		return nil
+	}
+	ips := []netip.Addr{}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = append(ips, ip)
+	} else if resolved, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host); err == nil {
+		ips = resolved
+	}
+	// Names only the proxy can resolve are left to it.
+	for _, ip := range ips {
+		if !g.allowed(ip) {
+			return fmt.Errorf("%w: %s (%s)", ErrBlocked, host, ip)
+		}
+	}
+	return nil
+}
+
+// proxyAddr is the host:port the transport dials for proxy u.
+func proxyAddr(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[u.Scheme]
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/realtime"
+	"log"
+	"time"
+)
+
+// columns maps outbox tables to the column lists announced to clients.
+var columns = map[string][]realtime.Column{
+	"messages":  realtime.MessageColumns,
+	"reactions": realtime.ReactionColumns,
+}
+
+// Dispatcher publishes the database's outbox events to the realtime hub.
+// Events are delivered at least once: anything left unsent by a crash is
+// published again on the next start.
+type Dispatcher struct {
+	DB  *db.Database
+	Hub *realtime.Hub
+	// Interval is a fallback poll in case a wake-up is missed, or a mark
+	// failed to save.
+	Interval time.Duration
+	// Subscribers see every event after it is published to the hub, with
+	// the same at-least-once guarantee. They run on the dispatch goroutine
+	// and must not block.
+	Subscribers []func(db.OutboxEvent)
+}
+
+// Run blocks, dispatching whenever the database signals new events.
+func (d *Dispatcher) Run() {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	d.dispatch()
+	for {
+		select {
+		case <-d.DB.OutboxReady():
+		case <-ticker.C:
+		}
+		d.dispatch()
+	}
+}
+
+func (d *Dispatcher) dispatch() {
+	pending := d.DB.PendingOutbox()
+	if len(pending) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(pending))
+	for _, e := range pending {
+		d.publish(e)
+		for _, sub := range d.Subscribers {
+			sub(e)
+		}
+		ids = append(ids, e.ID)
+	}
+	if err := d.DB.MarkOutboxSent(ids); err != nil {
+		log.Printf("outbox: failed to mark %d events sent: %v", len(ids), err)
+	}
+}
+
+func (d *Dispatcher) publish(e db.OutboxEvent) {
+	topic := realtime.MessagesTopic(e.SessionID)
+	if e.Inbox != "" {
+		topic = realtime.InboxTopic(e.Inbox)
+	}
+	switch e.Kind {
+	case db.OutboxChange:
+		if e.Type == "DELETE" {
+			d.Hub.BroadcastDelete(e.Seq, topic, e.Table, e.CreatedAt, e.Old, columns[e.Table])
+		} else {
+			d.Hub.BroadcastChange(e.Seq, topic, e.Table, e.Type, e.CreatedAt, e.Record, columns[e.Table])
+		}
+	case db.OutboxBroadcast:
+		d.Hub.BroadcastEvent(e.Seq, topic, e.Event, e.Record)
+	}
+}
@@ -0,0 +1,107 @@
+package payment
+
+import (
+	"chat-quick-chat-server/internal/outbound"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrSignature is returned for webhook calls that don't carry a valid
+// signature.
+var ErrSignature = errors.New("invalid webhook signature")
+
+// Request is a payment to collect in a chat session. Amount is in minor
+// units.
+type Request struct {
+	Amount      int64
+	Currency    string
+	Description string
+	SessionID   string
+}
+
+// Checkout is a hosted payment page created by a provider.
+type Checkout struct {
+	ID  string
+	URL string
+}
+
+// Update is a status change reported by a provider webhook; Status is
+// db.PaymentPaid or db.PaymentExpired.
+type Update struct {
+	CheckoutID string
+	Status     string
+}
+
+// Provider creates checkouts and reads the webhooks that report on them.
+// ParseWebhook returns nil, nil for events that don't concern a checkout's
+// outcome.
+type Provider interface {
+	Name() string
+	CreateCheckout(req Request) (*Checkout, error)
+	ParseWebhook(r *http.Request) (*Update, error)
+}
+
+// Config is the content of PAYMENT_CONFIG. Provider selects which of the
+// sections is used:
+//
+//	{"provider": "stripe", "stripe": {"secret_key": "sk_...", "webhook_secret": "whsec_...", "success_url": "...", "cancel_url": "..."}}
+//	{"provider": "webhook", "webhook": {"url": "...", "secret": "...", "id_field": "id", "url_field": "checkout_url"}}
+type Config struct {
+	Provider string   `json:"provider"`
+	Stripe   *Stripe  `json:"stripe"`
+	Webhook  *Webhook `json:"webhook"`
+}
+
+func LoadConfig(path string) (Provider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var p Provider
+	var missing bool
+	switch cfg.Provider {
+	case "stripe":
+		p, missing = cfg.Stripe, cfg.Stripe == nil || cfg.Stripe.SecretKey == "" || cfg.Stripe.WebhookSecret == ""
+	case "webhook":
+		p, missing = cfg.Webhook, cfg.Webhook == nil || cfg.Webhook.URL == "" || cfg.Webhook.Secret == ""
+	default:
+		return nil, fmt.Errorf("%s: provider must be stripe or webhook", path)
+	}
+	if missing {
+		return nil, fmt.Errorf("%s: %s section is incomplete", path, cfg.Provider)
+	}
+	return p, nil
+}
+
+// maxWebhookBody bounds the webhook payloads read.
+const maxWebhookBody = 1 << 20
+
+func readBody(r *http.Request) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+}
+
+// send performs a request and decodes a JSON answer into out.
+func send(req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	client := outbound.Client("payment", 20*time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
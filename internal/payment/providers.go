@@ -0,0 +1,235 @@
+package payment
+
+import (
+	"bytes"
+	"chat-quick-chat-server/internal/db"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeTolerance is how old a signed Stripe webhook may be.
+const stripeTolerance = 5 * time.Minute
+
+// Stripe collects payments with Checkout Sessions. WebhookSecret is the
+// signing secret of the endpoint registered for
+// checkout.session.completed, checkout.session.async_payment_succeeded and
+// checkout.session.expired. APIURL defaults to https://api.stripe.com.
+type Stripe struct {
+	SecretKey     string `json:"secret_key"`
+	WebhookSecret string `json:"webhook_secret"`
+	SuccessURL    string `json:"success_url"`
+	CancelURL     string `json:"cancel_url"`
+	APIURL        string `json:"api_url"`
+}
+
+func (s *Stripe) Name() string { return "stripe" }
+
+func (s *Stripe) CreateCheckout(req Request) (*Checkout, error) {
+	api := s.APIURL
+	if api == "" {
+		api = "https://api.stripe.com"
+	}
+	name := req.Description
+	if name == "" {
+		name = "Payment"
+	}
+	form := url.Values{
+		"mode":                                          {"payment"},
+		"client_reference_id":                           {req.SessionID},
+		"metadata[session_id]":                          {req.SessionID},
+		"line_items[0][quantity]":                       {"1"},
+		"line_items[0][price_data][currency]":           {req.Currency},
+		"line_items[0][price_data][unit_amount]":        {strconv.FormatInt(req.Amount, 10)},
+		"line_items[0][price_data][product_data][name]": {name},
+	}
+	if s.SuccessURL != "" {
+		form.Set("success_url", s.SuccessURL)
+	}
+	if s.CancelURL != "" {
+		form.Set("cancel_url", s.CancelURL)
+	}
+	httpReq, err := http.NewRequest("POST", strings.TrimSuffix(api, "/")+"/v1/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.SetBasicAuth(s.SecretKey, "")
+	var session struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := send(httpReq, &session); err != nil {
+		return nil, err
+	}
+	return &Checkout{ID: session.ID, URL: session.URL}, nil
+}
+
+func (s *Stripe) ParseWebhook(r *http.Request) (*Update, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	if !s.validSignature(r.Header.Get("Stripe-Signature"), body, time.Now()) {
+		return nil, ErrSignature
+	}
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID            string `json:"id"`
+				PaymentStatus string `json:"payment_status"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	obj := event.Data.Object
+	switch event.Type {
+	case "checkout.session.completed":
+		// Delayed methods (bank debits) complete unpaid and succeed later.
+		if obj.PaymentStatus != "paid" && obj.PaymentStatus != "no_payment_required" {
+			return nil, nil
+		}
+		return &Update{CheckoutID: obj.ID, Status: db.PaymentPaid}, nil
+	case "checkout.session.async_payment_succeeded":
+		return &Update{CheckoutID: obj.ID, Status: db.PaymentPaid}, nil
+	case "checkout.session.expired":
+		return &Update{CheckoutID: obj.ID, Status: db.PaymentExpired}, nil
+	}
+	return nil, nil
+}
+
+// validSignature checks a Stripe-Signature header, "t=<unix>,v1=<hex>",
+// against the payload.
+func (s *Stripe) validSignature(header string, body []byte, now time.Time) bool {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > stripeTolerance || d < -stripeTolerance {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.WebhookSecret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	want := mac.Sum(nil)
+	for _, sig := range sigs {
+		if got, err := hex.DecodeString(sig); err == nil && hmac.Equal(got, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook works with any payment service through a small adapter. Checkouts
+// are created by POSTing {"amount", "currency", "description",
+// "session_id"} to URL; IDField and URLField name the answer's fields
+// (default "id" and "url"). Status changes are POSTed back as
+// {"checkout_id": "...", "status": "paid" | "expired"} with the hex
+// HMAC-SHA256 of the body under Secret in SignatureHeader (default
+// X-Signature).
+type Webhook struct {
+	URL             string            `json:"url"`
+	Headers         map[string]string `json:"headers"`
+	Secret          string            `json:"secret"`
+	SignatureHeader string            `json:"signature_header"`
+	IDField         string            `json:"id_field"`
+	URLField        string            `json:"url_field"`
+}
+
+func (w *Webhook) Name() string { return "webhook" }
+
+func (w *Webhook) CreateCheckout(req Request) (*Checkout, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"amount":      req.Amount,
+		"currency":    req.Currency,
+		"description": req.Description,
+		"session_id":  req.SessionID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	var resp map[string]interface{}
+	if err := send(httpReq, &resp); err != nil {
+		return nil, err
+	}
+	idField, urlField := w.IDField, w.URLField
+	if idField == "" {
+		idField = "id"
+	}
+	if urlField == "" {
+		urlField = "url"
+	}
+	c := &Checkout{ID: field(resp, idField), URL: field(resp, urlField)}
+	if c.ID == "" || c.URL == "" {
+		return nil, fmt.Errorf("response has no %s or %s", idField, urlField)
+	}
+	return c, nil
+}
+
+func (w *Webhook) ParseWebhook(r *http.Request) (*Update, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	header := w.SignatureHeader
+	if header == "" {
+		header = "X-Signature"
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(header), "sha256="))
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrSignature
+	}
+	var u struct {
+		CheckoutID string `json:"checkout_id"`
+		Status     string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &u); err != nil {
+		return nil, err
+	}
+	if u.CheckoutID == "" || (u.Status != db.PaymentPaid && u.Status != db.PaymentExpired) {
+		return nil, nil
+	}
+	return &Update{CheckoutID: u.CheckoutID, Status: u.Status}, nil
+}
+
+// field formats a top-level string or number of a decoded JSON object.
+func field(m map[string]interface{}, key string) string {
+	switch v := m[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,233 @@
+// Package policy decides which rows a requester may read and write, the
+// way Supabase protects Postgres tables with row-level security: once a
+// table has a policy, only rows some policy for the requester's role lets
+// through are visible, and only rows some policy's check accepts can be
+// written. Tables without policies are open, and service_role bypasses
+// them all.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Action is what a statement does to a row.
+type Action string
+
+const (
+	Select Action = "select"
+	Insert Action = "insert"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// BypassRole is the role policies don't apply to, like Postgres's
+// BYPASSRLS.
+const BypassRole = "service_role"
+
+// Requester is who a request acts for.
+type Requester struct {
+	Role string
+	// Claims are the access token's claims, nil without one.
+	Claims map[string]interface{}
+}
+
+// Row is a row as its JSON columns.
+type Row map[string]interface{}
+
+// Condition is a policy expression. From a file it compares Column with
+// Claim, a dotted path into the claims such as app_metadata.sessions: the
+// row passes if the column equals the claim or, for an array claim, one of
+// its elements. A row never passes a missing claim. Set in code, Func
+// decides instead.
+type Condition struct {
+	Column string                    `json:"column"`
+	Claim  string                    `json:"claim"`
+	Func   func(Requester, Row) bool `json:"-"`
+}
+
+// Policy is one permissive policy on Table, like CREATE POLICY.
+type Policy struct {
+	Name  string `json:"name"`
+	Table string `json:"table"`
+	// Actions the policy covers; none covers all, as FOR ALL.
+	Actions []Action `json:"actions"`
+	// Roles the policy applies to; none is every role, as TO public.
+	Roles []string `json:"roles"`
+	// Using selects the existing rows the policy exposes to select, update
+	// and delete, and Check the new rows it lets insert and update write;
+	// Check defaults to Using. Nil is true.
+	Using *Condition `json:"using"`
+	Check *Condition `json:"check"`
+}
+
+// Engine holds the policies. A nil Engine allows everything.
+type Engine struct {
+	mu       sync.RWMutex
+	policies []Policy
+}
+
+// policyFile is the format Load reads.
+type policyFile struct {
+	Policies []Policy `json:"policies"`
+}
+
+// Load reads policies from a JSON file of {"policies": [...]}.
+func Load(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f policyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	e := &Engine{}
+	for _, p := range f.Policies {
+		if err := e.Add(p); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return e, nil
+}
+
+// Add adds p to the policies of its table.
+func (e *Engine) Add(p Policy) error {
+	if p.Table == "" {
+		return fmt.Errorf("policy %q: table is required", p.Name)
+	}
+	for _, a := range p.Actions {
+		switch a {
+		case Select, Insert, Update, Delete:
+		default:
+			return fmt.Errorf("policy %q: unknown action %q", p.Name, a)
+		}
+	}
+	for _, c := range []*Condition{p.Using, p.Check} {
+		if c != nil && c.Func == nil && (c.Column == "" || c.Claim == "") {
+			return fmt.Errorf("policy %q: a condition needs column and claim", p.Name)
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policies = append(e.policies, p)
+	return nil
+}
+
+// Protects reports whether table has any policy, and so whether rows of it
+// are hidden unless a policy allows them.
+func (e *Engine) Protects(table string) bool {
+	if e == nil {
+		return false
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, p := range e.policies {
+		if p.Table == table {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible reports whether req may act on the existing row of table: select
+// it, or have update or delete reach it.
+func (e *Engine) Visible(req Requester, table string, action Action, row Row) bool {
+	return e.allows(req, table, action, row, func(p Policy) *Condition { return p.Using })
+}
+
+// Writable reports whether req may insert row into table, or update a row
+// to it.
+func (e *Engine) Writable(req Requester, table string, action Action, row Row) bool {
+	return e.allows(req, table, action, row, func(p Policy) *Condition {
+		if p.Check != nil {
+			return p.Check
+		}
+		return p.Using
+	})
+}
+
+func (e *Engine) allows(req Requester, table string, action Action, row Row, cond func(Policy) *Condition) bool {
+	if e == nil || req.Role == BypassRole {
+		return true
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	protected := false
+	for _, p := range e.policies {
+		if p.Table != table {
+			continue
+		}
+		protected = true
+		if covers(p.Actions, action) && appliesTo(p.Roles, req.Role) && cond(p).holds(req, row) {
+			return true
+		}
+	}
+	return !protected
+}
+
+func covers(actions []Action, action Action) bool {
+	if len(actions) == 0 {
+		return true
+	}
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func appliesTo(roles []string, role string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Condition) holds(req Requester, row Row) bool {
+	switch {
+	case c == nil:
+		return true
+	case c.Func != nil:
+		return c.Func(req, row)
+	}
+	claim, ok := lookup(req.Claims, c.Claim)
+	if !ok {
+		return false
+	}
+	value := row[c.Column]
+	if list, ok := claim.([]interface{}); ok {
+		for _, v := range list {
+			if reflect.DeepEqual(v, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return reflect.DeepEqual(claim, value)
+}
+
+// lookup follows a dotted path into claims.
+func lookup(claims map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok || v == nil {
+			return nil, false
+		}
+	}
+	return v, true
+}
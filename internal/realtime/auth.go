@@ -0,0 +1,72 @@
+package realtime
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// TokenProtocolPrefix marks the Sec-WebSocket-Protocol entry that carries
+// the auth token, as in new WebSocket(url, ["phoenix", "bearer." + token]):
+// browsers can't set an Authorization header on a websocket.
+const TokenProtocolPrefix = "bearer."
+
+// tokenParams are the query parameters a token may still arrive in, as
+// supabase-js sends apikey. They are redacted wherever a URL is logged.
+var tokenParams = []string{"apikey", "access_token", "token"}
+
+// upgradeToken finds the token of an upgrade request: the Authorization
+// header, then the subprotocols, then the query string. protocol is the
+// subprotocol to answer with, "" if the client offered none. It is the
+// first one that isn't the token, or the token entry when that is all
+// there is, since browsers drop connections that get no protocol back.
+func upgradeToken(r *http.Request) (token, protocol string) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	var tokenProtocol string
+	for _, p := range websocket.Subprotocols(r) {
+		if t, ok := strings.CutPrefix(p, TokenProtocolPrefix); ok {
+			if token == "" {
+				token = t
+			}
+			tokenProtocol = p
+		} else if protocol == "" {
+			protocol = p
+		}
+	}
+	if protocol == "" {
+		protocol = tokenProtocol
+	}
+	if token == "" {
+		q := r.URL.Query()
+		for _, name := range tokenParams {
+			if v := q.Get(name); v != "" {
+				token = v
+				break
+			}
+		}
+	}
+	return token, protocol
+}
+
+// RedactURL returns u with the values of token parameters replaced, for
+// logging.
+func RedactURL(u *url.URL) string {
+	q := u.Query()
+	redacted := false
+	for _, name := range tokenParams {
+		if q.Has(name) {
+			q.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	c := *u
+	c.RawQuery = q.Encode()
+	return c.String()
+}
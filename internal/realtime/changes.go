@@ -0,0 +1,95 @@
+package realtime
+
+import "time"
+
+// Column describes one column of a postgres_changes payload.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// MessageColumns lists the columns of the messages table as announced to
+// Supabase Realtime clients.
+var MessageColumns = []Column{
+	{Name: "session_id", Type: "uuid"},
+	{Name: "seq", Type: "int8"},
+	{Name: "content", Type: "text"},
+	{Name: "message_type", Type: "text"},
+	{Name: "file_url", Type: "text"},
+	{Name: "sender_name", Type: "text"},
+	{Name: "sender_verified", Type: "bool"},
+	{Name: "sender_identity", Type: "jsonb"},
+	{Name: "origin", Type: "text"},
+	{Name: "metadata", Type: "jsonb"},
+	{Name: "event", Type: "jsonb"},
+	{Name: "schedule", Type: "jsonb"},
+	{Name: "payment", Type: "jsonb"},
+	{Name: "parent_message_id", Type: "uuid"},
+	{Name: "reply_count", Type: "int4"},
+	{Name: "created_at", Type: "timestamptz"},
+	{Name: "edited_at", Type: "timestamptz"},
+}
+
+// ReactionColumns lists the columns of the reactions table. Reaction changes
+// are sent on the session's messages topic.
+var ReactionColumns = []Column{
+	{Name: "message_id", Type: "uuid"},
+	{Name: "session_id", Type: "uuid"},
+	{Name: "emoji", Type: "text"},
+	{Name: "sender_name", Type: "text"},
+	{Name: "created_at", Type: "timestamptz"},
+}
+
+// MessagesTopic is the channel topic clients join for a session's messages.
+func MessagesTopic(sessionID string) string {
+	return "realtime:messages:" + sessionID
+}
+
+// InboxTopic is the topic of a tenant's session list.
+func InboxTopic(tenant string) string {
+	return "realtime:inbox:" + tenant
+}
+
+// BroadcastChange publishes a postgres_changes event shaped like the ones
+// Supabase Realtime emits for row changes. eventID is added to the payload
+// as event_id for client-side dedupe.
+func (h *Hub) BroadcastChange(eventID int64, topic, table, eventType string, commitTimestamp time.Time, record interface{}, columns []Column) {
+	h.broadcastChange(eventID, topic, table, eventType, commitTimestamp, record, map[string]interface{}{}, columns)
+}
+
+// BroadcastDelete publishes a DELETE event, which carries the removed row in
+// old and an empty record.
+func (h *Hub) BroadcastDelete(eventID int64, topic, table string, commitTimestamp time.Time, old interface{}, columns []Column) {
+	h.broadcastChange(eventID, topic, table, "DELETE", commitTimestamp, map[string]interface{}{}, old, columns)
+}
+
+func (h *Hub) broadcastChange(eventID int64, topic, table, eventType string, commitTimestamp time.Time, record, old interface{}, columns []Column) {
+	payload := map[string]interface{}{
+		"schema":           "public",
+		"table":            table,
+		"commit_timestamp": commitTimestamp,
+		"type":             eventType,
+		"record":           record,
+		"old":              old,
+		"errors":           nil,
+		"columns":          columns,
+		"event_id":         eventID,
+	}
+
+	data := map[string]interface{}{
+		"data": payload,
+		"ids":  []interface{}{},
+	}
+	h.Broadcast(topic, "postgres_changes", data)
+}
+
+// BroadcastEvent publishes a Supabase Realtime "broadcast" message, which
+// clients receive with channel.on('broadcast', {event}, ...).
+func (h *Hub) BroadcastEvent(eventID int64, topic, event string, payload interface{}) {
+	h.Broadcast(topic, "broadcast", map[string]interface{}{
+		"type":     "broadcast",
+		"event":    event,
+		"payload":  payload,
+		"event_id": eventID,
+	})
+}
@@ -0,0 +1,130 @@
+package realtime
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// typingTimeout is how long a typing broadcast counts for. Clients repeat
+// it while the user types, so a client that goes away stops counting soon.
+const typingTimeout = 6 * time.Second
+
+// TypingEvent is the broadcast event clients send while their user types.
+const TypingEvent = "typing"
+
+// clientBroadcast is the payload of a broadcast a client sends: the event
+// name it chose and the payload relayed with it.
+type clientBroadcast struct {
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// typingPayload is the part of a typing broadcast the server looks at.
+// typing is true when absent; false clears the indicator at once.
+type typingPayload struct {
+	DisplayName string `json:"display_name"`
+	Typing      *bool  `json:"typing"`
+}
+
+// relay passes a broadcast from c on to the other clients on the topic, as
+// Supabase broadcast does without self, and tracks typing broadcasts.
+func (c *Client) relay(msg IncomingMessage, now time.Time) {
+	c.hub.mu.RLock()
+	joined := c.topics[msg.Topic]
+	name := c.names[msg.Topic]
+	c.hub.mu.RUnlock()
+	if !joined {
+		c.sendJSON(OutgoingMessage{
+			Topic:   msg.Topic,
+			Event:   "phx_reply",
+			Ref:     msg.Ref,
+			Payload: map[string]interface{}{"status": "error", "response": map[string]string{"reason": "unmatched topic"}},
+		})
+		return
+	}
+
+	var b clientBroadcast
+	json.Unmarshal(msg.Payload, &b)
+	if b.Event == TypingEvent {
+		var p typingPayload
+		json.Unmarshal(b.Payload, &p)
+		if name == "" {
+			name = p.DisplayName
+		}
+		if name != "" {
+			c.hub.mu.Lock()
+			if p.Typing == nil || *p.Typing {
+				c.hub.startTyping(msg.Topic, name, now)
+			} else {
+				c.hub.stopTyping(msg.Topic, name)
+			}
+			c.hub.mu.Unlock()
+		}
+	}
+
+	c.hub.broadcast <- &BroadcastMessage{
+		Topic:  msg.Topic,
+		Msg:    &OutgoingMessage{Topic: msg.Topic, Event: "broadcast", Payload: msg.Payload},
+		Except: c,
+	}
+	if msg.Ref != "" {
+		c.sendJSON(OutgoingMessage{
+			Topic:   msg.Topic,
+			Event:   "phx_reply",
+			Ref:     msg.Ref,
+			Payload: map[string]interface{}{"status": "ok", "response": map[string]string{}},
+		})
+	}
+}
+
+// startTyping and stopTyping must be called with h.mu held. startTyping
+// also drops the names on topic that timed out.
+func (h *Hub) startTyping(topic, name string, now time.Time) {
+	if h.typing[topic] == nil {
+		h.typing[topic] = make(map[string]time.Time)
+	}
+	for n, until := range h.typing[topic] {
+		if !now.Before(until) {
+			delete(h.typing[topic], n)
+		}
+	}
+	h.typing[topic][name] = now.Add(typingTimeout)
+}
+
+func (h *Hub) stopTyping(topic, name string) {
+	if names, ok := h.typing[topic]; ok {
+		delete(names, name)
+		if len(names) == 0 {
+			delete(h.typing, topic)
+		}
+	}
+}
+
+// Typing returns the names typing on topic as of now, sorted.
+func (h *Hub) Typing(topic string, now time.Time) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	names := make([]string, 0)
+	for name, until := range h.typing[topic] {
+		if now.Before(until) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Connected returns the participant IDs of the clients joined to topic, as
+// OnJoin returned them.
+func (h *Hub) Connected(topic string) map[string]bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ids := make(map[string]bool)
+	for client := range h.topics[topic] {
+		if id := client.participants[topic]; id != "" {
+			ids[id] = true
+		}
+	}
+	return ids
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -52,6 +53,53 @@ type Client struct {
 	conn   *websocket.Conn
 	send   chan []byte
 	topics map[string]bool
+	// participants maps joined topics to the participant ID OnJoin returned,
+	// and names to the display name announced on join. Both are written
+	// under hub.mu.
+	participants map[string]string
+	names        map[string]string
+	// joinTokens is the client's token bucket for joins, as of joinAt.
+	joinTokens float64
+	joinAt     time.Time
+	// apikey is the API key the client connected with, which supabase-js
+	// sends as its access_token until a user signs in.
+	apikey string
+	// expiry closes the connection when its access token expires.
+	expiryMu sync.Mutex
+	expiry   *time.Timer
+}
+
+// JoinPayload is the part of a phx_join payload the server looks at.
+// supabase-js sends the presence key under config, along with anything else
+// passed as channel config, such as a session_token, and the user's
+// access_token beside it; display_name and session_token are also accepted
+// at the top for clients that don't use supabase-js.
+type JoinPayload struct {
+	Config struct {
+		Presence struct {
+			Key string `json:"key"`
+		} `json:"presence"`
+		SessionToken string `json:"session_token"`
+	} `json:"config"`
+	DisplayName  string `json:"display_name"`
+	SessionToken string `json:"session_token"`
+	AccessToken  string `json:"access_token"`
+}
+
+// Token returns the session token a joining client presented, if any.
+func (p JoinPayload) Token() string {
+	if p.SessionToken != "" {
+		return p.SessionToken
+	}
+	return p.Config.SessionToken
+}
+
+// Name returns the display name a joining client announced, if any.
+func (p JoinPayload) Name() string {
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return p.Config.Presence.Key
 }
 
 type Hub struct {
@@ -59,14 +107,63 @@ type Hub struct {
 	broadcast  chan *BroadcastMessage
 	register   chan *Client
 	unregister chan *Client
+	ping       chan chan struct{}
 	topics     map[string]map[*Client]bool
-	mu         sync.RWMutex
+	// typing maps topics to who is typing there, until when.
+	typing map[string]map[string]time.Time
+	mu     sync.RWMutex
+
+	// OnJoin, when set, is called for every join of a session messages topic
+	// and returns the participant ID to track for the connection ("" for
+	// none). OnSeen is then called with that ID on each heartbeat.
+	OnJoin func(sessionID string, payload JoinPayload) string
+	OnSeen func(participantID string)
+	// Authorize, when set, is called at upgrade time with the token the
+	// client presented ("" for none); connections it refuses get a 401.
+	// Nil lets everyone connect.
+	Authorize func(token string) bool
+	// VerifyToken, when set, checks the access tokens clients present: the
+	// token query parameter at upgrade, the access_token of joins, and
+	// access_token events, which replace it before it expires. It returns
+	// when the token expires; the connection is closed then. Nil accepts
+	// them unchecked.
+	VerifyToken func(token string) (expires time.Time, err error)
+	// AuthorizeJoin, when set, is called for every join of a session
+	// messages topic; joins it refuses get ErrorUnauthorized. Nil lets
+	// every connection join every session.
+	AuthorizeJoin func(sessionID string, payload JoinPayload) bool
+	// AuthorizeInbox, when set, is called for every join of a tenant's
+	// inbox topic, which carries whole session rows; joins it refuses get
+	// ErrorUnauthorized. Nil lets every connection join every inbox.
+	AuthorizeInbox func(tenant string, payload JoinPayload) bool
+	// MaxTopics caps the topics one connection may have joined, and
+	// JoinRate how many joins per second it may make, in bursts of up to
+	// JoinRate. Zero means DefaultMaxTopics and DefaultJoinRate; negative
+	// removes the limit. They keep a client from subscribing to every
+	// session by enumeration.
+	MaxTopics int
+	JoinRate  float64
 }
 
+// Defaults for Hub.MaxTopics and Hub.JoinRate.
+const (
+	DefaultMaxTopics = 50
+	DefaultJoinRate  = 10
+)
+
+// Codes of refused joins, in the response of the phx_reply.
+const (
+	ErrorTooManyTopics   = "too_many_topics"
+	ErrorJoinRateLimited = "join_rate_limited"
+	ErrorUnauthorized    = "unauthorized"
+)
+
 type BroadcastMessage struct {
 	Topic string
 	Msg   *OutgoingMessage
 	Ref   *int
+	// Except is left out, as with the sender of a client broadcast.
+	Except *Client
 }
 
 func NewHub() *Hub {
@@ -74,8 +171,10 @@ func NewHub() *Hub {
 		broadcast:  make(chan *BroadcastMessage),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		ping:       make(chan chan struct{}),
 		clients:    make(map[*Client]bool),
 		topics:     make(map[string]map[*Client]bool),
+		typing:     make(map[string]map[string]time.Time),
 	}
 }
 
@@ -98,15 +197,21 @@ func (h *Hub) Run() {
 							delete(h.topics, topic)
 						}
 					}
+					h.stopTyping(topic, client.names[topic])
 				}
 			}
 			h.mu.Unlock()
+		case done := <-h.ping:
+			close(done)
 		case message := <-h.broadcast:
 			h.mu.RLock()
 			if clients, ok := h.topics[message.Topic]; ok {
 				data, err := json.Marshal(message.Msg)
 				if err == nil {
 					for client := range clients {
+						if client == message.Except {
+							continue
+						}
 						select {
 						case client.send <- data:
 						default:
@@ -121,6 +226,19 @@ func (h *Hub) Run() {
 	}
 }
 
+// Alive reports whether Run answers within timeout, i.e. is running and
+// not stuck.
+func (h *Hub) Alive(timeout time.Duration) bool {
+	done := make(chan struct{})
+	select {
+	case h.ping <- done:
+	case <-time.After(timeout):
+		return false
+	}
+	<-done
+	return true
+}
+
 func (h *Hub) Broadcast(topic string, event string, payload interface{}) {
 	msg := &OutgoingMessage{
 		Topic:   topic,
@@ -136,6 +254,7 @@ func (h *Hub) Broadcast(topic string, event string, payload interface{}) {
 
 func (c *Client) readPump() {
 	defer func() {
+		c.expireAt(time.Time{})
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
@@ -164,6 +283,18 @@ func (c *Client) readPump() {
 func (c *Client) handleMessage(msg IncomingMessage) {
 	switch msg.Event {
 	case "phx_join":
+		sessionID := strings.TrimPrefix(msg.Topic, "realtime:messages:")
+		var payload JoinPayload
+		json.Unmarshal(msg.Payload, &payload)
+		if reason := c.refuseJoin(msg.Topic, payload, time.Now()); reason != nil {
+			c.sendJSON(OutgoingMessage{
+				Topic:   msg.Topic,
+				Event:   "phx_reply",
+				Ref:     msg.Ref,
+				Payload: map[string]interface{}{"status": "error", "response": reason},
+			})
+			return
+		}
 		c.hub.mu.Lock()
 		if c.hub.topics[msg.Topic] == nil {
 			c.hub.topics[msg.Topic] = make(map[*Client]bool)
@@ -172,24 +303,41 @@ func (c *Client) handleMessage(msg IncomingMessage) {
 		c.topics[msg.Topic] = true
 		c.hub.mu.Unlock()
 
-		sessionID := msg.Topic[len("realtime:messages:"):]
+		var participantID string
+		if c.hub.OnJoin != nil && sessionID != msg.Topic {
+			participantID = c.hub.OnJoin(sessionID, payload)
+		}
+		c.hub.mu.Lock()
+		if participantID != "" {
+			c.participants[msg.Topic] = participantID
+		}
+		if payload.Name() != "" {
+			c.names[msg.Topic] = payload.Name()
+		}
+		c.hub.mu.Unlock()
+		// Other topics, such as inboxes, carry broadcasts only, so there is
+		// no change subscription to describe.
+		response := map[string]any{}
+		if sessionID != msg.Topic {
+			response = map[string]any{
+				"event":  "INSERT",
+				"filter": fmt.Sprintf("session_id=eq.%s", sessionID),
+				"schema": "public",
+				"table":  "messages",
+			}
+		}
 		reply := OutgoingMessage{
 			Topic: msg.Topic,
 			Event: "phx_reply",
 			Ref:   msg.Ref,
 			Payload: map[string]interface{}{
-				"status": "ok",
-				"response": map[string]any{
-					"event":  "INSERT",
-					"filter": fmt.Sprintf("session_id=eq.%s", sessionID),
-					"schema": "public",
-					"table":  "messages",
-				},
+				"status":   "ok",
+				"response": response,
 			},
 		}
 		c.sendJSON(reply)
 
-		channel := msg.Topic[len("realtime:"):]
+		channel := strings.TrimPrefix(msg.Topic, "realtime:")
 		reply2 := OutgoingMessage{
 			Topic: msg.Topic,
 			Event: "system",
@@ -202,6 +350,11 @@ func (c *Client) handleMessage(msg IncomingMessage) {
 		}
 		c.sendJSON(reply2)
 	case "heartbeat":
+		if c.hub.OnSeen != nil {
+			for _, id := range c.participants {
+				c.hub.OnSeen(id)
+			}
+		}
 		reply := OutgoingMessage{
 			Topic: "phoenix",
 			Event: "phx_reply",
@@ -213,6 +366,29 @@ func (c *Client) handleMessage(msg IncomingMessage) {
 		}
 		c.sendJSON(reply)
 
+	case "broadcast":
+		c.relay(msg, time.Now())
+
+	case "access_token":
+		// supabase-js sends the refreshed token of a signed-in user on
+		// every channel; one that fails keeps the old expiry.
+		var payload struct {
+			AccessToken string `json:"access_token"`
+		}
+		json.Unmarshal(msg.Payload, &payload)
+		if err := c.acceptToken(payload.AccessToken); err != nil {
+			c.sendJSON(OutgoingMessage{
+				Topic: msg.Topic,
+				Event: "system",
+				Payload: map[string]interface{}{
+					"channel":   strings.TrimPrefix(msg.Topic, "realtime:"),
+					"message":   err.Error(),
+					"extension": "system",
+					"status":    "error",
+				},
+			})
+		}
+
 	case "phx_leave":
 		c.hub.mu.Lock()
 		if clients, ok := c.hub.topics[msg.Topic]; ok {
@@ -222,6 +398,9 @@ func (c *Client) handleMessage(msg IncomingMessage) {
 			}
 		}
 		delete(c.topics, msg.Topic)
+		c.hub.stopTyping(msg.Topic, c.names[msg.Topic])
+		delete(c.participants, msg.Topic)
+		delete(c.names, msg.Topic)
 		c.hub.mu.Unlock()
 
 		reply := OutgoingMessage{
@@ -237,6 +416,115 @@ func (c *Client) handleMessage(msg IncomingMessage) {
 	}
 }
 
+// refuseJoin applies the hub's join limits, VerifyToken, AuthorizeJoin and
+// AuthorizeInbox to a join of topic at now and returns the error response
+// if it is refused. Joining a
+// topic again counts against the rate but not the cap; refused joins count
+// against the rate too, so tokens can't be guessed quickly.
+func (c *Client) refuseJoin(topic string, payload JoinPayload, now time.Time) map[string]any {
+	if rate := c.hub.joinRate(); rate > 0 {
+		if c.joinAt.IsZero() {
+			c.joinTokens = rate
+		} else {
+			c.joinTokens = min(rate, c.joinTokens+now.Sub(c.joinAt).Seconds()*rate)
+		}
+		c.joinAt = now
+		if c.joinTokens < 1 {
+			wait := time.Duration((1 - c.joinTokens) / rate * float64(time.Second))
+			return map[string]any{
+				"code":           ErrorJoinRateLimited,
+				"reason":         fmt.Sprintf("at most %g joins per second", rate),
+				"retry_after_ms": wait.Milliseconds() + 1,
+			}
+		}
+		c.joinTokens--
+	}
+	if err := c.acceptToken(payload.AccessToken); err != nil {
+		return map[string]any{
+			"code":   ErrorUnauthorized,
+			"reason": err.Error(),
+		}
+	}
+	if sessionID, ok := strings.CutPrefix(topic, "realtime:messages:"); ok && c.hub.AuthorizeJoin != nil && !c.hub.AuthorizeJoin(sessionID, payload) {
+		return map[string]any{
+			"code":   ErrorUnauthorized,
+			"reason": "this session needs its session_token",
+		}
+	}
+	if tenant, ok := strings.CutPrefix(topic, "realtime:inbox:"); ok && c.hub.AuthorizeInbox != nil && !c.hub.AuthorizeInbox(tenant, payload) {
+		return map[string]any{
+			"code":   ErrorUnauthorized,
+			"reason": "inboxes are for service_role",
+		}
+	}
+	c.hub.mu.RLock()
+	joined := len(c.topics)
+	already := c.topics[topic]
+	c.hub.mu.RUnlock()
+	if max := c.hub.maxTopics(); max > 0 && !already && joined >= max {
+		return map[string]any{
+			"code":   ErrorTooManyTopics,
+			"reason": fmt.Sprintf("at most %d topics per connection; leave one first", max),
+		}
+	}
+	return nil
+}
+
+// JoinLimits returns the MaxTopics and JoinRate in force, with 0 for a
+// limit that is off.
+func (h *Hub) JoinLimits() (maxTopics int, joinRate float64) {
+	return max(h.maxTopics(), 0), max(h.joinRate(), 0)
+}
+
+func (h *Hub) maxTopics() int {
+	if h.MaxTopics == 0 {
+		return DefaultMaxTopics
+	}
+	return h.MaxTopics
+}
+
+func (h *Hub) joinRate() float64 {
+	if h.JoinRate == 0 {
+		return DefaultJoinRate
+	}
+	return h.JoinRate
+}
+
+// acceptToken checks an access token the client presented with
+// VerifyToken and makes its expiry the connection's. The API key and ""
+// aren't access tokens and pass.
+func (c *Client) acceptToken(token string) error {
+	if c.hub.VerifyToken == nil || token == "" || token == c.apikey {
+		return nil
+	}
+	expires, err := c.hub.VerifyToken(token)
+	if err != nil {
+		return err
+	}
+	c.expireAt(expires)
+	return nil
+}
+
+// expireAt schedules the connection to be closed at t, replacing any
+// earlier schedule; the zero t cancels it.
+func (c *Client) expireAt(t time.Time) {
+	c.expiryMu.Lock()
+	defer c.expiryMu.Unlock()
+	if c.expiry != nil {
+		c.expiry.Stop()
+		c.expiry = nil
+	}
+	if t.IsZero() {
+		return
+	}
+	c.expiry = time.AfterFunc(time.Until(t), func() {
+		// WriteControl and Close may be called alongside writePump.
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Token has expired")
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+		c.conn.Close()
+	})
+}
+
 func (c *Client) sendJSON(v interface{}) {
 	data, err := json.Marshal(v)
 	if err != nil {
@@ -286,14 +574,40 @@ func (c *Client) writePump() {
 	}
 }
 
+// ServeWs upgrades r to a realtime connection. A token query parameter
+// must be an access token VerifyToken accepts, or one of Authorize's own,
+// and bounds the connection to its lifetime.
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	token, protocol := upgradeToken(r)
+	if hub.Authorize != nil && !hub.Authorize(token) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	q := r.URL.Query()
+	apikey := q.Get("apikey")
+	if apikey == "" {
+		apikey = r.Header.Get("apikey")
+	}
+	var expires time.Time
+	if access := q.Get("token"); access != "" && access != apikey && hub.VerifyToken != nil {
+		var err error
+		if expires, err = hub.VerifyToken(access); err != nil && (hub.Authorize == nil || !hub.Authorize(access)) {
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	var header http.Header
+	if protocol != "" {
+		header = http.Header{"Sec-Websocket-Protocol": {protocol}}
+	}
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
-		log.Println(err)
+		log.Printf("Websocket upgrade for %s failed: %v", RedactURL(r.URL), err)
 		return
 	}
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256), topics: make(map[string]bool)}
+	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256), topics: make(map[string]bool), participants: make(map[string]string), names: make(map[string]string), apikey: apikey}
 	client.hub.register <- client
+	client.expireAt(expires)
 
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
@@ -0,0 +1,203 @@
+package scheduler
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Duration is a time.Duration that unmarshals from strings like "60s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// AutomationConfig is the per-deployment automated message sequence, read
+// from the JSON file named by AUTOMATIONS_FILE.
+type AutomationConfig struct {
+	// SenderName is shown as sender_name on every automated message.
+	SenderName string `json:"sender_name"`
+	// Greeting is posted as soon as a session is created.
+	Greeting string `json:"greeting,omitempty"`
+	// FollowUp is posted once if nobody has written After the session started.
+	FollowUp *FollowUp `json:"follow_up,omitempty"`
+	// Offline is posted on session creation outside business hours.
+	Offline *Offline `json:"offline,omitempty"`
+}
+
+type FollowUp struct {
+	After Duration `json:"after"`
+	Text  string   `json:"text"`
+}
+
+type Offline struct {
+	Text string `json:"text"`
+	// Timezone is an IANA name such as "Europe/Berlin"; defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// Days lists open weekdays, 0 = Sunday. Empty means every day.
+	Days []time.Weekday `json:"days,omitempty"`
+	// Start and End bound the open hours as "HH:MM" in Timezone.
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func LoadAutomationConfig(path string) (*AutomationConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseAutomationConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseAutomationConfig reads and checks an automation config.
+func ParseAutomationConfig(data []byte) (*AutomationConfig, error) {
+	var cfg AutomationConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Offline != nil {
+		if _, err := cfg.Offline.isOpen(time.Now()); err != nil {
+			return nil, fmt.Errorf("offline: %w", err)
+		}
+	}
+	return &cfg, nil
+}
+
+// isOpen reports whether t falls within business hours.
+func (o *Offline) isOpen(t time.Time) (bool, error) {
+	loc := time.UTC
+	if o.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(o.Timezone); err != nil {
+			return false, err
+		}
+	}
+	start, err := time.Parse("15:04", o.Start)
+	if err != nil {
+		return false, fmt.Errorf("start: %w", err)
+	}
+	end, err := time.Parse("15:04", o.End)
+	if err != nil {
+		return false, fmt.Errorf("end: %w", err)
+	}
+
+	local := t.In(loc)
+	if len(o.Days) > 0 {
+		open := false
+		for _, d := range o.Days {
+			if d == local.Weekday() {
+				open = true
+				break
+			}
+		}
+		if !open {
+			return false, nil
+		}
+	}
+	minute := local.Hour()*60 + local.Minute()
+	return minute >= start.Hour()*60+start.Minute() && minute < end.Hour()*60+end.Minute(), nil
+}
+
+// Automations posts the configured bot messages. SessionCreated is called by
+// the REST handler; Run is registered with the scheduler for follow-ups.
+type Automations struct {
+	DB     *db.Database
+	Config *AutomationConfig
+	// Path is where Replace writes the config, normally AUTOMATIONS_FILE.
+	Path string
+
+	mu sync.RWMutex
+}
+
+// Current returns the config in use. Set Config before serving and go
+// through Replace afterwards.
+func (a *Automations) Current() *AutomationConfig {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.Config
+}
+
+// Replace writes cfg to Path and starts using it.
+func (a *Automations) Replace(cfg *AutomationConfig) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.Path != "" {
+		if err := os.WriteFile(a.Path, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	a.Config = cfg
+	return nil
+}
+
+func (a *Automations) SessionCreated(session *db.ChatSession) {
+	cfg := a.Current()
+	if cfg.Greeting != "" {
+		a.post(session.ID, cfg.SenderName, cfg.Greeting)
+	}
+	if o := cfg.Offline; o != nil && o.Text != "" {
+		if open, err := o.isOpen(session.CreatedAt); err == nil && !open {
+			a.post(session.ID, cfg.SenderName, o.Text)
+		}
+	}
+}
+
+func (a *Automations) Run(now time.Time) {
+	cfg := a.Current()
+	f := cfg.FollowUp
+	if f == nil || f.Text == "" {
+		return
+	}
+	after := time.Duration(f.After)
+	for _, s := range a.DB.OpenSessionActivity() {
+		due := s.Session.CreatedAt.Add(after)
+		// A bot message at or after the due time can only be the follow-up,
+		// since the greeting and offline notice go out at creation.
+		if s.Replied || now.Before(due) || !s.LastBotMessage.Before(due) {
+			continue
+		}
+		a.post(s.Session.ID, cfg.SenderName, f.Text)
+	}
+}
+
+func (a *Automations) post(sessionID, senderName, text string) {
+	origin := db.OriginBot
+	msg := db.Message{
+		SessionID:   sessionID,
+		Content:     &text,
+		MessageType: "text",
+		Origin:      &origin,
+	}
+	if senderName != "" {
+		msg.SenderName = &senderName
+	}
+	if _, err := a.DB.CreateMessage(msg); err != nil {
+		log.Printf("automations: failed to post to session %s: %v", sessionID, err)
+	}
+}
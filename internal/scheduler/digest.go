@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/mail"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// digestPreview bounds how much of the waiting message a digest quotes.
+const digestPreview = 140
+
+// Digest emails operators a list of open sessions whose visitor has been
+// waiting at least WaitingFor, every Every. Nothing is sent when no session
+// is waiting. LinkTemplate turns a session into a deep link, with
+// {session_id} as placeholder; Agents is passed to UnansweredSessions.
+type Digest struct {
+	DB           *db.Database
+	Mailer       *mail.SMTP
+	To           []string
+	Every        time.Duration
+	WaitingFor   time.Duration
+	Agents       map[string]bool
+	LinkTemplate string
+
+	last time.Time
+}
+
+func (d *Digest) Run(now time.Time) {
+	if !d.last.IsZero() && now.Sub(d.last) < d.Every {
+		return
+	}
+	d.last = now
+	waiting := d.DB.UnansweredSessions(d.Agents, d.WaitingFor, now)
+	if len(waiting) == 0 {
+		return
+	}
+	subject, body := d.Compose(waiting, d.DB.Stats(), now)
+	// Sending may wait on a slow relay; the other jobs shouldn't.
+	go func() {
+		if err := d.Mailer.Send(d.To, subject, body); err != nil {
+			log.Printf("digest: sending failed: %v", err)
+		}
+	}()
+}
+
+// Compose renders the digest email.
+func (d *Digest) Compose(waiting []db.Unanswered, stats db.Stats, now time.Time) (subject, body string) {
+	subject = fmt.Sprintf("%d unanswered chat sessions", len(waiting))
+	if len(waiting) == 1 {
+		subject = "1 unanswered chat session"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s waiting for a reply for more than %s.\n", subject, roundDuration(d.WaitingFor))
+	fmt.Fprintf(&b, "Open sessions: %d. Messages in the last 24 hours: %d.\n", stats.OpenSessions, stats.MessagesLast24h)
+	for _, u := range waiting {
+		b.WriteString("\n")
+		name := u.Session.ID
+		if u.Session.Title != nil && *u.Session.Title != "" {
+			name = *u.Session.Title
+		} else if u.Session.Code != nil {
+			name = *u.Session.Code
+		}
+		fmt.Fprintf(&b, "* %s: %s waiting %s, %d message(s)\n", name, u.Visitor, roundDuration(now.Sub(u.WaitingSince)), u.Pending)
+		if u.LastMessage.Content != nil && *u.LastMessage.Content != "" {
+			fmt.Fprintf(&b, "  \"%s\"\n", preview(*u.LastMessage.Content))
+		}
+		if d.LinkTemplate != "" {
+			fmt.Fprintf(&b, "  %s\n", strings.ReplaceAll(d.LinkTemplate, "{session_id}", u.Session.ID))
+		}
+	}
+	return subject, b.String()
+}
+
+func preview(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > digestPreview {
+		return string(r[:digestPreview]) + "…"
+	}
+	return s
+}
+
+// roundDuration formats a wait as "2d 3h", "5h" or "25m".
+func roundDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		d = d.Round(time.Hour)
+		return fmt.Sprintf("%dd %dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", d.Round(time.Hour)/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d.Round(time.Minute)/time.Minute)
+}
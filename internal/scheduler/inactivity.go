@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"log"
+	"time"
+)
+
+// Inactivity warns and then closes sessions that have gone quiet. A zero
+// WarnAfter skips the warning; a zero CloseAfter disables closing.
+type Inactivity struct {
+	DB          *db.Database
+	WarnAfter   time.Duration
+	CloseAfter  time.Duration
+	WarningText string
+	ClosingText string
+}
+
+func (j *Inactivity) Run(now time.Time) {
+	for _, idle := range j.DB.OpenSessionActivity() {
+		quiet := now.Sub(idle.LastActivity)
+		switch {
+		case j.CloseAfter > 0 && quiet >= j.CloseAfter:
+			j.close(idle.Session.ID)
+		case j.WarnAfter > 0 && quiet >= j.WarnAfter && !idle.Warned:
+			j.post(db.SystemMessage(idle.Session.ID, j.WarningText, db.SystemEvent{Kind: db.EventIdleWarning}))
+		}
+	}
+}
+
+func (j *Inactivity) close(sessionID string) {
+	if _, err := j.DB.CloseSession(sessionID, db.CloseReasonInactivity); err != nil {
+		log.Printf("inactivity: failed to close session %s: %v", sessionID, err)
+		return
+	}
+	j.post(db.SystemMessage(sessionID, j.ClosingText, db.SystemEvent{
+		Kind: db.EventClosed,
+		Data: map[string]interface{}{"reason": db.CloseReasonInactivity},
+	}))
+}
+
+func (j *Inactivity) post(msg db.Message) {
+	if _, err := j.DB.CreateMessage(msg); err != nil {
+		log.Printf("inactivity: failed to post to session %s: %v", msg.SessionID, err)
+	}
+}
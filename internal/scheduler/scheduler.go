@@ -0,0 +1,54 @@
+package scheduler
+
+import (
+	"chat-quick-chat-server/internal/db"
+	"sync"
+	"time"
+)
+
+// Job is a unit of periodic background work. It receives the time of the
+// tick that triggered it.
+type Job func(now time.Time)
+
+// Scheduler runs registered jobs on a fixed tick, one after another.
+type Scheduler struct {
+	// Clock gives each tick its time; nil is the wall clock.
+	Clock db.Clock
+
+	interval time.Duration
+	jobs     []Job
+	mu       sync.Mutex
+}
+
+func New(interval time.Duration) *Scheduler {
+	return &Scheduler{interval: interval}
+}
+
+func (s *Scheduler) Add(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, job)
+}
+
+// Run blocks, executing every job on each tick.
+func (s *Scheduler) Run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.Tick()
+	}
+}
+
+// Tick executes every job once, at the clock's current time.
+func (s *Scheduler) Tick() {
+	now := time.Now()
+	if s.Clock != nil {
+		now = s.Clock.Now()
+	}
+	s.mu.Lock()
+	jobs := append([]Job(nil), s.jobs...)
+	s.mu.Unlock()
+	for _, job := range jobs {
+		job(now.UTC())
+	}
+}
@@ -0,0 +1,232 @@
+package scheduler
+
+import (
+	"bytes"
+	"chat-quick-chat-server/internal/db"
+	"chat-quick-chat-server/internal/outbound"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultSLAWarn is the share of an SLA target after which a running clock
+// is warned about.
+const DefaultSLAWarn = 0.8
+
+// SLA tracks sessions against first-response and resolution targets. The
+// first-response clock runs from the visitor's first message to the first
+// reply, the resolution clock from a session's creation until it is closed;
+// a zero target turns its clock off. When an open session's clock reaches
+// Warn of its target, and again when it runs out, Run broadcasts
+// sla.warning or sla.breached on the tenant's inbox topic and posts the
+// alert to WebhookURL. Agents is passed to SLATimes.
+type SLA struct {
+	DB            *db.Database
+	FirstResponse time.Duration
+	Resolution    time.Duration
+	// Warn is a fraction of the target; 0 means DefaultSLAWarn and 1 or
+	// more sends no warnings.
+	Warn       float64
+	Agents     map[string]bool
+	WebhookURL string
+	// StateFile keeps the time of the last check, so alerts that fell due
+	// while the server was down go out once when it is back. Empty keeps it
+	// in memory.
+	StateFile string
+
+	checked time.Time
+}
+
+// slaClock is a running or stopped SLA clock.
+type slaClock struct {
+	name   string
+	start  time.Time
+	target time.Duration
+}
+
+func (c slaClock) due() time.Time { return c.start.Add(c.target) }
+
+func (s *SLA) Run(now time.Time) {
+	since := s.lastCheck(now)
+	warn := s.Warn
+	if warn == 0 {
+		warn = DefaultSLAWarn
+	}
+	for _, t := range s.DB.SLATimes(s.Agents) {
+		if t.Session.ClosedAt != nil {
+			continue
+		}
+		for _, c := range s.running(t) {
+			warnAt := c.start.Add(time.Duration(float64(c.target) * warn))
+			switch {
+			case between(since, now, c.due()):
+				s.alert(t.Session, c, db.InboxSLABreached, now)
+			case warn < 1 && between(since, now, warnAt):
+				s.alert(t.Session, c, db.InboxSLAWarning, now)
+			}
+		}
+	}
+	s.setChecked(now)
+}
+
+// running lists the clocks of an open session that haven't stopped.
+func (s *SLA) running(t db.SLATimes) []slaClock {
+	var clocks []slaClock
+	if s.FirstResponse > 0 && !t.FirstVisitorAt.IsZero() && t.FirstResponseAt.IsZero() {
+		clocks = append(clocks, slaClock{db.SLAFirstResponse, t.FirstVisitorAt, s.FirstResponse})
+	}
+	if s.Resolution > 0 {
+		clocks = append(clocks, slaClock{db.SLAResolution, t.Session.CreatedAt, s.Resolution})
+	}
+	return clocks
+}
+
+// between reports whether t is in (since, now].
+func between(since, now, t time.Time) bool {
+	return t.After(since) && !t.After(now)
+}
+
+func (s *SLA) alert(session db.ChatSession, c slaClock, event string, now time.Time) {
+	alert := db.SLAAlert{
+		SLA:       c.name,
+		SessionID: session.ID,
+		Tenant:    session.TenantName(),
+		StartedAt: c.start,
+		DueAt:     c.due(),
+	}
+	if err := s.DB.NotifyInbox(session.ID, event, alert); err != nil {
+		log.Printf("sla: failed to broadcast %s for session %s: %v", event, session.ID, err)
+	}
+	if s.WebhookURL != "" {
+		// A slow endpoint shouldn't hold up the other jobs.
+		go s.post(event, alert, now)
+	}
+}
+
+// post sends {"event", "sla", "session_id", "tenant", "started_at",
+// "due_at", "at"} to WebhookURL.
+func (s *SLA) post(event string, alert db.SLAAlert, now time.Time) {
+	body, err := json.Marshal(struct {
+		Event string `json:"event"`
+		db.SLAAlert
+		At time.Time `json:"at"`
+	}{event, alert, now})
+	if err != nil {
+		log.Printf("sla: %v", err)
+		return
+	}
+	client := outbound.Client("sla", 10*time.Second)
+	resp, err := client.Post(s.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("sla: %s webhook for session %s failed: %v", event, alert.SessionID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("sla: %s webhook for session %s failed: %s", event, alert.SessionID, resp.Status)
+	}
+}
+
+// lastCheck is when Run last looked, or now on the first run ever.
+func (s *SLA) lastCheck(now time.Time) time.Time {
+	if s.checked.IsZero() && s.StateFile != "" {
+		if data, err := os.ReadFile(s.StateFile); err == nil {
+			s.checked, _ = time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+		}
+	}
+	if s.checked.IsZero() || s.checked.After(now) {
+		s.checked = now
+	}
+	return s.checked
+}
+
+func (s *SLA) setChecked(now time.Time) {
+	s.checked = now
+	if s.StateFile == "" {
+		return
+	}
+	if err := os.WriteFile(s.StateFile, []byte(now.Format(time.RFC3339Nano)+"\n"), 0644); err != nil {
+		log.Printf("sla: failed to save the last check: %v", err)
+	}
+}
+
+// SLAStats is how sessions did against one target. Met and Breached count
+// the clocks that stopped in time or ran out, and Pending those still
+// running in time; a clock stopped by closing the session before its
+// target without a reply counts in none. AverageSeconds is the mean time
+// of the stopped clocks, nil with none.
+type SLAStats struct {
+	TargetSeconds  float64  `json:"target_seconds"`
+	Met            int      `json:"met"`
+	Breached       int      `json:"breached"`
+	Pending        int      `json:"pending"`
+	AverageSeconds *float64 `json:"average_seconds"`
+}
+
+// Stats reports the stats of each target at now, by clock name.
+func (s *SLA) Stats(now time.Time) map[string]SLAStats {
+	var first, resolution slaTally
+	for _, t := range s.DB.SLATimes(s.Agents) {
+		if s.FirstResponse > 0 && !t.FirstVisitorAt.IsZero() {
+			first.add(slaClock{db.SLAFirstResponse, t.FirstVisitorAt, s.FirstResponse}, t.FirstResponseAt, t.Session.ClosedAt, now)
+		}
+		if s.Resolution > 0 {
+			var stop time.Time
+			if t.Session.ClosedAt != nil {
+				stop = *t.Session.ClosedAt
+			}
+			resolution.add(slaClock{db.SLAResolution, t.Session.CreatedAt, s.Resolution}, stop, nil, now)
+		}
+	}
+	stats := make(map[string]SLAStats)
+	if s.FirstResponse > 0 {
+		stats[db.SLAFirstResponse] = first.stats(s.FirstResponse)
+	}
+	if s.Resolution > 0 {
+		stats[db.SLAResolution] = resolution.stats(s.Resolution)
+	}
+	return stats
+}
+
+type slaTally struct {
+	SLAStats
+	stopped int
+	total   time.Duration
+}
+
+// add counts clock c, which stopped at stop unless that is zero. A clock
+// that never stopped ends at closed, if set, or is still running at now.
+func (t *slaTally) add(c slaClock, stop time.Time, closed *time.Time, now time.Time) {
+	if !stop.IsZero() {
+		t.stopped++
+		t.total += stop.Sub(c.start)
+		if stop.After(c.due()) {
+			t.Breached++
+		} else {
+			t.Met++
+		}
+		return
+	}
+	end := now
+	if closed != nil {
+		end = *closed
+	}
+	switch {
+	case end.After(c.due()):
+		t.Breached++
+	case closed == nil:
+		t.Pending++
+	}
+}
+
+func (t *slaTally) stats(target time.Duration) SLAStats {
+	s := t.SLAStats
+	s.TargetSeconds = target.Seconds()
+	if t.stopped > 0 {
+		avg := (t.total / time.Duration(t.stopped)).Seconds()
+		s.AverageSeconds = &avg
+	}
+	return s
+}
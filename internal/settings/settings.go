@@ -0,0 +1,98 @@
+// Package settings moves a deployment's configuration between environments,
+// e.g. from staging to production, as one JSON document.
+//
+// A bundle holds the configuration that lives in the server rather than in
+// its environment: the automated message sequence and the locally managed
+// blocklist. Integrations configured through environment variables and the
+// files they name (CRM, tickets, payments, calendar) carry credentials and
+// stay with each environment.
+package settings
+
+import (
+	"chat-quick-chat-server/internal/blocklist"
+	"chat-quick-chat-server/internal/scheduler"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Version is the bundle format written by Export.
+const Version = 1
+
+// Bundle is the exchange document. A section that is null or missing is
+// left as it is on import.
+type Bundle struct {
+	Version     int                         `json:"version"`
+	ExportedAt  time.Time                   `json:"exported_at"`
+	Automations *scheduler.AutomationConfig `json:"automations"`
+	Blocklist   *blocklist.List             `json:"blocklist"`
+}
+
+// Export collects the configuration of list and auto, either of which may be
+// nil when it isn't configured. Only the blocklist's local entries are
+// exported; the shared feed's are fetched by each environment.
+func Export(list *blocklist.Blocklist, auto *scheduler.Automations, now time.Time) *Bundle {
+	b := &Bundle{Version: Version, ExportedAt: now.UTC()}
+	if list != nil {
+		l := list.Local()
+		b.Blocklist = &l
+	}
+	if auto != nil {
+		b.Automations = auto.Current()
+	}
+	return b
+}
+
+// Parse reads and checks a bundle, so that Apply doesn't fail halfway on an
+// invalid section.
+func Parse(data []byte) (*Bundle, error) {
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	if b.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version %d, want %d", b.Version, Version)
+	}
+	if b.Automations != nil {
+		data, _ := json.Marshal(b.Automations)
+		if _, err := scheduler.ParseAutomationConfig(data); err != nil {
+			return nil, fmt.Errorf("automations: %w", err)
+		}
+	}
+	if b.Blocklist != nil {
+		if err := b.Blocklist.Validate(); err != nil {
+			return nil, fmt.Errorf("blocklist: %w", err)
+		}
+	}
+	return &b, nil
+}
+
+// Check reports a section of b that list or auto can't take because it
+// isn't configured.
+func Check(b *Bundle, list *blocklist.Blocklist, auto *scheduler.Automations) error {
+	if b.Automations != nil && auto == nil {
+		return fmt.Errorf("automations are not configured here; set AUTOMATIONS_FILE")
+	}
+	if b.Blocklist != nil && list == nil {
+		return fmt.Errorf("blocklist is not configured here")
+	}
+	return nil
+}
+
+// Apply replaces the configuration with the sections present in b.
+func Apply(b *Bundle, list *blocklist.Blocklist, auto *scheduler.Automations) error {
+	if err := Check(b, list, auto); err != nil {
+		return err
+	}
+	if b.Blocklist != nil {
+		if err := list.Import(*b.Blocklist, true); err != nil {
+			return fmt.Errorf("blocklist: %w", err)
+		}
+	}
+	if b.Automations != nil {
+		if err := auto.Replace(b.Automations); err != nil {
+			return fmt.Errorf("automations: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package ticket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GitHub files issues in a repository through the REST API. APIURL defaults
+// to https://api.github.com; set it for GitHub Enterprise.
+type GitHub struct {
+	Repo   string   `json:"repo"`
+	Token  string   `json:"token"`
+	Labels []string `json:"labels"`
+	APIURL string   `json:"api_url"`
+}
+
+func (g *GitHub) Name() string { return "github" }
+
+func (g *GitHub) Create(t Ticket) (*Result, error) {
+	api := g.APIURL
+	if api == "" {
+		api = "https://api.github.com"
+	}
+	body, err := json.Marshal(map[string]interface{}{"title": t.Title, "body": t.Body, "labels": g.Labels})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(api, "/")+"/repos/"+g.Repo+"/issues", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if g.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+	var issue struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := send(req, &issue); err != nil {
+		return nil, err
+	}
+	return &Result{ID: fmt.Sprintf("%s#%d", g.Repo, issue.Number), URL: issue.HTMLURL}, nil
+}
+
+// Jira creates issues with the v2 REST API, which takes a plain-text
+// description. Email and Token are an Atlassian account and API token.
+type Jira struct {
+	BaseURL   string `json:"base_url"`
+	Email     string `json:"email"`
+	Token     string `json:"token"`
+	Project   string `json:"project"`
+	IssueType string `json:"issue_type"`
+}
+
+func (j *Jira) Name() string { return "jira" }
+
+func (j *Jira) Create(t Ticket) (*Result, error) {
+	issueType := j.IssueType
+	if issueType == "" {
+		issueType = "Task"
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"fields": map[string]interface{}{
+			"project":     map[string]string{"key": j.Project},
+			"summary":     t.Title,
+			"description": t.Body,
+			"issuetype":   map[string]string{"name": issueType},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(j.BaseURL, "/")
+	req, err := http.NewRequest("POST", base+"/rest/api/2/issue", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(j.Email, j.Token)
+	var issue struct {
+		Key string `json:"key"`
+	}
+	if err := send(req, &issue); err != nil {
+		return nil, err
+	}
+	return &Result{ID: issue.Key, URL: base + "/browse/" + issue.Key}, nil
+}
+
+// Webhook files tickets with any REST API. Body is a JSON template in which
+// {{title}}, {{body}} and {{session_id}} are replaced by the JSON-escaped
+// values, so they belong inside string literals. IDField and URLField are
+// dot paths into the response (e.g. "data.id"); URLTemplate builds the link
+// from {{id}} when the response has none.
+type Webhook struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+	IDField     string            `json:"id_field"`
+	URLField    string            `json:"url_field"`
+	URLTemplate string            `json:"url_template"`
+}
+
+// defaultWebhookBody is sent when Body is empty.
+const defaultWebhookBody = `{"title": "{{title}}", "body": "{{body}}", "session_id": "{{session_id}}"}`
+
+func (w *Webhook) Name() string { return "webhook" }
+
+func (w *Webhook) Create(t Ticket) (*Result, error) {
+	tmpl := w.Body
+	if tmpl == "" {
+		tmpl = defaultWebhookBody
+	}
+	body := strings.NewReplacer(
+		"{{title}}", jsonEscape(t.Title),
+		"{{body}}", jsonEscape(t.Body),
+		"{{session_id}}", jsonEscape(t.SessionID),
+	).Replace(tmpl)
+	if !json.Valid([]byte(body)) {
+		return nil, fmt.Errorf("webhook body template does not produce valid JSON")
+	}
+	method := w.Method
+	if method == "" {
+		method = "POST"
+	}
+	req, err := http.NewRequest(method, w.URL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	var resp interface{}
+	if err := send(req, &resp); err != nil {
+		return nil, err
+	}
+
+	idField := w.IDField
+	if idField == "" {
+		idField = "id"
+	}
+	res := &Result{ID: lookup(resp, idField)}
+	if w.URLField != "" {
+		res.URL = lookup(resp, w.URLField)
+	}
+	if res.URL == "" && w.URLTemplate != "" {
+		res.URL = strings.ReplaceAll(w.URLTemplate, "{{id}}", res.ID)
+	}
+	if res.ID == "" && res.URL == "" {
+		return nil, fmt.Errorf("response has no %s", idField)
+	}
+	return res, nil
+}
+
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// lookup follows a dot path through decoded JSON and formats the value
+// found, or returns "".
+func lookup(v interface{}, path string) string {
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[key]
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package ticket
+
+import (
+	"chat-quick-chat-server/internal/outbound"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Ticket is an issue to file for an escalated conversation. Body carries
+// the transcript.
+type Ticket struct {
+	Title     string
+	Body      string
+	SessionID string
+}
+
+// Result identifies the created issue.
+type Result struct {
+	ID  string `json:"ticket_id"`
+	URL string `json:"url"`
+}
+
+// Provider files tickets in an issue tracker.
+type Provider interface {
+	Name() string
+	Create(t Ticket) (*Result, error)
+}
+
+// Config is the content of TICKET_CONFIG. Provider selects which of the
+// sections is used:
+//
+//	{"provider": "github", "github": {"repo": "acme/support", "token": "...", "labels": ["chat"]}}
+//	{"provider": "jira", "jira": {"base_url": "https://acme.atlassian.net", "email": "...", "token": "...", "project": "SUP"}}
+//	{"provider": "webhook", "webhook": {"url": "...", "body": "{\"subject\": \"{{title}}\"}", "id_field": "id"}}
+type Config struct {
+	Provider string   `json:"provider"`
+	GitHub   *GitHub  `json:"github"`
+	Jira     *Jira    `json:"jira"`
+	Webhook  *Webhook `json:"webhook"`
+}
+
+func LoadConfig(path string) (Provider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var p Provider
+	var missing bool
+	switch cfg.Provider {
+	case "github":
+		p, missing = cfg.GitHub, cfg.GitHub == nil || cfg.GitHub.Repo == ""
+	case "jira":
+		p, missing = cfg.Jira, cfg.Jira == nil || cfg.Jira.BaseURL == "" || cfg.Jira.Project == ""
+	case "webhook":
+		p, missing = cfg.Webhook, cfg.Webhook == nil || cfg.Webhook.URL == ""
+	default:
+		return nil, fmt.Errorf("%s: provider must be github, jira or webhook", path)
+	}
+	if missing {
+		return nil, fmt.Errorf("%s: %s section is incomplete", path, cfg.Provider)
+	}
+	return p, nil
+}
+
+// send performs a JSON request and decodes a JSON answer into out.
+func send(req *http.Request, out interface{}) error {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	client := outbound.Client("ticket", 20*time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}